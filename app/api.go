@@ -3,14 +3,13 @@ package app
 
 import (
 	"dnscoffee/datastore"
+	"dnscoffee/model"
 	"dnscoffee/server"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
-	"net"
 
 	"github.com/gorilla/mux"
 )
@@ -18,87 +17,127 @@ import (
 // APIStart entry point for starting application
 // adds routes to the server so that the correct handlers are registered
 func APIStart(app *appContext, coffeeServer *server.Server) {
-	app.api = make(map[string]string)
+	app.routes = make([]*apiRoute, 0)
 
-	// Adds a method to the router's GET handler but also adds it to the API index map
-	// description is the API function description
-	addAPI := func(path string, params []string, description string, fn http.HandlerFunc) {
-		re := regexp.MustCompile(":[a-zA-Z0-9_]*")
-		paramPath := re.ReplaceAllStringFunc(path, func(s string) string { return fmt.Sprintf("{%s}", s[1:]) })
+	// Adds a method to the router with handle but also adds it to the route registry
+	// name identifies the route in the index and the OpenAPI document, and must be documented in apiDocs
+	addRoute := func(method string, handle func(string, http.HandlerFunc), stream bool, path string, params []string, name string, fn http.HandlerFunc) {
 		if fn == nil { // hide WIP
 			return
-			//fn = server.HandlerNotImplemented
-			//description = fmt.Sprintf("[WIP] %s", description)
 		}
-		if params != nil {
-			paramPath += "?" + strings.Join(params, "&")
+		app.routes = append(app.routes, newAPIRoute(method, path, params, name, stream))
+		handle("/api"+path, fn)
+	}
+	addAPI := func(path string, params []string, name string, fn http.HandlerFunc) {
+		addRoute(http.MethodGet, coffeeServer.Get, false, path, params, name, fn)
+	}
+	addPostAPI := func(path string, params []string, name string, fn http.HandlerFunc) {
+		addRoute(http.MethodPost, coffeeServer.Post, false, path, params, name, fn)
+	}
+	// for the handlers writing their response with a server.Stream
+	addStreamAPI := func(path string, params []string, name string, fn http.HandlerFunc) {
+		addRoute(http.MethodGet, coffeeServer.GetStream, true, path, params, name, fn)
+	}
+	// a zone file holds every domain of the zone, which takes much longer than the other streams for the largest zones
+	addZoneFileAPI := func(path string, name string) {
+		handle := func(path string, fn http.HandlerFunc) {
+			coffeeServer.GetStreamTimeout(path, coffeeServer.APIConfig().ZoneFileTimeout, fn)
 		}
-		app.api[description] = paramPath
-		coffeeServer.Get("/api"+path, fn)
+		addRoute(http.MethodGet, handle, true, path, []string{"date={YYYY-MM-DD}"}, name, app.apiZoneFileHandler)
 	}
 
-	// imports
+	// API index
+	addAPI("/index", nil, "index", app.apiIndex)
+	addAPI("/openapi.json", nil, "openapi", app.apiOpenAPIHandler)
 
-	addAPI("/imports/{year}/{month}/{day}", nil, "import_day_view", nil)
-	addAPI("/imports/{year}/{month}/{day}/{zone}", nil, "import_day_view_zone", nil)
+	// imports
+	addAPI("/imports/health", nil, "import_health", app.apiImportHealthHandler)
+	addAPI("/imports/{year}/{month}/{day}", nil, "import_day_view", app.apiImportDayHandler)
+	addAPI("/imports/{year}/{month}/{day}/{zone}", nil, "import_day_view_zone", app.apiImportDayHandler)
 
 	// counts
-	addAPI("/counts", nil, "zone_counts", app.apiInternetHistoryCountsHandler)
-	addAPI("/counts/zone/{zone}", nil, "internet_counts", app.apiZoneHistoryCountsHandler)
-	addAPI("/counts/root", nil, "internet_counts", app.apiZoneHistoryCountsHandler)
-	addAPI("/counts/all", nil, "all_zone_counts", app.apiAllZoneHistoryCountsHandler)
+	countsParams := []string{"from={YYYY-MM-DD}", "to={YYYY-MM-DD}", "granularity={day|week|month|year}", "zones={zone,...}"}
+	addAPI("/counts", countsParams, "zone_counts", app.apiInternetHistoryCountsHandler)
+	addAPI("/counts/zone/{zone}", countsParams, "zone_history_counts", app.apiZoneHistoryCountsHandler)
+	addAPI("/counts/root", countsParams, "root_history_counts", app.apiZoneHistoryCountsHandler)
+	addStreamAPI("/counts/all", countsParams, "all_zone_counts", app.apiAllZoneHistoryCountsHandler)
 	//addAPI("/counts/top", nil, "top_zone_counts", app.apiTopZonesHandler)
 
+	// the resources can include their relationships and limit the fields of each type
+	resourceParams := []string{"date={YYYY-MM-DD}", "include={relationship.relationship,...}", "fields[{type}]={field,...}"}
+	// the relationship listings are paged, as of a day
+	listParams := []string{"limit={size}", "date={YYYY-MM-DD}"}
+
 	// zones
-	addAPI("/root", nil, "zone_view", app.apiZoneHandler)
+	addAPI("/root", resourceParams, "root_view", app.apiZoneHandler)
+	addZoneFileAPI("/root/zonefile", "root_zone_file")
 	addAPI("/zones", nil, "zones", app.apiLatestZonesHandler)
-	addAPI("/zones/{zone}", nil, "zone_view", app.apiZoneHandler)
+	addAPI("/zones/{zone}", resourceParams, "zone_view", app.apiZoneHandler)
 	addAPI("/zones/{zone}/import", nil, "zone_import", app.apiZoneImportHandler)
-	addAPI("/zones/{zone}/nameservers", nil, "zone_nameservers", nil)
-	addAPI("/zones/{zone}/nameservers/current", nil, "zone_nameservers_current", nil)
-	addAPI("/zones/{zone}/nameservers/archive", nil, "zone_nameservers_archive", nil)
-	addAPI("/zones/{zone}/nameservers/archive/page/{page}", nil, "zone_nameservers_archive_paged", nil)
+	addAPI("/zones/{zone}/diff", []string{"from={YYYY-MM-DD}", "to={YYYY-MM-DD}"}, "zone_diff", app.apiZoneDiffHandler)
+	addZoneFileAPI("/zones/{zone}/zonefile", "zone_file")
+	addAPI("/zones/{zone}/nameservers", listParams, "zone_nameservers", app.apiZoneNameServersHandler(""))
+	addAPI("/zones/{zone}/nameservers/page/{page}", listParams, "zone_nameservers_paged", app.apiZoneNameServersHandler(""))
+	addAPI("/zones/{zone}/nameservers/current", listParams, "zone_nameservers_current", app.apiZoneNameServersHandler(datastore.StateCurrent))
+	addAPI("/zones/{zone}/nameservers/current/page/{page}", listParams, "zone_nameservers_current_paged", app.apiZoneNameServersHandler(datastore.StateCurrent))
+	addAPI("/zones/{zone}/nameservers/archive", listParams, "zone_nameservers_archive", app.apiZoneNameServersHandler(datastore.StateArchive))
+	addAPI("/zones/{zone}/nameservers/archive/page/{page}", listParams, "zone_nameservers_archive_paged", app.apiZoneNameServersHandler(datastore.StateArchive))
+	addAPI("/zones/{zone}/domains", listParams, "zone_domains", app.apiZoneDomainsHandler(""))
+	addAPI("/zones/{zone}/domains/page/{page}", listParams, "zone_domains_paged", app.apiZoneDomainsHandler(""))
+	addAPI("/zones/{zone}/domains/current", listParams, "zone_domains_current", app.apiZoneDomainsHandler(datastore.StateCurrent))
+	addAPI("/zones/{zone}/domains/current/page/{page}", listParams, "zone_domains_current_paged", app.apiZoneDomainsHandler(datastore.StateCurrent))
+	addAPI("/zones/{zone}/domains/archive", listParams, "zone_domains_archive", app.apiZoneDomainsHandler(datastore.StateArchive))
+	addAPI("/zones/{zone}/domains/archive/page/{page}", listParams, "zone_domains_archive_paged", app.apiZoneDomainsHandler(datastore.StateArchive))
 
 	// domains
 	addAPI("/random", nil, "random_domain", app.apiRandomDomainHandler)
-	addAPI("/domains/{domain}", nil, "domain", app.apiDomainHandler)
-	addAPI("/domains/{domain}/nameservers", nil, "domain_nameservers", nil)
-	addAPI("/domains/{domain}/nameservers/current", nil, "domain_current_nameservers", nil)
-	addAPI("/domains/{domain}/nameservers/current/page/{page}", nil, "domain_current_nameservers_paged", nil)
-	addAPI("/domains/{domain}/nameservers/archive", nil, "domain_archive_nameservers", nil)
-	addAPI("/domains/{domain}/nameservers/archive/page/{page}", nil, "domain_archive_nameservers_paged", nil)
+	addAPI("/domains/{domain}", resourceParams, "domain", app.apiDomainHandler)
+	addAPI("/domains/{domain}/timeline", nil, "domain_timeline", app.apiDomainTimelineHandler)
+	addAPI("/domains/{domain}/nameservers", listParams, "domain_nameservers", app.apiDomainNameServersHandler(""))
+	addAPI("/domains/{domain}/nameservers/page/{page}", listParams, "domain_nameservers_paged", app.apiDomainNameServersHandler(""))
+	addAPI("/domains/{domain}/nameservers/current", listParams, "domain_current_nameservers", app.apiDomainNameServersHandler(datastore.StateCurrent))
+	addAPI("/domains/{domain}/nameservers/current/page/{page}", listParams, "domain_current_nameservers_paged", app.apiDomainNameServersHandler(datastore.StateCurrent))
+	addAPI("/domains/{domain}/nameservers/archive", listParams, "domain_archive_nameservers", app.apiDomainNameServersHandler(datastore.StateArchive))
+	addAPI("/domains/{domain}/nameservers/archive/page/{page}", listParams, "domain_archive_nameservers_paged", app.apiDomainNameServersHandler(datastore.StateArchive))
 
 	// nameservers
-	addAPI("/nameservers/{domain}", nil, "nameserver", app.apiNameserverHandler)
-	addAPI("/nameservers/{domain}/domains", nil, "nameserver_domains", nil)
-	addAPI("/nameservers/{domain}/domains/current", nil, "nameserver_current_domains", nil)
-	addAPI("/nameservers/{domain}/domains/current/page/{page}", nil, "nameserver_current_domains_paged", nil)
-	addAPI("/nameservers/{domain}/domains/archive", nil, "nameserver_archive_domains", nil)
-	addAPI("/nameservers/{domain}/domains/archive/page/{page}", nil, "nameserver_archive_domains_paged", nil)
-
-	addAPI("/nameservers/{domain}/ip", nil, "nameserver_ips", nil)
-	addAPI("/nameservers/{domain}/ip/4", nil, "nameserver_ipv4", nil)
-	addAPI("/nameservers/{domain}/ip/4/current", nil, "nameserver_ipv4_current", nil)
-	addAPI("/nameservers/{domain}/ip/4/current/page/{page}", nil, "nameserver_ipv4_current_paged", nil)
-	addAPI("/nameservers/{domain}/ip/4/archive", nil, "nameserver_ipv4_archive", nil)
-	addAPI("/nameservers/{domain}/ip/4/archive/page/{page}", nil, "nameserver_ipv4_archive_paged", nil)
-	addAPI("/nameservers/{domain}/ip/6", nil, "nameserver_ipv6", nil)
-	addAPI("/nameservers/{domain}/ip/6/current", nil, "nameserver_ipv6_current", nil)
-	addAPI("/nameservers/{domain}/ip/6/current/page/{page}", nil, "nameserver_ipv6_current_paged", nil)
-	addAPI("/nameservers/{domain}/ip/6/archive", nil, "nameserver_ipv6_archive", nil)
-	addAPI("/nameservers/{domain}/ip/6/archive/page/{page}", nil, "nameserver_ipv6_archive_paged", nil)
+	addAPI("/nameservers/{domain}", resourceParams, "nameserver", app.apiNameserverHandler)
+	addAPI("/nameservers/{domain}/domains", listParams, "nameserver_domains", app.apiNameServerDomainsHandler(""))
+	addAPI("/nameservers/{domain}/domains/page/{page}", listParams, "nameserver_domains_paged", app.apiNameServerDomainsHandler(""))
+	addAPI("/nameservers/{domain}/domains/current", listParams, "nameserver_current_domains", app.apiNameServerDomainsHandler(datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/domains/current/page/{page}", listParams, "nameserver_current_domains_paged", app.apiNameServerDomainsHandler(datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/domains/archive", listParams, "nameserver_archive_domains", app.apiNameServerDomainsHandler(datastore.StateArchive))
+	addAPI("/nameservers/{domain}/domains/archive/page/{page}", listParams, "nameserver_archive_domains_paged", app.apiNameServerDomainsHandler(datastore.StateArchive))
+
+	addAPI("/nameservers/{domain}/ip", listParams, "nameserver_ips", app.apiNameServerIPsHandler(0, ""))
+	addAPI("/nameservers/{domain}/ip/page/{page}", listParams, "nameserver_ips_paged", app.apiNameServerIPsHandler(0, ""))
+	addAPI("/nameservers/{domain}/ip/4", listParams, "nameserver_ipv4", app.apiNameServerIPsHandler(4, ""))
+	addAPI("/nameservers/{domain}/ip/4/page/{page}", listParams, "nameserver_ipv4_paged", app.apiNameServerIPsHandler(4, ""))
+	addAPI("/nameservers/{domain}/ip/4/current", listParams, "nameserver_ipv4_current", app.apiNameServerIPsHandler(4, datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/ip/4/current/page/{page}", listParams, "nameserver_ipv4_current_paged", app.apiNameServerIPsHandler(4, datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/ip/4/archive", listParams, "nameserver_ipv4_archive", app.apiNameServerIPsHandler(4, datastore.StateArchive))
+	addAPI("/nameservers/{domain}/ip/4/archive/page/{page}", listParams, "nameserver_ipv4_archive_paged", app.apiNameServerIPsHandler(4, datastore.StateArchive))
+	addAPI("/nameservers/{domain}/ip/6", listParams, "nameserver_ipv6", app.apiNameServerIPsHandler(6, ""))
+	addAPI("/nameservers/{domain}/ip/6/page/{page}", listParams, "nameserver_ipv6_paged", app.apiNameServerIPsHandler(6, ""))
+	addAPI("/nameservers/{domain}/ip/6/current", listParams, "nameserver_ipv6_current", app.apiNameServerIPsHandler(6, datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/ip/6/current/page/{page}", listParams, "nameserver_ipv6_current_paged", app.apiNameServerIPsHandler(6, datastore.StateCurrent))
+	addAPI("/nameservers/{domain}/ip/6/archive", listParams, "nameserver_ipv6_archive", app.apiNameServerIPsHandler(6, datastore.StateArchive))
+	addAPI("/nameservers/{domain}/ip/6/archive/page/{page}", listParams, "nameserver_ipv6_archive_paged", app.apiNameServerIPsHandler(6, datastore.StateArchive))
 
 	// ipv4 & ipv6
 	addAPI("/ip", []string{"ipprefix={prefix_to_search}"}, "ip", app.apiIPListHandler)
-	addAPI("/ip/{ip}", nil, "ip_view", app.apiIPHandler)
-	addAPI("/ip/{ip}/nameservers", nil, "ip_nameservers", nil)
-	addAPI("/ip/{ip}/nameservers/current", nil, "ip_nameservers_current", nil)
-	addAPI("/ip/{ip}/nameservers/archive", nil, "ip_nameservers_archive", nil)
+	addAPI("/ip/{ip}", resourceParams, "ip_view", app.apiIPHandler)
+	addAPI("/ip/{ip}/nameservers", listParams, "ip_nameservers", app.apiIPNameServersHandler(""))
+	addAPI("/ip/{ip}/nameservers/page/{page}", listParams, "ip_nameservers_paged", app.apiIPNameServersHandler(""))
+	addAPI("/ip/{ip}/nameservers/current", listParams, "ip_nameservers_current", app.apiIPNameServersHandler(datastore.StateCurrent))
+	addAPI("/ip/{ip}/nameservers/current/page/{page}", listParams, "ip_nameservers_current_paged", app.apiIPNameServersHandler(datastore.StateCurrent))
+	addAPI("/ip/{ip}/nameservers/archive", listParams, "ip_nameservers_archive", app.apiIPNameServersHandler(datastore.StateArchive))
+	addAPI("/ip/{ip}/nameservers/archive/page/{page}", listParams, "ip_nameservers_archive_paged", app.apiIPNameServersHandler(datastore.StateArchive))
 
 	// feeds
 	addAPI("/feeds/new", nil, "feeds_new", nil)
 	addAPI("/feeds/new/search/{search}", nil, "feeds_new_search", app.apiFeedsSearchNewHandler)
-	addAPI("/feeds/new/date/{date}", nil, "feeds_new_date", app.apiFeedsNewHandler)
+	addStreamAPI("/feeds/new/date/{date}", nil, "feeds_new_date", app.apiFeedsNewHandler)
 	addAPI("/feeds/ns/new/date/{date}", nil, "feeds_ns_new_date", app.apiFeedsNsNewHandler)
 	//addAPI("/feeds/new/page/{page}", nil, "feeds_new_paged", nil)
 	//addAPI("/feeds/new/{year}/{month}/{day}", nil, "feeds_new_date", app.apiFeedsNewHandler)
@@ -120,12 +159,27 @@ func APIStart(app *appContext, coffeeServer *server.Server) {
 	//addAPI("/feeds/moved/{year}/{month}/{day}", nil, "feeds_moved_date", nil)
 	//addAPI("/feeds/moved/{year}/{month}/{day}/page/{page}", nil, "feeds_moved_date_paged", nil)
 
+	// bulk lookups, the names are POSTed as a JSON array or a name per line
+	addPostAPI("/bulk/domains", nil, "bulk_domains", app.apiBulkHandler(bulkDomains))
+	addPostAPI("/bulk/nameservers", nil, "bulk_nameservers", app.apiBulkHandler(bulkNameServers))
+	addPostAPI("/bulk/ip", nil, "bulk_ip", app.apiBulkHandler(bulkIPs))
+
+	// GraphQL, the query is POSTed as {"query": ..., "variables": ...}
+	addPostAPI("/graphql", nil, "graphql", app.apiGraphQLHandler)
+
+	// prefixes
+	addStreamAPI("/prefixes/{type}/{prefix}", nil, "prefixes", app.apiPrefixesHandler)
+
 	// research
 	addAPI("/research/ipnszonecount/{ip}", nil, "ip_ns_zone_count", app.apiIPNsZoneCount)
-	addAPI("/research/active_ips/{date}", nil, "active_ips", app.apiActiveIPs)
-
-	// API index
-//	coffeeServer.Get("/api", app.apiIndex)
+	addStreamAPI("/research/active_ips/{date}", nil, "active_ips", app.apiActiveIPs)
+	addAPI("/research/trust-tree/{domain}", []string{"date={YYYY-MM-DD}", "depth={levels}", "resolve_zones={true|false}"}, "trust_tree", app.apiTrustTreeHandler)
+	addStreamAPI("/research/dangling", []string{"date={YYYY-MM-DD}", "zone={zone}"}, "dangling_nameservers", app.apiDanglingNameServers)
+	addStreamAPI("/research/operators", append(countsParams, "top={count}"), "operator_market", app.apiOperatorMarket)
+	addAPI("/research/migrations/{zone}", []string{"from={YYYY-MM-DD}", "to={YYYY-MM-DD}", "top={count}"}, "provider_migration", app.apiProviderMigration)
+
+	// v2, JSON:API documents
+	app.v2Routes(addAPI)
 }
 
 func (app *appContext) apiLatestZonesHandler(w http.ResponseWriter, r *http.Request) {
@@ -133,8 +187,9 @@ func (app *appContext) apiLatestZonesHandler(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		panic(err)
 	}
+	datastore.MarkStale(zoneImportResults)
 
-	server.WriteJSON(w, zoneImportResults)
+	server.WriteData(w, r, zoneImportResults)
 }
 
 /*
@@ -145,37 +200,44 @@ func (app *appContext) apiTopZonesHandler(w http.ResponseWriter, r *http.Request
 		panic(err)
 	}
 
-	server.WriteJSON(w, zoneImportResults)
+	server.WriteData(w, r, zoneImportResults)
 }*/
 
 func (app *appContext) apiZoneImportHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	zone := cleanDomain(params["zone"])
+	zone, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	zoneImportResult, err := app.ds.GetZoneImport(r.Context(), zone)
 	if err != nil {
-		// TODO handle error no rows found
-		panic(err)
+		writeError(w, err)
+		return
 	}
 
-	server.WriteJSON(w, zoneImportResult)
+	server.WriteData(w, r, zoneImportResult)
 }
 
 func (app *appContext) apiFeedsNewHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
-	data, err := app.ds.GetFeedNew(r.Context(), date)
-	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+	header := &model.Feed{Change: "new", Date: date}
+	stream := server.NewStream(w, r, header, "domains")
+	if stream == nil {
+		return
+	}
+	stream.Begin("domains", '[')
+	err = app.ds.StreamFeedNew(r.Context(), date, func(d *model.Domain) error {
+		d.GenerateMetaData()
+		return stream.Write(d, header.Record(d))
+	})
+	stream.End()
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
 	}
-
-	server.WriteJSON(w, data)
 }
 
 func (app *appContext) apiFeedsSearchMovedHandler(w http.ResponseWriter, r *http.Request) {
@@ -183,14 +245,11 @@ func (app *appContext) apiFeedsSearchMovedHandler(w http.ResponseWriter, r *http
 	search := params["search"]
 	data, err := app.ds.GetMovedFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		writeError(w, err)
+		return
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiFeedsSearchOldHandler(w http.ResponseWriter, r *http.Request) {
@@ -198,14 +257,11 @@ func (app *appContext) apiFeedsSearchOldHandler(w http.ResponseWriter, r *http.R
 	search := params["search"]
 	data, err := app.ds.GetOldFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		writeError(w, err)
+		return
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiFeedsSearchNewHandler(w http.ResponseWriter, r *http.Request) {
@@ -213,21 +269,18 @@ func (app *appContext) apiFeedsSearchNewHandler(w http.ResponseWriter, r *http.R
 	search := strings.ToLower(params["search"])
 	data, err := app.ds.GetNewFeedCount(r.Context(), search)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		writeError(w, err)
+		return
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiFeedsMovedHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
 	data, err := app.ds.GetFeedMoved(r.Context(), date)
 	if err != nil {
@@ -238,14 +291,14 @@ func (app *appContext) apiFeedsMovedHandler(w http.ResponseWriter, r *http.Reque
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiFeedsOldHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
 	data, err := app.ds.GetFeedOld(r.Context(), date)
 	if err != nil {
@@ -256,14 +309,14 @@ func (app *appContext) apiFeedsOldHandler(w http.ResponseWriter, r *http.Request
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiFeedsNsNewHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
 	data, err := app.ds.GetFeedNsNew(r.Context(), date)
 	if err != nil {
@@ -274,13 +327,13 @@ func (app *appContext) apiFeedsNsNewHandler(w http.ResponseWriter, r *http.Reque
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 func (app *appContext) apiFeedsNsMovedHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
 	data, err := app.ds.GetFeedNsMoved(r.Context(), date)
 	if err != nil {
@@ -291,13 +344,13 @@ func (app *appContext) apiFeedsNsMovedHandler(w http.ResponseWriter, r *http.Req
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 func (app *appContext) apiFeedsNsOldHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
 	}
 	data, err := app.ds.GetFeedNsOld(r.Context(), date)
 	if err != nil {
@@ -308,14 +361,27 @@ func (app *appContext) apiFeedsNsOldHandler(w http.ResponseWriter, r *http.Reque
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 // domainHandler returns domain object for the queried domain
 func (app *appContext) apiDomainHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
-	data, err := app.ds.GetDomain(r.Context(), domain)
+	domain, err := domainVar(r, "domain")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	include, err := parseInclude(r, "domain")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := app.getDomain(r.Context(), domain, date)
 	if err != nil {
 		if err == datastore.ErrNoResource {
 			server.WriteJSONError(w, server.ErrResourceNotFound)
@@ -324,13 +390,31 @@ func (app *appContext) apiDomainHandler(w http.ResponseWriter, r *http.Request)
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	err = app.include(r.Context(), date, include, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiIPHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
-	data, err := app.ds.GetIP(r.Context(), ip)
+	ip, err := ipVar(r, "ip")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	include, err := parseInclude(r, "ip")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := app.getIP(r.Context(), ip, date)
 	if err != nil {
 		if err == datastore.ErrNoResource {
 			server.WriteJSONError(w, server.ErrResourceNotFound)
@@ -339,12 +423,21 @@ func (app *appContext) apiIPHandler(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	err = app.include(r.Context(), date, include, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiIPListHandler(w http.ResponseWriter, r *http.Request) {
 	queryVars := r.URL.Query()
-	_, ipPrefix, err := net.ParseCIDR(queryVars.Get("ipprefix"))
+	ipPrefix, err := parseCIDR("ipprefix", queryVars.Get("ipprefix"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
 	data, err := app.ds.GetIPs(r.Context(), ipPrefix)
 	if err != nil {
@@ -356,14 +449,27 @@ func (app *appContext) apiIPListHandler(w http.ResponseWriter, r *http.Request)
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 
 func (app *appContext) apiZoneHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["zone"])
-	data, err1 := app.ds.GetZone(r.Context(), domain)
+	domain, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	include, err := parseInclude(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err1 := app.getZone(r.Context(), domain, date)
 	if err1 != nil {
 		if err1 == datastore.ErrNoResource {
 			server.WriteJSONError(w, server.ErrResourceNotFound)
@@ -373,18 +479,43 @@ func (app *appContext) apiZoneHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// add some metadata to the zone response
 	importData, err := app.ds.GetZoneImport(r.Context(), domain)
-	if err == nil {
-		// TODO check for datastore.ErrNoResource and sql.NoRows
-		// TODO in fact, make ErrNoResource include? sql.NowRows as well
+	switch err {
+	case nil:
 		data.ImportData = importData
+	case datastore.ErrNoResource:
+		// a zone seen only as a parent has no import
+	default:
+		panic(err)
+	}
+	err = app.include(r.Context(), date, include, data)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiZoneHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	zone := cleanDomain(params["zone"])
-	data, err1 := app.ds.GetZoneHistoryCounts(r.Context(), zone)
+	zone, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	opts, err := countsOptions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// with ?zones= the zone is compared to the other zones
+	if opts.Zones != nil {
+		opts.Zones = append([]string{zone}, opts.Zones...)
+		if opts.Granularity == "" {
+			opts.Granularity = datastore.GranularityWeek
+		}
+		app.writeAllZoneHistoryCounts(w, r, opts)
+		return
+	}
+	data, err1 := app.ds.GetZoneHistoryCounts(r.Context(), zone, opts)
 	if err1 != nil {
 		if err1 == datastore.ErrNoResource {
 			server.WriteJSONError(w, server.ErrResourceNotFound)
@@ -393,33 +524,68 @@ func (app *appContext) apiZoneHistoryCountsHandler(w http.ResponseWriter, r *htt
 		panic(err1)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 func (app *appContext) apiAllZoneHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := app.ds.GetAllZoneHistoryCounts(r.Context())
+	opts, err := countsOptions(r)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		writeError(w, err)
+		return
 	}
+	app.writeAllZoneHistoryCounts(w, r, opts)
+}
 
-	server.WriteJSON(w, data)
+func (app *appContext) writeAllZoneHistoryCounts(w http.ResponseWriter, r *http.Request, opts datastore.CountsOptions) {
+	stream := server.NewStream(w, r, &model.AllZoneCounts{}, "counts")
+	if stream == nil {
+		return
+	}
+	stream.Begin("counts", '{')
+	zone := ""
+	started := false
+	err := app.ds.StreamAllZoneHistoryCounts(r.Context(), opts, func(z string, c *model.ZoneCounts) error {
+		if !started || z != zone {
+			if started {
+				stream.End()
+				stream.End()
+			}
+			started, zone = true, z
+			stream.Begin(zone, '{')
+			stream.Field("zone", zone)
+			stream.Begin("history", '[')
+		}
+		return stream.Write(c, model.CountsRecord(zone, c))
+	})
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
 }
 
 func (app *appContext) apiInternetHistoryCountsHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := app.ds.GetInternetHistoryCounts(r.Context())
+	opts, err := countsOptions(r)
 	if err != nil {
-		if err == datastore.ErrNoResource {
+		writeError(w, err)
+		return
+	}
+	// with ?zones= only the listed zones are returned, each on their own
+	if opts.Zones != nil {
+		if opts.Granularity == "" {
+			opts.Granularity = datastore.GranularityWeek
+		}
+		app.writeAllZoneHistoryCounts(w, r, opts)
+		return
+	}
+	data, err1 := app.ds.GetInternetHistoryCounts(r.Context(), opts)
+	if err1 != nil {
+		if err1 == datastore.ErrNoResource {
 			server.WriteJSONError(w, server.ErrResourceNotFound)
 			return
 		}
-		panic(err)
+		panic(err1)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
 // randomDomainHandler returns a random domain from the system
@@ -428,15 +594,28 @@ func (app *appContext) apiRandomDomainHandler(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		panic(err)
 	}
-	server.WriteJSON(w, domain)
+	server.WriteData(w, r, domain)
 }
 
 // nameserverHandler returns nameserver object for the queried domain
 func (app *appContext) apiNameserverHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
+	domain, err := domainVar(r, "domain")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	include, err := parseInclude(r, "nameserver")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	data, err1 := app.ds.GetNameServer(r.Context(), domain)
+	data, err1 := app.getNameServer(r.Context(), domain, date)
 	if err1 != nil {
 		//TODO combine common code below
 		if err1 == datastore.ErrNoResource {
@@ -447,16 +626,365 @@ func (app *appContext) apiNameserverHandler(w http.ResponseWriter, r *http.Reque
 		panic(err1)
 	}
 
-	server.WriteJSON(w, data)
+	err = app.include(r.Context(), date, include, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	server.WriteData(w, r, data)
+}
+
+// listOptions returns the datastore options for a paginated listing in the given state
+// the page size can be set with ?limit= up to datastore.MaxPageSize
+// and the listing can be made as of a day with ?date=
+func listOptions(r *http.Request, state string) (datastore.ListOptions, error) {
+	opts := datastore.ListOptions{State: state, Page: mux.Vars(r)["page"]}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return opts, invalidParameter("limit", "The limit must be a positive number.")
+		}
+		opts.Limit = limit
+		if opts.Limit > datastore.MaxPageSize {
+			opts.Limit = datastore.MaxPageSize
+		}
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Date = date
+	return opts, nil
+}
+
+// countsOptions reads the ?from=, ?to=, ?granularity= and ?zones= parameters of the counts API
+// zones may be comma separated or repeated, and "." is the root zone
+func countsOptions(r *http.Request) (datastore.CountsOptions, error) {
+	var opts datastore.CountsOptions
+	query := r.URL.Query()
+	for _, field := range []struct {
+		name string
+		date **time.Time
+	}{{"from", &opts.From}, {"to", &opts.To}} {
+		value := query.Get(field.name)
+		if value == "" {
+			continue
+		}
+		date, err := parseDate(field.name, value)
+		if err != nil {
+			return opts, err
+		}
+		*field.date = &date
+	}
+	if opts.From != nil && opts.To != nil && opts.From.After(*opts.To) {
+		return opts, invalidParameter("from", "The from date must not be after the to date.")
+	}
+	opts.Granularity = strings.ToLower(query.Get("granularity"))
+	if opts.Granularity != "" && !datastore.ValidGranularity(opts.Granularity) {
+		return opts, invalidParameter("granularity", "The granularity must be one of day, week, month or year.")
+	}
+	for _, value := range query["zones"] {
+		for _, zone := range strings.Split(value, ",") {
+			zone = strings.TrimSpace(zone)
+			if zone == "" {
+				continue
+			}
+			if zone == "." {
+				zone = ""
+			}
+			zone, err := parseDomain("zones", zone)
+			if err != nil {
+				return opts, err
+			}
+			opts.Zones = append(opts.Zones, zone)
+		}
+	}
+	return opts, nil
+}
+
+// apiDomainNameServersHandler returns a page of the domain's nameservers in state
+func (app *appContext) apiDomainNameServersHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, err := domainVar(r, "domain")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetDomainNameServers(r.Context(), domain, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiZoneNameServersHandler returns a page of the zone's nameservers in state
+func (app *appContext) apiZoneNameServersHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zone, err := domainVar(r, "zone")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetZoneNameServers(r.Context(), zone, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiZoneDomainsHandler returns a page of the domains delegated in the zone in state
+func (app *appContext) apiZoneDomainsHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zone, err := domainVar(r, "zone")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetZoneDomains(r.Context(), zone, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiIPNameServersHandler returns a page of the nameservers using the IP in state
+func (app *appContext) apiIPNameServersHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, err := ipVar(r, "ip")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetIPNameServers(r.Context(), ip, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiNameServerDomainsHandler returns a page of the nameserver's domains in state
+func (app *appContext) apiNameServerDomainsHandler(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nameserver, err := domainVar(r, "domain")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetNameServerDomains(r.Context(), nameserver, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiNameServerIPsHandler returns a page of the nameserver's IPs of version in state
+// version 0 lists both IPv4 and IPv6
+func (app *appContext) apiNameServerIPsHandler(version int, state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nameserver, err := domainVar(r, "domain")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		opts, err := listOptions(r, state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data, err := app.ds.GetNameServerIPs(r.Context(), nameserver, version, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, data)
+	}
+}
+
+// apiDomainTimelineHandler returns the delegation change events of the domain
+func (app *appContext) apiDomainTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	domain, err := domainVar(r, "domain")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := app.ds.GetDomainTimeline(r.Context(), domain)
+	if err != nil {
+		if err == datastore.ErrNoResource {
+			server.WriteJSONError(w, server.ErrResourceNotFound)
+			return
+		}
+		panic(err)
+	}
+
+	server.WriteData(w, r, data)
+}
+
+// apiZoneDiffHandler returns the domains added, removed and moved in the zone between ?from= and ?to=
+// the lists are paginated with ?page= and ?limit=
+func (app *appContext) apiZoneDiffHandler(w http.ResponseWriter, r *http.Request) {
+	zone, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseDate("from", query.Get("from"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	to, err := parseDate("to", query.Get("to"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !from.Before(to) {
+		writeError(w, invalidParameter("from", "The from date must be before the to date."))
+		return
+	}
+	var page, limit int
+	if value := query.Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			writeError(w, invalidParameter("page", "The page must be a positive number."))
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeError(w, invalidParameter("limit", "The limit must be a positive number."))
+			return
+		}
+	}
+	if limit > datastore.MaxPageSize {
+		limit = datastore.MaxPageSize
+	}
+
+	data, err := app.ds.GetZoneDiff(r.Context(), zone, from, to, page, limit)
+	if err != nil {
+		if err == datastore.ErrNoResource {
+			server.WriteJSONError(w, server.ErrResourceNotFound)
+			return
+		}
+		panic(err)
+	}
+
+	server.WriteData(w, r, data)
 }
 
-// API Index handler
-// Displays the map of the API methods available
-func (app *appContext) apiIndex(w http.ResponseWriter, req *http.Request) {
-	// TODO change to use mux.Get API documentation functions
-	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(app.api)
-	if err != nil && err != http.ErrHandlerTimeout {
+// apiImportDayHandler returns the zone imports of the day, limited to {zone} if set
+func (app *appContext) apiImportDayHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	date, err := time.Parse("2006-1-2", fmt.Sprintf("%s-%s-%s", params["year"], params["month"], params["day"]))
+	if err != nil {
+		writeError(w, invalidParameter("day", "The year, month and day must form a valid date."))
+		return
+	}
+	data, err := app.ds.GetImportProgress(r.Context(), date)
+	if err != nil {
 		panic(err)
 	}
+
+	if zoneParam, ok := params["zone"]; ok {
+		zone, err := parseDomain("zone", zoneParam)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		_, err = app.ds.GetZoneID(r.Context(), zone)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		imports := data.Imports[:0]
+		for _, zi := range data.Imports {
+			if zi.Zone == zone {
+				imports = append(imports, zi)
+			}
+		}
+		data.Imports = imports
+		data.Count = len(imports)
+		data.Zone = &zone
+	}
+
+	server.WriteData(w, r, data)
+}
+
+// apiImportHealthHandler returns the import freshness, gaps and anomalies of every zone
+func (app *appContext) apiImportHealthHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.ds.GetImportHealth(r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	server.WriteData(w, r, data)
+}
+
+// apiPrefixesHandler streams the active or available domains for the prefix
+func (app *appContext) apiPrefixesHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := domainVar(r, "prefix")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	name = prefixLabel(name)
+	header := &model.PrefixList{Prefix: name}
+	streamPrefixes := app.ds.StreamAvailablePrefixes
+	switch strings.ToLower(mux.Vars(r)["type"]) {
+	case "active":
+		header.Active = true
+		streamPrefixes = app.ds.StreamTakenPrefixes
+	case "available":
+	default:
+		server.WriteJSONError(w, server.ErrResourceNotFound)
+		return
+	}
+
+	stream := server.NewStream(w, r, header, "domains")
+	if stream == nil {
+		return
+	}
+	stream.Begin("domains", '[')
+	err = streamPrefixes(r.Context(), name, func(d model.PrefixResult) error {
+		return stream.Write(d, header.Record(d))
+	})
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
 }
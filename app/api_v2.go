@@ -0,0 +1,495 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// the v2 API serves the data of v1 as JSON:API documents
+// the resources are those of v1, their relationships are listed at /api/v2/{type}/{id}/{relationship}
+
+// v2Routes adds the v2 API with addAPI
+func (app *appContext) v2Routes(addAPI func(path string, params []string, description string, fn http.HandlerFunc)) {
+	resourceParams := []string{"date={YYYY-MM-DD}", "include={relationship.relationship,...}", "fields[{type}]={field,...}"}
+	listingParams := []string{"filter[state]={current|archive}", "page[size]={size}", "page[cursor]={cursor}", "date={YYYY-MM-DD}"}
+
+	// imports
+	addAPI("/v2/imports/health", nil, "v2_import_health", app.apiV2ImportHealthHandler)
+	addAPI("/v2/imports/{date}", nil, "v2_import_day", app.apiV2ImportDayHandler(false))
+	addAPI("/v2/imports/{date}/root", nil, "v2_import_day_root", app.apiV2ImportDayHandler(true))
+	addAPI("/v2/imports/{date}/{zone}", nil, "v2_import_day_zone", app.apiV2ImportDayHandler(true))
+
+	// counts
+	countsParams := []string{"from={YYYY-MM-DD}", "to={YYYY-MM-DD}", "granularity={day|week|month|year}"}
+	addAPI("/v2/counts/root", countsParams, "v2_root_counts", app.apiV2ZoneCountsHandler)
+	addAPI("/v2/counts/zones/{zone}", countsParams, "v2_zone_counts", app.apiV2ZoneCountsHandler)
+
+	// resources, the root zone has the ID "." and the path /root
+	addAPI("/v2/root", resourceParams, "v2_root", app.apiV2ZoneHandler)
+	addAPI("/v2/zones/{zone}", resourceParams, "v2_zone", app.apiV2ZoneHandler)
+	addAPI("/v2/domains/{domain}", resourceParams, "v2_domain", app.apiV2DomainHandler)
+	addAPI("/v2/nameservers/{nameserver}", resourceParams, "v2_nameserver", app.apiV2NameServerHandler)
+	addAPI("/v2/ip/{ip}", resourceParams, "v2_ip", app.apiV2IPHandler)
+
+	// relationships
+	addAPI("/v2/root/nameservers", listingParams, "v2_root_nameservers", app.apiV2ListingHandler("zone", app.v2ZoneNameServers))
+	addAPI("/v2/zones/{zone}/nameservers", listingParams, "v2_zone_nameservers", app.apiV2ListingHandler("zone", app.v2ZoneNameServers))
+	addAPI("/v2/domains/{domain}/nameservers", listingParams, "v2_domain_nameservers", app.apiV2ListingHandler("domain", app.v2DomainNameServers))
+	addAPI("/v2/nameservers/{nameserver}/domains", listingParams, "v2_nameserver_domains", app.apiV2ListingHandler("nameserver", app.v2NameServerDomains))
+	addAPI("/v2/nameservers/{nameserver}/ipv4", listingParams, "v2_nameserver_ipv4", app.apiV2ListingHandler("nameserver", app.v2NameServerIPs(4)))
+	addAPI("/v2/nameservers/{nameserver}/ipv6", listingParams, "v2_nameserver_ipv6", app.apiV2ListingHandler("nameserver", app.v2NameServerIPs(6)))
+	addAPI("/v2/ip/{ip}/nameservers", listingParams, "v2_ip_nameservers", app.apiV2ListingHandler("ip", app.v2IPNameServers))
+
+	// feeds
+	for _, change := range []string{"new", "old", "moved"} {
+		addAPI("/v2/feeds/"+change+"/search/{search}", nil, "v2_feeds_"+change+"_search", app.apiV2FeedsSearchHandler(change))
+	}
+
+	// search
+	addAPI("/v2/search", []string{"query={name}", "type={zone|domain|nameserver|ip}"}, "v2_search", app.apiV2SearchHandler)
+}
+
+// v2Var returns the name of the resource of type kind in its route variable
+// the routes of the root zone have no variable, and so the empty name of the root
+func v2Var(r *http.Request, kind string) (string, error) {
+	if kind == "ip" {
+		return ipVar(r, "ip")
+	}
+	return domainVar(r, kind)
+}
+
+// v2ListOptions returns the datastore options of a v2 relationship listing
+// ?filter[state]= is current or archive, ?page[size]= is up to datastore.MaxPageSize and ?page[cursor]= is the page
+func v2ListOptions(r *http.Request) (datastore.ListOptions, error) {
+	query := r.URL.Query()
+	opts := datastore.ListOptions{Page: query.Get("page[cursor]")}
+	switch state := query.Get("filter[state]"); state {
+	case "", datastore.StateCurrent, datastore.StateArchive:
+		opts.State = state
+	default:
+		return opts, invalidParameter("filter[state]", "The state must be current or archive.")
+	}
+	if value := query.Get("page[size]"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return opts, invalidParameter("page[size]", "The page size must be a positive number.")
+		}
+		opts.Limit = size
+		if opts.Limit > datastore.MaxPageSize {
+			opts.Limit = datastore.MaxPageSize
+		}
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Date = date
+	return opts, nil
+}
+
+// apiV2ResourceHandler returns the v2 document of the resource of type kind loaded by get
+// the relationships of ?include= are loaded and added to the included resources
+func (app *appContext) apiV2ResourceHandler(kind string, get func(ctx context.Context, name string, date *time.Time) (model.APIData, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, err := v2Var(r, kind)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		date, err := dateParam(r)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		include, err := parseInclude(r, kind)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		data, err := get(r.Context(), name, date)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		err = app.include(r.Context(), date, include, data)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+
+		inc := newInclusion()
+		var res *model.Resource
+		switch v := data.(type) {
+		case *model.Domain:
+			res = v.Resource()
+			inc.seen[res.Type+"/"+res.ID] = true
+			inc.domains([]*model.Domain{v}, include)
+		case *model.Zone:
+			res = v.Resource()
+			inc.seen[res.Type+"/"+res.ID] = true
+			inc.zones([]*model.Zone{v}, include)
+		case *model.NameServer:
+			res = v.Resource()
+			inc.seen[res.Type+"/"+res.ID] = true
+			inc.nameservers([]*model.NameServer{v}, include)
+		case *model.IP:
+			res = v.Resource()
+			inc.seen[res.Type+"/"+res.ID] = true
+			inc.ips([]*model.IP{v}, include)
+		}
+		doc := model.NewDocument(res)
+		doc.Included = inc.resources
+		doc.Links = &model.Links{Self: res.Links.Self}
+		server.WriteDocument(w, r, doc)
+	}
+}
+
+func (app *appContext) apiV2DomainHandler(w http.ResponseWriter, r *http.Request) {
+	app.apiV2ResourceHandler("domain", func(ctx context.Context, name string, date *time.Time) (model.APIData, error) {
+		return app.getDomain(ctx, name, date)
+	})(w, r)
+}
+
+func (app *appContext) apiV2ZoneHandler(w http.ResponseWriter, r *http.Request) {
+	app.apiV2ResourceHandler("zone", func(ctx context.Context, name string, date *time.Time) (model.APIData, error) {
+		zone, err := app.getZone(ctx, name, date)
+		if err != nil {
+			return nil, err
+		}
+		importData, err := app.ds.GetZoneImport(ctx, name)
+		if err == nil {
+			zone.ImportData = importData
+		}
+		return zone, nil
+	})(w, r)
+}
+
+func (app *appContext) apiV2NameServerHandler(w http.ResponseWriter, r *http.Request) {
+	app.apiV2ResourceHandler("nameserver", func(ctx context.Context, name string, date *time.Time) (model.APIData, error) {
+		return app.getNameServer(ctx, name, date)
+	})(w, r)
+}
+
+func (app *appContext) apiV2IPHandler(w http.ResponseWriter, r *http.Request) {
+	app.apiV2ResourceHandler("ip", func(ctx context.Context, name string, date *time.Time) (model.APIData, error) {
+		return app.getIP(ctx, name, date)
+	})(w, r)
+}
+
+// inclusion collects the resources of the included relationships once each
+type inclusion struct {
+	seen      map[string]bool
+	resources []*model.Resource
+}
+
+func newInclusion() *inclusion {
+	return &inclusion{seen: make(map[string]bool), resources: make([]*model.Resource, 0)}
+}
+
+// add adds the resource unless it is already included, as the requested resource is
+func (inc *inclusion) add(res *model.Resource) {
+	key := res.Type + "/" + res.ID
+	if inc.seen[key] {
+		return
+	}
+	inc.seen[key] = true
+	inc.resources = append(inc.resources, res)
+}
+
+// the walks below follow the includeTree over the relationships the includer loaded
+
+func (inc *inclusion) domains(domains []*model.Domain, tree includeTree) {
+	for _, d := range domains {
+		inc.add(d.Resource())
+	}
+	for _, name := range tree.names() {
+		switch name {
+		case "nameservers", "archive_nameservers":
+			related := make([]*model.NameServer, 0)
+			for _, d := range domains {
+				if name == "nameservers" {
+					related = append(related, d.NameServers...)
+				} else {
+					related = append(related, d.ArchiveNameServers...)
+				}
+			}
+			inc.nameservers(related, tree[name])
+		case "zone":
+			for _, d := range domains {
+				if d.Zone != nil {
+					inc.add(d.Zone.Resource())
+				}
+			}
+		}
+	}
+}
+
+func (inc *inclusion) zones(zones []*model.Zone, tree includeTree) {
+	for _, name := range tree.names() {
+		related := make([]*model.NameServer, 0)
+		for _, z := range zones {
+			if name == "nameservers" {
+				related = append(related, z.NameServers...)
+			} else {
+				related = append(related, z.ArchiveNameServers...)
+			}
+		}
+		inc.nameservers(related, tree[name])
+	}
+}
+
+func (inc *inclusion) nameservers(nameservers []*model.NameServer, tree includeTree) {
+	for _, ns := range nameservers {
+		inc.add(ns.Resource())
+	}
+	for _, name := range tree.names() {
+		switch name {
+		case "domains", "archive_domains":
+			related := make([]*model.Domain, 0)
+			for _, ns := range nameservers {
+				if name == "domains" {
+					related = append(related, ns.Domains...)
+				} else {
+					related = append(related, ns.ArchiveDomains...)
+				}
+			}
+			inc.domains(related, tree[name])
+		default:
+			related := make([]*model.IP, 0)
+			for _, ns := range nameservers {
+				switch name {
+				case "ipv4":
+					related = append(related, model.IP4s(ns.IP4)...)
+				case "archive_ipv4":
+					related = append(related, model.IP4s(ns.ArchiveIP4)...)
+				case "ipv6":
+					related = append(related, model.IP6s(ns.IP6)...)
+				case "archive_ipv6":
+					related = append(related, model.IP6s(ns.ArchiveIP6)...)
+				}
+			}
+			inc.ips(related, tree[name])
+		}
+	}
+}
+
+func (inc *inclusion) ips(ips []*model.IP, tree includeTree) {
+	for _, ip := range ips {
+		inc.add(ip.Resource())
+	}
+	for _, name := range tree.names() {
+		related := make([]*model.NameServer, 0)
+		for _, ip := range ips {
+			if name == "nameservers" {
+				related = append(related, ip.NameServers...)
+			} else {
+				related = append(related, ip.ArchiveNameServers...)
+			}
+		}
+		inc.nameservers(related, tree[name])
+	}
+}
+
+// v2Listing lists a page of a relationship of the named resource
+type v2Listing func(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error)
+
+// apiV2ListingHandler returns a page of a relationship of the resource of type kind as a v2 document
+func (app *appContext) apiV2ListingHandler(kind string, list v2Listing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, err := v2Var(r, kind)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		opts, err := v2ListOptions(r)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		p, resources, err := list(r.Context(), name, opts)
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, model.V2Prefix)
+		server.WriteDocument(w, r, model.ListingDocument(p, resources, path, r.URL.Query()))
+	}
+}
+
+func nameServerResources(nameservers []*model.NameServer) []*model.Resource {
+	out := make([]*model.Resource, 0, len(nameservers))
+	for _, ns := range nameservers {
+		out = append(out, ns.Resource())
+	}
+	return out
+}
+
+func (app *appContext) v2DomainNameServers(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error) {
+	data, err := app.ds.GetDomainNameServers(ctx, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &data.Pagination, nameServerResources(data.NameServers), nil
+}
+
+func (app *appContext) v2ZoneNameServers(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error) {
+	data, err := app.ds.GetZoneNameServers(ctx, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &data.Pagination, nameServerResources(data.NameServers), nil
+}
+
+func (app *appContext) v2IPNameServers(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error) {
+	data, err := app.ds.GetIPNameServers(ctx, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &data.Pagination, nameServerResources(data.NameServers), nil
+}
+
+func (app *appContext) v2NameServerDomains(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error) {
+	data, err := app.ds.GetNameServerDomains(ctx, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	resources := make([]*model.Resource, 0, len(data.Domains))
+	for _, d := range data.Domains {
+		resources = append(resources, d.Resource())
+	}
+	return &data.Pagination, resources, nil
+}
+
+func (app *appContext) v2NameServerIPs(version int) v2Listing {
+	return func(ctx context.Context, name string, opts datastore.ListOptions) (*model.Pagination, []*model.Resource, error) {
+		data, err := app.ds.GetNameServerIPs(ctx, name, version, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		resources := make([]*model.Resource, 0, len(data.IPs))
+		for _, ip := range data.IPs {
+			ip.Version = version
+			resources = append(resources, ip.Resource())
+		}
+		return &data.Pagination, resources, nil
+	}
+}
+
+func (app *appContext) apiV2ImportHealthHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.ds.GetImportHealth(r.Context())
+	if err != nil {
+		panic(err)
+	}
+	server.WriteDocument(w, r, data.Document())
+}
+
+// apiV2ImportDayHandler returns the zone imports of {date}, limited to {zone} or the root when byZone is set
+func (app *appContext) apiV2ImportDayHandler(byZone bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date, err := dateVar(r, "date")
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		data, err := app.ds.GetImportProgress(r.Context(), date)
+		if err != nil {
+			panic(err)
+		}
+		if byZone {
+			zone, err := v2Var(r, "zone")
+			if err != nil {
+				writeDocumentError(w, err)
+				return
+			}
+			_, err = app.ds.GetZoneID(r.Context(), zone)
+			if err != nil {
+				writeDocumentError(w, err)
+				return
+			}
+			imports := data.Imports[:0]
+			for _, zi := range data.Imports {
+				if zi.Zone == zone {
+					imports = append(imports, zi)
+				}
+			}
+			data.Imports = imports
+			data.Count = len(imports)
+			data.Zone = &zone
+		}
+		server.WriteDocument(w, r, data.Document())
+	}
+}
+
+func (app *appContext) apiV2ZoneCountsHandler(w http.ResponseWriter, r *http.Request) {
+	zone, err := v2Var(r, "zone")
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	opts, err := countsOptions(r)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	data, err := app.ds.GetZoneHistoryCounts(r.Context(), zone, opts)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	server.WriteDocument(w, r, data.Document(r.URL.Query()))
+}
+
+// apiV2FeedsSearchHandler returns the daily counts of the domains matching {search} in the feed of change
+func (app *appContext) apiV2FeedsSearchHandler(change string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count := app.ds.GetNewFeedCount
+		switch change {
+		case "old":
+			count = app.ds.GetOldFeedCount
+		case "moved":
+			count = app.ds.GetMovedFeedCount
+		}
+		data, err := count(r.Context(), strings.ToLower(mux.Vars(r)["search"]))
+		if err != nil {
+			writeDocumentError(w, err)
+			return
+		}
+		server.WriteDocument(w, r, data.Document())
+	}
+}
+
+// apiV2SearchHandler returns the zone, domain, nameserver and IP named ?query=, of ?type= if set
+func (app *appContext) apiV2SearchHandler(w http.ResponseWriter, r *http.Request) {
+	var s model.Search
+	var err error
+	s.Query, err = parseSearch("query", r.URL.Query().Get("query"))
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	s.Type = strings.ToLower(r.URL.Query().Get("type"))
+	switch s.Type {
+	case "", "zone", "domain", "nameserver", "ip":
+	default:
+		writeDocumentError(w, invalidParameter("type", "The type must be zone, domain, nameserver or ip."))
+		return
+	}
+	s.Results = make([]model.SearchResult, 0)
+	if s.Query != "" {
+		for _, result := range app.exactMatches(r.Context(), s.Query) {
+			if s.Type == "" || strings.EqualFold(result.Type, s.Type) {
+				s.Results = append(s.Results, result)
+			}
+		}
+	}
+	server.WriteDocument(w, r, s.Document())
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+)
+
+// dateParam returns the day set with ?date=YYYY-MM-DD, or nil if there is none
+func dateParam(r *http.Request) (*time.Time, error) {
+	value := r.URL.Query().Get("date")
+	if value == "" {
+		return nil, nil
+	}
+	date, err := parseDate("date", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// getDomain gets the domain as of date, or the current domain if date is nil
+func (app *appContext) getDomain(ctx context.Context, domain string, date *time.Time) (*model.Domain, error) {
+	if date == nil {
+		return app.ds.GetDomain(ctx, domain)
+	}
+	return datastore.GetDomainOn(ctx, app.ds, domain, *date)
+}
+
+// getZone gets the zone as of date, or the current zone if date is nil
+func (app *appContext) getZone(ctx context.Context, zone string, date *time.Time) (*model.Zone, error) {
+	if date == nil {
+		return app.ds.GetZone(ctx, zone)
+	}
+	return datastore.GetZoneOn(ctx, app.ds, zone, *date)
+}
+
+// getIP gets the IP as of date, or the current IP if date is nil
+func (app *appContext) getIP(ctx context.Context, ip string, date *time.Time) (*model.IP, error) {
+	if date == nil {
+		return app.ds.GetIP(ctx, ip)
+	}
+	return datastore.GetIPOn(ctx, app.ds, ip, *date)
+}
+
+// getNameServer gets the nameserver as of date, or the current nameserver if date is nil
+func (app *appContext) getNameServer(ctx context.Context, nameserver string, date *time.Time) (*model.NameServer, error) {
+	if date == nil {
+		return app.ds.GetNameServer(ctx, nameserver)
+	}
+	return datastore.GetNameServerOn(ctx, app.ds, nameserver, *date)
+}
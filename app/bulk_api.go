@@ -0,0 +1,212 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// limits of the bulk lookups
+const (
+	maxBulkNames = 10000
+	// enough for maxBulkNames of the longest domain names as a JSON array
+	maxBulkBody = maxBulkNames * 260
+)
+
+// the kinds of bulk lookups
+const (
+	bulkDomains     = "domains"
+	bulkNameServers = "nameservers"
+	bulkIPs         = "ip"
+)
+
+// readBulkNames reads the names of a bulk lookup from the request body
+// the body is a JSON array of names, or a name per line with blank lines and # comments left out
+func readBulkNames(r *http.Request) ([]string, *model.JSONError) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBulkBody+1))
+	if err != nil {
+		return nil, server.ErrBadRequest
+	}
+	if len(body) > maxBulkBody {
+		return nil, server.ErrRequestTooLarge
+	}
+	body = bytes.TrimSpace(body)
+	var names []string
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &names)
+		if err != nil {
+			return nil, server.ErrBadRequest
+		}
+	} else {
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				names = append(names, line)
+			}
+		}
+	}
+	if len(names) > maxBulkNames {
+		return nil, server.ErrRequestTooLarge
+	}
+	return names, nil
+}
+
+// apiBulkHandler looks up every name of the request body as a domain, nameserver or IP depending on kind
+// each result is in the order of the names, once per name
+func (app *appContext) apiBulkHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names, jsonErr := readBulkNames(r)
+		if jsonErr != nil {
+			server.WriteJSONError(w, jsonErr)
+			return
+		}
+
+		// the valid names to look up, the results of the others say why they are not valid
+		results := make([]*model.BulkResult, 0, len(names))
+		byName := make(map[string]*model.BulkResult, len(names))
+		lookup := make([]string, 0, len(names))
+		for _, name := range names {
+			var clean string
+			var err error
+			if kind == bulkIPs {
+				clean, err = parseIP("ip", strings.TrimSpace(name))
+			} else {
+				clean, err = parseDomain("name", name)
+			}
+			if err == nil {
+				if _, ok := byName[clean]; ok {
+					continue
+				}
+			}
+			result := &model.BulkResult{Name: name}
+			results = append(results, result)
+			if err != nil {
+				var validationErr *datastore.ValidationError
+				if !errors.As(err, &validationErr) {
+					panic(err)
+				}
+				result.Error = validationErr.Detail
+				continue
+			}
+			result.Name = clean
+			byName[clean] = result
+			lookup = append(lookup, clean)
+		}
+
+		var err error
+		switch kind {
+		case bulkDomains:
+			err = app.bulkDomains(r.Context(), lookup, byName)
+		case bulkNameServers:
+			err = app.bulkNameServers(r.Context(), lookup, byName)
+		case bulkIPs:
+			err = app.bulkIPs(r.Context(), lookup, byName)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		server.WriteData(w, r, &model.BulkLookup{Kind: kind, Results: results})
+	}
+}
+
+// currentRelationships are the options of the current relationships of the bulk results
+var currentRelationships = datastore.ListOptions{State: datastore.StateCurrent, Limit: includeLimit}
+
+func nameServerNames(nameservers []*model.NameServer) []string {
+	out := make([]string, 0, len(nameservers))
+	for _, ns := range nameservers {
+		out = append(out, ns.Name)
+	}
+	return out
+}
+
+func (app *appContext) bulkDomains(ctx context.Context, names []string, results map[string]*model.BulkResult) error {
+	domains, err := app.ds.GetDomainsByName(ctx, names)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(domains))
+	for _, d := range domains {
+		ids = append(ids, d.ID)
+	}
+	nameservers, err := app.ds.GetDomainsNameServers(ctx, ids, currentRelationships)
+	if err != nil {
+		return err
+	}
+	for name, d := range domains {
+		result := results[name]
+		result.Found = true
+		result.FirstSeen, result.LastSeen = d.FirstSeen, d.LastSeen
+		result.Zone = &d.Zone.Name
+		result.NameServers = nameServerNames(nameservers[d.ID])
+	}
+	return nil
+}
+
+func (app *appContext) bulkNameServers(ctx context.Context, names []string, results map[string]*model.BulkResult) error {
+	nameservers, err := app.ds.GetNameServersByName(ctx, names)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(nameservers))
+	for _, ns := range nameservers {
+		ids = append(ids, ns.ID)
+	}
+	ips := make(map[int]map[int64][]*model.IP)
+	for _, version := range []int{4, 6} {
+		ips[version], err = app.ds.GetNameServersIPs(ctx, ids, version, currentRelationships)
+		if err != nil {
+			return err
+		}
+	}
+	for name, ns := range nameservers {
+		result := results[name]
+		result.Found = true
+		result.FirstSeen, result.LastSeen = ns.FirstSeen, ns.LastSeen
+		if ns.Zone != nil {
+			result.Zone = &ns.Zone.Name
+		}
+		result.IPs = make([]string, 0)
+		for _, version := range []int{4, 6} {
+			for _, ip := range ips[version][ns.ID] {
+				result.IPs = append(result.IPs, ip.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (app *appContext) bulkIPs(ctx context.Context, names []string, results map[string]*model.BulkResult) error {
+	ips, err := app.ds.GetIPsByName(ctx, names)
+	if err != nil {
+		return err
+	}
+	ids := map[int][]int64{4: {}, 6: {}}
+	for _, ip := range ips {
+		ids[ip.Version] = append(ids[ip.Version], ip.ID)
+	}
+	nameservers := make(map[int]map[int64][]*model.NameServer)
+	for _, version := range []int{4, 6} {
+		nameservers[version], err = app.ds.GetIPsNameServers(ctx, ids[version], version, currentRelationships)
+		if err != nil {
+			return err
+		}
+	}
+	for name, ip := range ips {
+		result := results[name]
+		result.Found = true
+		result.FirstSeen, result.LastSeen = ip.FirstSeen, ip.LastSeen
+		result.NameServers = nameServerNames(nameservers[ip.Version][ip.ID])
+	}
+	return nil
+}
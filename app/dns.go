@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+
+	"github.com/miekg/dns"
+)
+
+// the DNS frontend answers NS queries for domains and zones, and A and AAAA queries for nameservers
+// like an authoritative server would from the zone files of a day, today unless a date is asked for
+// the date is either a first label of the form YYYYMMDD, as in 20200315.example.com, or the EDNS option dnsAsOfOption
+
+// dnsAsOfOption is the code of the EDNS option holding the date as YYYYMMDD, from the local use range
+const dnsAsOfOption = dns.EDNS0LOCALSTART
+
+const (
+	// the answers of today change, so they are not cached for long
+	dnsTTL = 300
+	// the time to answer a query in
+	dnsTimeout = 5 * time.Second
+)
+
+// StartDNS answers DNS queries on addr over UDP and TCP from ds
+// it blocks until one of the listeners fails
+func StartDNS(ds datastore.DataStore, addr string) error {
+	handler := &dnsHandler{ds: ds}
+	errs := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: network, Handler: handler}
+		go func() {
+			errs <- srv.ListenAndServe()
+		}()
+	}
+	return <-errs
+}
+
+// dnsHandler answers the DNS queries
+type dnsHandler struct {
+	ds datastore.DataStore
+}
+
+// dnsQuery is the name and date asked for by a question
+type dnsQuery struct {
+	name string
+	date *time.Time
+}
+
+// parseDNSQuery returns the name and date of the question
+// the date label is only taken for a name below it, and when it is a valid date
+func parseDNSQuery(req *dns.Msg) dnsQuery {
+	name := strings.ToLower(strings.TrimSuffix(req.Question[0].Name, "."))
+	q := dnsQuery{name: name}
+	labels := strings.SplitN(name, ".", 2)
+	if len(labels) == 2 && len(labels[0]) == 8 {
+		date, err := time.Parse("20060102", labels[0])
+		if err == nil {
+			q.name, q.date = labels[1], &date
+			return q
+		}
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			local, ok := option.(*dns.EDNS0_LOCAL)
+			if !ok || local.Code != dnsAsOfOption {
+				continue
+			}
+			date, err := time.Parse("20060102", string(local.Data))
+			if err == nil {
+				q.date = &date
+			}
+		}
+	}
+	return q
+}
+
+// ServeDNS answers a query
+func (h *dnsHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	switch {
+	case req.Opcode != dns.OpcodeQuery:
+		resp.Rcode = dns.RcodeNotImplemented
+	case len(req.Question) != 1:
+		resp.Rcode = dns.RcodeFormatError
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+		defer cancel()
+		err := h.answer(ctx, req, resp)
+		switch err {
+		case nil:
+		case datastore.ErrNoResource:
+			resp.Rcode = dns.RcodeNameError
+		default:
+			log.Printf("dns: %s %s: %s", dns.TypeToString[req.Question[0].Qtype], req.Question[0].Name, err)
+			resp.Rcode = dns.RcodeServerFailure
+		}
+	}
+
+	// UDP answers are truncated to the buffer size of the client
+	size := dns.MinMsgSize
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		size = dns.MaxMsgSize
+	} else if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	if req.IsEdns0() != nil {
+		resp.SetEdns0(dns.DefaultMsgSize, false)
+	}
+	resp.Truncate(size)
+	err := w.WriteMsg(resp)
+	if err != nil {
+		log.Printf("dns: unable to write answer: %s", err)
+	}
+}
+
+// answer fills resp with the records of the question of req
+// other types than NS, A and AAAA have no records
+func (h *dnsHandler) answer(ctx context.Context, req *dns.Msg, resp *dns.Msg) error {
+	question := req.Question[0]
+	q := parseDNSQuery(req)
+	switch question.Qtype {
+	case dns.TypeNS:
+		nameservers, err := h.nameServers(ctx, q)
+		if err != nil {
+			return err
+		}
+		ids := make([]int64, 0, len(nameservers))
+		for _, ns := range nameservers {
+			resp.Answer = append(resp.Answer, &dns.NS{Hdr: dnsHeader(question.Name, dns.TypeNS), Ns: dns.Fqdn(ns.Name)})
+			ids = append(ids, ns.ID)
+		}
+		// glue, loaded for all the nameservers at once
+		for _, version := range []int{4, 6} {
+			ips, err := h.ds.GetNameServersIPs(ctx, ids, version, dnsListOptions(q))
+			if err != nil {
+				return err
+			}
+			for _, ns := range nameservers {
+				for _, ip := range ips[ns.ID] {
+					resp.Extra = append(resp.Extra, dnsAddress(dns.Fqdn(ns.Name), ip))
+				}
+			}
+		}
+	case dns.TypeA, dns.TypeAAAA:
+		version := 4
+		if question.Qtype == dns.TypeAAAA {
+			version = 6
+		}
+		l, err := h.ds.GetNameServerIPs(ctx, q.name, version, dnsListOptions(q))
+		if err != nil {
+			return err
+		}
+		for _, ip := range l.IPs {
+			resp.Answer = append(resp.Answer, dnsAddress(question.Name, ip))
+		}
+	}
+	return nil
+}
+
+// nameServers returns the nameservers of the domain, or of the zone if there is no such domain
+func (h *dnsHandler) nameServers(ctx context.Context, q dnsQuery) ([]*model.NameServer, error) {
+	domain, err := h.ds.GetDomainNameServers(ctx, q.name, dnsListOptions(q))
+	if err == nil {
+		return domain.NameServers, nil
+	}
+	if err != datastore.ErrNoResource {
+		return nil, err
+	}
+	zone, err := h.ds.GetZoneNameServers(ctx, q.name, dnsListOptions(q))
+	if err != nil {
+		return nil, err
+	}
+	return zone.NameServers, nil
+}
+
+// dnsListOptions lists the current relationships on the date of the query
+func dnsListOptions(q dnsQuery) datastore.ListOptions {
+	return datastore.ListOptions{State: datastore.StateCurrent, Date: q.date, Limit: includeLimit}
+}
+
+func dnsHeader(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: dnsTTL}
+}
+
+// dnsAddress returns the A or AAAA record of the IP
+func dnsAddress(name string, ip *model.IP) dns.RR {
+	if ip.Version == 6 {
+		return &dns.AAAA{Hdr: dnsHeader(name, dns.TypeAAAA), AAAA: *ip.IP}
+	}
+	return &dns.A{Hdr: dnsHeader(name, dns.TypeA), A: *ip.IP}
+}
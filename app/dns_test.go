@@ -0,0 +1,107 @@
+package app
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"dnscoffee/datastore"
+
+	"github.com/miekg/dns"
+)
+
+// testDNS starts the DNS frontend of the sample fixture on a local UDP port
+// returns its address and the function stopping it
+func testDNS(t *testing.T) (string, func()) {
+	ds, err := datastore.NewMemory("../fixtures/sample.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: &dnsHandler{ds: ds}, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { srv.Shutdown() }
+}
+
+// testExchange asks the question and returns the rcode and the sorted answer and additional records
+func testExchange(t *testing.T, addr string, m *dns.Msg) (int, []string, []string) {
+	resp, err := dns.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := func(rrs []dns.RR) []string {
+		out := make([]string, 0, len(rrs))
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			out = append(out, strings.Replace(rr.String(), "\t", " ", -1))
+		}
+		sort.Strings(out)
+		return out
+	}
+	return resp.Rcode, records(resp.Answer), records(resp.Extra)
+}
+
+func TestDNS(t *testing.T) {
+	addr, stop := testDNS(t)
+	defer stop()
+
+	asOf := new(dns.Msg).SetQuestion("shop.com.", dns.TypeNS)
+	asOf.SetEdns0(dns.DefaultMsgSize, false)
+	asOf.IsEdns0().Option = append(asOf.IsEdns0().Option, &dns.EDNS0_LOCAL{Code: dnsAsOfOption, Data: []byte("20200201")})
+
+	tests := []struct {
+		name   string
+		m      *dns.Msg
+		rcode  int
+		answer []string
+		extra  []string
+	}{
+		{
+			name:   "current nameservers",
+			m:      new(dns.Msg).SetQuestion("shop.com.", dns.TypeNS),
+			answer: []string{"shop.com. 300 IN NS ns-1.awsdns-01.org.", "shop.com. 300 IN NS ns-2.awsdns-02.net."},
+		},
+		{
+			name:   "nameservers as of a date label",
+			m:      new(dns.Msg).SetQuestion("20200201.shop.com.", dns.TypeNS),
+			answer: []string{"20200201.shop.com. 300 IN NS ns1.domaincontrol.com.", "20200201.shop.com. 300 IN NS ns2.domaincontrol.com."},
+			extra:  []string{"ns1.domaincontrol.com. 300 IN A 97.74.100.1", "ns2.domaincontrol.com. 300 IN A 173.201.68.1"},
+		},
+		{
+			name:   "nameservers as of the EDNS option",
+			m:      asOf,
+			answer: []string{"shop.com. 300 IN NS ns1.domaincontrol.com.", "shop.com. 300 IN NS ns2.domaincontrol.com."},
+			extra:  []string{"ns1.domaincontrol.com. 300 IN A 97.74.100.1", "ns2.domaincontrol.com. 300 IN A 173.201.68.1"},
+		},
+		{
+			name:   "nameserver address",
+			m:      new(dns.Msg).SetQuestion("ns1.domaincontrol.com.", dns.TypeA),
+			answer: []string{"ns1.domaincontrol.com. 300 IN A 97.74.100.1"},
+		},
+		{
+			name:  "unknown domain",
+			m:     new(dns.Msg).SetQuestion("nope.com.", dns.TypeNS),
+			rcode: dns.RcodeNameError,
+		},
+	}
+	for _, test := range tests {
+		rcode, answer, extra := testExchange(t, addr, test.m)
+		if rcode != test.rcode {
+			t.Errorf("%s: rcode %s, want %s", test.name, dns.RcodeToString[rcode], dns.RcodeToString[test.rcode])
+		}
+		if strings.Join(answer, "\n") != strings.Join(test.answer, "\n") {
+			t.Errorf("%s: answer\n%s\nwant\n%s", test.name, strings.Join(answer, "\n"), strings.Join(test.answer, "\n"))
+		}
+		if test.extra != nil && strings.Join(extra, "\n") != strings.Join(test.extra, "\n") {
+			t.Errorf("%s: additional\n%s\nwant\n%s", test.name, strings.Join(extra, "\n"), strings.Join(test.extra, "\n"))
+		}
+	}
+}
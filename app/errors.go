@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// maxSuggestions limits the similar names shown on the not found page
+const maxSuggestions = 10
+
+// ErrorPage holds the data of the HTML error pages
+// Name is the name that was not found, and Suggestions are similar names that exist
+type ErrorPage struct {
+	Error       *model.JSONError
+	Name        string
+	Suggestions []model.SearchResult
+}
+
+// renderError renders the error page of jsonErr
+func (app *appContext) renderError(w io.Writer, jsonErr *model.JSONError, data ErrorPage) error {
+	data.Error = jsonErr
+	p := Page{jsonErr.Title, "", data}
+	return app.templates.ExecuteTemplate(w, "error.tmpl", p)
+}
+
+// errorPage renders the error pages of the server, which know nothing about the request
+func (app *appContext) errorPage(w io.Writer, jsonErr *model.JSONError) error {
+	return app.renderError(w, jsonErr, ErrorPage{})
+}
+
+// writeErrorPage writes the error page of jsonErr with its status
+func (app *appContext) writeErrorPage(w http.ResponseWriter, jsonErr *model.JSONError, data ErrorPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(jsonErr.Status)
+	err := app.renderError(w, jsonErr, data)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writeWebError writes the HTML error page for the errors returned by validation and the datastore
+// the not found page suggests names similar to name of kind, one of the search types
+// unknown errors are server faults and passed on to the recovery handler
+func (app *appContext) writeWebError(w http.ResponseWriter, r *http.Request, err error, kind, name string) {
+	var validationErr *datastore.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		app.writeErrorPage(w, server.InvalidParameter(validationErr.Parameter, validationErr.Detail), ErrorPage{})
+	case err == datastore.ErrNoResource:
+		app.writeErrorPage(w, server.ErrResourceNotFound, ErrorPage{Name: name, Suggestions: app.suggest(r.Context(), kind, name)})
+	case err == datastore.ErrInvalidCursor:
+		app.writeErrorPage(w, server.ErrInvalidPage, ErrorPage{})
+	default:
+		panic(err)
+	}
+}
+
+// suggest returns existing names similar to name of kind
+// domains suggest the same label in other zones, nameservers their domain, and zones the zones with a close name
+// the suggestions are a best effort, so datastore errors are ignored
+func (app *appContext) suggest(ctx context.Context, kind, name string) []model.SearchResult {
+	out := make([]model.SearchResult, 0, maxSuggestions)
+	switch kind {
+	case "domain":
+		labels := strings.SplitN(name, ".", 2)
+		if len(labels) != 2 || labels[0] == "" {
+			break
+		}
+		prefixes, err := app.ds.GetTakenPrefixes(ctx, labels[0])
+		if err != nil {
+			break
+		}
+		for _, d := range prefixes.Domains {
+			if d.Domain != name && len(out) < maxSuggestions {
+				out = append(out, model.SearchResult{Name: d.Domain, Link: "/domains/" + d.Domain, Type: kind})
+			}
+		}
+	case "nameserver":
+		labels := strings.SplitN(name, ".", 2)
+		if len(labels) != 2 {
+			break
+		}
+		if _, _, err := app.ds.GetDomainID(ctx, labels[1]); err == nil {
+			out = append(out, model.SearchResult{Name: labels[1], Link: "/domains/" + labels[1], Type: "domain"})
+		}
+	case "zone":
+		zones, err := app.ds.GetZoneImportResults(ctx)
+		if err != nil {
+			break
+		}
+		for _, z := range zones.Zones {
+			if z.Zone != "" && z.Zone != name && (strings.HasPrefix(z.Zone, name) || strings.HasPrefix(name, z.Zone) || editDistance(z.Zone, name) <= 1) {
+				out = append(out, model.SearchResult{Name: z.Zone, Link: "/zones/" + z.Zone, Type: kind})
+			}
+		}
+		sort.Slice(out, func(i, j int) bool { return editDistance(out[i].Name, name) < editDistance(out[j].Name, name) })
+		if len(out) > maxSuggestions {
+			out = out[:maxSuggestions]
+		}
+	}
+	return out
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,1078 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/psl"
+	"dnscoffee/server"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// the GraphQL API mirrors the zones, domains, nameservers and IPs of the model
+// relationships are connections taking the state and date of the listings and a cursor
+// the first page of a connection is loaded for all of its siblings with the batched loaders,
+// the totals, page info and later pages come from the paginated listing of each object
+
+// limits of the GraphQL queries
+// every object a connection can return costs 1, and every paginated listing graphQLListingCost
+// the objects are charged at the page size before they are loaded, for every object of the group of a batched load
+const (
+	maxGraphQLBody  = 64 << 10
+	maxGraphQLDepth = 15
+	maxGraphQLCost  = 10000
+	// a paginated listing is a query for a single object
+	graphQLListingCost = 10
+)
+
+const graphQLSchemaSDL = `
+schema {
+	query: Query
+}
+
+"A day, formatted as YYYY-MM-DD"
+scalar Date
+
+"CURRENT relationships are active on the day, ARCHIVE ones ended before it"
+enum State {
+	CURRENT
+	ARCHIVE
+	ALL
+}
+
+"""
+asOf is the default day of the relationships below the object, today when not set
+"""
+type Query {
+	"The zone, . for the root"
+	zone(name: String!, asOf: Date): Zone
+	root(asOf: Date): Zone
+	domain(name: String!, asOf: Date): Domain
+	nameserver(name: String!, asOf: Date): NameServer
+	ip(address: String!, asOf: Date): IP
+}
+
+type Zone {
+	name: String!
+	firstSeen: Date
+	lastSeen: Date
+	nameservers(state: State = CURRENT, asOf: Date, first: Int, after: String): NameServerConnection!
+	"The domains delegated in the zone, from the first to the last day they are delegated"
+	domains(state: State = CURRENT, asOf: Date, first: Int, after: String): DomainConnection!
+}
+
+type Domain {
+	name: String!
+	"The registrable domain of the name in the Public Suffix List, null for a public suffix"
+	registrableDomain: String
+	publicSuffix: String!
+	firstSeen: Date
+	lastSeen: Date
+	zone: Zone
+	nameservers(state: State = CURRENT, asOf: Date, first: Int, after: String): NameServerConnection!
+}
+
+type NameServer {
+	name: String!
+	"The registrable domain of the name in the Public Suffix List, the domain of its operator"
+	registrableDomain: String
+	publicSuffix: String!
+	firstSeen: Date
+	lastSeen: Date
+	"The zone of the glue records of the nameserver"
+	zone: Zone
+	domains(state: State = CURRENT, asOf: Date, first: Int, after: String): DomainConnection!
+	ipv4(state: State = CURRENT, asOf: Date, first: Int, after: String): IPConnection!
+	ipv6(state: State = CURRENT, asOf: Date, first: Int, after: String): IPConnection!
+}
+
+type IP {
+	address: String!
+	version: Int!
+	firstSeen: Date
+	lastSeen: Date
+	nameservers(state: State = CURRENT, asOf: Date, first: Int, after: String): NameServerConnection!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	"The cursor to pass as after for the next page"
+	endCursor: String
+}
+
+"The dates of an edge are those of the relationship"
+type NameServerEdge {
+	node: NameServer!
+	firstSeen: Date
+	lastSeen: Date
+}
+
+type DomainEdge {
+	node: Domain!
+	firstSeen: Date
+	lastSeen: Date
+}
+
+type IPEdge {
+	node: IP!
+	firstSeen: Date
+	lastSeen: Date
+}
+
+type NameServerConnection {
+	totalCount: Int!
+	pageInfo: PageInfo!
+	edges: [NameServerEdge!]!
+}
+
+type DomainConnection {
+	totalCount: Int!
+	pageInfo: PageInfo!
+	edges: [DomainEdge!]!
+}
+
+type IPConnection {
+	totalCount: Int!
+	pageInfo: PageInfo!
+	edges: [IPEdge!]!
+}
+`
+
+var graphQLSchema = graphql.MustParseSchema(graphQLSchemaSDL, &gqlQuery{},
+	graphql.UseStringDescriptions(),
+	graphql.MaxDepth(maxGraphQLDepth),
+)
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// apiGraphQLHandler runs the GraphQL query of the request body
+func (app *appContext) apiGraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxGraphQLBody+1))
+	if err != nil {
+		server.WriteJSONError(w, server.ErrBadQuery)
+		return
+	}
+	if len(body) > maxGraphQLBody {
+		server.WriteJSONError(w, server.ErrQueryTooLarge)
+		return
+	}
+	var params graphQLRequest
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		server.WriteJSONError(w, server.ErrBadQuery)
+		return
+	}
+	if params.Query == "" {
+		writeError(w, invalidParameter("query", "The body must have a GraphQL query."))
+		return
+	}
+
+	req := &gqlRequest{ds: app.ds, zones: make(map[string]*gqlZone)}
+	ctx := context.WithValue(r.Context(), gqlRequestKey{}, req)
+	response := graphQLSchema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
+
+// gqlRequest is the state of a GraphQL query, kept in its context
+type gqlRequest struct {
+	ds datastore.DataStore
+
+	mu    sync.Mutex
+	cost  int
+	zones map[string]*gqlZone
+}
+
+type gqlRequestKey struct{}
+
+func gqlRequestFrom(ctx context.Context) *gqlRequest {
+	return ctx.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// charge adds cost to the query, failing once it is more than maxGraphQLCost
+func (req *gqlRequest) charge(cost int) error {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.cost += cost
+	if req.cost > maxGraphQLCost {
+		return invalidParameter("query", fmt.Sprintf("The query costs more than %d, the page size of each connection for each object and %d for each total, page info or page after the first.", maxGraphQLCost, graphQLListingCost))
+	}
+	return nil
+}
+
+// zone returns the zone name as of date, the same object for every use in the query
+func (req *gqlRequest) zone(name string, date *time.Time) *gqlZone {
+	key := name
+	if date != nil {
+		key += "@" + date.Format("2006-01-02")
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	z, ok := req.zones[key]
+	if !ok {
+		z = &gqlZone{gqlNode: newGQLNode(req, date), z: &model.Zone{Name: name}}
+		req.zones[key] = z
+	}
+	return z
+}
+
+// gqlError returns the error of a resolver
+// missing objects are null rather than errors, and unexpected errors are logged and not shown
+func gqlError(err error) error {
+	switch err {
+	case nil, datastore.ErrNoResource:
+		return nil
+	case datastore.ErrInvalidCursor:
+		return invalidParameter("after", "The cursor is not valid.")
+	}
+	if _, ok := err.(*datastore.ValidationError); ok {
+		return err
+	}
+	log.Printf("graphql: %s", err)
+	return fmt.Errorf("internal server error")
+}
+
+// gqlDate is the Date scalar
+type gqlDate struct {
+	time.Time
+}
+
+func newGQLDate(t *time.Time) *gqlDate {
+	if t == nil {
+		return nil
+	}
+	return &gqlDate{*t}
+}
+
+// registrableDomain returns the registrable domain of name, nil for a public suffix
+func registrableDomain(name string) *string {
+	domain := psl.RegistrableDomain(name)
+	if domain == "" {
+		return nil
+	}
+	return &domain
+}
+
+// ImplementsGraphQLType maps gqlDate to the Date scalar
+func (gqlDate) ImplementsGraphQLType(name string) bool {
+	return name == "Date"
+}
+
+// UnmarshalGraphQL parses the YYYY-MM-DD date of an argument
+func (d *gqlDate) UnmarshalGraphQL(input interface{}) error {
+	s, ok := input.(string)
+	if !ok {
+		return fmt.Errorf("a Date must be a string")
+	}
+	t, err := parseDate("asOf", s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// MarshalJSON formats the date as YYYY-MM-DD
+func (d gqlDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format("2006-01-02"))
+}
+
+// gqlGroup holds the objects of a list, whose relationships are loaded for all of them at once
+// each load is keyed by the relationship and its options, and run by the first object asking for it
+type gqlGroup struct {
+	ids   []int64
+	names []string
+
+	mu    sync.Mutex
+	loads map[string]*gqlLoad
+}
+
+type gqlLoad struct {
+	once   sync.Once
+	result interface{}
+	err    error
+}
+
+func newGQLGroup() *gqlGroup {
+	return &gqlGroup{loads: make(map[string]*gqlLoad)}
+}
+
+func (g *gqlGroup) add(id int64, name string) {
+	g.ids = append(g.ids, id)
+	g.names = append(g.names, name)
+}
+
+// load returns the result of fn for key, calling it once for the group
+func (g *gqlGroup) load(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	l, ok := g.loads[key]
+	if !ok {
+		l = &gqlLoad{}
+		g.loads[key] = l
+	}
+	g.mu.Unlock()
+	l.once.Do(func() {
+		l.result, l.err = fn()
+	})
+	return l.result, l.err
+}
+
+// gqlNode is the part common to the objects
+// date is the default day of their relationships
+type gqlNode struct {
+	req   *gqlRequest
+	group *gqlGroup
+	date  *time.Time
+}
+
+func newGQLNode(req *gqlRequest, date *time.Time) gqlNode {
+	return gqlNode{req: req, group: newGQLGroup(), date: date}
+}
+
+// gqlEdgeArgs are the arguments of the connections
+type gqlEdgeArgs struct {
+	State string
+	AsOf  *gqlDate
+	First *int32
+	After *string
+}
+
+// opts returns the listing options of the arguments, as of date unless asOf is set
+func (args *gqlEdgeArgs) opts(date *time.Time) (datastore.ListOptions, error) {
+	opts := datastore.ListOptions{Date: date, Limit: datastore.PageSize}
+	switch args.State {
+	case "CURRENT":
+		opts.State = datastore.StateCurrent
+	case "ARCHIVE":
+		opts.State = datastore.StateArchive
+	}
+	if args.AsOf != nil {
+		opts.Date = &args.AsOf.Time
+	}
+	if args.First != nil {
+		if *args.First < 1 || *args.First > datastore.MaxPageSize {
+			return opts, invalidParameter("first", fmt.Sprintf("The page size must be between 1 and %d.", datastore.MaxPageSize))
+		}
+		opts.Limit = int(*args.First)
+	}
+	if args.After != nil {
+		opts.Page = *args.After
+	}
+	return opts, nil
+}
+
+// gqlLoadKey keys the batched load of the relationship with opts in a group
+func gqlLoadKey(relationship string, opts datastore.ListOptions) string {
+	date := ""
+	if opts.Date != nil {
+		date = opts.Date.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%s/%s/%s/%d", relationship, opts.State, date, opts.Limit)
+}
+
+// gqlConnection is the part common to the connections
+// the listing is loaded once, when the totals, page info or a page after the first are asked for
+type gqlConnection struct {
+	req  *gqlRequest
+	opts datastore.ListOptions
+
+	once    sync.Once
+	listing func() (*model.Pagination, interface{}, error)
+	p       *model.Pagination
+	items   interface{}
+	err     error
+}
+
+func (c *gqlConnection) list() (*model.Pagination, interface{}, error) {
+	c.once.Do(func() {
+		c.err = c.req.charge(graphQLListingCost)
+		if c.err != nil {
+			return
+		}
+		c.p, c.items, c.err = c.listing()
+		c.err = gqlError(c.err)
+	})
+	return c.p, c.items, c.err
+}
+
+// TotalCount is the number of relationships in the state on the day
+func (c *gqlConnection) TotalCount() (int32, error) {
+	p, _, err := c.list()
+	if err != nil || p == nil {
+		return 0, err
+	}
+	return int32(p.Total), nil
+}
+
+// PageInfo is the page info of the connection
+func (c *gqlConnection) PageInfo() (*gqlPageInfo, error) {
+	p, _, err := c.list()
+	if err != nil || p == nil {
+		return &gqlPageInfo{}, err
+	}
+	return &gqlPageInfo{next: p.NextPage}, nil
+}
+
+type gqlPageInfo struct {
+	next string
+}
+
+// HasNextPage is true if there are relationships after the page
+func (p *gqlPageInfo) HasNextPage() bool {
+	return p.next != ""
+}
+
+// EndCursor is the cursor of the page after this one
+func (p *gqlPageInfo) EndCursor() *string {
+	if p.next == "" {
+		return nil
+	}
+	return &p.next
+}
+
+// pageSize is the number of relationships a page of the connection can have
+func (c *gqlConnection) pageSize() int {
+	if c.opts.Limit > 0 {
+		return c.opts.Limit
+	}
+	return datastore.PageSize
+}
+
+// page returns the relationships of the page
+// the first page is the batched load of the group, later pages the listing
+func (c *gqlConnection) page(batched func() (interface{}, error)) (interface{}, error) {
+	if c.opts.Page != "" {
+		err := c.req.charge(c.pageSize())
+		if err != nil {
+			return nil, err
+		}
+		_, items, err := c.list()
+		return items, err
+	}
+	items, err := batched()
+	return items, gqlError(err)
+}
+
+// loadBatch charges the first page of every object of g, then loads it once for the group with batch
+func (c *gqlConnection) loadBatch(g *gqlGroup, relationship string, batch func() (interface{}, error)) (interface{}, error) {
+	return g.load(gqlLoadKey(relationship, c.opts), func() (interface{}, error) {
+		err := c.req.charge(len(g.ids) * c.pageSize())
+		if err != nil {
+			return nil, err
+		}
+		return batch()
+	})
+}
+
+// the connections of each type
+
+type gqlNameServerConnection struct {
+	gqlConnection
+	batched func() (interface{}, error)
+}
+
+type gqlNameServerEdge struct {
+	edge *model.NameServer
+	node *gqlNameServer
+}
+
+// nameServers returns the connection to the nameservers of the object id in g
+// batch loads the nameservers of every object of g and list the nameservers of the object
+func (req *gqlRequest) nameServers(args *gqlEdgeArgs, date *time.Time, g *gqlGroup, relationship string, id int64,
+	batch func(opts datastore.ListOptions) (map[int64][]*model.NameServer, error),
+	list func(opts datastore.ListOptions) (*model.Pagination, []*model.NameServer, error)) (*gqlNameServerConnection, error) {
+	opts, err := args.opts(date)
+	if err != nil {
+		return nil, err
+	}
+	c := &gqlNameServerConnection{gqlConnection: gqlConnection{req: req, opts: opts}}
+	c.listing = func() (*model.Pagination, interface{}, error) {
+		return list(opts)
+	}
+	c.batched = func() (interface{}, error) {
+		byID, err := c.loadBatch(g, relationship, func() (interface{}, error) {
+			return batch(opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return byID.(map[int64][]*model.NameServer)[id], nil
+	}
+	return c, nil
+}
+
+// Edges are the nameservers of the page
+func (c *gqlNameServerConnection) Edges() ([]*gqlNameServerEdge, error) {
+	items, err := c.page(c.batched)
+	if err != nil {
+		return nil, err
+	}
+	nameservers, _ := items.([]*model.NameServer)
+	group := newGQLGroup()
+	edges := make([]*gqlNameServerEdge, 0, len(nameservers))
+	for _, ns := range nameservers {
+		group.add(ns.ID, ns.Name)
+		node := &gqlNameServer{gqlNode: gqlNode{req: c.req, group: group, date: c.opts.Date}, ns: ns}
+		edges = append(edges, &gqlNameServerEdge{edge: ns, node: node})
+	}
+	return edges, nil
+}
+
+func (e *gqlNameServerEdge) Node() *gqlNameServer { return e.node }
+
+func (e *gqlNameServerEdge) FirstSeen() *gqlDate { return newGQLDate(e.edge.FirstSeen) }
+
+func (e *gqlNameServerEdge) LastSeen() *gqlDate { return newGQLDate(e.edge.LastSeen) }
+
+type gqlDomainConnection struct {
+	gqlConnection
+	batched func() (interface{}, error)
+}
+
+type gqlDomainEdge struct {
+	edge *model.Domain
+	node *gqlDomain
+}
+
+// domains returns the connection to the domains of the object id in g, as nameServers does
+func (req *gqlRequest) domains(args *gqlEdgeArgs, date *time.Time, g *gqlGroup, relationship string, id int64,
+	batch func(opts datastore.ListOptions) (map[int64][]*model.Domain, error),
+	list func(opts datastore.ListOptions) (*model.Pagination, []*model.Domain, error)) (*gqlDomainConnection, error) {
+	opts, err := args.opts(date)
+	if err != nil {
+		return nil, err
+	}
+	c := &gqlDomainConnection{gqlConnection: gqlConnection{req: req, opts: opts}}
+	c.listing = func() (*model.Pagination, interface{}, error) {
+		return list(opts)
+	}
+	c.batched = func() (interface{}, error) {
+		byID, err := c.loadBatch(g, relationship, func() (interface{}, error) {
+			return batch(opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return byID.(map[int64][]*model.Domain)[id], nil
+	}
+	return c, nil
+}
+
+// Edges are the domains of the page
+func (c *gqlDomainConnection) Edges() ([]*gqlDomainEdge, error) {
+	items, err := c.page(c.batched)
+	if err != nil {
+		return nil, err
+	}
+	domains, _ := items.([]*model.Domain)
+	group := newGQLGroup()
+	edges := make([]*gqlDomainEdge, 0, len(domains))
+	for _, d := range domains {
+		group.add(d.ID, d.Name)
+		node := &gqlDomain{gqlNode: gqlNode{req: c.req, group: group, date: c.opts.Date}, d: d}
+		edges = append(edges, &gqlDomainEdge{edge: d, node: node})
+	}
+	return edges, nil
+}
+
+func (e *gqlDomainEdge) Node() *gqlDomain { return e.node }
+
+func (e *gqlDomainEdge) FirstSeen() *gqlDate { return newGQLDate(e.edge.FirstSeen) }
+
+func (e *gqlDomainEdge) LastSeen() *gqlDate { return newGQLDate(e.edge.LastSeen) }
+
+type gqlIPConnection struct {
+	gqlConnection
+	batched func() (interface{}, error)
+}
+
+type gqlIPEdge struct {
+	edge *model.IP
+	node *gqlIP
+}
+
+// ips returns the connection to the IPs of the object id in g, as nameServers does
+func (req *gqlRequest) ips(args *gqlEdgeArgs, date *time.Time, g *gqlGroup, relationship string, id int64,
+	batch func(opts datastore.ListOptions) (map[int64][]*model.IP, error),
+	list func(opts datastore.ListOptions) (*model.Pagination, []*model.IP, error)) (*gqlIPConnection, error) {
+	opts, err := args.opts(date)
+	if err != nil {
+		return nil, err
+	}
+	c := &gqlIPConnection{gqlConnection: gqlConnection{req: req, opts: opts}}
+	c.listing = func() (*model.Pagination, interface{}, error) {
+		return list(opts)
+	}
+	c.batched = func() (interface{}, error) {
+		byID, err := c.loadBatch(g, relationship, func() (interface{}, error) {
+			return batch(opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return byID.(map[int64][]*model.IP)[id], nil
+	}
+	return c, nil
+}
+
+// Edges are the IPs of the page
+func (c *gqlIPConnection) Edges() ([]*gqlIPEdge, error) {
+	items, err := c.page(c.batched)
+	if err != nil {
+		return nil, err
+	}
+	ips, _ := items.([]*model.IP)
+	group := newGQLGroup()
+	edges := make([]*gqlIPEdge, 0, len(ips))
+	for _, ip := range ips {
+		group.add(ip.ID, ip.Name)
+		node := &gqlIP{gqlNode: gqlNode{req: c.req, group: group, date: c.opts.Date}, ip: ip}
+		edges = append(edges, &gqlIPEdge{edge: ip, node: node})
+	}
+	return edges, nil
+}
+
+func (e *gqlIPEdge) Node() *gqlIP { return e.node }
+
+func (e *gqlIPEdge) FirstSeen() *gqlDate { return newGQLDate(e.edge.FirstSeen) }
+
+func (e *gqlIPEdge) LastSeen() *gqlDate { return newGQLDate(e.edge.LastSeen) }
+
+// the objects
+// those of a connection only have the dates of the relationship, their own are looked up for the group
+
+type gqlZone struct {
+	gqlNode
+	z *model.Zone
+
+	once sync.Once
+	info *model.Zone
+	err  error
+}
+
+// load gets the zone itself, there is no bulk lookup of zones
+func (z *gqlZone) load(ctx context.Context) (*model.Zone, error) {
+	z.once.Do(func() {
+		if z.info != nil {
+			return
+		}
+		z.info, z.err = z.req.ds.GetZone(ctx, z.z.Name)
+		z.err = gqlError(z.err)
+		if z.info != nil {
+			// the zone is alone in its group, for the cost of its batched loads
+			z.group.add(z.info.ID, z.info.Name)
+		}
+	})
+	return z.info, z.err
+}
+
+// Name is the name of the zone, . for the root
+func (z *gqlZone) Name() string {
+	if z.z.Name == "" {
+		return "."
+	}
+	return z.z.Name
+}
+
+func (z *gqlZone) FirstSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := z.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.FirstSeen), nil
+}
+
+func (z *gqlZone) LastSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := z.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.LastSeen), nil
+}
+
+func (z *gqlZone) NameServers(ctx context.Context, args gqlEdgeArgs) (*gqlNameServerConnection, error) {
+	info, err := z.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	ds := z.req.ds
+	ids := []int64{info.ID}
+	return z.req.nameServers(&args, z.date, z.group, "nameservers", info.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.NameServer, error) {
+			return ds.GetZonesNameServers(ctx, ids, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.NameServer, error) {
+			l, err := ds.GetZoneNameServers(ctx, z.z.Name, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.NameServers, nil
+		})
+}
+
+func (z *gqlZone) Domains(ctx context.Context, args gqlEdgeArgs) (*gqlDomainConnection, error) {
+	info, err := z.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	ds := z.req.ds
+	ids := []int64{info.ID}
+	return z.req.domains(&args, z.date, z.group, "domains", info.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.Domain, error) {
+			return ds.GetZonesDomains(ctx, ids, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.Domain, error) {
+			l, err := ds.GetZoneDomains(ctx, z.z.Name, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.Domains, nil
+		})
+}
+
+type gqlDomain struct {
+	gqlNode
+	d *model.Domain
+
+	// the domain itself, from the bulk lookup of the group
+	info *model.Domain
+}
+
+func (d *gqlDomain) load(ctx context.Context) (*model.Domain, error) {
+	if d.info != nil {
+		return d.info, nil
+	}
+	ds, names := d.req.ds, d.group.names
+	found, err := d.group.load("info", func() (interface{}, error) {
+		return ds.GetDomainsByName(ctx, names)
+	})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	return found.(map[string]*model.Domain)[d.d.Name], nil
+}
+
+func (d *gqlDomain) Name() string {
+	return d.d.Name
+}
+
+func (d *gqlDomain) RegistrableDomain() *string {
+	return registrableDomain(d.d.Name)
+}
+
+func (d *gqlDomain) PublicSuffix() string {
+	suffix, _ := psl.PublicSuffix(d.d.Name)
+	return suffix
+}
+
+func (d *gqlDomain) FirstSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := d.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.FirstSeen), nil
+}
+
+func (d *gqlDomain) LastSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := d.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.LastSeen), nil
+}
+
+func (d *gqlDomain) Zone(ctx context.Context) (*gqlZone, error) {
+	info, err := d.load(ctx)
+	if err != nil || info == nil || info.Zone == nil {
+		return nil, err
+	}
+	return d.req.zone(info.Zone.Name, d.date), nil
+}
+
+func (d *gqlDomain) NameServers(ctx context.Context, args gqlEdgeArgs) (*gqlNameServerConnection, error) {
+	ds, ids, name := d.req.ds, d.group.ids, d.d.Name
+	return d.req.nameServers(&args, d.date, d.group, "nameservers", d.d.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.NameServer, error) {
+			return ds.GetDomainsNameServers(ctx, ids, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.NameServer, error) {
+			l, err := ds.GetDomainNameServers(ctx, name, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.NameServers, nil
+		})
+}
+
+type gqlNameServer struct {
+	gqlNode
+	ns *model.NameServer
+
+	// the nameserver itself, from the bulk lookup of the group
+	info *model.NameServer
+}
+
+func (ns *gqlNameServer) load(ctx context.Context) (*model.NameServer, error) {
+	if ns.info != nil {
+		return ns.info, nil
+	}
+	ds, names := ns.req.ds, ns.group.names
+	found, err := ns.group.load("info", func() (interface{}, error) {
+		return ds.GetNameServersByName(ctx, names)
+	})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	return found.(map[string]*model.NameServer)[ns.ns.Name], nil
+}
+
+func (ns *gqlNameServer) Name() string {
+	return ns.ns.Name
+}
+
+func (ns *gqlNameServer) RegistrableDomain() *string {
+	return registrableDomain(ns.ns.Name)
+}
+
+func (ns *gqlNameServer) PublicSuffix() string {
+	suffix, _ := psl.PublicSuffix(ns.ns.Name)
+	return suffix
+}
+
+func (ns *gqlNameServer) FirstSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := ns.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.FirstSeen), nil
+}
+
+func (ns *gqlNameServer) LastSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := ns.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.LastSeen), nil
+}
+
+func (ns *gqlNameServer) Zone(ctx context.Context) (*gqlZone, error) {
+	info, err := ns.load(ctx)
+	if err != nil || info == nil || info.Zone == nil {
+		return nil, err
+	}
+	return ns.req.zone(info.Zone.Name, ns.date), nil
+}
+
+func (ns *gqlNameServer) Domains(ctx context.Context, args gqlEdgeArgs) (*gqlDomainConnection, error) {
+	ds, ids, name := ns.req.ds, ns.group.ids, ns.ns.Name
+	return ns.req.domains(&args, ns.date, ns.group, "domains", ns.ns.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.Domain, error) {
+			return ds.GetNameServersDomains(ctx, ids, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.Domain, error) {
+			l, err := ds.GetNameServerDomains(ctx, name, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.Domains, nil
+		})
+}
+
+// ips returns the connection to the IPs of version of the nameserver
+func (ns *gqlNameServer) ips(ctx context.Context, args gqlEdgeArgs, version int) (*gqlIPConnection, error) {
+	ds, ids, name := ns.req.ds, ns.group.ids, ns.ns.Name
+	return ns.req.ips(&args, ns.date, ns.group, fmt.Sprintf("ipv%d", version), ns.ns.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.IP, error) {
+			return ds.GetNameServersIPs(ctx, ids, version, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.IP, error) {
+			l, err := ds.GetNameServerIPs(ctx, name, version, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.IPs, nil
+		})
+}
+
+func (ns *gqlNameServer) IPv4(ctx context.Context, args gqlEdgeArgs) (*gqlIPConnection, error) {
+	return ns.ips(ctx, args, 4)
+}
+
+func (ns *gqlNameServer) IPv6(ctx context.Context, args gqlEdgeArgs) (*gqlIPConnection, error) {
+	return ns.ips(ctx, args, 6)
+}
+
+type gqlIP struct {
+	gqlNode
+	ip *model.IP
+
+	// the IP itself, from the bulk lookup of the group
+	info *model.IP
+}
+
+func (ip *gqlIP) load(ctx context.Context) (*model.IP, error) {
+	if ip.info != nil {
+		return ip.info, nil
+	}
+	ds, names := ip.req.ds, ip.group.names
+	found, err := ip.group.load("info", func() (interface{}, error) {
+		return ds.GetIPsByName(ctx, names)
+	})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	return found.(map[string]*model.IP)[ip.ip.IP.String()], nil
+}
+
+func (ip *gqlIP) Address() string {
+	return ip.ip.Name
+}
+
+func (ip *gqlIP) Version() int32 {
+	return int32(ip.ip.Version)
+}
+
+func (ip *gqlIP) FirstSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := ip.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.FirstSeen), nil
+}
+
+func (ip *gqlIP) LastSeen(ctx context.Context) (*gqlDate, error) {
+	info, err := ip.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return newGQLDate(info.LastSeen), nil
+}
+
+func (ip *gqlIP) NameServers(ctx context.Context, args gqlEdgeArgs) (*gqlNameServerConnection, error) {
+	ds, ids, name, version := ip.req.ds, ip.group.ids, ip.ip.Name, ip.ip.Version
+	return ip.req.nameServers(&args, ip.date, ip.group, "nameservers", ip.ip.ID,
+		func(opts datastore.ListOptions) (map[int64][]*model.NameServer, error) {
+			return ds.GetIPsNameServers(ctx, ids, version, opts)
+		},
+		func(opts datastore.ListOptions) (*model.Pagination, []*model.NameServer, error) {
+			l, err := ds.GetIPNameServers(ctx, name, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &l.Pagination, l.NameServers, nil
+		})
+}
+
+// gqlQuery resolves the Query type
+type gqlQuery struct{}
+
+type gqlNameArgs struct {
+	Name string
+	AsOf *gqlDate
+}
+
+func asOfDate(d *gqlDate) *time.Time {
+	if d == nil {
+		return nil
+	}
+	return &d.Time
+}
+
+func (q *gqlQuery) Zone(ctx context.Context, args gqlNameArgs) (*gqlZone, error) {
+	name := ""
+	if args.Name != "." {
+		var err error
+		name, err = parseDomain("name", args.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	z := gqlRequestFrom(ctx).zone(name, asOfDate(args.AsOf))
+	info, err := z.load(ctx)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (q *gqlQuery) Root(ctx context.Context, args struct{ AsOf *gqlDate }) (*gqlZone, error) {
+	return q.Zone(ctx, gqlNameArgs{Name: ".", AsOf: args.AsOf})
+}
+
+func (q *gqlQuery) Domain(ctx context.Context, args gqlNameArgs) (*gqlDomain, error) {
+	name, err := parseDomain("name", args.Name)
+	if err != nil {
+		return nil, err
+	}
+	req := gqlRequestFrom(ctx)
+	found, err := req.ds.GetDomainsByName(ctx, []string{name})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	d, ok := found[name]
+	if !ok {
+		return nil, nil
+	}
+	node := &gqlDomain{gqlNode: newGQLNode(req, asOfDate(args.AsOf)), d: d, info: d}
+	node.group.add(d.ID, d.Name)
+	return node, nil
+}
+
+func (q *gqlQuery) NameServer(ctx context.Context, args gqlNameArgs) (*gqlNameServer, error) {
+	name, err := parseDomain("name", args.Name)
+	if err != nil {
+		return nil, err
+	}
+	req := gqlRequestFrom(ctx)
+	found, err := req.ds.GetNameServersByName(ctx, []string{name})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	ns, ok := found[name]
+	if !ok {
+		return nil, nil
+	}
+	node := &gqlNameServer{gqlNode: newGQLNode(req, asOfDate(args.AsOf)), ns: ns, info: ns}
+	node.group.add(ns.ID, ns.Name)
+	return node, nil
+}
+
+func (q *gqlQuery) IP(ctx context.Context, args struct {
+	Address string
+	AsOf    *gqlDate
+}) (*gqlIP, error) {
+	address, err := parseIP("address", args.Address)
+	if err != nil {
+		return nil, err
+	}
+	req := gqlRequestFrom(ctx)
+	found, err := req.ds.GetIPsByName(ctx, []string{address})
+	if err != nil {
+		return nil, gqlError(err)
+	}
+	ip, ok := found[address]
+	if !ok {
+		return nil, nil
+	}
+	node := &gqlIP{gqlNode: newGQLNode(req, asOfDate(args.AsOf)), ip: ip, info: ip}
+	node.group.add(ip.ID, ip.Name)
+	return node, nil
+}
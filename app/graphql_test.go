@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"dnscoffee/datastore"
+)
+
+// testGraphQL runs the query against the sample fixture and returns the response
+func testGraphQL(t *testing.T, query string) (json.RawMessage, []string) {
+	t.Helper()
+	ds, err := datastore.NewMemory("../fixtures/sample.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &gqlRequest{ds: ds, zones: make(map[string]*gqlZone)}
+	ctx := context.WithValue(context.Background(), gqlRequestKey{}, req)
+	response := graphQLSchema.Exec(ctx, query, "", nil)
+	errs := make([]string, 0, len(response.Errors))
+	for _, e := range response.Errors {
+		errs = append(errs, e.Message)
+	}
+	return response.Data, errs
+}
+
+func TestGraphQLZoneDomains(t *testing.T) {
+	data, errs := testGraphQL(t, `{ zone(name: "com") { domains(first: 10) { totalCount edges { node { name
+		nameservers { edges { node { name ipv4 { edges { node { address } } } } } } } } } } }`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var body struct {
+		Zone struct {
+			Domains struct {
+				TotalCount int
+				Edges      []struct {
+					Node struct {
+						Name        string
+						NameServers struct {
+							Edges []struct {
+								Node struct {
+									IPv4 struct {
+										Edges []struct{ Node struct{ Address string } }
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	domains := body.Zone.Domains
+	if domains.TotalCount == 0 || len(domains.Edges) != domains.TotalCount {
+		t.Fatalf("%d edges of %d domains, want every current domain of com", len(domains.Edges), domains.TotalCount)
+	}
+	addresses := 0
+	for _, d := range domains.Edges {
+		for _, ns := range d.Node.NameServers.Edges {
+			addresses += len(ns.Node.IPv4.Edges)
+		}
+	}
+	if addresses == 0 {
+		t.Error("no IPv4 address of the nameservers of the domains of com")
+	}
+}
+
+func TestGraphQLInvalidName(t *testing.T) {
+	for _, query := range []string{`{ domain(name: "bad name") { name } }`, `{ nameserver(name: "-ns.example") { name } }`, `{ zone(name: "a..b") { name } }`} {
+		if _, errs := testGraphQL(t, query); len(errs) != 1 {
+			t.Errorf("%s: errors %q, want the invalid name", query, errs)
+		}
+	}
+	if _, errs := testGraphQL(t, `{ domain(name: "unknown.com") { name } }`); len(errs) != 0 {
+		t.Errorf("an unknown domain has errors %q, want null", errs)
+	}
+}
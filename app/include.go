@@ -0,0 +1,426 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+)
+
+// limits of ?include=
+// the depth is the number of relationships in a path, and the objects are counted over all of them
+const (
+	maxIncludeDepth   = 3
+	maxIncludeObjects = 2000
+	// relationships included for each object, as many as the resources themselves list
+	// the longer lists are cut and their relationship named in the Truncated of the object
+	includeLimit = 100
+)
+
+// includeTree holds the paths of ?include= as a tree of relationship names
+type includeTree map[string]includeTree
+
+// names returns the relationships of the tree in order
+func (tree includeTree) names() []string {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// includeRelationship is a relationship that can be included
+// kind is the type of the related objects and state the datastore state they are listed in
+// top relationships can only be included on the requested resource, as only it has them loaded
+type includeRelationship struct {
+	kind  string
+	state string
+	top   bool
+}
+
+// includeRelationships are the relationships of each type that can be included, by name
+var includeRelationships = map[string]map[string]includeRelationship{
+	"domain": {
+		"nameservers":         {kind: "nameserver", state: datastore.StateCurrent},
+		"archive_nameservers": {kind: "nameserver", state: datastore.StateArchive},
+		"zone":                {kind: "zone", top: true},
+	},
+	"zone": {
+		"nameservers":         {kind: "nameserver", state: datastore.StateCurrent},
+		"archive_nameservers": {kind: "nameserver", state: datastore.StateArchive},
+	},
+	"nameserver": {
+		"domains":         {kind: "domain", state: datastore.StateCurrent},
+		"archive_domains": {kind: "domain", state: datastore.StateArchive},
+		"ipv4":            {kind: "ip", state: datastore.StateCurrent},
+		"archive_ipv4":    {kind: "ip", state: datastore.StateArchive},
+		"ipv6":            {kind: "ip", state: datastore.StateCurrent},
+		"archive_ipv6":    {kind: "ip", state: datastore.StateArchive},
+	},
+	"ip": {
+		"nameservers":         {kind: "nameserver", state: datastore.StateCurrent},
+		"archive_nameservers": {kind: "nameserver", state: datastore.StateArchive},
+	},
+}
+
+// parseInclude parses the comma separated relationship paths of ?include= for a resource of kind
+// returns nil if there are none
+func parseInclude(r *http.Request, kind string) (includeTree, error) {
+	value := r.URL.Query().Get("include")
+	if value == "" {
+		return nil, nil
+	}
+	tree := make(includeTree)
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		names := strings.Split(path, ".")
+		if len(names) > maxIncludeDepth {
+			return nil, invalidParameter("include", fmt.Sprintf("%s is more than %d relationships deep.", path, maxIncludeDepth))
+		}
+		node, nodeKind := tree, kind
+		for i, name := range names {
+			rel, ok := includeRelationships[nodeKind][name]
+			if !ok || (rel.top && i > 0) {
+				return nil, invalidParameter("include", fmt.Sprintf("%s has no relationship %s that can be included.", nodeKind, name))
+			}
+			if node[name] == nil {
+				node[name] = make(includeTree)
+			}
+			node, nodeKind = node[name], rel.kind
+		}
+	}
+	return tree, nil
+}
+
+// include loads the relationships of tree on data, the domain, zone, nameserver or IP requested
+// relationships are as of date when it is set
+func (app *appContext) include(ctx context.Context, date *time.Time, tree includeTree, data model.APIData) error {
+	if len(tree) == 0 {
+		return nil
+	}
+	inc := &includer{ctx: ctx, ds: app.ds, date: date}
+	switch v := data.(type) {
+	case *model.Domain:
+		return inc.domains([]*model.Domain{v}, tree)
+	case *model.Zone:
+		return inc.zones([]*model.Zone{v}, tree)
+	case *model.NameServer:
+		return inc.nameservers([]*model.NameServer{v}, tree)
+	case *model.IP:
+		return inc.ips([]*model.IP{v}, tree)
+	}
+	return nil
+}
+
+// includer loads the relationships of an includeTree a level at a time
+// each relationship is loaded for all the objects of the level with a single batched query
+// relationships that are already loaded, such as those of the requested resource, are kept
+type includer struct {
+	ctx     context.Context
+	ds      datastore.DataStore
+	date    *time.Time
+	objects int
+}
+
+// opts lists one relationship more than includeLimit, to tell the lists that are cut
+func (inc *includer) opts(state string) datastore.ListOptions {
+	return datastore.ListOptions{State: state, Date: inc.date, Limit: includeLimit + 1}
+}
+
+// truncate returns the length of a list of n relationships once cut at includeLimit,
+// adding name to truncated when it is cut
+func truncate(truncated *[]string, name string, n int) int {
+	if n <= includeLimit {
+		return n
+	}
+	*truncated = append(*truncated, name)
+	return includeLimit
+}
+
+// count adds n included objects, failing once there are more than maxIncludeObjects
+func (inc *includer) count(n int) error {
+	inc.objects += n
+	if inc.objects > maxIncludeObjects {
+		return invalidParameter("include", fmt.Sprintf("The relationships include more than %d objects.", maxIncludeObjects))
+	}
+	return nil
+}
+
+// missing returns the IDs of the objects whose relationship is not loaded yet
+func missing(ids []int64, loaded []bool) []int64 {
+	out := make([]int64, 0, len(ids))
+	for i, id := range ids {
+		if !loaded[i] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// nameServerLists loads the nameserver lists that are nil from load, then includes tree on all of them
+// the lists cut at includeLimit are named name in the truncated of their object
+func (inc *includer) nameServerLists(lists []*[]*model.NameServer, truncated []*[]string, name string, ids []int64, load func(ids []int64) (map[int64][]*model.NameServer, error), tree includeTree) error {
+	loaded := make([]bool, len(lists))
+	for i, list := range lists {
+		loaded[i] = *list != nil
+	}
+	if want := missing(ids, loaded); len(want) > 0 {
+		batch, err := load(want)
+		if err != nil {
+			return err
+		}
+		for i, list := range lists {
+			if !loaded[i] {
+				related := batch[ids[i]]
+				*list = append(make([]*model.NameServer, 0), related[:truncate(truncated[i], name, len(related))]...)
+			}
+		}
+	}
+	related := make([]*model.NameServer, 0)
+	for _, list := range lists {
+		related = append(related, *list...)
+	}
+	if err := inc.count(len(related)); err != nil {
+		return err
+	}
+	return inc.nameservers(related, tree)
+}
+
+func (inc *includer) domains(domains []*model.Domain, tree includeTree) error {
+	ids := make([]int64, len(domains))
+	for i, d := range domains {
+		ids[i] = d.ID
+	}
+	for _, name := range tree.names() {
+		rel := includeRelationships["domain"][name]
+		var err error
+		switch name {
+		case "nameservers", "archive_nameservers":
+			lists := make([]*[]*model.NameServer, len(domains))
+			truncated := make([]*[]string, len(domains))
+			for i, d := range domains {
+				lists[i], truncated[i] = &d.NameServers, &d.Truncated
+				if rel.state == datastore.StateArchive {
+					lists[i] = &d.ArchiveNameServers
+				}
+			}
+			err = inc.nameServerLists(lists, truncated, name, ids, func(ids []int64) (map[int64][]*model.NameServer, error) {
+				return inc.ds.GetDomainsNameServers(inc.ctx, ids, inc.opts(rel.state))
+			}, tree[name])
+		case "zone":
+			zones := make([]*model.Zone, 0, len(domains))
+			for _, d := range domains {
+				if d.Zone != nil {
+					zones = append(zones, d.Zone)
+				}
+			}
+			err = inc.zones(zones, tree[name])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inc *includer) zones(zones []*model.Zone, tree includeTree) error {
+	ids := make([]int64, len(zones))
+	for i, z := range zones {
+		ids[i] = z.ID
+	}
+	for _, name := range tree.names() {
+		rel := includeRelationships["zone"][name]
+		lists := make([]*[]*model.NameServer, len(zones))
+		truncated := make([]*[]string, len(zones))
+		for i, z := range zones {
+			lists[i], truncated[i] = &z.NameServers, &z.Truncated
+			if rel.state == datastore.StateArchive {
+				lists[i] = &z.ArchiveNameServers
+			}
+		}
+		err := inc.nameServerLists(lists, truncated, name, ids, func(ids []int64) (map[int64][]*model.NameServer, error) {
+			return inc.ds.GetZonesNameServers(inc.ctx, ids, inc.opts(rel.state))
+		}, tree[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inc *includer) nameservers(nameservers []*model.NameServer, tree includeTree) error {
+	ids := make([]int64, len(nameservers))
+	for i, ns := range nameservers {
+		ids[i] = ns.ID
+	}
+	for _, name := range tree.names() {
+		rel := includeRelationships["nameserver"][name]
+		archive := rel.state == datastore.StateArchive
+		var err error
+		switch name {
+		case "domains", "archive_domains":
+			err = inc.nameServerDomains(nameservers, ids, name, archive, tree[name])
+		case "ipv4", "archive_ipv4", "ipv6", "archive_ipv6":
+			version := 4
+			if strings.HasSuffix(name, "6") {
+				version = 6
+			}
+			err = inc.nameServerIPs(nameservers, ids, name, version, archive, tree[name])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inc *includer) nameServerDomains(nameservers []*model.NameServer, ids []int64, name string, archive bool, tree includeTree) error {
+	state := datastore.StateCurrent
+	if archive {
+		state = datastore.StateArchive
+	}
+	lists := make([]*[]*model.Domain, len(nameservers))
+	loaded := make([]bool, len(nameservers))
+	for i, ns := range nameservers {
+		lists[i] = &ns.Domains
+		if archive {
+			lists[i] = &ns.ArchiveDomains
+		}
+		loaded[i] = *lists[i] != nil
+	}
+	if want := missing(ids, loaded); len(want) > 0 {
+		batch, err := inc.ds.GetNameServersDomains(inc.ctx, want, inc.opts(state))
+		if err != nil {
+			return err
+		}
+		for i, list := range lists {
+			if !loaded[i] {
+				related := batch[nameservers[i].ID]
+				*list = append(make([]*model.Domain, 0), related[:truncate(&nameservers[i].Truncated, name, len(related))]...)
+			}
+		}
+	}
+	related := make([]*model.Domain, 0)
+	for _, list := range lists {
+		related = append(related, *list...)
+	}
+	if err := inc.count(len(related)); err != nil {
+		return err
+	}
+	return inc.domains(related, tree)
+}
+
+func (inc *includer) nameServerIPs(nameservers []*model.NameServer, ids []int64, name string, version int, archive bool, tree includeTree) error {
+	state := datastore.StateCurrent
+	if archive {
+		state = datastore.StateArchive
+	}
+	// the IP4 and IP6 lists are of different types, so they are read and set through the list of IPs
+	get := func(ns *model.NameServer) []*model.IP {
+		var ips []*model.IP
+		switch {
+		case version == 4 && !archive && ns.IP4 != nil:
+			ips = model.IP4s(ns.IP4)
+		case version == 4 && archive && ns.ArchiveIP4 != nil:
+			ips = model.IP4s(ns.ArchiveIP4)
+		case version == 6 && !archive && ns.IP6 != nil:
+			ips = model.IP6s(ns.IP6)
+		case version == 6 && archive && ns.ArchiveIP6 != nil:
+			ips = model.IP6s(ns.ArchiveIP6)
+		}
+		return ips
+	}
+	set := func(ns *model.NameServer, ips []*model.IP) {
+		switch {
+		case version == 4 && !archive:
+			ns.IP4 = make([]*model.IP4, 0, len(ips))
+			for _, ip := range ips {
+				ns.IP4 = append(ns.IP4, &model.IP4{IP: *ip})
+			}
+		case version == 4:
+			ns.ArchiveIP4 = make([]*model.IP4, 0, len(ips))
+			for _, ip := range ips {
+				ns.ArchiveIP4 = append(ns.ArchiveIP4, &model.IP4{IP: *ip})
+			}
+		case !archive:
+			ns.IP6 = make([]*model.IP6, 0, len(ips))
+			for _, ip := range ips {
+				ns.IP6 = append(ns.IP6, &model.IP6{IP: *ip})
+			}
+		default:
+			ns.ArchiveIP6 = make([]*model.IP6, 0, len(ips))
+			for _, ip := range ips {
+				ns.ArchiveIP6 = append(ns.ArchiveIP6, &model.IP6{IP: *ip})
+			}
+		}
+	}
+
+	loaded := make([]bool, len(nameservers))
+	for i, ns := range nameservers {
+		loaded[i] = get(ns) != nil
+	}
+	if want := missing(ids, loaded); len(want) > 0 {
+		batch, err := inc.ds.GetNameServersIPs(inc.ctx, want, version, inc.opts(state))
+		if err != nil {
+			return err
+		}
+		for i, ns := range nameservers {
+			if !loaded[i] {
+				related := batch[ns.ID]
+				set(ns, related[:truncate(&ns.Truncated, name, len(related))])
+			}
+		}
+	}
+	related := make([]*model.IP, 0)
+	for _, ns := range nameservers {
+		related = append(related, get(ns)...)
+	}
+	// not every datastore sets the version of the IPs it lists by version
+	for _, ip := range related {
+		ip.Version = version
+	}
+	if err := inc.count(len(related)); err != nil {
+		return err
+	}
+	return inc.ips(related, tree)
+}
+
+func (inc *includer) ips(ips []*model.IP, tree includeTree) error {
+	for _, version := range []int{4, 6} {
+		versionIPs := make([]*model.IP, 0, len(ips))
+		ids := make([]int64, 0, len(ips))
+		for _, ip := range ips {
+			if ip.Version == version {
+				versionIPs = append(versionIPs, ip)
+				ids = append(ids, ip.ID)
+			}
+		}
+		if len(versionIPs) == 0 {
+			continue
+		}
+		for _, name := range tree.names() {
+			rel := includeRelationships["ip"][name]
+			lists := make([]*[]*model.NameServer, len(versionIPs))
+			truncated := make([]*[]string, len(versionIPs))
+			for i, ip := range versionIPs {
+				lists[i], truncated[i] = &ip.NameServers, &ip.Truncated
+				if rel.state == datastore.StateArchive {
+					lists[i] = &ip.ArchiveNameServers
+				}
+			}
+			err := inc.nameServerLists(lists, truncated, name, ids, func(ids []int64) (map[int64][]*model.NameServer, error) {
+				return inc.ds.GetIPsNameServers(inc.ctx, ids, version, inc.opts(rel.state))
+			}, tree[name])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
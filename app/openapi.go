@@ -0,0 +1,258 @@
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/server"
+	"dnscoffee/version"
+)
+
+// the OpenAPI document is generated from the route registry
+// the schemas of the responses are reflected from the model types and their JSON tags
+
+// openAPIVersion is the version of the OpenAPI specification of the document
+const openAPIVersion = "3.0.3"
+
+// formatMediaTypes are the media types of the formats of the routes
+var formatMediaTypes = map[string]string{
+	"json":     "application/json",
+	"jsonapi":  server.JSONAPIContentType,
+	"csv":      "text/csv",
+	"tsv":      "text/tab-separated-values",
+	"ndjson":   "application/x-ndjson",
+	"zonefile": zoneFileContentType,
+	"gml":      trustTreeFormats["gml"],
+	"graphml":  trustTreeFormats["graphml"],
+	"dot":      trustTreeFormats["dot"],
+}
+
+// schemaRef returns a reference to the schema of the component name
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemas generates the component schemas of the named types it is asked for
+type schemas map[string]interface{}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	dateType     = reflect.TypeOf(model.Date{})
+	isoType      = reflect.TypeOf(model.Duration(0))
+	secondsType  = reflect.TypeOf(model.Seconds(0))
+	ipType       = reflect.TypeOf(net.IP{})
+	rawType      = reflect.TypeOf(json.RawMessage{})
+	apiDataType  = reflect.TypeOf((*model.APIData)(nil)).Elem()
+)
+
+// schema returns the schema of t, a reference for named structs whose schema is added to the components
+func (s schemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case dateType:
+		return map[string]interface{}{"type": "string", "format": "date"}
+	case isoType:
+		return map[string]interface{}{"type": "string", "description": "ISO 8601 duration"}
+	case secondsType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "seconds"}
+	case ipType:
+		return map[string]interface{}{"type": "string"}
+	case rawType:
+		// any JSON value
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			// added before its fields so that recursive types end
+			s[t.Name()] = nil
+			s[t.Name()] = s.object(t)
+		}
+		return schemaRef(t.Name())
+	}
+	// interfaces can hold anything
+	return map[string]interface{}{}
+}
+
+// object returns the object schema of the struct t with the fields of its embedded structs
+func (s schemas) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	s.fields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (s schemas) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.fields(embedded, properties)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schema(field.Type)
+	}
+}
+
+// openAPI returns the OpenAPI document of the routes
+func (app *appContext) openAPI() map[string]interface{} {
+	components := make(schemas)
+	paths := make(map[string]map[string]interface{})
+	tagSet := make(map[string]bool)
+	for _, route := range app.routes {
+		if route.response == nil {
+			// undocumented
+			continue
+		}
+		parameters := make([]interface{}, 0, len(route.Parameters))
+		for _, param := range route.Parameters {
+			p := map[string]interface{}{
+				"name":        param.Name,
+				"in":          param.In,
+				"description": param.Description,
+				"required":    param.Required,
+				"schema":      map[string]interface{}{"type": "string"},
+			}
+			// fields[{type}] is a family of parameters, one per type
+			if i := strings.Index(param.Name, "[{"); i > 0 {
+				p["name"] = param.Name[:i]
+				p["style"] = "deepObject"
+				p["schema"] = map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}}
+			}
+			parameters = append(parameters, p)
+		}
+
+		content := make(map[string]interface{})
+		for _, format := range route.Formats {
+			var schema map[string]interface{}
+			switch {
+			case format == "json" && reflect.PtrTo(route.response).Implements(apiDataType):
+				// the model types are the data of a model.JSONResponse
+				schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{"data": components.schema(route.response)}}
+			case format == "json" || format == "jsonapi":
+				schema = components.schema(route.response)
+			default:
+				schema = map[string]interface{}{"type": "string"}
+			}
+			content[formatMediaTypes[format]] = map[string]interface{}{"schema": schema}
+		}
+		errorContent := map[string]interface{}{"application/json": map[string]interface{}{"schema": components.schema(reflect.TypeOf(model.JSONErrors{}))}}
+		if strings.HasPrefix(route.Path, model.V2Prefix+"/") {
+			errorContent = map[string]interface{}{server.JSONAPIContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": route.Name,
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"parameters":  parameters,
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": route.Summary, "content": content},
+				"default": map[string]interface{}{"description": "an error", "content": errorContent},
+			},
+		}
+		if body, ok := apiRequestBodies[route.Name]; ok {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": components.schema(reflect.TypeOf(body).Elem())},
+				},
+			}
+		} else if route.Method == http.MethodPost {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+					"text/plain":       map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+			}
+		}
+
+		path := strings.TrimPrefix(route.Path, "/api")
+		if path == "" {
+			path = "/"
+		}
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+		tagSet[route.Tag] = true
+	}
+
+	tagNames := make([]string, 0, len(tagSet))
+	for tag := range tagSet {
+		tagNames = append(tagNames, tag)
+	}
+	sort.Strings(tagNames)
+	tags := make([]interface{}, 0, len(tagNames))
+	for _, tag := range tagNames {
+		tags = append(tags, map[string]interface{}{"name": tag})
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "DZDB API Documentation",
+			"description": "This API allows querying the zone file collection maintained by DZDB.",
+			"contact":     map[string]interface{}{"email": "dzdb@caida.org"},
+			"version":     version.String(),
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/api"}},
+		"tags":       tags,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": components},
+	}
+}
+
+// apiOpenAPIHandler returns the OpenAPI document of the API
+func (app *appContext) apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(app.openAPI())
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+)
+
+// the RDAP service answers domain, nameserver and IP queries with the RFC 9083 objects
+// the objects are as of ?date= when it is set, like the API
+
+// rdapNotices are the notices of every RDAP object
+var rdapNotices = []model.RDAPNotice{{
+	Title: "Source",
+	Description: []string{
+		"The delegations of the zone files collected by DZDB.",
+		"The registration and deletion events are the days the object was first and last seen in the zone files.",
+	},
+}}
+
+// rdapStart registers the RDAP paths
+// the names match the rest of the path, so a malformed name with a slash is a bad request and not an unknown route
+func (app *appContext) rdapStart(s *server.Server) {
+	s.Get(model.RDAPPrefix+"/help", app.rdapHelpHandler)
+	s.Get(model.RDAPPrefix+"/domain/{name:.+}", app.rdapDomainHandler)
+	s.Get(model.RDAPPrefix+"/nameserver/{name:.+}", app.rdapNameServerHandler)
+	s.Get(model.RDAPPrefix+"/ip/{addr}", app.rdapIPHandler)
+}
+
+// rdapBase returns the URL of the service the request was made to, for the links
+func rdapBase(r *http.Request) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// unicodeName returns the U-label form of the name, or "" if it is the same as the name
+func unicodeName(name string) string {
+	unicode, err := punyCode.ToUnicode(name)
+	if err != nil || unicode == name {
+		return ""
+	}
+	return unicode
+}
+
+// writeRDAPError writes err as an RDAP error
+func writeRDAPError(w http.ResponseWriter, err error) {
+	server.WriteRDAPError(w, jsonError(err))
+}
+
+func (app *appContext) rdapHelpHandler(w http.ResponseWriter, r *http.Request) {
+	help := model.RDAPHelp{
+		RDAPConformance: model.RDAPConformance,
+		Notices: append([]model.RDAPNotice{{
+			Title: "Queries",
+			Description: []string{
+				"domain/{name}, nameserver/{name} and ip/{address} return the objects as they are now.",
+				"Add ?date=YYYY-MM-DD to any of them for the object as it was on the day.",
+			},
+		}}, rdapNotices...),
+	}
+	server.WriteRDAP(w, help)
+}
+
+func (app *appContext) rdapDomainHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := domainVar(r, "name")
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	d, err := app.getDomain(r.Context(), name, date)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	nameservers, err := app.rdapNameServers(r, d.NameServers, date)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	obj := d.RDAP(rdapBase(r), unicodeName(d.Name), nameservers)
+	obj.RDAPConformance, obj.Notices = model.RDAPConformance, rdapNotices
+	server.WriteRDAP(w, obj)
+}
+
+// rdapNameServers returns the RDAP objects of the nameservers of a domain with their addresses on date
+// the nameservers and their addresses are each loaded with a single query
+func (app *appContext) rdapNameServers(r *http.Request, list []*model.NameServer, date *time.Time) ([]*model.RDAPNameServer, error) {
+	ctx := r.Context()
+	ids := make([]int64, 0, len(list))
+	names := make([]string, 0, len(list))
+	for _, ns := range list {
+		ids = append(ids, ns.ID)
+		names = append(names, ns.Name)
+	}
+	found, err := app.ds.GetNameServersByName(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	opts := datastore.ListOptions{State: datastore.StateCurrent, Date: date, Limit: includeLimit}
+	ip4, err := app.ds.GetNameServersIPs(ctx, ids, 4, opts)
+	if err != nil {
+		return nil, err
+	}
+	ip6, err := app.ds.GetNameServersIPs(ctx, ids, 6, opts)
+	if err != nil {
+		return nil, err
+	}
+	base := rdapBase(r)
+	out := make([]*model.RDAPNameServer, 0, len(list))
+	for _, ns := range list {
+		// the nameserver itself rather than the delegation, which is in the events of the domain
+		info, ok := found[ns.Name]
+		if !ok {
+			info = &model.NameServer{Name: ns.Name}
+		}
+		info.AsOf = date
+		for _, ip := range ip4[ns.ID] {
+			info.IP4 = append(info.IP4, &model.IP4{IP: *ip})
+		}
+		for _, ip := range ip6[ns.ID] {
+			info.IP6 = append(info.IP6, &model.IP6{IP: *ip})
+		}
+		out = append(out, info.RDAP(base, unicodeName(info.Name)))
+	}
+	return out, nil
+}
+
+func (app *appContext) rdapNameServerHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := domainVar(r, "name")
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	ns, err := app.getNameServer(r.Context(), name, date)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	obj := ns.RDAP(rdapBase(r), unicodeName(ns.Name))
+	obj.RDAPConformance, obj.Notices = model.RDAPConformance, rdapNotices
+	server.WriteRDAP(w, obj)
+}
+
+func (app *appContext) rdapIPHandler(w http.ResponseWriter, r *http.Request) {
+	addr, err := ipVar(r, "addr")
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	ip, err := app.getIP(r.Context(), addr, date)
+	if err != nil {
+		writeRDAPError(w, err)
+		return
+	}
+	obj := ip.RDAP(rdapBase(r))
+	obj.RDAPConformance, obj.Notices = model.RDAPConformance, rdapNotices
+	server.WriteRDAP(w, obj)
+}
@@ -2,16 +2,21 @@ package app
 
 import (
 	"dnscoffee/datastore"
+	"dnscoffee/model"
 	"dnscoffee/server"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
-
-	"github.com/gorilla/mux"
 )
 
 func (app *appContext) apiIPNsZoneCount(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
+	ip, err := ipVar(r, "ip")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
 	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip)
 	if err != nil {
@@ -22,25 +27,247 @@ func (app *appContext) apiIPNsZoneCount(w http.ResponseWriter, r *http.Request)
 		panic(err)
 	}
 
-	server.WriteJSON(w, data)
+	server.WriteData(w, r, data)
 }
 
-// apiActiveIPs exposes GetActiveIPs as an API
+// apiActiveIPs streams the IPs of StreamActiveIPs
 func (app *appContext) apiActiveIPs(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	date, err := time.Parse("2006-01-02", params["date"])
+	date, err := dateVar(r, "date")
 	if err != nil {
-		panic(err)
+		writeError(w, err)
+		return
+	}
+
+	header := &model.ActiveIPs{Date: date}
+	stream := server.NewStream(w, r, header, "ipv4_ips", "ipv6_ips")
+	if stream == nil {
+		return
+	}
+	list := 0
+	stream.Begin("ipv4_ips", '[')
+	err = app.ds.StreamActiveIPs(r.Context(), date, func(version int, ip string) error {
+		if version == 6 && list == 4 {
+			stream.End()
+			stream.Begin("ipv6_ips", '[')
+		}
+		list = version
+		return stream.Write(ip, header.Record(version, ip))
+	})
+	if err == nil && list != 6 {
+		stream.End()
+		stream.Begin("ipv6_ips", '[')
 	}
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
+}
 
-	data, err := app.ds.GetActiveIPs(r.Context(), date)
+// trustTreeFormats are the content types of the formats of the trust trees
+var trustTreeFormats = map[string]string{
+	"json":    "application/json",
+	"gml":     "text/x-gml",
+	"graphml": "application/graphml+xml",
+	"dot":     "text/vnd.graphviz",
+}
+
+// apiTrustTreeHandler returns the trust tree of {domain} as of ?date=, followed ?depth= levels deep
+// ?format= is json, gml, graphml or dot, and ?resolve_zones=true follows the nameservers of the zones too
+func (app *appContext) apiTrustTreeHandler(w http.ResponseWriter, r *http.Request) {
+	domain, err := domainVar(r, "domain")
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := trustTreeFormats[format]
+	if !ok {
+		writeError(w, invalidParameter("format", "The format must be one of json, gml, graphml or dot."))
+		return
+	}
+	opts := datastore.TrustTreeOptions{Date: date}
+	if value := query.Get("depth"); value != "" {
+		opts.MaxDepth, err = strconv.Atoi(value)
+		if err != nil || opts.MaxDepth < 1 || opts.MaxDepth > datastore.MaxTrustTreeDepth {
+			writeError(w, invalidParameter("depth", fmt.Sprintf("The depth must be a number from 1 to %d.", datastore.MaxTrustTreeDepth)))
 			return
 		}
+	}
+	if value := query.Get("resolve_zones"); value != "" {
+		opts.ResolveZones, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, invalidParameter("resolve_zones", "The resolve_zones parameter must be true or false."))
+			return
+		}
+	}
+
+	tree, err := datastore.BuildTrustTree(r.Context(), app.ds, domain, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if format == "json" {
+		server.WriteJSON(w, tree)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", domain+"."+format))
+	switch format {
+	case "gml":
+		err = tree.WriteGML(w)
+	case "graphml":
+		err = tree.WriteGraphML(w)
+	case "dot":
+		err = tree.WriteDOT(w)
+	}
+	if err != nil && err != http.ErrHandlerTimeout {
 		panic(err)
 	}
+}
+
+// danglingParams returns the ?date=, today by default, and the ?zone= of the dangling nameservers
+func (app *appContext) danglingParams(r *http.Request) (time.Time, string, error) {
+	date, err := dateParam(r)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if date == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		date = &today
+	}
+	zone := strings.TrimSuffix(r.URL.Query().Get("zone"), ".")
+	if zone == "" {
+		return *date, "", nil
+	}
+	zone, err = parseDomain("zone", zone)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if _, err = app.ds.GetZoneID(r.Context(), zone); err != nil {
+		return time.Time{}, "", err
+	}
+	return *date, zone, nil
+}
 
-	server.WriteJSON(w, data)
+// apiDanglingNameServers streams the dangling nameservers on ?date=, named in ?zone= if it is set
+func (app *appContext) apiDanglingNameServers(w http.ResponseWriter, r *http.Request) {
+	date, zone, err := app.danglingParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	header := &model.DanglingNameServers{Date: date, Zone: zone}
+	stream := server.NewStream(w, r, header, "nameservers")
+	if stream == nil {
+		return
+	}
+	stream.Begin("nameservers", '[')
+	err = app.ds.StreamDanglingNameServers(r.Context(), date, zone, func(ns *model.DanglingNameServer) error {
+		return stream.Write(ns, header.Record(ns))
+	})
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
+}
+
+// topParam returns the ?top= number of operators to list, OperatorTop by default
+func topParam(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("top")
+	if value == "" {
+		return datastore.OperatorTop, nil
+	}
+	top, err := strconv.Atoi(value)
+	if err != nil || top < 1 || top > datastore.MaxOperatorTop {
+		return 0, invalidParameter("top", fmt.Sprintf("The top must be a number from 1 to %d.", datastore.MaxOperatorTop))
+	}
+	return top, nil
+}
+
+// apiOperatorMarket streams the shares of the nameserver operators of the ?zones=, up to MaxOperatorZones,
+// from ?from= to ?to= by ?granularity=, the past year by month by default, with the ?top= operators of each
+func (app *appContext) apiOperatorMarket(w http.ResponseWriter, r *http.Request) {
+	opts, err := countsOptions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(opts.Zones) == 0 || len(opts.Zones) > datastore.MaxOperatorZones {
+		writeError(w, invalidParameter("zones", fmt.Sprintf("The zones parameter must list from 1 to %d zones.", datastore.MaxOperatorZones)))
+		return
+	}
+	opts = datastore.OperatorRange(opts)
+	if len(datastore.OperatorDates(opts)) > datastore.MaxOperatorDates {
+		writeError(w, invalidParameter("from", fmt.Sprintf("The range must hold at most %d periods of the granularity.", datastore.MaxOperatorDates)))
+		return
+	}
+	top, err := topParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	header := &model.OperatorMarket{From: *opts.From, To: *opts.To, Granularity: opts.Granularity, Zones: opts.Zones, Top: top}
+	stream := server.NewStream(w, r, header, "history")
+	if stream == nil {
+		return
+	}
+	stream.Begin("history", '[')
+	err = app.ds.StreamOperatorShares(r.Context(), opts, top, func(s *model.OperatorShares) error {
+		return stream.Write(s, header.Record(s))
+	})
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
+}
+
+// migrationParams returns the ?from= and ?to= of the migrations, the past MigrationDays days by default,
+// and the ?top= operators to list
+func migrationParams(r *http.Request) (time.Time, time.Time, int, error) {
+	opts, err := countsOptions(r)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	from, to := datastore.MigrationRange(opts.From, opts.To)
+	if from.After(to) {
+		return time.Time{}, time.Time{}, 0, invalidParameter("from", "The from date must not be after the to date.")
+	}
+	if to.Sub(from) >= datastore.MaxMigrationDays*24*time.Hour {
+		return time.Time{}, time.Time{}, 0, invalidParameter("from", fmt.Sprintf("The range must hold at most %d days.", datastore.MaxMigrationDays))
+	}
+	top, err := topParam(r)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	return from, to, top, nil
+}
+
+// apiProviderMigration returns the flows of the domains of {zone} between nameserver operators
+// from ?from= to ?to=, the past MigrationDays days by default, with the ?top= operators
+func (app *appContext) apiProviderMigration(w http.ResponseWriter, r *http.Request) {
+	zone, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	from, to, top, err := migrationParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, err := app.ds.GetProviderMigration(r.Context(), zone, from, to, top)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	server.WriteData(w, r, data)
 }
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"dnscoffee/model"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// apiRoute is a route of the API as registered by APIStart
+// the OpenAPI document and the JSON index are both generated from these
+type apiRoute struct {
+	Name       string      `json:"name"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Summary    string      `json:"summary"`
+	Tag        string      `json:"tag"`
+	Parameters []*apiParam `json:"parameters"`
+	Response   string      `json:"response"`
+	Formats    []string    `json:"formats"`
+	Stream     bool        `json:"stream,omitempty"`
+
+	// the type of the response data, nil when the route is undocumented
+	response reflect.Type
+}
+
+// apiParam is a path or query parameter of a route
+type apiParam struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// apiDoc documents the routes with a name
+// response is a nil pointer of the type of the response data
+type apiDoc struct {
+	tag      string
+	summary  string
+	response interface{}
+}
+
+// apiDocs are the docs of every route by name
+// paged routes named {name}_paged use the doc of {name}
+var apiDocs = map[string]apiDoc{
+	// index
+	"index":   {"index", "The routes of the API", (*[]*apiRoute)(nil)},
+	"openapi": {"index", "The OpenAPI 3 document of the API", (*map[string]interface{})(nil)},
+
+	// imports
+	"import_health":        {"imports", "Import freshness, gaps and anomalies of every zone", (*model.ImportHealth)(nil)},
+	"import_day_view":      {"imports", "Zone imports of the day", (*model.ImportProgress)(nil)},
+	"import_day_view_zone": {"imports", "Import of the zone on the day", (*model.ImportProgress)(nil)},
+
+	// counts
+	"zone_counts":         {"counts", "Domain counts of all zones together over time", (*model.ZoneCount)(nil)},
+	"zone_history_counts": {"counts", "Domain counts of the zone over time", (*model.ZoneCount)(nil)},
+	"root_history_counts": {"counts", "Domain counts of the root zone over time", (*model.ZoneCount)(nil)},
+	"all_zone_counts":     {"counts", "Domain counts of every zone over time", (*model.AllZoneCounts)(nil)},
+
+	// zones
+	"root_view":                {"zones", "Info for the root zone", (*model.Zone)(nil)},
+	"zones":                    {"zones", "Latest import of every zone", (*model.ZoneImportResults)(nil)},
+	"zone_view":                {"zones", "Info for the zone", (*model.Zone)(nil)},
+	"zone_import":              {"zones", "Latest import of the zone", (*model.ZoneImportResult)(nil)},
+	"zone_diff":                {"zones", "Domains added, removed and moved in the zone between two days", (*model.ZoneDiff)(nil)},
+	"zone_file":                {"zones", "Zone file of the delegations and glue of the zone on the day", (*model.ZoneRecord)(nil)},
+	"root_zone_file":           {"zones", "Zone file of the delegations and glue of the root zone on the day", (*model.ZoneRecord)(nil)},
+	"zone_nameservers":         {"zones", "Nameservers of the zone", (*model.ZoneNameServers)(nil)},
+	"zone_nameservers_current": {"zones", "Current nameservers of the zone", (*model.ZoneNameServers)(nil)},
+	"zone_nameservers_archive": {"zones", "Past nameservers of the zone", (*model.ZoneNameServers)(nil)},
+	"zone_domains":             {"zones", "Domains delegated in the zone", (*model.ZoneDomains)(nil)},
+	"zone_domains_current":     {"zones", "Domains currently delegated in the zone", (*model.ZoneDomains)(nil)},
+	"zone_domains_archive":     {"zones", "Domains that were delegated in the zone", (*model.ZoneDomains)(nil)},
+
+	// domains
+	"random_domain":              {"domains", "A random active domain", (*model.Domain)(nil)},
+	"domain":                     {"domains", "Info for the domain", (*model.Domain)(nil)},
+	"domain_timeline":            {"domains", "Nameserver and glue changes of the domain over time", (*model.DomainTimeline)(nil)},
+	"domain_nameservers":         {"domains", "Nameservers of the domain", (*model.DomainNameServers)(nil)},
+	"domain_current_nameservers": {"domains", "Current nameservers of the domain", (*model.DomainNameServers)(nil)},
+	"domain_archive_nameservers": {"domains", "Past nameservers of the domain", (*model.DomainNameServers)(nil)},
+
+	// nameservers
+	"nameserver":                 {"nameservers", "Info for the nameserver", (*model.NameServer)(nil)},
+	"nameserver_domains":         {"nameservers", "Domains using the nameserver", (*model.NameServerDomains)(nil)},
+	"nameserver_current_domains": {"nameservers", "Domains currently using the nameserver", (*model.NameServerDomains)(nil)},
+	"nameserver_archive_domains": {"nameservers", "Domains that used the nameserver", (*model.NameServerDomains)(nil)},
+	"nameserver_ips":             {"nameservers", "IPv4 and IPv6 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv4":            {"nameservers", "IPv4 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv4_current":    {"nameservers", "Current IPv4 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv4_archive":    {"nameservers", "Past IPv4 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv6":            {"nameservers", "IPv6 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv6_current":    {"nameservers", "Current IPv6 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+	"nameserver_ipv6_archive":    {"nameservers", "Past IPv6 addresses of the nameserver", (*model.NameServerIPs)(nil)},
+
+	// ips
+	"ip":                     {"ip", "IP addresses in the prefix", (*model.IPList)(nil)},
+	"ip_view":                {"ip", "Info for the IP address", (*model.IP)(nil)},
+	"ip_nameservers":         {"ip", "Nameservers using the IP address", (*model.IPNameServers)(nil)},
+	"ip_nameservers_current": {"ip", "Nameservers currently using the IP address", (*model.IPNameServers)(nil)},
+	"ip_nameservers_archive": {"ip", "Nameservers that used the IP address", (*model.IPNameServers)(nil)},
+
+	// feeds
+	"feeds_new_search":    {"feeds", "Daily counts of new domains containing the search string", (*model.FeedCountList)(nil)},
+	"feeds_new_date":      {"feeds", "Domains added on the day", (*model.Feed)(nil)},
+	"feeds_ns_new_date":   {"feeds", "Nameservers added on the day", (*model.NSFeed)(nil)},
+	"feeds_old_search":    {"feeds", "Daily counts of removed domains containing the search string", (*model.FeedCountList)(nil)},
+	"feeds_old_date":      {"feeds", "Domains removed on the day", (*model.Feed)(nil)},
+	"feeds_ns_old_date":   {"feeds", "Nameservers that lost their glue records on the day", (*model.NSFeed)(nil)},
+	"feeds_moved_search":  {"feeds", "Daily counts of domains containing the search string that changed nameservers", (*model.FeedCountList)(nil)},
+	"feeds_moved_date":    {"feeds", "Domains that changed nameservers on the day", (*model.Feed)(nil)},
+	"feeds_ns_moved_date": {"feeds", "Nameservers that changed IP addresses on the day", (*model.NSFeed)(nil)},
+
+	// bulk
+	"bulk_domains":     {"bulk", "Look up the domains of the body, a JSON array or a name per line", (*model.BulkLookup)(nil)},
+	"bulk_nameservers": {"bulk", "Look up the nameservers of the body, a JSON array or a name per line", (*model.BulkLookup)(nil)},
+	"bulk_ip":          {"bulk", "Look up the IP addresses of the body, a JSON array or an address per line", (*model.BulkLookup)(nil)},
+
+	// graphql
+	"graphql": {"graphql", "Run the GraphQL query of the body on the zones, domains, nameservers and IPs", (*graphql.Response)(nil)},
+
+	// prefixes
+	"prefixes": {"prefixes", "Active or available domains starting with the prefix", (*model.PrefixList)(nil)},
+
+	// research
+	"ip_ns_zone_count":     {"research", "Nameservers using the IP address by zone", (*model.ResearchIPNsZoneCount)(nil)},
+	"active_ips":           {"research", "IP addresses of nameservers active on the day", (*model.ActiveIPs)(nil)},
+	"trust_tree":           {"research", "Zones, nameservers and IPs the resolution of the domain depends on, with their hazards", (*model.TrustTree)(nil)},
+	"dangling_nameservers": {"research", "Nameservers named under unregistered domains or without addresses, with the domains still delegated to them", (*model.DanglingNameServers)(nil)},
+	"operator_market":      {"research", "Shares of the nameserver operators of the zones over time, with their concentration", (*model.OperatorMarket)(nil)},
+	"provider_migration":   {"research", "Domains of a zone that moved between nameserver operators, as a Sankey edge list", (*model.ProviderMigration)(nil)},
+
+	// v2
+	"v2_import_health":      {"v2", "Import health of every zone", (*model.Document)(nil)},
+	"v2_import_day":         {"v2", "Zone imports of the day", (*model.Document)(nil)},
+	"v2_import_day_root":    {"v2", "Import of the root zone on the day", (*model.Document)(nil)},
+	"v2_import_day_zone":    {"v2", "Import of the zone on the day", (*model.Document)(nil)},
+	"v2_root_counts":        {"v2", "Domain counts of the root zone over time", (*model.Document)(nil)},
+	"v2_zone_counts":        {"v2", "Domain counts of the zone over time", (*model.Document)(nil)},
+	"v2_root":               {"v2", "The root zone", (*model.Document)(nil)},
+	"v2_zone":               {"v2", "The zone", (*model.Document)(nil)},
+	"v2_domain":             {"v2", "The domain", (*model.Document)(nil)},
+	"v2_nameserver":         {"v2", "The nameserver", (*model.Document)(nil)},
+	"v2_ip":                 {"v2", "The IP address", (*model.Document)(nil)},
+	"v2_root_nameservers":   {"v2", "Nameservers of the root zone", (*model.Document)(nil)},
+	"v2_zone_nameservers":   {"v2", "Nameservers of the zone", (*model.Document)(nil)},
+	"v2_domain_nameservers": {"v2", "Nameservers of the domain", (*model.Document)(nil)},
+	"v2_nameserver_domains": {"v2", "Domains of the nameserver", (*model.Document)(nil)},
+	"v2_nameserver_ipv4":    {"v2", "IPv4 addresses of the nameserver", (*model.Document)(nil)},
+	"v2_nameserver_ipv6":    {"v2", "IPv6 addresses of the nameserver", (*model.Document)(nil)},
+	"v2_ip_nameservers":     {"v2", "Nameservers of the IP address", (*model.Document)(nil)},
+	"v2_feeds_new_search":   {"v2", "Daily counts of new domains containing the search string", (*model.Document)(nil)},
+	"v2_feeds_old_search":   {"v2", "Daily counts of removed domains containing the search string", (*model.Document)(nil)},
+	"v2_feeds_moved_search": {"v2", "Daily counts of moved domains containing the search string", (*model.Document)(nil)},
+	"v2_search":             {"v2", "Zones, domains, nameservers and IP addresses with the name", (*model.Document)(nil)},
+}
+
+// apiRequestBodies are the types of the bodies of the POST routes that are not a list of names
+var apiRequestBodies = map[string]interface{}{
+	"graphql": (*graphQLRequest)(nil),
+}
+
+// pathParamDocs describe the variables of the paths
+var pathParamDocs = map[string]string{
+	"zone":       "the requested zone",
+	"domain":     "the requested domain or nameserver",
+	"nameserver": "the requested nameserver",
+	"ip":         "the requested IP address",
+	"page":       "the page cursor, from the next or prev link of the previous page",
+	"date":       "the day, as YYYY-MM-DD",
+	"year":       "the year of the day",
+	"month":      "the month of the day",
+	"day":        "the day of the month",
+	"search":     "the string the domains contain",
+	"type":       "active or available",
+	"prefix":     "the start of the domain names",
+}
+
+// queryParamDocs describe the query parameters, by their name before =
+var queryParamDocs = map[string]string{
+	"date":           "the day to view the data as of, as YYYY-MM-DD",
+	"include":        "comma separated relationship paths to include, such as nameservers.ipv4",
+	"fields[{type}]": "comma separated fields to keep in the objects of type",
+	"from":           "the first day, as YYYY-MM-DD",
+	"to":             "the last day, as YYYY-MM-DD",
+	"granularity":    "the period of each count, day, week, month or year",
+	"zones":          "comma separated zones to count, . for the root",
+	"ipprefix":       "the IP prefix to search, in CIDR notation",
+	"filter[state]":  "current or archive, both when not set",
+	"page[size]":     "the number of resources per page",
+	"page[cursor]":   "the page cursor, from the next or prev link of the previous page",
+	"query":          "the name to search for",
+	"type":           "the type of the results, zone, domain, nameserver or ip",
+	"limit":          "the number of objects per page",
+	"format":         "the output format",
+	"depth":          "the number of levels of the tree to follow, two per delegation",
+	"resolve_zones":  "true to follow the nameservers of the zones as well as those of the domains",
+	"zone":           "the zone the nameservers are named in",
+	"top":            "the number of operators to list",
+}
+
+// routeDoc returns the doc of the route name, falling back to {name} for {name}_paged
+func routeDoc(name string) (apiDoc, bool) {
+	doc, ok := apiDocs[name]
+	if !ok && strings.HasSuffix(name, "_paged") {
+		doc, ok = apiDocs[strings.TrimSuffix(name, "_paged")]
+		if ok {
+			doc.summary += ", a page after the first"
+		}
+	}
+	return doc, ok
+}
+
+var (
+	pathVars    = regexp.MustCompile(`{([^}]*)}`)
+	recordsType = reflect.TypeOf((*model.Records)(nil)).Elem()
+	// the zone files are text, a record per line
+	zoneRecordType = reflect.TypeOf(model.ZoneRecord{})
+	// the trust trees are graphs, also written in the formats of the graph tools
+	trustTreeType = reflect.TypeOf(model.TrustTree{})
+)
+
+// newAPIRoute returns the route of the API at path
+// params are the query parameters of the route as name={placeholder}
+func newAPIRoute(method, path string, params []string, name string, stream bool) *apiRoute {
+	route := &apiRoute{Name: name, Method: method, Path: "/api" + path, Stream: stream, Parameters: make([]*apiParam, 0)}
+	for _, match := range pathVars.FindAllStringSubmatch(path, -1) {
+		route.Parameters = append(route.Parameters, &apiParam{Name: match[1], In: "path", Description: pathParamDocs[match[1]], Required: true})
+	}
+	for _, param := range params {
+		paramName := strings.SplitN(param, "=", 2)[0]
+		route.Parameters = append(route.Parameters, &apiParam{Name: paramName, In: "query", Description: queryParamDocs[paramName]})
+	}
+	doc, ok := routeDoc(name)
+	if !ok {
+		return route
+	}
+	route.Tag, route.Summary = doc.tag, doc.summary
+	route.response = reflect.TypeOf(doc.response).Elem()
+	route.Response = route.response.String()
+	route.Formats = []string{"json"}
+	if strings.HasPrefix(path, "/v2/") {
+		route.Formats = []string{"jsonapi"}
+	} else if route.response == zoneRecordType {
+		route.Formats = []string{"zonefile"}
+	} else if route.response == trustTreeType {
+		route.Formats = []string{"json", "gml", "graphml", "dot"}
+	} else if reflect.PtrTo(route.response).Implements(recordsType) {
+		route.Formats = []string{"json", "csv", "tsv", "ndjson"}
+	}
+	if len(route.Formats) > 1 {
+		last := len(route.Formats) - 1
+		description := queryParamDocs["format"] + ", " + strings.Join(route.Formats[:last], ", ") + " or " + route.Formats[last]
+		route.Parameters = append(route.Parameters, &apiParam{Name: "format", In: "query", Description: description})
+	}
+	return route
+}
+
+// apiIndex lists the routes of the API
+func (app *appContext) apiIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(app.routes)
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
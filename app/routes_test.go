@@ -0,0 +1,67 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"dnscoffee/server"
+)
+
+// testRoutes registers the API on a server that is never started and returns its routes
+func testRoutes(t *testing.T) *appContext {
+	s, err := server.New("", server.DefaultAPIConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &appContext{}
+	APIStart(app, s)
+	if len(app.routes) == 0 {
+		t.Fatal("no routes registered")
+	}
+	return app
+}
+
+// TestRoutesDocumented fails for a route of the API without docs, which would be missing from the OpenAPI document
+func TestRoutesDocumented(t *testing.T) {
+	app := testRoutes(t)
+	names := make(map[string]string)
+	used := make(map[string]bool)
+	for _, route := range app.routes {
+		if path, ok := names[route.Name]; ok {
+			t.Errorf("%s %s has the same name %q as %s", route.Method, route.Path, route.Name, path)
+		}
+		names[route.Name] = route.Path
+
+		if _, ok := routeDoc(route.Name); !ok {
+			t.Errorf("%s %s is undocumented, add %q to apiDocs", route.Method, route.Path, route.Name)
+			continue
+		}
+		used[route.Name] = true
+		used[strings.TrimSuffix(route.Name, "_paged")] = true
+		if route.Summary == "" || route.Tag == "" || route.response == nil {
+			t.Errorf("%s %s needs a summary, tag and response in apiDocs", route.Method, route.Path)
+		}
+		for _, param := range route.Parameters {
+			if param.Description == "" {
+				t.Errorf("%s %s has the undocumented %s parameter %q", route.Method, route.Path, param.In, param.Name)
+			}
+		}
+	}
+	for name := range apiDocs {
+		if !used[name] {
+			t.Errorf("apiDocs documents %q, which is not a route", name)
+		}
+	}
+}
+
+// TestOpenAPIPaths checks that every route is an operation of the OpenAPI document
+func TestOpenAPIPaths(t *testing.T) {
+	app := testRoutes(t)
+	paths := app.openAPI()["paths"].(map[string]map[string]interface{})
+	for _, route := range app.routes {
+		path := strings.TrimPrefix(route.Path, "/api")
+		if _, ok := paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is missing from the OpenAPI document", route.Method, route.Path)
+		}
+	}
+}
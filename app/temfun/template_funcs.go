@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"dnscoffee/psl"
+
 	"golang.org/x/net/idna"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
@@ -19,8 +21,13 @@ func init() {
 	Funcs["count"] = count
 	Funcs["nfmt"] = nfmt
 	Funcs["date"] = date
+	Funcs["isoDate"] = isoDate
+	Funcs["day"] = day
+	Funcs["duration"] = duration
 	Funcs["drefInt"] = defrefInt
 	Funcs["toUnicode"] = toUnicode
+	Funcs["publicSuffix"] = publicSuffix
+	Funcs["registrableDomain"] = psl.RegistrableDomain
 }
 
 func count(count int, totalCount *int64) string {
@@ -41,6 +48,25 @@ func date(date *time.Time) string {
 	return date.Format("Jan 02, 2006")
 }
 
+func day(date time.Time) string {
+	return date.Format("Jan 02, 2006")
+}
+
+func duration(d time.Duration) string {
+	days := int64(d / (24 * time.Hour))
+	if days > 0 {
+		return fmt.Sprintf("%d days", days)
+	}
+	return fmt.Sprintf("%d hours", int64(d/time.Hour))
+}
+
+func isoDate(date *time.Time) string {
+	if date == nil {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
 func defrefInt(i *int64) int64 {
 	return *i
 }
@@ -54,3 +80,8 @@ func toUnicode(s string) string {
 		return fmt.Sprintf("%v (%v)", lcStr, uniStr)
 	}
 }
+
+func publicSuffix(name string) string {
+	suffix, _ := psl.PublicSuffix(name)
+	return suffix
+}
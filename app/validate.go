@@ -0,0 +1,138 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+
+	"github.com/gorilla/mux"
+)
+
+// invalidParameter returns a ValidationError for parameter
+func invalidParameter(parameter, detail string) error {
+	return &datastore.ValidationError{Parameter: parameter, Detail: detail}
+}
+
+// parseDate parses a YYYY-MM-DD date from the parameter
+func parseDate(parameter, value string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return date, invalidParameter(parameter, "The date must be formatted as YYYY-MM-DD.")
+	}
+	return date, nil
+}
+
+// limits of RFC 1035 on the text form of a name, without its trailing dot
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+)
+
+// parseDomain cleans the domain from the parameter, which must be a hostname once in A-labels
+// a trailing dot is dropped, and the empty name is the root
+func parseDomain(parameter, value string) (string, error) {
+	domain, err := cleanDomain(value)
+	if err == nil {
+		domain = strings.TrimSuffix(domain, ".")
+	}
+	if err != nil || !isHostname(domain) {
+		return "", invalidParameter(parameter, "The name is not a valid domain name.")
+	}
+	return domain, nil
+}
+
+// isHostname returns true if the lowercase name is made of LDH labels,
+// letters, digits and hyphens not starting or ending with a hyphen
+func isHostname(name string) bool {
+	if name == "" {
+		return true
+	}
+	if len(name) > maxDomainLength {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxLabelLength || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseSearch cleans the search query from the parameter, an IP address or a domain
+func parseSearch(parameter, value string) (string, error) {
+	if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+		return ip.String(), nil
+	}
+	return parseDomain(parameter, value)
+}
+
+// parseIP checks that the IP address from the parameter can be parsed
+func parseIP(parameter, value string) (string, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", invalidParameter(parameter, "The IP address must be a valid IPv4 or IPv6 address.")
+	}
+	return ip.String(), nil
+}
+
+// parseCIDR parses the IP prefix from the parameter
+func parseCIDR(parameter, value string) (*net.IPNet, error) {
+	_, prefix, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, invalidParameter(parameter, "The IP prefix must be in CIDR notation, such as 192.0.2.0/24.")
+	}
+	return prefix, nil
+}
+
+// dateVar returns the date in the route variable name
+func dateVar(r *http.Request, name string) (time.Time, error) {
+	return parseDate(name, mux.Vars(r)[name])
+}
+
+// domainVar returns the cleaned domain in the route variable name
+func domainVar(r *http.Request, name string) (string, error) {
+	return parseDomain(name, mux.Vars(r)[name])
+}
+
+// ipVar returns the IP address in the route variable name
+func ipVar(r *http.Request, name string) (string, error) {
+	return parseIP(name, mux.Vars(r)[name])
+}
+
+// writeError writes the JSON error for the errors returned by validation and the datastore
+// unknown errors are server faults and passed on to the recovery handler
+func writeError(w http.ResponseWriter, err error) {
+	server.WriteJSONError(w, jsonError(err))
+}
+
+// writeDocumentError writes err as a JSON:API error document for the v2 API
+func writeDocumentError(w http.ResponseWriter, err error) {
+	server.WriteDocumentError(w, jsonError(err))
+}
+
+// jsonError returns the JSON error for the datastore or validation error err
+// other errors are unexpected and panic
+func jsonError(err error) *model.JSONError {
+	var validationErr *datastore.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return server.InvalidParameter(validationErr.Parameter, validationErr.Detail)
+	case err == datastore.ErrNoResource:
+		return server.ErrResourceNotFound
+	case err == datastore.ErrInvalidCursor:
+		return server.ErrInvalidPage
+	}
+	panic(err)
+}
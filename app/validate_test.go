@@ -0,0 +1,32 @@
+package app
+
+import "testing"
+
+func TestParseDomain(t *testing.T) {
+	tests := []struct {
+		value, want string
+		valid       bool
+	}{
+		{"Example.COM", "example.com", true},
+		{"example.com.", "example.com", true},
+		{"", "", true},
+		{"xn--85x722f.com.cn", "xn--85x722f.com.cn", true},
+		{"食狮.com.cn", "xn--85x722f.com.cn", true},
+		{"a-b.example", "a-b.example", true},
+		{"not a domain", "", false},
+		{"-a.example", "", false},
+		{"a-.example", "", false},
+		{"a..example", "", false},
+		{"a_b.example", "", false},
+		{"2001:db8::1", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseDomain("name", tt.value)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("parseDomain(%q) = %q, %v, want %q and valid %t", tt.value, got, err, tt.want, tt.valid)
+		}
+	}
+	if got, err := parseSearch("query", "2001:DB8::1"); err != nil || got != "2001:db8::1" {
+		t.Errorf("parseSearch of an IPv6 address = %q, %v", got, err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"dnscoffee/app/temfun"
 	"dnscoffee/datastore"
 	"dnscoffee/model"
+	"dnscoffee/psl"
 	"dnscoffee/server"
 	"dnscoffee/version"
 
@@ -20,10 +21,10 @@ import (
 
 // object to hold application context and persistent storage
 type appContext struct {
-	ds *datastore.DataStore
+	ds datastore.DataStore
 
-	// used for creating the API index
-	api map[string]string
+	// the routes of the API, for the index and OpenAPI document
+	routes []*apiRoute
 
 	templates *template.Template
 }
@@ -37,15 +38,19 @@ type Page struct {
 
 // Start entry point for starting application
 // adds routes to the server so that the correct handlers are registered
-func Start(ds *datastore.DataStore, server *server.Server) {
+func Start(ds datastore.DataStore, server *server.Server) {
 	var app appContext
 	app.ds = ds
 	// compile all templates and cache them
 	//app.templates = template.Must(template.ParseGlob("templates/*.tmpl").Funcs(temfun.Funcs))
 	app.templates = template.Must(template.New("main").Funcs(temfun.Funcs).ParseGlob("templates/*.tmpl"))
 
+	// errors outside of the handlers use the same error page
+	server.SetErrorPage(app.errorPage)
+
 	// load the api
 	APIStart(&app, server)
+	app.rdapStart(server)
 
 	//TODO add feeds page
 	//server.Get("/feeds", app.TodoHandler)
@@ -72,10 +77,13 @@ func Start(ds *datastore.DataStore, server *server.Server) {
 	server.Get("/zones", app.zoneIndexHandler)
 	server.Get("/tlds", app.tldIndexHandler)
 	server.Get("/tlds/graveyard", app.tldGraveyardIndexHandler)
+	server.Get("/imports/health", app.importHealthHandler)
 
 	// research
 	server.Get("/research/trust-tree", app.trustTreeHandler)
 	server.Get("/research/ipnszonecount/{ip}", app.ipNsZoneCountHandler)
+	server.Get("/research/dangling", app.danglingHandler)
+	server.Get("/research/migrations", app.migrationsHandler)
 }
 
 func (app *appContext) searchIndexHandler(w http.ResponseWriter, r *http.Request) {
@@ -89,9 +97,13 @@ func (app *appContext) searchIndexHandler(w http.ResponseWriter, r *http.Request
 
 func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 	var s model.Search
-	s.Query = cleanDomain(r.FormValue("query"))
-	s.Type = r.FormValue("type")
 	var err error
+	s.Query, err = parseSearch("query", r.FormValue("query"))
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	s.Type = r.FormValue("type")
 
 	// since the root zone is the empty string, this prevents empty searches from redirecting to the zones page
 	if len(s.Query) > 0 {
@@ -109,6 +121,10 @@ func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/domains/"+s.Query, http.StatusFound)
 				return
 			}
+			if domain, ok := app.registrableMatch(r.Context(), s.Query); ok {
+				http.Redirect(w, r, "/domains/"+domain, http.StatusFound)
+				return
+			}
 		case "nameserver":
 			_, err = app.ds.GetNameServerID(r.Context(), s.Query)
 			if err == nil {
@@ -122,21 +138,8 @@ func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		case "_":
-			s.Results = make([]model.SearchResult, 0)
 			// now handle multiple results types
-			// this is a very poor exact match search... add prefix too?
-			if _, err = app.ds.GetZoneID(r.Context(), s.Query); err == nil {
-				s.Results = append(s.Results, model.SearchResult{Name: s.Query, Link: "/zones/" + s.Query, Type: "zone"})
-			}
-			if _, _, err = app.ds.GetDomainID(r.Context(), s.Query); err == nil {
-				s.Results = append(s.Results, model.SearchResult{Name: s.Query, Link: "/domains/" + s.Query, Type: "domain"})
-			}
-			if _, err = app.ds.GetNameServerID(r.Context(), s.Query); err == nil {
-				s.Results = append(s.Results, model.SearchResult{Name: s.Query, Link: "/nameservers/" + s.Query, Type: "nameserver"})
-			}
-			if _, _, err = app.ds.GetIPID(r.Context(), s.Query); err == nil {
-				s.Results = append(s.Results, model.SearchResult{Name: s.Query, Link: "/ip/" + s.Query, Type: "IP"})
-			}
+			s.Results = app.exactMatches(r.Context(), s.Query)
 
 			// still want to redirect if only one type is found
 			if len(s.Results) == 1 {
@@ -156,6 +159,52 @@ func (app *appContext) searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// exactMatches returns the zone, domain, nameserver and IP named query
+// this is a very poor exact match search... add prefix too?
+func (app *appContext) exactMatches(ctx context.Context, query string) []model.SearchResult {
+	results := make([]model.SearchResult, 0)
+	if _, err := app.ds.GetZoneID(ctx, query); err == nil {
+		results = append(results, model.SearchResult{Name: query, Link: "/zones/" + query, Type: "zone"})
+	}
+	if _, _, err := app.ds.GetDomainID(ctx, query); err == nil {
+		results = append(results, model.SearchResult{Name: query, Link: "/domains/" + query, Type: "domain"})
+	}
+	if _, err := app.ds.GetNameServerID(ctx, query); err == nil {
+		results = append(results, model.SearchResult{Name: query, Link: "/nameservers/" + query, Type: "nameserver"})
+	}
+	if _, _, err := app.ds.GetIPID(ctx, query); err == nil {
+		results = append(results, model.SearchResult{Name: query, Link: "/ip/" + query, Type: "IP"})
+	}
+	if domain, ok := app.registrableMatch(ctx, query); ok {
+		results = append(results, model.SearchResult{Name: domain, Link: "/domains/" + domain, Type: "domain"})
+	}
+	return results
+}
+
+// registrableMatch returns the registrable domain of query when it is a domain other than query,
+// so a search for a host such as www.example.co.uk finds example.co.uk
+// the ICANN rules are used, as the zone files only hold the domains delegated by registries
+func (app *appContext) registrableMatch(ctx context.Context, query string) (string, bool) {
+	domain := psl.ICANNRegistrableDomain(query)
+	if domain == "" || domain == query {
+		return "", false
+	}
+	if _, _, err := app.ds.GetDomainID(ctx, domain); err != nil {
+		return "", false
+	}
+	return domain, true
+}
+
+// prefixLabel returns the prefix of a prefix search, the label of name before its public suffix,
+// so example.co.uk searches for example
+func prefixLabel(name string) string {
+	domain := psl.ICANNRegistrableDomain(name)
+	if domain == "" {
+		return name
+	}
+	return domain[:strings.IndexByte(domain, '.')]
+}
+
 // used for the search redirect
 func (app *appContext) findObjectLinkByName(ctx context.Context, s string) string {
 	if _, err := app.ds.GetZoneID(ctx, s); err == nil {
@@ -170,6 +219,9 @@ func (app *appContext) findObjectLinkByName(ctx context.Context, s string) strin
 	if _, _, err := app.ds.GetIPID(ctx, s); err == nil {
 		return "/ip/" + s
 	}
+	if domain, ok := app.registrableMatch(ctx, s); ok {
+		return "/domains/" + domain
+	}
 	return ""
 }
 
@@ -178,6 +230,7 @@ func (app *appContext) zoneIndexHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		panic(err)
 	}
+	datastore.MarkStale(data)
 
 	p := Page{"Zones", "Zones", data}
 	err = app.templates.ExecuteTemplate(w, "zones.tmpl", p)
@@ -186,6 +239,20 @@ func (app *appContext) zoneIndexHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// importHealthHandler shows the import freshness, gaps and anomalies of every zone
+func (app *appContext) importHealthHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := app.ds.GetImportHealth(r.Context())
+	if err != nil {
+		panic(err)
+	}
+
+	p := Page{"Import Health", "Zones", data}
+	err = app.templates.ExecuteTemplate(w, "import_health.tmpl", p)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (app *appContext) tldIndexHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := app.ds.GetZoneImportResults(r.Context())
 	if err != nil {
@@ -201,14 +268,15 @@ func (app *appContext) tldIndexHandler(w http.ResponseWriter, r *http.Request) {
 
 func (app *appContext) rootHandler(w http.ResponseWriter, r *http.Request) {
 	name := ""
-	data, err := app.ds.GetZone(r.Context(), name)
+	date, err := dateParam(r)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data, err := app.getZone(r.Context(), name, date)
+	if err != nil {
+		app.writeWebError(w, r, err, "zone", name)
+		return
 	}
 	importData, err := app.ds.GetZoneImport(r.Context(), name)
 	if err == nil {
@@ -225,16 +293,20 @@ func (app *appContext) rootHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *appContext) zoneHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["zone"])
-	data, err := app.ds.GetZone(r.Context(), name)
+	name, err := domainVar(r, "zone")
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data, err := app.getZone(r.Context(), name, date)
+	if err != nil {
+		app.writeWebError(w, r, err, "zone", name)
+		return
 	}
 	importData, err := app.ds.GetZoneImport(r.Context(), name)
 	if err == nil {
@@ -257,16 +329,20 @@ func (app *appContext) zoneHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *appContext) nameserverHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["nameserver"])
-	data, err := app.ds.GetNameServer(r.Context(), name)
+	name, err := domainVar(r, "nameserver")
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data, err := app.getNameServer(r.Context(), name, date)
+	if err != nil {
+		app.writeWebError(w, r, err, "nameserver", name)
+		return
 	}
 
 	p := Page{name, "Records", data}
@@ -278,16 +354,20 @@ func (app *appContext) nameserverHandler(w http.ResponseWriter, r *http.Request)
 
 // domainHandler returns domain object for the queried domain
 func (app *appContext) domainHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	domain := cleanDomain(params["domain"])
-	data, err := app.ds.GetDomain(r.Context(), domain)
+	domain, err := domainVar(r, "domain")
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data, err := app.getDomain(r.Context(), domain, date)
+	if err != nil {
+		app.writeWebError(w, r, err, "domain", domain)
+		return
 	}
 
 	p := Page{domain, "Records", data}
@@ -299,16 +379,20 @@ func (app *appContext) domainHandler(w http.ResponseWriter, r *http.Request) {
 
 // ipHandler returns ip object for the queried domain
 func (app *appContext) ipHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	name := cleanDomain(params["ip"])
-	data, err := app.ds.GetIP(r.Context(), name)
+	name, err := ipVar(r, "ip")
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data, err := app.getIP(r.Context(), name, date)
+	if err != nil {
+		app.writeWebError(w, r, err, "ip", name)
+		return
 	}
 
 	p := Page{data.Name, "Records", data}
@@ -344,23 +428,24 @@ func (app *appContext) prefixHandler(w http.ResponseWriter, r *http.Request) {
 	var data *model.PrefixList
 	params := mux.Vars(r)
 	prefixType := strings.ToLower(params["type"])
-	name := cleanDomain(params["prefix"])
+	name, err := domainVar(r, "prefix")
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	name = prefixLabel(name)
 	if prefixType == "active" {
 		data, err = app.ds.GetTakenPrefixes(r.Context(), name)
 
 	} else if prefixType == "available" {
 		data, err = app.ds.GetAvailablePrefixes(r.Context(), name)
 	} else {
-		server.WriteJSONError(w, server.ErrResourceNotFound)
+		app.writeErrorPage(w, server.ErrNotFound, ErrorPage{})
 		return
 	}
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			// TODO make http err (not json)
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "", name)
+		return
 	}
 
 	p := Page{name + " Prefix", "Search", data}
@@ -423,16 +508,16 @@ func (app *appContext) ipIndexHandler(w http.ResponseWriter, r *http.Request) {
 
 // research
 func (app *appContext) ipNsZoneCountHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	ip := cleanDomain(params["ip"])
+	ip, err := ipVar(r, "ip")
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
 
 	data, err := app.ds.GetIPNsZoneCount(r.Context(), ip)
 	if err != nil {
-		if err == datastore.ErrNoResource {
-			server.WriteJSONError(w, server.ErrResourceNotFound)
-			return
-		}
-		panic(err)
+		app.writeWebError(w, r, err, "ip", ip)
+		return
 	}
 
 	p := Page{"IP NS Zone Count", "Research", data}
@@ -450,28 +535,80 @@ func (app *appContext) trustTreeHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// danglingPageLimit is the number of dangling nameservers listed on the page, the API lists them all
+const danglingPageLimit = 500
+
+func (app *appContext) danglingHandler(w http.ResponseWriter, r *http.Request) {
+	date, zone, err := app.danglingParams(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "zone", r.URL.Query().Get("zone"))
+		return
+	}
+
+	data, err := app.ds.GetDanglingNameServers(r.Context(), date, zone, danglingPageLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	p := Page{"Dangling Nameservers", "Research", data}
+	err = app.templates.ExecuteTemplate(w, "dangling.tmpl", p)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// migrationsHandler shows the flows of the domains of ?zone= between nameserver operators as a Sankey diagram,
+// only the form until a zone is given
+func (app *appContext) migrationsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, top, err := migrationParams(r)
+	if err != nil {
+		app.writeWebError(w, r, err, "", "")
+		return
+	}
+	data := &model.ProviderMigration{From: from, To: to}
+	if value := strings.TrimSuffix(r.URL.Query().Get("zone"), "."); value != "" {
+		zone, err := parseDomain("zone", value)
+		if err != nil {
+			app.writeWebError(w, r, err, "", "")
+			return
+		}
+		data, err = app.ds.GetProviderMigration(r.Context(), zone, from, to, top)
+		if err != nil {
+			app.writeWebError(w, r, err, "zone", zone)
+			return
+		}
+	}
+
+	p := Page{"Provider Migrations", "Research", data}
+	err = app.templates.ExecuteTemplate(w, "migrations.tmpl", p)
+	if err != nil {
+		panic(err)
+	}
+}
+
 // From zonetools/parser/clean.go
 var punyCode = idna.Registration
 
 // cleanDomain lowercases all inputs and converts to punycode if necessary
 // assumes input domain to be unicode, if it is not, we will guess the encoding
 // and convert to UTF-8 return value should always be ASCII
-func cleanDomain(domain string) string {
+// returns an error if the domain is not a valid IDNA name
+func cleanDomain(domain string) (string, error) {
 	domain = strings.TrimSpace(domain)
 	// if ASCII just lowercase
 	if isASCII(domain) {
 		domain = strings.ToLower(domain)
-		return domain
+		return domain, nil
 	}
 	// for non ASCII domains, only lowercase ASCII portions
 	domain = asciiLower(domain)
 	// convert unicode to ascii via puny code
 	punycode, err := punyCode.ToASCII(domain)
 	if err != nil {
-		panic(fmt.Errorf("idna parse error on (%q -> %q) %w", domain, punycode, err))
+		return "", fmt.Errorf("idna parse error on (%q -> %q) %w", domain, punycode, err)
 	}
 	punycode = strings.ToLower(punycode)
-	return punycode
+	return punycode, nil
 }
 
 func isASCII(s string) bool {
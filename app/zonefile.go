@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnscoffee/datastore"
+	"dnscoffee/model"
+	"dnscoffee/server"
+
+	"github.com/miekg/dns"
+)
+
+// the zone file of a day is rebuilt from the delegations seen in the zone files that day
+// it holds the NS records of every domain of the zone and the in-zone glue of their nameservers,
+// enough for a resolver to replay the zone, but not the other records of the zone, which are not kept
+// the domains of the root zone are the TLDs, delegated to their nameservers with the addresses of those as glue
+
+const (
+	// zoneFileContentType is the media type of master files, RFC 4027
+	zoneFileContentType = "text/dns"
+	// zoneFileTTL is the $TTL of the zone files, as the TTLs of the records are not kept
+	zoneFileTTL = 86400
+)
+
+// zoneFileHeader returns the directives and apex records of the zone file of zone on date
+// the SOA is synthesized, with the first nameserver of the zone as its primary and the date as its serial
+func zoneFileHeader(zone string, date time.Time, nameservers []*model.NameServer) []string {
+	origin := dns.Fqdn(zone)
+	primary := origin
+	if len(nameservers) > 0 {
+		primary = dns.Fqdn(nameservers[0].Name)
+	}
+	displayZone := zone
+	if zone == "" {
+		displayZone = "."
+	}
+	lines := []string{
+		fmt.Sprintf("; zone file of %s on %s, rebuilt from the delegations seen in the zone files", displayZone, date.Format("2006-01-02")),
+		"; the SOA record is synthesized and the TTLs are not those of the zone",
+		"$ORIGIN " + origin,
+		fmt.Sprintf("$TTL %d", zoneFileTTL),
+	}
+	soa := model.ZoneRecord{
+		Name:  zone,
+		Type:  "SOA",
+		Value: fmt.Sprintf("%s %s %s00 1800 900 604800 %d", primary, dns.Fqdn(strings.TrimSuffix("hostmaster."+zone, ".")), date.Format("20060102"), zoneFileTTL),
+	}
+	lines = append(lines, soa.String())
+	for _, ns := range nameservers {
+		apex := model.ZoneRecord{Name: zone, Type: "NS", Value: ns.Name}
+		lines = append(lines, apex.String())
+	}
+	return lines
+}
+
+// apiZoneFileHandler streams the zone file of {zone} on ?date=, today by default
+func (app *appContext) apiZoneFileHandler(w http.ResponseWriter, r *http.Request) {
+	zone, err := domainVar(r, "zone")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	date, err := dateParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if date == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		date = &today
+	}
+	// also checks that the zone exists before anything is sent
+	apex, err := app.ds.GetZoneNameServers(r.Context(), zone, datastore.ListOptions{State: datastore.StateCurrent, Date: date, Limit: datastore.MaxPageSize})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	filename := zone
+	if zone == "" {
+		filename = "root"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"-"+date.Format("2006-01-02")+".zone"))
+	stream := server.NewTextStream(w, r, zoneFileContentType)
+	for _, line := range zoneFileHeader(zone, *date, apex.NameServers) {
+		if err = stream.Write(nil, line); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = app.ds.StreamZoneFile(r.Context(), zone, *date, func(rr *model.ZoneRecord) error {
+			return stream.Write(nil, rr)
+		})
+	}
+	if err = stream.Close(err); err != nil {
+		writeError(w, err)
+	}
+}
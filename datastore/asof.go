@@ -0,0 +1,324 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// the as of views are built from the base objects and the relationship listings so they work on any DataStore
+// the current relationships are the ones active on the date, and the archive ones ended before it
+
+// asOf returns the listing options for the relationships in state on date
+func asOf(state string, date time.Time) ListOptions {
+	return ListOptions{State: state, Date: &date}
+}
+
+// seenOn clamps the first and last seen dates of an object to date
+// an object first seen after date is not found, and one still seen on date has no last seen, like a current one
+func seenOn(first, last **time.Time, date time.Time) error {
+	if *first != nil && (*first).After(date) {
+		return ErrNoResource
+	}
+	if *last != nil && !(*last).Before(date) {
+		*last = nil
+	}
+	return nil
+}
+
+// GetDomainOn gets the provided domain with the nameservers it had on date
+func GetDomainOn(ctx context.Context, ds DataStore, domain string, date time.Time) (*model.Domain, error) {
+	d, err := ds.GetDomainBase(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	if err = seenOn(&d.FirstSeen, &d.LastSeen, date); err != nil {
+		return nil, err
+	}
+	current, err := ds.GetDomainNameServers(ctx, domain, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ds.GetDomainNameServers(ctx, domain, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	d.NameServers, d.NameServerCount = current.NameServers, &current.Total
+	d.ArchiveNameServers, d.ArchiveNameServerCount = archive.NameServers, &archive.Total
+	d.AsOf = &date
+	return d, nil
+}
+
+// GetZoneOn gets the provided zone with the nameservers it had on date
+func GetZoneOn(ctx context.Context, ds DataStore, zone string, date time.Time) (*model.Zone, error) {
+	z, err := ds.GetZoneBase(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	if err = seenOn(&z.FirstSeen, &z.LastSeen, date); err != nil {
+		return nil, err
+	}
+	current, err := ds.GetZoneNameServers(ctx, zone, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ds.GetZoneNameServers(ctx, zone, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	z.NameServers, z.NameServerCount = current.NameServers, &current.Total
+	z.ArchiveNameServers, z.ArchiveNameServerCount = archive.NameServers, &archive.Total
+	z.AsOf = &date
+	return z, nil
+}
+
+// GetIPOn gets the provided IP with the nameservers that used it on date
+func GetIPOn(ctx context.Context, ds DataStore, ip string, date time.Time) (*model.IP, error) {
+	i, err := ds.GetIPBase(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	if err = seenOn(&i.FirstSeen, &i.LastSeen, date); err != nil {
+		return nil, err
+	}
+	current, err := ds.GetIPNameServers(ctx, ip, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ds.GetIPNameServers(ctx, ip, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	i.NameServers, i.NameServerCount = current.NameServers, &current.Total
+	i.ArchiveNameServers, i.ArchiveNameServerCount = archive.NameServers, &archive.Total
+	i.AsOf = &date
+	return i, nil
+}
+
+// GetNameServerOn gets the provided nameserver with the domains and IPs it had on date
+func GetNameServerOn(ctx context.Context, ds DataStore, nameserver string, date time.Time) (*model.NameServer, error) {
+	ns, err := ds.GetNameServerBase(ctx, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	if err = seenOn(&ns.FirstSeen, &ns.LastSeen, date); err != nil {
+		return nil, err
+	}
+	current, err := ds.GetNameServerDomains(ctx, nameserver, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ds.GetNameServerDomains(ctx, nameserver, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	ns.Domains, ns.DomainCount = current.Domains, &current.Total
+	ns.ArchiveDomains, ns.ArchiveDomainCount = archive.Domains, &archive.Total
+
+	current4, err := ds.GetNameServerIPs(ctx, nameserver, 4, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive4, err := ds.GetNameServerIPs(ctx, nameserver, 4, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	ns.IP4, ns.IP4Count = toIP4s(current4.IPs), &current4.Total
+	ns.ArchiveIP4, ns.ArchiveIP4Count = toIP4s(archive4.IPs), &archive4.Total
+
+	current6, err := ds.GetNameServerIPs(ctx, nameserver, 6, asOf(StateCurrent, date))
+	if err != nil {
+		return nil, err
+	}
+	archive6, err := ds.GetNameServerIPs(ctx, nameserver, 6, asOf(StateArchive, date))
+	if err != nil {
+		return nil, err
+	}
+	ns.IP6, ns.IP6Count = toIP6s(current6.IPs), &current6.Total
+	ns.ArchiveIP6, ns.ArchiveIP6Count = toIP6s(archive6.IPs), &archive6.Total
+
+	ns.AsOf = &date
+	return ns, nil
+}
+
+func toIP4s(ips []*model.IP) []*model.IP4 {
+	out := make([]*model.IP4, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, &model.IP4{IP: *ip})
+	}
+	return out
+}
+
+func toIP6s(ips []*model.IP) []*model.IP6 {
+	out := make([]*model.IP6, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, &model.IP6{IP: *ip})
+	}
+	return out
+}
+
+// seenQuery selects the first and last seen dates of the rows of table with column $1, NULL first as the Get methods do
+func seenQuery(table, column string) string {
+	return fmt.Sprintf(`SELECT (array_agg(first_seen ORDER BY first_seen ASC NULLS FIRST))[1],
+		(array_agg(last_seen ORDER BY last_seen DESC NULLS FIRST))[1]
+		FROM %s WHERE %s = $1`, table, column)
+}
+
+// GetDomainBase gets the domain with its zone and seen dates, without its nameservers
+func (ds *PostgresDataStore) GetDomainBase(ctx context.Context, domain string) (*model.Domain, error) {
+	d := &model.Domain{Name: domain, Zone: &model.Zone{}}
+	var err error
+	d.ID, d.Zone.ID, err = ds.GetDomainID(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.db.QueryRow(ctx, "select zones.zone, zone_imports.first_import_date, zone_imports.last_import_date from zones, zone_imports where zones.id = zone_imports.zone_id and zones.id = $1 limit 1", d.Zone.ID).Scan(&d.Zone.Name, &d.Zone.FirstSeen, &d.Zone.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.db.QueryRow(ctx, seenQuery("domains_nameservers", "domain_id"), d.ID).Scan(&d.FirstSeen, &d.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetZoneBase gets the zone with its seen dates, without its nameservers
+func (ds *PostgresDataStore) GetZoneBase(ctx context.Context, zone string) (*model.Zone, error) {
+	z := &model.Zone{Name: zone}
+	var err error
+	z.ID, err = ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.db.QueryRow(ctx, seenQuery("zones_nameservers", "zone_id"), z.ID).Scan(&z.FirstSeen, &z.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// GetIPBase gets the IP with its seen dates, without its nameservers
+func (ds *PostgresDataStore) GetIPBase(ctx context.Context, name string) (*model.IP, error) {
+	ip := &model.IP{}
+	var err error
+	ip.ID, ip.Version, err = ds.GetIPID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	netIP := net.ParseIP(name)
+	if netIP == nil {
+		return nil, fmt.Errorf("unable top parse IP %s", name)
+	}
+	ip.IP = &netIP
+	ip.Name = ip.IPString()
+	query := seenQuery("a_nameservers", "a_id")
+	if ip.Version == 6 {
+		query = seenQuery("aaaa_nameservers", "aaaa_id")
+	}
+	err = ds.db.QueryRow(ctx, query, ip.ID).Scan(&ip.FirstSeen, &ip.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+// GetNameServerBase gets the nameserver with its seen dates and the zone of its glue, without its domains and IPs
+func (ds *PostgresDataStore) GetNameServerBase(ctx context.Context, name string) (*model.NameServer, error) {
+	ns := &model.NameServer{Name: name}
+	var err error
+	ns.ID, err = ds.GetNameServerID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.db.QueryRow(ctx, "select first_seen, last_seen from nameserver_metadata where nameserver_id = $1", ns.ID).Scan(&ns.FirstSeen, &ns.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	var z model.Zone
+	err = ds.db.QueryRow(ctx, `SELECT zones.zone, zones.id, zone_imports.first_import_date, zone_imports.last_import_date
+		FROM zones, zone_imports
+		WHERE zones.id = zone_imports.zone_id AND zones.id = (SELECT ans.zone_id FROM a_nameservers ans WHERE ans.nameserver_id = $1 limit 1)`, ns.ID).Scan(&z.Name, &z.ID, &z.FirstSeen, &z.LastSeen)
+	switch err {
+	case nil:
+		ns.Zone = &z
+	case pgx.ErrNoRows:
+		// without glue the zone of the nameserver is not imported
+	default:
+		return nil, err
+	}
+	return ns, nil
+}
+
+// GetDomainBase gets the domain with its zone and seen dates, without its nameservers
+func (ds *MemoryDataStore) GetDomainBase(ctx context.Context, domain string) (*model.Domain, error) {
+	md, ok := ds.domainsByName[domain]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	d := &model.Domain{ID: md.id, Name: md.name}
+	mz := ds.zonesByID[md.zoneID]
+	d.Zone = &model.Zone{ID: mz.id, Name: mz.name}
+	if len(mz.imports) > 0 {
+		d.Zone.FirstSeen = &mz.firstImport().date
+		d.Zone.LastSeen = &mz.lastImport().date
+	}
+	edges := ds.domainsNameservers.byParent[md.id]
+	d.FirstSeen = memFirstSeen(edges)
+	d.LastSeen = memLastSeen(edges)
+	return d, nil
+}
+
+// GetZoneBase gets the zone with its seen dates, without its nameservers
+func (ds *MemoryDataStore) GetZoneBase(ctx context.Context, zone string) (*model.Zone, error) {
+	mz, ok := ds.zonesByName[zone]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	edges := ds.zonesNameservers.byParent[mz.id]
+	return &model.Zone{ID: mz.id, Name: mz.name, FirstSeen: memFirstSeen(edges), LastSeen: memLastSeen(edges)}, nil
+}
+
+// GetIPBase gets the IP with its seen dates, without its nameservers
+func (ds *MemoryDataStore) GetIPBase(ctx context.Context, name string) (*model.IP, error) {
+	ip := &model.IP{}
+	var err error
+	ip.ID, ip.Version, err = ds.GetIPID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	netIP := net.ParseIP(name)
+	ip.IP = &netIP
+	ip.Name = ip.IPString()
+	edges := ds.ipNameservers[ip.Version].byChild[ip.ID]
+	ip.FirstSeen = memFirstSeen(edges)
+	ip.LastSeen = memLastSeen(edges)
+	return ip, nil
+}
+
+// GetNameServerBase gets the nameserver with its seen dates and the zone of its glue, without its domains and IPs
+func (ds *MemoryDataStore) GetNameServerBase(ctx context.Context, name string) (*model.NameServer, error) {
+	mns, ok := ds.nameserversByName[name]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	ns := &model.NameServer{ID: mns.id, Name: mns.name}
+	a := ds.ipNameservers[4].byParent[mns.id]
+	all := append(append(append([]*memEdge{}, ds.domainsNameservers.byChild[mns.id]...), a...), ds.ipNameservers[6].byParent[mns.id]...)
+	ns.FirstSeen = memFirstSeen(all)
+	ns.LastSeen = memLastSeen(all)
+	if len(a) > 0 && a[0].zoneID != 0 {
+		mz := ds.zonesByID[a[0].zoneID]
+		ns.Zone = &model.Zone{ID: mz.id, Name: mz.name}
+		if len(mz.imports) > 0 {
+			ns.Zone.FirstSeen = &mz.firstImport().date
+			ns.Zone.LastSeen = &mz.lastImport().date
+		}
+	}
+	return ns, nil
+}
@@ -0,0 +1,229 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+
+	"dnscoffee/model"
+)
+
+// the batched relationship loaders get the relationships of many parents in one query
+// they take the State, Date and Limit of ListOptions, Limit being per parent, and ignore Page
+// the results are keyed by parent ID, parents without relationships in the state are left out
+
+// inner queries for the batched loaders
+// each selects parent, grp, id, name, first_seen and last_seen for the parent IDs in $1
+const (
+	domainsNameServersBatch = "SELECT dns.domain_id AS parent, 0 AS grp, ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.id AND dns.domain_id = ANY($1)"
+	zonesNameServersBatch   = "SELECT zns.zone_id AS parent, 0 AS grp, ns.id, ns.domain AS name, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.id AND zns.zone_id = ANY($1)"
+	nameServersDomainsBatch = "SELECT dns.nameserver_id AS parent, 0 AS grp, d.id, d.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE dns.domain_id = d.id AND dns.nameserver_id = ANY($1)"
+	zonesDomainsBatch       = "SELECT d.zone_id AS parent, 0 AS grp, d.id, d.domain AS name, s.first_seen, s.last_seen FROM domains d, LATERAL (SELECT " + seenAggregate + ", count(*) AS n FROM domains_nameservers WHERE domain_id = d.id) s WHERE s.n > 0 AND d.zone_id = ANY($1)"
+	aNameServersBatch       = "SELECT ans.a_id AS parent, 0 AS grp, ns.id, ns.domain AS name, ans.first_seen, ans.last_seen FROM a_nameservers ans, nameservers ns WHERE ans.nameserver_id = ns.id AND ans.a_id = ANY($1)"
+	aaaaNameServersBatch    = "SELECT ans.aaaa_id AS parent, 0 AS grp, ns.id, ns.domain AS name, ans.first_seen, ans.last_seen FROM aaaa_nameservers ans, nameservers ns WHERE ans.nameserver_id = ns.id AND ans.aaaa_id = ANY($1)"
+	nameServersIP4Batch     = "SELECT ans.nameserver_id AS parent, 4 AS grp, ip.id, host(ip.ip) AS name, ans.first_seen, ans.last_seen FROM a_nameservers ans, a ip WHERE ans.a_id = ip.id AND ans.nameserver_id = ANY($1)"
+	nameServersIP6Batch     = "SELECT ans.nameserver_id AS parent, 6 AS grp, ip.id, host(ip.ip) AS name, ans.first_seen, ans.last_seen FROM aaaa_nameservers ans, aaaa ip WHERE ans.aaaa_id = ip.id AND ans.nameserver_id = ANY($1)"
+)
+
+// batchEdges runs the batched query inner for the parent IDs
+// returns the first opts.Limit rows of each parent in listing order
+func (ds *PostgresDataStore) batchEdges(ctx context.Context, inner string, ids []int64, opts ListOptions) (map[int64][]*edgeRow, error) {
+	out := make(map[int64][]*edgeRow)
+	if len(ids) == 0 {
+		return out, nil
+	}
+	where, args := stateCondition(opts, []interface{}{ids})
+	query := fmt.Sprintf(`SELECT e.parent, e.grp, e.id, e.name, e.first_seen, e.last_seen
+		FROM (
+			SELECT e.*, row_number() OVER (PARTITION BY e.parent ORDER BY e.grp, e.id, coalesce(e.first_seen, '1970-01-01'::date)) AS n
+			FROM (%s) e
+			WHERE %s
+		) e
+		WHERE e.n <= %d
+		ORDER BY e.parent, e.n`, inner, where, opts.limit())
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var parent int64
+		var r edgeRow
+		err = rows.Scan(&parent, &r.group, &r.id, &r.name, &r.firstSeen, &r.lastSeen)
+		if err != nil {
+			return nil, err
+		}
+		out[parent] = append(out[parent], &r)
+	}
+	return out, rows.Err()
+}
+
+func batchNameServers(batch map[int64][]*edgeRow) map[int64][]*model.NameServer {
+	out := make(map[int64][]*model.NameServer, len(batch))
+	for parent, rows := range batch {
+		out[parent] = rowsToNameServers(rows)
+	}
+	return out
+}
+
+func batchDomains(batch map[int64][]*edgeRow) map[int64][]*model.Domain {
+	out := make(map[int64][]*model.Domain, len(batch))
+	for parent, rows := range batch {
+		out[parent] = rowsToDomains(rows)
+	}
+	return out
+}
+
+func batchIPs(batch map[int64][]*edgeRow) map[int64][]*model.IP {
+	out := make(map[int64][]*model.IP, len(batch))
+	for parent, rows := range batch {
+		out[parent] = rowsToIPs(rows)
+	}
+	return out
+}
+
+// GetDomainsNameServers gets the nameservers of each of the domains
+func (ds *PostgresDataStore) GetDomainsNameServers(ctx context.Context, domainIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	batch, err := ds.batchEdges(ctx, domainsNameServersBatch, domainIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
+
+// GetZonesNameServers gets the nameservers of each of the zones
+func (ds *PostgresDataStore) GetZonesNameServers(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	batch, err := ds.batchEdges(ctx, zonesNameServersBatch, zoneIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
+
+// GetNameServersDomains gets the domains using each of the nameservers
+func (ds *PostgresDataStore) GetNameServersDomains(ctx context.Context, nameserverIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error) {
+	batch, err := ds.batchEdges(ctx, nameServersDomainsBatch, nameserverIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchDomains(batch), nil
+}
+
+// GetZonesDomains gets the domains delegated in each of the zones
+func (ds *PostgresDataStore) GetZonesDomains(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error) {
+	batch, err := ds.batchEdges(ctx, zonesDomainsBatch, zoneIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchDomains(batch), nil
+}
+
+// GetNameServersIPs gets the IPs of version 4 or 6 of each of the nameservers
+func (ds *PostgresDataStore) GetNameServersIPs(ctx context.Context, nameserverIDs []int64, version int, opts ListOptions) (map[int64][]*model.IP, error) {
+	query := nameServersIP4Batch
+	if version == 6 {
+		query = nameServersIP6Batch
+	}
+	batch, err := ds.batchEdges(ctx, query, nameserverIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchIPs(batch), nil
+}
+
+// GetIPsNameServers gets the nameservers using each of the IPs of version 4 or 6
+func (ds *PostgresDataStore) GetIPsNameServers(ctx context.Context, ipIDs []int64, version int, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	query := aNameServersBatch
+	if version == 6 {
+		query = aaaaNameServersBatch
+	}
+	batch, err := ds.batchEdges(ctx, query, ipIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
+
+// memBatch pages through the edges of each of the IDs
+// edges returns the edges of an ID and row the listed side of each edge
+func memBatch(ids []int64, opts ListOptions, edges func(id int64) []*memEdge, row func(e *memEdge) *edgeRow) (map[int64][]*edgeRow, error) {
+	opts.Page = ""
+	out := make(map[int64][]*edgeRow)
+	for _, id := range ids {
+		rows, _, _, _, err := memPage(edges(id), opts, row)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) > 0 {
+			out[id] = rows
+		}
+	}
+	return out, nil
+}
+
+// GetDomainsNameServers gets the nameservers of each of the domains
+func (ds *MemoryDataStore) GetDomainsNameServers(ctx context.Context, domainIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	batch, err := memBatch(domainIDs, opts,
+		func(id int64) []*memEdge { return ds.domainsNameservers.byParent[id] },
+		func(e *memEdge) *edgeRow { return ds.nameServerRow(e.child, e) })
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
+
+// GetZonesNameServers gets the nameservers of each of the zones
+func (ds *MemoryDataStore) GetZonesNameServers(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	batch, err := memBatch(zoneIDs, opts,
+		func(id int64) []*memEdge { return ds.zonesNameservers.byParent[id] },
+		func(e *memEdge) *edgeRow { return ds.nameServerRow(e.child, e) })
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
+
+// GetNameServersDomains gets the domains using each of the nameservers
+func (ds *MemoryDataStore) GetNameServersDomains(ctx context.Context, nameserverIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error) {
+	batch, err := memBatch(nameserverIDs, opts,
+		func(id int64) []*memEdge { return ds.domainsNameservers.byChild[id] },
+		func(e *memEdge) *edgeRow {
+			return &edgeRow{id: e.parent, name: ds.domainsByID[e.parent].name, firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+		})
+	if err != nil {
+		return nil, err
+	}
+	return batchDomains(batch), nil
+}
+
+// GetZonesDomains gets the domains delegated in each of the zones
+func (ds *MemoryDataStore) GetZonesDomains(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error) {
+	batch, err := memBatch(zoneIDs, opts, ds.zoneDomainEdges, ds.domainRow)
+	if err != nil {
+		return nil, err
+	}
+	return batchDomains(batch), nil
+}
+
+// GetNameServersIPs gets the IPs of version 4 or 6 of each of the nameservers
+func (ds *MemoryDataStore) GetNameServersIPs(ctx context.Context, nameserverIDs []int64, version int, opts ListOptions) (map[int64][]*model.IP, error) {
+	batch, err := memBatch(nameserverIDs, opts,
+		func(id int64) []*memEdge { return ds.ipNameservers[version].byParent[id] },
+		func(e *memEdge) *edgeRow {
+			return &edgeRow{group: version, id: e.child, name: ds.ipsByID[version][e.child].ip.String(), firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+		})
+	if err != nil {
+		return nil, err
+	}
+	return batchIPs(batch), nil
+}
+
+// GetIPsNameServers gets the nameservers using each of the IPs of version 4 or 6
+func (ds *MemoryDataStore) GetIPsNameServers(ctx context.Context, ipIDs []int64, version int, opts ListOptions) (map[int64][]*model.NameServer, error) {
+	batch, err := memBatch(ipIDs, opts,
+		func(id int64) []*memEdge { return ds.ipNameservers[version].byChild[id] },
+		func(e *memEdge) *edgeRow { return ds.nameServerRow(e.parent, e) })
+	if err != nil {
+		return nil, err
+	}
+	return batchNameServers(batch), nil
+}
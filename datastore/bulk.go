@@ -0,0 +1,181 @@
+package datastore
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// the bulk lookups find many names with a query per table
+// the results are keyed by the names found, with the IDs, first and last seen dates and zones set
+// the relationships are left for the batched loaders
+
+// seenAggregate selects the first_seen and last_seen of a resource from the edges of one of its tables
+// a NULL first_seen or last_seen wins, as in the single lookups
+const seenAggregate = `CASE WHEN bool_or(first_seen IS NULL) THEN NULL ELSE min(first_seen) END AS first_seen,
+	CASE WHEN bool_or(last_seen IS NULL) THEN NULL ELSE max(last_seen) END AS last_seen`
+
+// GetDomainsByName looks up the domains with their zone
+func (ds *PostgresDataStore) GetDomainsByName(ctx context.Context, names []string) (map[string]*model.Domain, error) {
+	out := make(map[string]*model.Domain, len(names))
+	rows, err := ds.db.Query(ctx, `SELECT d.id, d.domain, z.id, z.zone, s.first_seen, s.last_seen
+		FROM domains d
+		JOIN zones z ON z.id = d.zone_id
+		LEFT JOIN LATERAL (SELECT `+seenAggregate+` FROM domains_nameservers WHERE domain_id = d.id) s ON true
+		WHERE d.domain = ANY($1)`, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := &model.Domain{Zone: &model.Zone{}}
+		err = rows.Scan(&d.ID, &d.Name, &d.Zone.ID, &d.Zone.Name, &d.FirstSeen, &d.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		out[d.Name] = d
+	}
+	return out, rows.Err()
+}
+
+// GetNameServersByName looks up the nameservers with the zone of their glue records
+func (ds *PostgresDataStore) GetNameServersByName(ctx context.Context, names []string) (map[string]*model.NameServer, error) {
+	out := make(map[string]*model.NameServer, len(names))
+	rows, err := ds.db.Query(ctx, `SELECT ns.id, ns.domain, m.first_seen, m.last_seen, z.id, z.zone
+		FROM nameservers ns
+		LEFT JOIN nameserver_metadata m ON m.nameserver_id = ns.id
+		LEFT JOIN LATERAL (SELECT zone_id FROM a_nameservers WHERE nameserver_id = ns.id LIMIT 1) glue ON true
+		LEFT JOIN zones z ON z.id = glue.zone_id
+		WHERE ns.domain = ANY($1)`, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ns model.NameServer
+		var zoneID *int64
+		var zoneName *string
+		err = rows.Scan(&ns.ID, &ns.Name, &ns.FirstSeen, &ns.LastSeen, &zoneID, &zoneName)
+		if err != nil {
+			return nil, err
+		}
+		if zoneID != nil {
+			ns.Zone = &model.Zone{ID: *zoneID, Name: *zoneName}
+		}
+		out[ns.Name] = &ns
+	}
+	return out, rows.Err()
+}
+
+// GetIPsByName looks up the IPs, keyed by their name as net.IP formats it
+func (ds *PostgresDataStore) GetIPsByName(ctx context.Context, ips []string) (map[string]*model.IP, error) {
+	out := make(map[string]*model.IP, len(ips))
+	byVersion := map[int][]string{4: {}, 6: {}}
+	for _, ip := range ips {
+		version := 4
+		if strings.Contains(ip, ":") {
+			version = 6
+		}
+		byVersion[version] = append(byVersion[version], ip)
+	}
+	tables := map[int][2]string{4: {"a", "a_nameservers"}, 6: {"aaaa", "aaaa_nameservers"}}
+	for _, version := range []int{4, 6} {
+		if len(byVersion[version]) == 0 {
+			continue
+		}
+		table, edges := tables[version][0], tables[version][1]
+		rows, err := ds.db.Query(ctx, `SELECT ip.id, host(ip.ip), s.first_seen, s.last_seen
+			FROM `+table+` ip
+			LEFT JOIN LATERAL (SELECT `+seenAggregate+` FROM `+edges+` WHERE `+table+`_id = ip.id) s ON true
+			WHERE ip.ip = ANY($1::text[]::inet[])`, byVersion[version])
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			ip := &model.IP{Version: version}
+			var host string
+			err = rows.Scan(&ip.ID, &host, &ip.FirstSeen, &ip.LastSeen)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			netIP := net.ParseIP(host)
+			ip.IP = &netIP
+			ip.Name = ip.IPString()
+			out[netIP.String()] = ip
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+	}
+	return out, nil
+}
+
+// seen returns the first and last seen dates of the edges
+func seen(edges []*memEdge) (*time.Time, *time.Time) {
+	return memFirstSeen(edges), memLastSeen(edges)
+}
+
+// GetDomainsByName looks up the domains with their zone
+func (ds *MemoryDataStore) GetDomainsByName(ctx context.Context, names []string) (map[string]*model.Domain, error) {
+	out := make(map[string]*model.Domain, len(names))
+	for _, name := range names {
+		md, ok := ds.domainsByName[name]
+		if !ok {
+			continue
+		}
+		mz := ds.zonesByID[md.zoneID]
+		d := &model.Domain{ID: md.id, Name: md.name, Zone: &model.Zone{ID: mz.id, Name: mz.name}}
+		d.FirstSeen, d.LastSeen = seen(ds.domainsNameservers.byParent[md.id])
+		out[name] = d
+	}
+	return out, nil
+}
+
+// GetNameServersByName looks up the nameservers with the zone of their glue records
+func (ds *MemoryDataStore) GetNameServersByName(ctx context.Context, names []string) (map[string]*model.NameServer, error) {
+	out := make(map[string]*model.NameServer, len(names))
+	for _, name := range names {
+		mns, ok := ds.nameserversByName[name]
+		if !ok {
+			continue
+		}
+		ns := &model.NameServer{ID: mns.id, Name: mns.name}
+		a := ds.ipNameservers[4].byParent[mns.id]
+		all := make([]*memEdge, 0)
+		all = append(append(append(all, ds.domainsNameservers.byChild[mns.id]...), a...), ds.ipNameservers[6].byParent[mns.id]...)
+		ns.FirstSeen, ns.LastSeen = seen(all)
+		if len(a) > 0 && a[0].zoneID != 0 {
+			mz := ds.zonesByID[a[0].zoneID]
+			ns.Zone = &model.Zone{ID: mz.id, Name: mz.name}
+		}
+		out[name] = ns
+	}
+	return out, nil
+}
+
+// GetIPsByName looks up the IPs, keyed by their name as net.IP formats it
+func (ds *MemoryDataStore) GetIPsByName(ctx context.Context, ips []string) (map[string]*model.IP, error) {
+	out := make(map[string]*model.IP, len(ips))
+	for _, name := range ips {
+		id, version, err := ds.GetIPID(ctx, name)
+		if err == ErrNoResource {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		mip := ds.ipsByID[version][id]
+		ip := &model.IP{ID: id, Version: version}
+		netIP := mip.ip
+		ip.IP = &netIP
+		ip.Name = ip.IPString()
+		ip.FirstSeen, ip.LastSeen = seen(ds.ipNameservers[version].byChild[id])
+		out[netIP.String()] = ip
+	}
+	return out, nil
+}
@@ -0,0 +1,172 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"dnscoffee/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// granularities of the history counts, named after the date_trunc fields
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+	GranularityYear  = "year"
+)
+
+// maxCountBuckets limits the number of buckets returned for a single zone
+const maxCountBuckets = 50000
+
+// CountsOptions selects the range and bucket size of the history counts
+// From and To are inclusive, Zones limits GetAllZoneHistoryCounts to the named zones
+// the zero value keeps the default range and buckets of each method
+type CountsOptions struct {
+	From        *time.Time
+	To          *time.Time
+	Granularity string
+	Zones       []string
+}
+
+// ValidGranularity returns true if g is one of the supported granularities
+func ValidGranularity(g string) bool {
+	switch g {
+	case GranularityDay, GranularityWeek, GranularityMonth, GranularityYear:
+		return true
+	}
+	return false
+}
+
+func (opts CountsOptions) isZero() bool {
+	return opts.From == nil && opts.To == nil && opts.Granularity == "" && opts.Zones == nil
+}
+
+func (opts CountsOptions) granularity(fallback string) string {
+	if opts.Granularity == "" {
+		return fallback
+	}
+	return opts.Granularity
+}
+
+func (opts CountsOptions) inRange(date time.Time) bool {
+	return (opts.From == nil || !date.Before(*opts.From)) && (opts.To == nil || !date.After(*opts.To))
+}
+
+// truncDate mirrors date_trunc(granularity, date)
+func truncDate(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return truncWeek(t)
+	case GranularityMonth:
+		return truncMonth(t)
+	case GranularityYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+func scanCounts(rows pgx.Rows) ([]*model.ZoneCounts, error) {
+	history := make([]*model.ZoneCounts, 0, 100)
+	for rows.Next() {
+		var c model.ZoneCounts
+		err := rows.Scan(&c.Date, &c.Domains, &c.Old, &c.Moved, &c.New)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &c)
+	}
+	return history, rows.Err()
+}
+
+// internetHistoryCounts sums weighted_counts of every zone per day, then buckets the days
+func (ds *PostgresDataStore) internetHistoryCounts(ctx context.Context, opts CountsOptions) (*model.ZoneCount, error) {
+	rows, err := ds.db.Query(ctx, `WITH
+		s as (select date,
+				sum(domains) as domains,
+				sum(old) as old,
+				sum(moved) as moved,
+				sum(new) as new
+			from weighted_counts
+			where ($2::date IS NULL OR date >= $2) AND ($3::date IS NULL OR date <= $3)
+			group by 1)
+		select date_trunc($1::text, date) AS bucket,
+			floor(AVG(domains)) as domains,
+			sum(old) as old,
+			sum(moved) as moved,
+			sum(new) as new
+		from s
+		group by 1
+		order by 1 desc
+		limit $4`, opts.granularity(GranularityWeek), opts.From, opts.To, maxCountBuckets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history, err := scanCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneCount{Zone: "", History: history}, nil
+}
+
+// zoneHistoryCounts buckets the import_info counts of zone
+func (ds *PostgresDataStore) zoneHistoryCounts(ctx context.Context, zone string, opts CountsOptions) (*model.ZoneCount, error) {
+	rows, err := ds.db.Query(ctx, `select
+			date_trunc($2::text, date) as bucket,
+			floor(AVG(domains)) as domains,
+			sum(feed_old) as old,
+			sum(feed_moved) as moved,
+			sum(feed_new) as new
+		from import_info, zones
+		where zone_id = zones.id
+			and zones.zone = $1
+			and ($3::date IS NULL OR date >= $3) AND ($4::date IS NULL OR date <= $4)
+		group by 1
+		order by 1 desc
+		limit $5`, zone, opts.granularity(GranularityWeek), opts.From, opts.To, maxCountBuckets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history, err := scanCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneCount{Zone: zone, History: history}, nil
+}
+
+// memHistory buckets the imports in the range of opts, most recent first
+func memHistory(imports []*memImport, opts CountsOptions, granularity string) []*model.ZoneCounts {
+	buckets := make(map[time.Time]*memBucket)
+	for _, i := range imports {
+		if !opts.inRange(i.date) {
+			continue
+		}
+		date := truncDate(i.date, granularity)
+		if _, ok := buckets[date]; !ok {
+			buckets[date] = &memBucket{date: date}
+		}
+		buckets[date].add(i)
+	}
+	history := make([]*model.ZoneCounts, 0, len(buckets))
+	for _, b := range buckets {
+		history = append(history, b.counts())
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Date.After(history[j].Date) })
+	if len(history) > maxCountBuckets {
+		history = history[:maxCountBuckets]
+	}
+	return history
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
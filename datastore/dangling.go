@@ -0,0 +1,270 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/psl"
+)
+
+// the dangling nameservers are the nameservers with domains delegated to them on a date
+// that are named under a domain not delegated in its zone, or that have no A or AAAA glue
+// only the nameservers named in an imported zone are checked, as nothing is known of the others
+// the domain a nameserver is named under is its registrable domain by the ICANN rules of the Public Suffix List,
+// the one the trust trees follow
+
+// danglingDomainsLimit is the number of the domains delegated to a dangling nameserver listed with it
+const danglingDomainsLimit = 20
+
+// errListFull stops a stream once the list collecting it is full
+var errListFull = errors.New("list full")
+
+// collectDanglingNameServers collects up to limit rows of StreamDanglingNameServers, all of them if limit is 0
+func collectDanglingNameServers(ctx context.Context, ds DataStore, date time.Time, zone string, limit int) (*model.DanglingNameServers, error) {
+	dn := &model.DanglingNameServers{Date: date, Zone: zone, NameServers: make([]*model.DanglingNameServer, 0)}
+	err := ds.StreamDanglingNameServers(ctx, date, zone, func(ns *model.DanglingNameServer) error {
+		if limit > 0 && len(dn.NameServers) >= limit {
+			dn.Truncated = true
+			return errListFull
+		}
+		dn.NameServers = append(dn.NameServers, ns)
+		return nil
+	})
+	if err != nil && err != errListFull {
+		return nil, err
+	}
+	return dn, nil
+}
+
+// danglingParent returns the domain the nameserver name is named under and its zone,
+// ok is false when the zone is not in zone, if it is set, or is not imported
+func danglingParent(name, zone string, imported func(zone string) bool) (parent, parentZone string, ok bool) {
+	parent = psl.ICANNRegistrableDomain(name)
+	if parent == "" {
+		return "", "", false
+	}
+	parentZone = parent[strings.IndexByte(parent, '.')+1:]
+	if (zone != "" && parentZone != zone) || !imported(parentZone) {
+		return "", "", false
+	}
+	return parent, parentZone, true
+}
+
+// danglingBatch checks the nameservers with delegated domains a page of nameservers at a time,
+// and lists the domains of the dangling ones before passing them on to fn
+type danglingBatch struct {
+	ctx  context.Context
+	ds   DataStore
+	opts ListOptions
+	// registered returns which of the domains are delegated on the date
+	registered  func(domains []string) (map[string]bool, error)
+	fn          func(ns *model.DanglingNameServer) error
+	nameservers []*model.DanglingNameServer
+}
+
+func newDanglingBatch(ctx context.Context, ds DataStore, date time.Time, registered func(domains []string) (map[string]bool, error), fn func(ns *model.DanglingNameServer) error) *danglingBatch {
+	return &danglingBatch{
+		ctx:         ctx,
+		ds:          ds,
+		opts:        ListOptions{State: StateCurrent, Date: &date, Limit: danglingDomainsLimit},
+		registered:  registered,
+		fn:          fn,
+		nameservers: make([]*model.DanglingNameServer, 0, PageSize),
+	}
+}
+
+// add queues the nameserver, with the domain it is named under and whether it has no address set,
+// flushing the batch when it is full
+func (b *danglingBatch) add(ns *model.DanglingNameServer) error {
+	b.nameservers = append(b.nameservers, ns)
+	if len(b.nameservers) < PageSize {
+		return nil
+	}
+	return b.flush()
+}
+
+// flush checks the queued nameservers, lists the domains of the dangling ones and passes them on
+func (b *danglingBatch) flush() error {
+	if len(b.nameservers) == 0 {
+		return nil
+	}
+	parents := make([]string, 0, len(b.nameservers))
+	for _, ns := range b.nameservers {
+		parents = append(parents, ns.Domain)
+	}
+	registered, err := b.registered(parents)
+	if err != nil {
+		return err
+	}
+	dangling := make([]*model.DanglingNameServer, 0)
+	ids := make([]int64, 0)
+	for _, ns := range b.nameservers {
+		ns.Unregistered = !registered[ns.Domain]
+		if ns.Unregistered || ns.NoAddress {
+			dangling = append(dangling, ns)
+			ids = append(ids, ns.ID)
+		}
+	}
+	b.nameservers = b.nameservers[:0]
+	if len(dangling) == 0 {
+		return nil
+	}
+	domains, err := b.ds.GetNameServersDomains(b.ctx, ids, b.opts)
+	if err != nil {
+		return err
+	}
+	for _, ns := range dangling {
+		for _, d := range domains[ns.ID] {
+			ns.Domains = append(ns.Domains, d.Name)
+		}
+		if err = b.fn(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDanglingNameServers gets up to limit dangling nameservers on date, named in zone if it is set
+func (ds *PostgresDataStore) GetDanglingNameServers(ctx context.Context, date time.Time, zone string, limit int) (*model.DanglingNameServers, error) {
+	return collectDanglingNameServers(ctx, ds, date, zone, limit)
+}
+
+// activeNameServersQuery selects the nameservers with domains delegated to them on $1, named under $2 if it is set,
+// whether they have no address, and the count of their domains in each zone, by nameserver
+const activeNameServersQuery = `WITH active AS (
+		SELECT nameserver_id, domain_id FROM domains_nameservers
+		WHERE (first_seen IS NULL OR first_seen <= $1) AND (last_seen IS NULL OR last_seen >= $1)
+	)
+	SELECT ns.id, ns.domain,
+		NOT EXISTS (SELECT 1 FROM a_nameservers ans
+			WHERE ans.nameserver_id = ns.id AND (ans.first_seen IS NULL OR ans.first_seen <= $1) AND (ans.last_seen IS NULL OR ans.last_seen >= $1))
+		AND NOT EXISTS (SELECT 1 FROM aaaa_nameservers ans
+			WHERE ans.nameserver_id = ns.id AND (ans.first_seen IS NULL OR ans.first_seen <= $1) AND (ans.last_seen IS NULL OR ans.last_seen >= $1)),
+		dz.zone, count(*)
+	FROM nameservers ns, active a, domains d, zones dz
+	WHERE a.nameserver_id = ns.id AND d.id = a.domain_id AND dz.id = d.zone_id
+		AND ($2 = '' OR ns.domain LIKE '%.' || $2)
+	GROUP BY ns.id, ns.domain, dz.zone
+	ORDER BY ns.domain`
+
+// registeredDomainsQuery selects which of the domains $1 are delegated on $2
+const registeredDomainsQuery = `SELECT DISTINCT d.domain FROM domains d, domains_nameservers dns
+	WHERE d.domain = ANY($1) AND dns.domain_id = d.id
+		AND (dns.first_seen IS NULL OR dns.first_seen <= $2) AND (dns.last_seen IS NULL OR dns.last_seen >= $2)`
+
+// StreamDanglingNameServers calls fn for every dangling nameserver on date named in zone, or any zone if it is empty, by name
+func (ds *PostgresDataStore) StreamDanglingNameServers(ctx context.Context, date time.Time, zone string, fn func(ns *model.DanglingNameServer) error) error {
+	zones := make(map[string]bool)
+	err := ds.streamRows(ctx, "SELECT zone FROM zones", nil, func(scan func(dest ...interface{}) error) error {
+		var name string
+		err := scan(&name)
+		zones[name] = true
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	imported := func(zone string) bool { return zones[zone] }
+	registered := func(domains []string) (map[string]bool, error) {
+		out := make(map[string]bool, len(domains))
+		err := ds.streamRows(ctx, registeredDomainsQuery, []interface{}{domains, date}, func(scan func(dest ...interface{}) error) error {
+			var name string
+			err := scan(&name)
+			out[name] = true
+			return err
+		})
+		return out, err
+	}
+	batch := newDanglingBatch(ctx, ds, date, registered, fn)
+
+	// the rows of a nameserver are consecutive, one per zone of its domains
+	var current *model.DanglingNameServer
+	counts := make(map[string]int64)
+	emit := func() error {
+		if current == nil {
+			return nil
+		}
+		defer func() { counts = make(map[string]int64) }()
+		parent, parentZone, ok := danglingParent(current.Name, zone, imported)
+		if !ok {
+			return nil
+		}
+		return batch.add(model.NewDanglingNameServer(current.ID, current.Name, parent, parentZone, false, current.NoAddress, counts))
+	}
+	err = ds.streamRows(ctx, activeNameServersQuery, []interface{}{date, zone}, func(scan func(dest ...interface{}) error) error {
+		var row model.DanglingNameServer
+		var domainZone string
+		var count int64
+		err := scan(&row.ID, &row.Name, &row.NoAddress, &domainZone, &count)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.ID != row.ID {
+			if err = emit(); err != nil {
+				return err
+			}
+			current = &row
+		}
+		counts[domainZone] += count
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = emit(); err != nil {
+		return err
+	}
+	return batch.flush()
+}
+
+// GetDanglingNameServers gets up to limit dangling nameservers on date, named in zone if it is set
+func (ds *MemoryDataStore) GetDanglingNameServers(ctx context.Context, date time.Time, zone string, limit int) (*model.DanglingNameServers, error) {
+	return collectDanglingNameServers(ctx, ds, date, zone, limit)
+}
+
+// StreamDanglingNameServers calls fn for every dangling nameserver on date named in zone, or any zone if it is empty, by name
+func (ds *MemoryDataStore) StreamDanglingNameServers(ctx context.Context, date time.Time, zone string, fn func(ns *model.DanglingNameServer) error) error {
+	nameservers := make([]*memNameServer, len(ds.nameservers))
+	copy(nameservers, ds.nameservers)
+	sort.Slice(nameservers, func(i, j int) bool { return nameservers[i].name < nameservers[j].name })
+
+	imported := func(zone string) bool {
+		_, ok := ds.zonesByName[zone]
+		return ok
+	}
+	registered := func(domains []string) (map[string]bool, error) {
+		out := make(map[string]bool, len(domains))
+		for _, name := range domains {
+			if d, ok := ds.domainsByName[name]; ok && anyActiveOn(ds.domainsNameservers.byParent[d.id], date) {
+				out[name] = true
+			}
+		}
+		return out, nil
+	}
+	batch := newDanglingBatch(ctx, ds, date, registered, fn)
+	for _, ns := range nameservers {
+		parent, parentZone, ok := danglingParent(ns.name, zone, imported)
+		if !ok {
+			continue
+		}
+		counts := make(map[string]int64)
+		for _, e := range ds.domainsNameservers.byChild[ns.id] {
+			if e.activeOn(date) {
+				counts[ds.zonesByID[ds.domainsByID[e.parent].zoneID].name]++
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+		noAddress := !anyActiveOn(ds.ipNameservers[4].byParent[ns.id], date) && !anyActiveOn(ds.ipNameservers[6].byParent[ns.id], date)
+		err := batch.add(model.NewDanglingNameServer(ns.id, ns.name, parent, parentZone, false, noAddress, counts))
+		if err != nil {
+			return err
+		}
+	}
+	return batch.flush()
+}
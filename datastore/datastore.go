@@ -14,7 +14,6 @@ import (
 
 	"dnscoffee/model"
 
-	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -25,15 +24,104 @@ import (
 // ErrNoResource a 404 for a resource
 var ErrNoResource = errors.New("the requested object does not exist")
 
-// DataStore stores references to the database and
+// DataStore is implemented by every backend the frontend can query
+// PostgresDataStore is used in production, MemoryDataStore for local development and CI
+type DataStore interface {
+	Close() error
+
+	GetDomainID(ctx context.Context, domain string) (int64, int64, error)
+	GetIPID(ctx context.Context, ipStr string) (int64, int, error)
+	GetZoneID(ctx context.Context, name string) (int64, error)
+	GetNameServerID(ctx context.Context, domain string) (int64, error)
+
+	GetZone(ctx context.Context, name string) (*model.Zone, error)
+	GetDomain(ctx context.Context, domain string) (*model.Domain, error)
+	GetNameServer(ctx context.Context, domain string) (*model.NameServer, error)
+	GetIP(ctx context.Context, name string) (*model.IP, error)
+	GetIPs(ctx context.Context, ipPrefix *net.IPNet) (*model.IPList, error)
+	GetDomainCount(ctx context.Context) (int64, error)
+	GetRandomDomain(ctx context.Context) (*model.Domain, error)
+	GetDomainTimeline(ctx context.Context, domain string) (*model.DomainTimeline, error)
+
+	// base objects with their seen dates, without relationships, for the as of views
+	GetZoneBase(ctx context.Context, name string) (*model.Zone, error)
+	GetDomainBase(ctx context.Context, domain string) (*model.Domain, error)
+	GetNameServerBase(ctx context.Context, name string) (*model.NameServer, error)
+	GetIPBase(ctx context.Context, name string) (*model.IP, error)
+
+	// paginated relationship listings
+	GetDomainNameServers(ctx context.Context, domain string, opts ListOptions) (*model.DomainNameServers, error)
+	GetZoneNameServers(ctx context.Context, zone string, opts ListOptions) (*model.ZoneNameServers, error)
+	GetZoneDomains(ctx context.Context, zone string, opts ListOptions) (*model.ZoneDomains, error)
+	GetIPNameServers(ctx context.Context, ip string, opts ListOptions) (*model.IPNameServers, error)
+	GetNameServerDomains(ctx context.Context, nameserver string, opts ListOptions) (*model.NameServerDomains, error)
+	GetNameServerIPs(ctx context.Context, nameserver string, version int, opts ListOptions) (*model.NameServerIPs, error)
+
+	// bulk lookups, keyed by name
+	GetDomainsByName(ctx context.Context, names []string) (map[string]*model.Domain, error)
+	GetNameServersByName(ctx context.Context, names []string) (map[string]*model.NameServer, error)
+	GetIPsByName(ctx context.Context, ips []string) (map[string]*model.IP, error)
+
+	// batched relationship loading, keyed by parent ID
+	GetDomainsNameServers(ctx context.Context, domainIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error)
+	GetZonesNameServers(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.NameServer, error)
+	GetNameServersDomains(ctx context.Context, nameserverIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error)
+	GetZonesDomains(ctx context.Context, zoneIDs []int64, opts ListOptions) (map[int64][]*model.Domain, error)
+	GetNameServersIPs(ctx context.Context, nameserverIDs []int64, version int, opts ListOptions) (map[int64][]*model.IP, error)
+	GetIPsNameServers(ctx context.Context, ipIDs []int64, version int, opts ListOptions) (map[int64][]*model.NameServer, error)
+
+	GetFeedNew(ctx context.Context, date time.Time) (*model.Feed, error)
+	GetFeedOld(ctx context.Context, date time.Time) (*model.Feed, error)
+	GetFeedMoved(ctx context.Context, date time.Time) (*model.Feed, error)
+	GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetFeedNsOld(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error)
+	GetNewFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+	GetOldFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+	GetMovedFeedCount(ctx context.Context, search string) (*model.FeedCountList, error)
+
+	GetZoneDiff(ctx context.Context, zone string, from, to time.Time, page, limit int) (*model.ZoneDiff, error)
+
+	GetImportProgress(ctx context.Context, date time.Time) (*model.ImportProgress, error)
+	GetImportHealth(ctx context.Context) (*model.ImportHealth, error)
+	GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error)
+	GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error)
+	GetInternetHistoryCounts(ctx context.Context, opts CountsOptions) (*model.ZoneCount, error)
+	GetZoneHistoryCounts(ctx context.Context, zone string, opts CountsOptions) (*model.ZoneCount, error)
+	GetAllZoneHistoryCounts(ctx context.Context, opts CountsOptions) (*model.AllZoneCounts, error)
+
+	GetAvailablePrefixes(ctx context.Context, name string) (*model.PrefixList, error)
+	GetTakenPrefixes(ctx context.Context, name string) (*model.PrefixList, error)
+	GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error)
+	GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error)
+	GetTopNameservers(ctx context.Context, topN int) ([]*model.NameServer, error)
+
+	// research
+	GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error)
+	GetIPNsZoneCount(ctx context.Context, ip string) (*model.ResearchIPNsZoneCount, error)
+	GetDanglingNameServers(ctx context.Context, date time.Time, zone string, limit int) (*model.DanglingNameServers, error)
+	GetProviderMigration(ctx context.Context, zone string, from, to time.Time, top int) (*model.ProviderMigration, error)
+
+	// streams
+	StreamActiveIPs(ctx context.Context, date time.Time, fn func(version int, ip string) error) error
+	StreamFeedNew(ctx context.Context, date time.Time, fn func(d *model.Domain) error) error
+	StreamAllZoneHistoryCounts(ctx context.Context, opts CountsOptions, fn func(zone string, c *model.ZoneCounts) error) error
+	StreamTakenPrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error
+	StreamAvailablePrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error
+	StreamZoneFile(ctx context.Context, zone string, date time.Time, fn func(rr *model.ZoneRecord) error) error
+	StreamDanglingNameServers(ctx context.Context, date time.Time, zone string, fn func(ns *model.DanglingNameServer) error) error
+	StreamOperatorShares(ctx context.Context, opts CountsOptions, top int, fn func(s *model.OperatorShares) error) error
+}
+
+// PostgresDataStore stores references to the database and
 // has methods for querying the database
-type DataStore struct {
+type PostgresDataStore struct {
 	db *pgxpool.Pool
 }
 
-// New Creates a new DataStore with the provided database configuration
+// New Creates a new PostgresDataStore with the provided database configuration
 // database connection variables are set from environment variables
-func New(ctx context.Context) (*DataStore, error) {
+func New(ctx context.Context) (*PostgresDataStore, error) {
 	connPoolConfig, err := pgxpool.ParseConfig(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		return nil, err
@@ -55,18 +143,18 @@ func New(ctx context.Context) (*DataStore, error) {
 	}
 	err = conn.Close(ctx)
 
-	ds := DataStore{pool}
+	ds := PostgresDataStore{pool}
 	return &ds, err
 }
 
 // Close closes the database connection
-func (ds *DataStore) Close() error {
+func (ds *PostgresDataStore) Close() error {
 	ds.db.Close()
 	return nil
 }
 
 // GetDomainID gets the domain's ID and domain's zone's ID
-func (ds *DataStore) GetDomainID(ctx context.Context, domain string) (int64, int64, error) {
+func (ds *PostgresDataStore) GetDomainID(ctx context.Context, domain string) (int64, int64, error) {
 	var id, zoneID int64
 	err := ds.db.QueryRow(ctx, "SELECT id, zone_id FROM domains WHERE domain = $1", domain).Scan(&id, &zoneID)
 	if err == pgx.ErrNoRows {
@@ -76,7 +164,7 @@ func (ds *DataStore) GetDomainID(ctx context.Context, domain string) (int64, int
 }
 
 // GetIPID gets the IPs ID, and the version (4 or 6)
-func (ds *DataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, error) {
+func (ds *PostgresDataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, error) {
 	var id int64
 	var version int
 	var err error
@@ -97,7 +185,7 @@ func (ds *DataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, err
 }
 
 // GetZoneID gets the zoneID with the given name
-func (ds *DataStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+func (ds *PostgresDataStore) GetZoneID(ctx context.Context, name string) (int64, error) {
 	var id int64
 	err := ds.db.QueryRow(ctx, "select id from zones where zone = $1 limit 1", name).Scan(&id)
 	if err == pgx.ErrNoRows {
@@ -107,7 +195,7 @@ func (ds *DataStore) GetZoneID(ctx context.Context, name string) (int64, error)
 }
 
 // GetZone gets the Zone with the given name from zones_nameservers
-func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, error) {
+func (ds *PostgresDataStore) GetZone(ctx context.Context, name string) (*model.Zone, error) {
 	var z model.Zone
 	var err error
 
@@ -192,7 +280,7 @@ func (ds *DataStore) GetZone(ctx context.Context, name string) (*model.Zone, err
 }
 
 // GetNameServerID given a nameserver, find its ID
-func (ds *DataStore) GetNameServerID(ctx context.Context, domain string) (int64, error) {
+func (ds *PostgresDataStore) GetNameServerID(ctx context.Context, domain string) (int64, error) {
 	var id int64
 	err := ds.db.QueryRow(ctx, "SELECT id FROM nameservers WHERE domain = $1", domain).Scan(&id)
 	if err == pgx.ErrNoRows {
@@ -201,31 +289,22 @@ func (ds *DataStore) GetNameServerID(ctx context.Context, domain string) (int64,
 	return id, err
 }
 
-func (ds *DataStore) GetFeedNew(ctx context.Context, date time.Time) (*model.Feed, error) {
+func (ds *PostgresDataStore) GetFeedNew(ctx context.Context, date time.Time) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "new"
-	var err error
 	f.Date = date
-
-	rows, err := ds.db.Query(ctx, "SELECT domain_id, domain from recent_new_domains where date = $1", date)
+	f.Domains = make([]*model.Domain, 0, 100)
+	err := ds.StreamFeedNew(ctx, date, func(d *model.Domain) error {
+		f.Domains = append(f.Domains, d)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	f.Domains = make([]*model.Domain, 0, 100)
-	for rows.Next() {
-		var d model.Domain
-		err = rows.Scan(&d.ID, &d.Name)
-		if err != nil {
-			return nil, err
-		}
-		f.Domains = append(f.Domains, &d)
-	}
-
-	return &f, err
+	return &f, nil
 }
 
-func (ds *DataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Feed, error) {
+func (ds *PostgresDataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "old"
 	var err error
@@ -249,7 +328,7 @@ func (ds *DataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Fee
 	return &f, err
 }
 
-func (ds *DataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.Feed, error) {
+func (ds *PostgresDataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.Feed, error) {
 	var f model.Feed
 	f.Change = "moved"
 	var err error
@@ -273,7 +352,7 @@ func (ds *DataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.F
 	return &f, err
 }
 
-func (ds *DataStore) GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+func (ds *PostgresDataStore) GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error) {
 	var f model.NSFeed
 	f.Change = "moved"
 	var err error
@@ -308,7 +387,7 @@ func (ds *DataStore) GetFeedNsMoved(ctx context.Context, date time.Time) (*model
 	return &f, err
 }
 
-func (ds *DataStore) GetNewFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+func (ds *PostgresDataStore) GetNewFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
 	list, err := ds.getFeedCount(ctx, "recent_new_domains", search)
 	if err != nil {
 		return nil, err
@@ -317,7 +396,7 @@ func (ds *DataStore) GetNewFeedCount(ctx context.Context, search string) (*model
 	return list, nil
 }
 
-func (ds *DataStore) GetOldFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+func (ds *PostgresDataStore) GetOldFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
 	list, err := ds.getFeedCount(ctx, "recent_old_domains", search)
 	if err != nil {
 		return nil, err
@@ -326,7 +405,7 @@ func (ds *DataStore) GetOldFeedCount(ctx context.Context, search string) (*model
 	return list, nil
 }
 
-func (ds *DataStore) GetMovedFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+func (ds *PostgresDataStore) GetMovedFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
 	list, err := ds.getFeedCount(ctx, "recent_moved_domains", search)
 	if err != nil {
 		return nil, err
@@ -335,13 +414,13 @@ func (ds *DataStore) GetMovedFeedCount(ctx context.Context, search string) (*mod
 	return list, nil
 }
 
-func (ds *DataStore) getFeedCount(ctx context.Context, table, search string) (*model.FeedCountList, error) {
+func (ds *PostgresDataStore) getFeedCount(ctx context.Context, table, search string) (*model.FeedCountList, error) {
 	var fc model.FeedCountList
 	fc.Search = strings.ToLower(search)
 	var err error
 
-	if len(search) < 4 {
-		return nil, fmt.Errorf("search term must be at least %d long", 4)
+	if err := checkSearch(search); err != nil {
+		return nil, err
 	}
 
 	// TODO add index here for like substring search
@@ -365,7 +444,7 @@ func (ds *DataStore) getFeedCount(ctx context.Context, table, search string) (*m
 	return &fc, err
 }
 
-func (ds *DataStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+func (ds *PostgresDataStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error) {
 	var f model.NSFeed
 	f.Change = "new"
 	var err error
@@ -400,7 +479,7 @@ func (ds *DataStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.N
 	return &f, err
 }
 
-func (ds *DataStore) GetFeedNsOld(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+func (ds *PostgresDataStore) GetFeedNsOld(ctx context.Context, date time.Time) (*model.NSFeed, error) {
 	var f model.NSFeed
 	f.Change = "old"
 	var err error
@@ -436,7 +515,7 @@ func (ds *DataStore) GetFeedNsOld(ctx context.Context, date time.Time) (*model.N
 }
 
 // GetDomain gets information for the provided domain
-func (ds *DataStore) GetDomain(ctx context.Context, domain string) (*model.Domain, error) {
+func (ds *PostgresDataStore) GetDomain(ctx context.Context, domain string) (*model.Domain, error) {
 	var d model.Domain
 	var z model.Zone
 	d.Zone = &z
@@ -513,7 +592,7 @@ func (ds *DataStore) GetDomain(ctx context.Context, domain string) (*model.Domai
 }
 
 // GetDomainCount gets the number of domains in the system (approx)
-func (ds *DataStore) GetDomainCount(ctx context.Context) (int64, error) {
+func (ds *PostgresDataStore) GetDomainCount(ctx context.Context) (int64, error) {
 	row := ds.db.QueryRow(ctx, "SELECT max(id) from domains;")
 	var count int64
 	err := row.Scan(&count)
@@ -521,7 +600,7 @@ func (ds *DataStore) GetDomainCount(ctx context.Context) (int64, error) {
 }
 
 // GetRandomDomain finds a random active domain
-func (ds *DataStore) GetRandomDomain(ctx context.Context) (*model.Domain, error) {
+func (ds *PostgresDataStore) GetRandomDomain(ctx context.Context) (*model.Domain, error) {
 	count, err := ds.GetDomainCount(ctx)
 	if err != nil {
 		return nil, err
@@ -540,7 +619,7 @@ func (ds *DataStore) GetRandomDomain(ctx context.Context) (*model.Domain, error)
 }
 
 // GetZoneImport gets the most-recent recent ZoneImportResult for the given zone
-func (ds *DataStore) GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error) {
+func (ds *PostgresDataStore) GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error) {
 	var r model.ZoneImportResult
 	err := ds.db.QueryRow(ctx,
 		`SELECT
@@ -561,6 +640,9 @@ func (ds *DataStore) GetZoneImport(ctx context.Context, zone string) (*model.Zon
 			and zone_imports.last_import_id = import_info.import_id
 			and zones.zone = $1`,
 		zone).Scan(&r.Zone, &r.Domains, &r.Records, &r.FirstImportDate, &r.FirstImportID, &r.LastImportDate, &r.LastImportID, &r.Count)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoResource
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -568,7 +650,7 @@ func (ds *DataStore) GetZoneImport(ctx context.Context, zone string) (*model.Zon
 }
 
 // GetZoneImportResults gets the most-recent recent ZoneImportResults for every zone
-func (ds *DataStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error) {
+func (ds *PostgresDataStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error) {
 	var zoneImportResults model.ZoneImportResults
 	zoneImportResults.Zones = make([]*model.ZoneImportResult, 0, 100)
 
@@ -591,7 +673,11 @@ func (ds *DataStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImpor
 }
 
 // GetInternetHistoryCounts returns the counts averages weekly for the past imports for all zones
-func (ds *DataStore) GetInternetHistoryCounts(ctx context.Context) (*model.ZoneCount, error) {
+// opts can change the range and granularity
+func (ds *PostgresDataStore) GetInternetHistoryCounts(ctx context.Context, opts CountsOptions) (*model.ZoneCount, error) {
+	if !opts.isZero() {
+		return ds.internetHistoryCounts(ctx, opts)
+	}
 	var zc model.ZoneCount
 	zc.History = make([]*model.ZoneCounts, 0, 100)
 	zc.Zone = ""
@@ -633,7 +719,11 @@ func (ds *DataStore) GetInternetHistoryCounts(ctx context.Context) (*model.ZoneC
 }
 
 // GetZoneHistoryCounts returns the counts averages weekly for the past imports for a given zone
-func (ds *DataStore) GetZoneHistoryCounts(ctx context.Context, zone string) (*model.ZoneCount, error) {
+// opts can change the range and granularity
+func (ds *PostgresDataStore) GetZoneHistoryCounts(ctx context.Context, zone string, opts CountsOptions) (*model.ZoneCount, error) {
+	if !opts.isZero() {
+		return ds.zoneHistoryCounts(ctx, zone, opts)
+	}
 	var zc model.ZoneCount
 	zc.History = make([]*model.ZoneCounts, 0, 100)
 	zc.Zone = zone
@@ -684,36 +774,13 @@ func (ds *DataStore) GetZoneHistoryCounts(ctx context.Context, zone string) (*mo
 }
 
 // GetAllZoneHistoryCounts returns the counts averages monthly for the past imports for all zones
-func (ds *DataStore) GetAllZoneHistoryCounts(ctx context.Context) (*model.AllZoneCounts, error) {
-	var all model.AllZoneCounts
-	all.Counts = make(map[string]*model.ZoneCount)
-
-	rows, err := ds.db.Query(ctx, "select date_trunc('month', date) AS month, zone, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new from weighted_counts, zones where zones.id = zone_id group by 1, 2 order by 1 desc, 2 limit 300 * 1200")
-	if err != nil {
-		return nil, err
-	}
-
-	for rows.Next() {
-		var c model.ZoneCounts
-		var zone string
-		err = rows.Scan(&c.Date, &zone, &c.Domains, &c.Old, &c.Moved, &c.New)
-		if err != nil {
-			return nil, err
-		}
-		if _, ok := all.Counts[zone]; !ok {
-			all.Counts[zone] = new(model.ZoneCount)
-			all.Counts[zone].Zone = zone
-			all.Counts[zone].History = make([]*model.ZoneCounts, 0, 100)
-		}
-
-		all.Counts[zone].History = append(all.Counts[zone].History, &c)
-	}
-
-	return &all, nil
+// opts can change the range and granularity, and limit the zones
+func (ds *PostgresDataStore) GetAllZoneHistoryCounts(ctx context.Context, opts CountsOptions) (*model.AllZoneCounts, error) {
+	return collectAllZoneHistoryCounts(ctx, ds, opts)
 }
 
 // GetNameServer gets information for the provided nameserver
-func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.NameServer, error) {
+func (ds *PostgresDataStore) GetNameServer(ctx context.Context, domain string) (*model.NameServer, error) {
 	var ns model.NameServer
 	var z model.Zone
 
@@ -862,7 +929,7 @@ func (ds *DataStore) GetNameServer(ctx context.Context, domain string) (*model.N
 }
 
 // GetIP gets information for the provided IP
-func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error) {
+func (ds *PostgresDataStore) GetIP(ctx context.Context, name string) (*model.IP, error) {
 	var ip model.IP
 	var err error
 	ip.ID, ip.Version, err = ds.GetIPID(ctx, name)
@@ -990,7 +1057,7 @@ func (ds *DataStore) GetIP(ctx context.Context, name string) (*model.IP, error)
 }
 
 // GetIPs gets multiple IPs that fit query parameters
-func (ds *DataStore) GetIPs(ctx context.Context, ipPrefix *net.IPNet) (*model.IPList, error) {
+func (ds *PostgresDataStore) GetIPs(ctx context.Context, ipPrefix *net.IPNet) (*model.IPList, error) {
 	if ipPrefix == nil { // Return nothing if no prefix query
 		return nil, ErrNoResource
 	}
@@ -1044,114 +1111,39 @@ func (ds *DataStore) GetIPs(ctx context.Context, ipPrefix *net.IPNet) (*model.IP
 }
 
 // GetAvailablePrefixes returns available prefixes for the queried prefix
-func (ds *DataStore) GetAvailablePrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
+func (ds *PostgresDataStore) GetAvailablePrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
 	var prefixes model.PrefixList
-	var err error
 	prefixes.Active = false
 	prefixes.Prefix = name
 	prefixes.Domains = make([]model.PrefixResult, 0, 10)
-
-	rows, err := ds.db.Query(ctx, `With available_domains as 
-	(
-	   WITH available AS 
-	   (
-		  WITH taken AS 
-		  (
-			 SELECT DISTINCT
-				domains.zone_id 
-			 FROM
-				domains,
-				domains_nameservers 
-			 WHERE
-				domains.id = domains_nameservers.domain_id 
-				AND domains_nameservers.last_seen IS NULL 
-				AND domains.domain LIKE $1 || '.%'
-		  )
-		  SELECT
-			 zone_imports.zone_id 
-		  FROM
-		  	 zones,
-			 zone_imports 
-			 LEFT JOIN
-				taken 
-				ON taken.zone_id = zone_imports.zone_id 
-		  WHERE
-			 taken.zone_id IS NULL
-			 AND zones.id = zone_imports.zone_id
-			 AND zones.zone != ''
-			 AND zones.zone != 'ARPA'
-	   )
-	   SELECT
-		  $1 || '.' || zones.zone AS domain 
-	   FROM
-		  zones,
-		  available 
-	   WHERE
-		  available.zone_id = zones.id 
-	)
-	Select
-	   available_domains.domain,
-	   max(Domains_nameservers.last_seen) last_seen 
-	from
-	   available_domains 
-	   Left join
-		  domains 
-		  on domains.domain = available_domains.domain 
-	   Left join
-		  domains_nameservers 
-		  on domains.id = domains_nameservers.domain_id 
-	Group by
-	   available_domains.domain 
-	ORDER BY
-	   Char_length(available_domains.domain),
-	   1,  2`, name)
+	err := ds.StreamAvailablePrefixes(ctx, name, func(domain model.PrefixResult) error {
+		prefixes.Domains = append(prefixes.Domains, domain)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		var domain model.PrefixResult
-		var lastSeen pgtype.Date
-		err = rows.Scan(&domain.Domain, &lastSeen)
-		if err != nil {
-			return nil, err
-		}
-		if lastSeen.Status == pgtype.Present {
-			domain.LastSeen = &lastSeen.Time
-		}
-		prefixes.Domains = append(prefixes.Domains, domain)
-	}
-
 	return &prefixes, nil
 }
 
 // GetTakenPrefixes searched for domain prefixes that match the given pattern that are active
-func (ds *DataStore) GetTakenPrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
+func (ds *PostgresDataStore) GetTakenPrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
 	var prefixes model.PrefixList
-	var err error
 	prefixes.Prefix = name
 	prefixes.Active = true
 	prefixes.Domains = make([]model.PrefixResult, 0, 10)
-	rows, err := ds.db.Query(ctx, "select domains.domain, min(domains_nameservers.first_seen) first_seen from domains, domains_nameservers where domains.id = domains_nameservers.domain_id and domain LIKE $1 || '.%' and last_seen is null group by domains.domain order by domains.domain", prefixes.Prefix)
+	err := ds.StreamTakenPrefixes(ctx, name, func(domain model.PrefixResult) error {
+		prefixes.Domains = append(prefixes.Domains, domain)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		var domain model.PrefixResult
-		var firstSeen pgtype.Date
-		err = rows.Scan(&domain.Domain, &firstSeen)
-		if err != nil {
-			return nil, err
-		}
-		if firstSeen.Status == pgtype.Present {
-			domain.FirstSeen = &firstSeen.Time
-		}
-		prefixes.Domains = append(prefixes.Domains, domain)
-	}
 	return &prefixes, nil
 }
 
 // GetDeadTLDs returns zones that have been removed from the root and their ages
-func (ds *DataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error) {
+func (ds *PostgresDataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error) {
 	out := make([]*model.TLDLife, 0, 20)
 
 	rows, err := ds.db.Query(ctx, `with dead_zones as (SELECT zones.zone, zones.id FROM zones WHERE NOT (EXISTS ( SELECT zones_nameservers.zone_id FROM zones_nameservers WHERE zones.id = zones_nameservers.zone_id AND zones_nameservers.last_seen IS NULL)))
@@ -1186,7 +1178,7 @@ func (ds *DataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error)
 // GetDomainsInZoneID returns a sample of 50 domains in a given zoneID
 // note: when joining with zones to turn the zone into a zone ID it is extremely slow
 // useing a zoneId is fast
-func (ds *DataStore) GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error) {
+func (ds *PostgresDataStore) GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error) {
 	out := make([]model.Domain, 0, 50)
 	rows, err := ds.db.Query(ctx, `WITH dupes
 	AS (
@@ -1220,7 +1212,7 @@ func (ds *DataStore) GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]mo
 
 // GetTopNameservers returns the topN nameservers sorted by number of
 // domains
-func (ds *DataStore) GetTopNameservers(ctx context.Context, topN int) ([]*model.NameServer, error) {
+func (ds *PostgresDataStore) GetTopNameservers(ctx context.Context, topN int) ([]*model.NameServer, error) {
 	out := make([]*model.NameServer, 0, topN)
 
 	rows, err := ds.db.Query(ctx,
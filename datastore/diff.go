@@ -0,0 +1,164 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// changes between two dates, named like the feeds
+const (
+	changeNew   = "new"
+	changeOld   = "old"
+	changeMoved = "moved"
+)
+
+// newZoneDiff returns an empty ZoneDiff for the page and limit
+func newZoneDiff(zone string, from, to time.Time, page, limit int) *model.ZoneDiff {
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = PageSize
+	}
+	return &model.ZoneDiff{
+		Zone:    zone,
+		From:    from,
+		To:      to,
+		Page:    page,
+		Limit:   limit,
+		Added:   make([]*model.Domain, 0),
+		Removed: make([]*model.Domain, 0),
+		Moved:   make([]*model.Domain, 0),
+	}
+}
+
+// addToDiff adds the domain to the list of change if it is on the page
+// n is the position of the domain in its list starting at 1
+func addToDiff(zd *model.ZoneDiff, change string, n int64, d *model.Domain) {
+	first := int64((zd.Page-1)*zd.Limit) + 1
+	if n < first || n >= first+int64(zd.Limit) {
+		return
+	}
+	switch change {
+	case changeNew:
+		zd.Added = append(zd.Added, d)
+	case changeOld:
+		zd.Removed = append(zd.Removed, d)
+	case changeMoved:
+		zd.Moved = append(zd.Moved, d)
+	}
+}
+
+// GetZoneDiff gets the domains of zone that were added, removed or moved to other nameservers
+// between the from and to dates, comparing the nameservers each domain had on both days
+// only the domains of the recent_*_domains feeds after from up to to are compared
+func (ds *PostgresDataStore) GetZoneDiff(ctx context.Context, zone string, from, to time.Time, page, limit int) (*model.ZoneDiff, error) {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	zd := newZoneDiff(zone, from, to, page, limit)
+
+	rows, err := ds.db.Query(ctx, `WITH changed AS (
+			SELECT DISTINCT f.domain_id
+			FROM (
+				SELECT domain_id, date FROM recent_new_domains
+				UNION ALL SELECT domain_id, date FROM recent_old_domains
+				UNION ALL SELECT domain_id, date FROM recent_moved_domains
+			) f, domains d
+			WHERE f.domain_id = d.id AND d.zone_id = $1 AND f.date > $2 AND f.date <= $3
+		), sets AS (
+			SELECT c.domain_id,
+				array_agg(dns.nameserver_id ORDER BY dns.nameserver_id) FILTER (WHERE (dns.first_seen IS NULL OR dns.first_seen <= $2) AND (dns.last_seen IS NULL OR dns.last_seen >= $2)) AS before,
+				array_agg(dns.nameserver_id ORDER BY dns.nameserver_id) FILTER (WHERE (dns.first_seen IS NULL OR dns.first_seen <= $3) AND (dns.last_seen IS NULL OR dns.last_seen >= $3)) AS after
+			FROM changed c, domains_nameservers dns
+			WHERE dns.domain_id = c.domain_id
+			GROUP BY c.domain_id
+		), diff AS (
+			SELECT s.domain_id, d.domain, CASE WHEN s.before IS NULL THEN 'new' WHEN s.after IS NULL THEN 'old' ELSE 'moved' END AS change
+			FROM sets s, domains d
+			WHERE s.domain_id = d.id AND s.before IS DISTINCT FROM s.after
+		), numbered AS (
+			SELECT domain_id, domain, change,
+				row_number() OVER (PARTITION BY change ORDER BY domain) AS n,
+				count(*) OVER (PARTITION BY change) AS total
+			FROM diff
+		)
+		SELECT domain_id, domain, change, n, total FROM numbered
+		WHERE n = 1 OR (n > $4 AND n <= $5)
+		ORDER BY change, n`, zoneID, from, to, (zd.Page-1)*zd.Limit, zd.Page*zd.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d model.Domain
+		var change string
+		var n, total int64
+		err = rows.Scan(&d.ID, &d.Name, &change, &n, &total)
+		if err != nil {
+			return nil, err
+		}
+		switch change {
+		case changeNew:
+			zd.AddedCount = total
+		case changeOld:
+			zd.RemovedCount = total
+		case changeMoved:
+			zd.MovedCount = total
+		}
+		addToDiff(zd, change, n, &d)
+	}
+	return zd, rows.Err()
+}
+
+// GetZoneDiff gets the domains of zone that were added, removed or moved to other nameservers
+// between the from and to dates, comparing the nameservers each domain had on both days
+// only the domains of the feeds after from up to to are compared
+func (ds *MemoryDataStore) GetZoneDiff(ctx context.Context, zone string, from, to time.Time, page, limit int) (*model.ZoneDiff, error) {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	zd := newZoneDiff(zone, from, to, page, limit)
+	changed := make(map[*memDomain]bool)
+	for _, feed := range []string{changeNew, changeOld, changeMoved} {
+		for date, domains := range ds.feeds[feed] {
+			if !date.After(from) || date.After(to) {
+				continue
+			}
+			for _, d := range domains {
+				if d.zoneID == zoneID {
+					changed[d] = true
+				}
+			}
+		}
+	}
+	changes := map[string][]*memDomain{}
+	for d := range changed {
+		edges := ds.domainsNameservers.byParent[d.id]
+		before, after := activeChildren(edges, from), activeChildren(edges, to)
+		switch {
+		case sameSet(before, after):
+		case len(before) == 0:
+			changes[changeNew] = append(changes[changeNew], d)
+		case len(after) == 0:
+			changes[changeOld] = append(changes[changeOld], d)
+		default:
+			changes[changeMoved] = append(changes[changeMoved], d)
+		}
+	}
+	for change, domains := range changes {
+		sort.Slice(domains, func(i, j int) bool { return domains[i].name < domains[j].name })
+		for i, d := range domains {
+			addToDiff(zd, change, int64(i+1), &model.Domain{ID: d.id, Name: d.name})
+		}
+	}
+	zd.AddedCount = int64(len(changes[changeNew]))
+	zd.RemovedCount = int64(len(changes[changeOld]))
+	zd.MovedCount = int64(len(changes[changeMoved]))
+	return zd, nil
+}
@@ -0,0 +1,239 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// StaleAfter is how far a zone's last import can be behind the most recent import of any zone before it is stale
+const StaleAfter = 48 * time.Hour
+
+// import health checks
+const (
+	// days of imports checked for gaps and anomalies
+	healthWindow = 90
+	// number of previous imports an import is compared to, and the least needed to compare
+	anomalyHistory    = 7
+	anomalyMinHistory = 3
+	// an import is unusual if it differs from the median by this ratio and at least anomalyMinimum
+	anomalyRatio   = 0.25
+	anomalyMinimum = 10
+)
+
+// importRow holds the counts of a single import used by the health checks
+type importRow struct {
+	zone             string
+	date             time.Time
+	records, domains int64
+}
+
+// newestImport returns the most recent last import date in dates
+func newestImport(dates []*time.Time) *time.Time {
+	var newest *time.Time
+	for _, d := range dates {
+		if d != nil && (newest == nil || d.After(*newest)) {
+			newest = d
+		}
+	}
+	return newest
+}
+
+// MarkStale sets Stale on the results of zones whose last import is more than StaleAfter behind the newest one
+func MarkStale(results *model.ZoneImportResults) {
+	dates := make([]*time.Time, 0, len(results.Zones))
+	for _, r := range results.Zones {
+		dates = append(dates, r.LastImportDate)
+	}
+	newest := newestImport(dates)
+	if newest == nil {
+		return
+	}
+	for _, r := range results.Zones {
+		r.Stale = r.LastImportDate != nil && newest.Sub(*r.LastImportDate) > StaleAfter
+	}
+}
+
+// median returns the median of values
+func median(values []int64) int64 {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// unusual returns true if value is far enough from expected to be an anomaly
+func unusual(value, expected int64) bool {
+	diff := value - expected
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff >= anomalyMinimum && float64(diff) > anomalyRatio*float64(expected)
+}
+
+// findAnomalies returns the imports whose records or domains are unusual compared to the previous imports
+// rows must be ordered by date
+func findAnomalies(rows []importRow) []*model.ImportAnomaly {
+	out := make([]*model.ImportAnomaly, 0)
+	fields := []struct {
+		name  string
+		value func(r importRow) int64
+	}{
+		{"records", func(r importRow) int64 { return r.records }},
+		{"domains", func(r importRow) int64 { return r.domains }},
+	}
+	for i := anomalyMinHistory; i < len(rows); i++ {
+		start := i - anomalyHistory
+		if start < 0 {
+			start = 0
+		}
+		for _, field := range fields {
+			history := make([]int64, 0, anomalyHistory)
+			for _, r := range rows[start:i] {
+				history = append(history, field.value(r))
+			}
+			expected := median(history)
+			if value := field.value(rows[i]); unusual(value, expected) {
+				out = append(out, &model.ImportAnomaly{Date: rows[i].date, Field: field.name, Value: value, Expected: expected})
+			}
+		}
+	}
+	return out
+}
+
+// buildImportHealth checks the zones for staleness, missing days and anomalies
+// imports are the imports of every zone since windowStart ordered by date
+func buildImportHealth(zones []*model.ZoneHealth, imports []importRow, windowStart, now time.Time) *model.ImportHealth {
+	health := &model.ImportHealth{StaleAfter: model.Seconds(StaleAfter), WindowStart: windowStart, Zones: zones}
+	dates := make([]*time.Time, 0, len(zones))
+	for _, zh := range zones {
+		dates = append(dates, zh.LastImportDate)
+	}
+	health.LastImportDate = newestImport(dates)
+	if health.LastImportDate == nil {
+		return health
+	}
+	health.SinceLastImport = model.Seconds(now.Sub(*health.LastImportDate))
+
+	byZone := make(map[string][]importRow)
+	for _, i := range imports {
+		byZone[i.zone] = append(byZone[i.zone], i)
+	}
+	for _, zh := range zones {
+		zh.MissingDays = make([]time.Time, 0)
+		zh.Anomalies = findAnomalies(byZone[zh.Zone])
+		if zh.LastImportDate == nil {
+			continue
+		}
+		zh.SinceLastImport = model.Seconds(now.Sub(*zh.LastImportDate))
+		zh.Stale = health.LastImportDate.Sub(*zh.LastImportDate) > StaleAfter
+		if zh.Stale {
+			health.StaleCount++
+		}
+
+		// every day from the start of the window, or the zone's first import, until the newest import should have an import
+		imported := make(map[time.Time]bool)
+		for _, i := range byZone[zh.Zone] {
+			imported[i.date] = true
+		}
+		day := windowStart
+		if zh.FirstImportDate != nil && zh.FirstImportDate.After(day) {
+			day = *zh.FirstImportDate
+		}
+		for ; !day.After(*health.LastImportDate); day = day.AddDate(0, 0, 1) {
+			if !imported[day] {
+				zh.MissingDays = append(zh.MissingDays, day)
+			}
+		}
+	}
+	return health
+}
+
+// GetImportHealth gets the import freshness, missing days and anomalies of every zone
+func (ds *PostgresDataStore) GetImportHealth(ctx context.Context) (*model.ImportHealth, error) {
+	zones := make([]*model.ZoneHealth, 0, 100)
+	rows, err := ds.db.Query(ctx, "SELECT zones.zone, zone_imports.first_import_date, zone_imports.last_import_date FROM zones, zone_imports WHERE zones.id = zone_imports.zone_id ORDER BY zones.zone")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var zh model.ZoneHealth
+		err = rows.Scan(&zh.Zone, &zh.FirstImportDate, &zh.LastImportDate)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, &zh)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var newest *time.Time
+	err = ds.db.QueryRow(ctx, "SELECT max(last_import_date) FROM zone_imports").Scan(&newest)
+	if err != nil {
+		return nil, err
+	}
+	if newest == nil {
+		return buildImportHealth(zones, nil, time.Time{}, time.Now()), nil
+	}
+	windowStart := newest.AddDate(0, 0, -healthWindow)
+
+	imports := make([]importRow, 0, len(zones)*healthWindow)
+	rows, err = ds.db.Query(ctx, `SELECT zones.zone, import_info.date, import_info.records, import_info.domains
+		FROM import_info, zones
+		WHERE zones.id = import_info.zone_id AND import_info.date >= $1
+		ORDER BY import_info.date`, windowStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i importRow
+		err = rows.Scan(&i.zone, &i.date, &i.records, &i.domains)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, i)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return buildImportHealth(zones, imports, windowStart, time.Now()), nil
+}
+
+// GetImportHealth gets the import freshness, missing days and anomalies of every zone
+func (ds *MemoryDataStore) GetImportHealth(ctx context.Context) (*model.ImportHealth, error) {
+	zones := make([]*model.ZoneHealth, 0, len(ds.zones))
+	var newest time.Time
+	for _, z := range ds.zones {
+		if len(z.imports) == 0 {
+			continue
+		}
+		first, last := z.imports[0].date, z.imports[len(z.imports)-1].date
+		zones = append(zones, &model.ZoneHealth{Zone: z.name, FirstImportDate: &first, LastImportDate: &last})
+		if last.After(newest) {
+			newest = last
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	windowStart := newest.AddDate(0, 0, -healthWindow)
+
+	imports := make([]importRow, 0)
+	for _, z := range ds.zones {
+		for _, i := range z.imports {
+			if !i.date.Before(windowStart) {
+				imports = append(imports, importRow{zone: z.name, date: i.date, records: i.records, domains: i.domains})
+			}
+		}
+	}
+	sort.SliceStable(imports, func(i, j int) bool { return imports[i].date.Before(imports[j].date) })
+
+	return buildImportHealth(zones, imports, windowStart, time.Now()), nil
+}
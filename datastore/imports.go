@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// GetImportProgress gets every zone import that happened on date
+func (ds *PostgresDataStore) GetImportProgress(ctx context.Context, date time.Time) (*model.ImportProgress, error) {
+	var ip model.ImportProgress
+	ip.Date = date
+	ip.Imports = make([]*model.ZoneImport, 0, 100)
+
+	rows, err := ds.db.Query(ctx, `SELECT
+			zones.zone,
+			import_info.date,
+			import_info.records,
+			import_info.domains,
+			import_info.feed_new,
+			import_info.feed_old,
+			import_info.feed_moved
+		FROM import_info
+		JOIN zones ON zones.id = import_info.zone_id
+		WHERE import_info.date = $1
+		ORDER BY zones.zone`, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var zi model.ZoneImport
+		err = rows.Scan(&zi.Zone, &zi.Date, &zi.Count, &zi.Domains, &zi.FeedNew, &zi.FeedOld, &zi.FeedMoved)
+		if err != nil {
+			return nil, err
+		}
+		ip.Imports = append(ip.Imports, &zi)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	ip.Count = len(ip.Imports)
+
+	return &ip, nil
+}
+
+// GetImportProgress gets every zone import that happened on date
+func (ds *MemoryDataStore) GetImportProgress(ctx context.Context, date time.Time) (*model.ImportProgress, error) {
+	var ip model.ImportProgress
+	ip.Date = date
+	ip.Imports = make([]*model.ZoneImport, 0, 100)
+	for _, z := range ds.zones {
+		for _, i := range z.imports {
+			if !i.date.Equal(date) {
+				continue
+			}
+			importDate := i.date
+			ip.Imports = append(ip.Imports, &model.ZoneImport{
+				Date:      &importDate,
+				Count:     uint64(i.records),
+				Zone:      z.name,
+				Domains:   i.domains,
+				FeedNew:   i.feedNew,
+				FeedOld:   i.feedOld,
+				FeedMoved: i.feedMoved,
+			})
+		}
+	}
+	sort.Slice(ip.Imports, func(a, b int) bool { return ip.Imports[a].Zone < ip.Imports[b].Zone })
+	ip.Count = len(ip.Imports)
+	return &ip, nil
+}
@@ -0,0 +1,409 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"dnscoffee/model"
+)
+
+// inner queries for the relationship listings
+// each selects grp, id, name, first_seen and last_seen for the parent ID in $1
+// a domain is in its zone from the first to the last day it is delegated, seenAggregate of its nameservers
+const (
+	domainNameServersQuery = "SELECT 0 AS grp, ns.id, ns.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.id AND dns.domain_id = $1"
+	zoneNameServersQuery   = "SELECT 0 AS grp, ns.id, ns.domain AS name, zns.first_seen, zns.last_seen FROM zones_nameservers zns, nameservers ns WHERE zns.nameserver_id = ns.id AND zns.zone_id = $1"
+	nameServerDomainsQuery = "SELECT 0 AS grp, d.id, d.domain AS name, dns.first_seen, dns.last_seen FROM domains_nameservers dns, domains d WHERE dns.domain_id = d.id AND dns.nameserver_id = $1"
+	zoneDomainsQuery       = "SELECT 0 AS grp, d.id, d.domain AS name, s.first_seen, s.last_seen FROM domains d, LATERAL (SELECT " + seenAggregate + ", count(*) AS n FROM domains_nameservers WHERE domain_id = d.id) s WHERE s.n > 0 AND d.zone_id = $1"
+	aNameServersQuery      = "SELECT 0 AS grp, ns.id, ns.domain AS name, ans.first_seen, ans.last_seen FROM a_nameservers ans, nameservers ns WHERE ans.nameserver_id = ns.id AND ans.a_id = $1"
+	aaaaNameServersQuery   = "SELECT 0 AS grp, ns.id, ns.domain AS name, ans.first_seen, ans.last_seen FROM aaaa_nameservers ans, nameservers ns WHERE ans.nameserver_id = ns.id AND ans.aaaa_id = $1"
+	nameServerIP4Query     = "SELECT 4 AS grp, ip.id, host(ip.ip) AS name, ans.first_seen, ans.last_seen FROM a_nameservers ans, a ip WHERE ans.a_id = ip.id AND ans.nameserver_id = $1"
+	nameServerIP6Query     = "SELECT 6 AS grp, ip.id, host(ip.ip) AS name, ans.first_seen, ans.last_seen FROM aaaa_nameservers ans, aaaa ip WHERE ans.aaaa_id = ip.id AND ans.nameserver_id = $1"
+)
+
+// stateCondition returns the SQL filter for the relationship state on the edge alias e
+// any parameters it uses are appended to args
+func stateCondition(opts ListOptions, args []interface{}) (string, []interface{}) {
+	if opts.Date == nil {
+		switch opts.State {
+		case StateCurrent:
+			return "e.last_seen IS NULL", args
+		case StateArchive:
+			return "e.last_seen IS NOT NULL", args
+		}
+		return "true", args
+	}
+	args = append(args, *opts.Date)
+	date := fmt.Sprintf("$%d::date", len(args))
+	where := fmt.Sprintf("(e.first_seen IS NULL OR e.first_seen <= %s)", date)
+	switch opts.State {
+	case StateCurrent:
+		where = fmt.Sprintf("%s AND (e.last_seen IS NULL OR e.last_seen >= %s)", where, date)
+	case StateArchive:
+		where = fmt.Sprintf("%s AND e.last_seen < %s", where, date)
+	}
+	return where, args
+}
+
+// pageEdges runs a keyset paginated query over the rows selected by inner
+// returns the rows, the total number of rows in the state, and the next and prev page tokens
+func (ds *PostgresDataStore) pageEdges(ctx context.Context, inner string, parentID int64, opts ListOptions) ([]*edgeRow, int64, string, string, error) {
+	c, err := parseCursor(opts.Page)
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+	where, args := stateCondition(opts, []interface{}{parentID})
+
+	var total int64
+	err = ds.db.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM (%s) e WHERE %s", inner, where), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+
+	limit := opts.limit()
+	key := "(e.grp, e.id, coalesce(e.first_seen, '1970-01-01'::date))"
+	order := "ASC"
+	if c != nil {
+		op := ">"
+		if c.before {
+			op = "<"
+			order = "DESC"
+		}
+		n := len(args)
+		where = fmt.Sprintf("%s AND %s %s ($%d::int, $%d::bigint, $%d::date)", where, key, op, n+1, n+2, n+3)
+		args = append(args, c.group, c.id, c.firstSeen)
+	}
+	query := fmt.Sprintf(`SELECT e.grp, e.id, e.name, e.first_seen, e.last_seen
+		FROM (%s) e
+		WHERE %s
+		ORDER BY e.grp %s, e.id %[3]s, coalesce(e.first_seen, '1970-01-01'::date) %[3]s
+		LIMIT %d`, inner, where, order, limit+1)
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, "", "", err
+	}
+	defer rows.Close()
+	out := make([]*edgeRow, 0, limit+1)
+	for rows.Next() {
+		var r edgeRow
+		err = rows.Scan(&r.group, &r.id, &r.name, &r.firstSeen, &r.lastSeen)
+		if err != nil {
+			return nil, 0, "", "", err
+		}
+		out = append(out, &r)
+	}
+	if rows.Err() != nil {
+		return nil, 0, "", "", rows.Err()
+	}
+
+	out, next, prev := finishPage(out, c, limit)
+	return out, total, next, prev, nil
+}
+
+func rowsToNameServers(rows []*edgeRow) []*model.NameServer {
+	out := make([]*model.NameServer, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, &model.NameServer{ID: r.id, Name: r.name, FirstSeen: r.firstSeen, LastSeen: r.lastSeen})
+	}
+	return out
+}
+
+func rowsToDomains(rows []*edgeRow) []*model.Domain {
+	out := make([]*model.Domain, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, &model.Domain{ID: r.id, Name: r.name, FirstSeen: r.firstSeen, LastSeen: r.lastSeen})
+	}
+	return out
+}
+
+func rowsToIPs(rows []*edgeRow) []*model.IP {
+	out := make([]*model.IP, 0, len(rows))
+	for _, r := range rows {
+		netIP := net.ParseIP(r.name)
+		ip := &model.IP{ID: r.id, IP: &netIP, Version: r.group, FirstSeen: r.firstSeen, LastSeen: r.lastSeen}
+		ip.Name = ip.IPString()
+		out = append(out, ip)
+	}
+	return out
+}
+
+func newPagination(opts ListOptions, total int64, next, prev string) model.Pagination {
+	return model.Pagination{State: opts.State, Total: total, Limit: opts.Limit, Date: opts.Date, Page: opts.Page, NextPage: next, PrevPage: prev}
+}
+
+// GetDomainNameServers gets a page of the nameservers for the provided domain
+func (ds *PostgresDataStore) GetDomainNameServers(ctx context.Context, domain string, opts ListOptions) (*model.DomainNameServers, error) {
+	id, _, err := ds.GetDomainID(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, domainNameServersQuery, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.DomainNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		Domain:      domain,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetZoneNameServers gets a page of the nameservers for the provided zone
+func (ds *PostgresDataStore) GetZoneNameServers(ctx context.Context, zone string, opts ListOptions) (*model.ZoneNameServers, error) {
+	id, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, zoneNameServersQuery, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		Zone:        zone,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetIPNameServers gets a page of the nameservers using the provided IP
+func (ds *PostgresDataStore) GetIPNameServers(ctx context.Context, ip string, opts ListOptions) (*model.IPNameServers, error) {
+	id, version, err := ds.GetIPID(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	query := aNameServersQuery
+	if version == 6 {
+		query = aaaaNameServersQuery
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, query, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.IPNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		IP:          ip,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetNameServerDomains gets a page of the domains using the provided nameserver
+func (ds *PostgresDataStore) GetNameServerDomains(ctx context.Context, nameserver string, opts ListOptions) (*model.NameServerDomains, error) {
+	id, err := ds.GetNameServerID(ctx, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, nameServerDomainsQuery, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.NameServerDomains{
+		Pagination: newPagination(opts, total, next, prev),
+		NameServer: nameserver,
+		Domains:    rowsToDomains(rows),
+	}, nil
+}
+
+// GetZoneDomains gets a page of the domains delegated in the provided zone
+func (ds *PostgresDataStore) GetZoneDomains(ctx context.Context, zone string, opts ListOptions) (*model.ZoneDomains, error) {
+	id, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, zoneDomainsQuery, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneDomains{
+		Pagination: newPagination(opts, total, next, prev),
+		Zone:       zone,
+		Domains:    rowsToDomains(rows),
+	}, nil
+}
+
+// GetNameServerIPs gets a page of the IPs of the provided nameserver
+// version selects IPv4 or IPv6, 0 lists both
+func (ds *PostgresDataStore) GetNameServerIPs(ctx context.Context, nameserver string, version int, opts ListOptions) (*model.NameServerIPs, error) {
+	id, err := ds.GetNameServerID(ctx, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	var query string
+	switch version {
+	case 4:
+		query = nameServerIP4Query
+	case 6:
+		query = nameServerIP6Query
+	default:
+		query = nameServerIP4Query + " UNION ALL " + nameServerIP6Query
+	}
+	rows, total, next, prev, err := ds.pageEdges(ctx, query, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &model.NameServerIPs{
+		Pagination: newPagination(opts, total, next, prev),
+		NameServer: nameserver,
+		Version:    version,
+		IPs:        rowsToIPs(rows),
+	}, nil
+}
+
+// memPage filters edges by state and pages through them
+// row returns the listed side of each edge
+func memPage(edges []*memEdge, opts ListOptions, row func(e *memEdge) *edgeRow) ([]*edgeRow, int64, string, string, error) {
+	rows := make([]*edgeRow, 0, len(edges))
+	for _, e := range edges {
+		if !opts.includes(e.firstSeen, e.lastSeen) {
+			continue
+		}
+		rows = append(rows, row(e))
+	}
+	total := int64(len(rows))
+	rows, next, prev, err := pageRows(rows, opts)
+	return rows, total, next, prev, err
+}
+
+func (ds *MemoryDataStore) nameServerRow(id int64, e *memEdge) *edgeRow {
+	return &edgeRow{id: id, name: ds.nameserversByID[id].name, firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+}
+
+// GetDomainNameServers gets a page of the nameservers for the provided domain
+func (ds *MemoryDataStore) GetDomainNameServers(ctx context.Context, domain string, opts ListOptions) (*model.DomainNameServers, error) {
+	id, _, err := ds.GetDomainID(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := memPage(ds.domainsNameservers.byParent[id], opts, func(e *memEdge) *edgeRow { return ds.nameServerRow(e.child, e) })
+	if err != nil {
+		return nil, err
+	}
+	return &model.DomainNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		Domain:      domain,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetZoneNameServers gets a page of the nameservers for the provided zone
+func (ds *MemoryDataStore) GetZoneNameServers(ctx context.Context, zone string, opts ListOptions) (*model.ZoneNameServers, error) {
+	id, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := memPage(ds.zonesNameservers.byParent[id], opts, func(e *memEdge) *edgeRow { return ds.nameServerRow(e.child, e) })
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		Zone:        zone,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetIPNameServers gets a page of the nameservers using the provided IP
+func (ds *MemoryDataStore) GetIPNameServers(ctx context.Context, ip string, opts ListOptions) (*model.IPNameServers, error) {
+	id, version, err := ds.GetIPID(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := memPage(ds.ipNameservers[version].byChild[id], opts, func(e *memEdge) *edgeRow { return ds.nameServerRow(e.parent, e) })
+	if err != nil {
+		return nil, err
+	}
+	return &model.IPNameServers{
+		Pagination:  newPagination(opts, total, next, prev),
+		IP:          ip,
+		NameServers: rowsToNameServers(rows),
+	}, nil
+}
+
+// GetNameServerDomains gets a page of the domains using the provided nameserver
+func (ds *MemoryDataStore) GetNameServerDomains(ctx context.Context, nameserver string, opts ListOptions) (*model.NameServerDomains, error) {
+	id, err := ds.GetNameServerID(ctx, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := memPage(ds.domainsNameservers.byChild[id], opts, func(e *memEdge) *edgeRow {
+		return &edgeRow{id: e.parent, name: ds.domainsByID[e.parent].name, firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.NameServerDomains{
+		Pagination: newPagination(opts, total, next, prev),
+		NameServer: nameserver,
+		Domains:    rowsToDomains(rows),
+	}, nil
+}
+
+// zoneDomainEdges returns an edge from the zone to each of its delegated domains,
+// from the first to the last day the domain is delegated
+func (ds *MemoryDataStore) zoneDomainEdges(zoneID int64) []*memEdge {
+	edges := make([]*memEdge, 0)
+	for _, d := range ds.domains {
+		nameservers := ds.domainsNameservers.byParent[d.id]
+		if d.zoneID != zoneID || len(nameservers) == 0 {
+			continue
+		}
+		firstSeen, lastSeen := seen(nameservers)
+		edges = append(edges, &memEdge{parent: zoneID, child: d.id, zoneID: zoneID, firstSeen: firstSeen, lastSeen: lastSeen})
+	}
+	return edges
+}
+
+func (ds *MemoryDataStore) domainRow(e *memEdge) *edgeRow {
+	return &edgeRow{id: e.child, name: ds.domainsByID[e.child].name, firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+}
+
+// GetZoneDomains gets a page of the domains delegated in the provided zone
+func (ds *MemoryDataStore) GetZoneDomains(ctx context.Context, zone string, opts ListOptions) (*model.ZoneDomains, error) {
+	id, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, next, prev, err := memPage(ds.zoneDomainEdges(id), opts, ds.domainRow)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ZoneDomains{
+		Pagination: newPagination(opts, total, next, prev),
+		Zone:       zone,
+		Domains:    rowsToDomains(rows),
+	}, nil
+}
+
+// GetNameServerIPs gets a page of the IPs of the provided nameserver
+// version selects IPv4 or IPv6, 0 lists both
+func (ds *MemoryDataStore) GetNameServerIPs(ctx context.Context, nameserver string, version int, opts ListOptions) (*model.NameServerIPs, error) {
+	id, err := ds.GetNameServerID(ctx, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	versions := []int{4, 6}
+	if version != 0 {
+		versions = []int{version}
+	}
+	edges := make([]*memEdge, 0)
+	edgeVersion := make(map[*memEdge]int)
+	for _, v := range versions {
+		for _, e := range ds.ipNameservers[v].byParent[id] {
+			edges = append(edges, e)
+			edgeVersion[e] = v
+		}
+	}
+	rows, total, next, prev, err := memPage(edges, opts, func(e *memEdge) *edgeRow {
+		v := edgeVersion[e]
+		return &edgeRow{group: v, id: e.child, name: ds.ipsByID[v][e.child].ip.String(), firstSeen: e.firstSeen, lastSeen: e.lastSeen}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.NameServerIPs{
+		Pagination: newPagination(opts, total, next, prev),
+		NameServer: nameserver,
+		Version:    version,
+		IPs:        rowsToIPs(rows),
+	}, nil
+}
@@ -0,0 +1,1419 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// MemoryDataStore is a DataStore that holds the entire dataset in memory
+// it is loaded once from a JSON fixture file and is read only afterwards
+// this allows running the frontend without a live database
+type MemoryDataStore struct {
+	zones       []*memZone
+	zonesByID   map[int64]*memZone
+	zonesByName map[string]*memZone
+
+	domains       []*memDomain
+	domainsByID   map[int64]*memDomain
+	domainsByName map[string]*memDomain
+
+	nameservers       []*memNameServer
+	nameserversByID   map[int64]*memNameServer
+	nameserversByName map[string]*memNameServer
+
+	ips       map[int][]*memIP
+	ipsByID   map[int]map[int64]*memIP
+	ipsByName map[int]map[string]*memIP
+
+	// edge tables, named after their SQL counterparts
+	domainsNameservers *memEdges
+	zonesNameservers   *memEdges
+	ipNameservers      map[int]*memEdges
+
+	// precomputed feeds keyed by date
+	feeds   map[string]map[time.Time][]*memDomain
+	nsFeeds map[string]map[time.Time][]*memNSChange
+
+	nextImportID int64
+}
+
+type memZone struct {
+	id      int64
+	name    string
+	imports []*memImport
+}
+
+type memImport struct {
+	id                          int64
+	date                        time.Time
+	records, domains            int64
+	feedNew, feedOld, feedMoved int64
+}
+
+type memDomain struct {
+	id     int64
+	zoneID int64
+	name   string
+}
+
+type memNameServer struct {
+	id   int64
+	name string
+}
+
+type memIP struct {
+	id      int64
+	version int
+	ip      net.IP
+}
+
+type memNSChange struct {
+	ns      *memNameServer
+	version int
+}
+
+// memEdge is a single row of a *_nameservers table
+// parent is the domain, zone or nameserver, child is the nameserver or IP
+type memEdge struct {
+	parent    int64
+	child     int64
+	zoneID    int64
+	firstSeen *time.Time
+	lastSeen  *time.Time
+}
+
+// activeOn returns true if the edge existed on the given date
+func (e *memEdge) activeOn(date time.Time) bool {
+	if e.firstSeen != nil && e.firstSeen.After(date) {
+		return false
+	}
+	return e.lastSeen == nil || !e.lastSeen.Before(date)
+}
+
+type memEdges struct {
+	all      []*memEdge
+	byParent map[int64][]*memEdge
+	byChild  map[int64][]*memEdge
+}
+
+func newMemEdges() *memEdges {
+	return &memEdges{
+		byParent: make(map[int64][]*memEdge),
+		byChild:  make(map[int64][]*memEdge),
+	}
+}
+
+func (m *memEdges) add(e *memEdge) {
+	m.all = append(m.all, e)
+	m.byParent[e.parent] = append(m.byParent[e.parent], e)
+	m.byChild[e.child] = append(m.byChild[e.child], e)
+}
+
+// memoryFixture is the on-disk format loaded by NewMemory
+// dates are formatted as YYYY-MM-DD, a null or missing last_seen marks a current record
+type memoryFixture struct {
+	Zones []struct {
+		Zone    string          `json:"zone"`
+		Imports []fixtureImport `json:"imports"`
+	} `json:"zones"`
+	Domains []struct {
+		Domain string `json:"domain"`
+		Zone   string `json:"zone"`
+	} `json:"domains"`
+	DomainsNameServers []fixtureEdge `json:"domains_nameservers"`
+	ZonesNameServers   []fixtureEdge `json:"zones_nameservers"`
+	ANameServers       []fixtureEdge `json:"a_nameservers"`
+	AAAANameServers    []fixtureEdge `json:"aaaa_nameservers"`
+}
+
+// fixtureImport is a row of import_info, counts left out are computed from the edges
+type fixtureImport struct {
+	Date      fixtureDate `json:"date"`
+	Records   *int64      `json:"records"`
+	Domains   *int64      `json:"domains"`
+	FeedNew   *int64      `json:"feed_new"`
+	FeedOld   *int64      `json:"feed_old"`
+	FeedMoved *int64      `json:"feed_moved"`
+}
+
+type fixtureEdge struct {
+	Domain     string       `json:"domain"`
+	Zone       string       `json:"zone"`
+	NameServer string       `json:"nameserver"`
+	IP         string       `json:"ip"`
+	FirstSeen  *fixtureDate `json:"first_seen"`
+	LastSeen   *fixtureDate `json:"last_seen"`
+}
+
+type fixtureDate struct {
+	time.Time
+}
+
+// UnmarshalJSON parses YYYY-MM-DD dates
+func (d *fixtureDate) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Time, err = time.Parse("2006-01-02", s)
+	return err
+}
+
+func (d *fixtureDate) ptr() *time.Time {
+	if d == nil {
+		return nil
+	}
+	t := d.Time
+	return &t
+}
+
+// NewMemory creates a new MemoryDataStore loaded from the JSON fixture at path
+func NewMemory(path string) (*MemoryDataStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fixture memoryFixture
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&fixture)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse fixture %s: %w", path, err)
+	}
+
+	ds := &MemoryDataStore{
+		zonesByID:          make(map[int64]*memZone),
+		zonesByName:        make(map[string]*memZone),
+		domainsByID:        make(map[int64]*memDomain),
+		domainsByName:      make(map[string]*memDomain),
+		nameserversByID:    make(map[int64]*memNameServer),
+		nameserversByName:  make(map[string]*memNameServer),
+		ips:                make(map[int][]*memIP),
+		ipsByID:            map[int]map[int64]*memIP{4: {}, 6: {}},
+		ipsByName:          map[int]map[string]*memIP{4: {}, 6: {}},
+		domainsNameservers: newMemEdges(),
+		zonesNameservers:   newMemEdges(),
+		ipNameservers:      map[int]*memEdges{4: newMemEdges(), 6: newMemEdges()},
+	}
+
+	// import counts are filled in once the feeds are known
+	pending := make(map[*memImport]fixtureImport)
+	for _, z := range fixture.Zones {
+		zone := ds.addZone(z.Zone)
+		for _, i := range z.Imports {
+			ds.nextImportID++
+			imp := &memImport{id: ds.nextImportID, date: i.Date.Time}
+			zone.imports = append(zone.imports, imp)
+			pending[imp] = i
+		}
+		sort.Slice(zone.imports, func(a, b int) bool { return zone.imports[a].date.Before(zone.imports[b].date) })
+	}
+	for _, d := range fixture.Domains {
+		_, err = ds.addDomain(d.Domain, d.Zone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range fixture.DomainsNameServers {
+		d, err := ds.addDomain(e.Domain, e.Zone)
+		if err != nil {
+			return nil, err
+		}
+		ns := ds.addNameServer(e.NameServer)
+		ds.domainsNameservers.add(&memEdge{parent: d.id, child: ns.id, zoneID: d.zoneID, firstSeen: e.FirstSeen.ptr(), lastSeen: e.LastSeen.ptr()})
+	}
+	for _, e := range fixture.ZonesNameServers {
+		z, ok := ds.zonesByName[cleanName(e.Zone)]
+		if !ok {
+			z = ds.addZone(e.Zone)
+		}
+		ns := ds.addNameServer(e.NameServer)
+		ds.zonesNameservers.add(&memEdge{parent: z.id, child: ns.id, zoneID: z.id, firstSeen: e.FirstSeen.ptr(), lastSeen: e.LastSeen.ptr()})
+	}
+	glue := map[int][]fixtureEdge{4: fixture.ANameServers, 6: fixture.AAAANameServers}
+	for _, version := range []int{4, 6} {
+		for _, e := range glue[version] {
+			ip, err := ds.addIP(version, e.IP)
+			if err != nil {
+				return nil, err
+			}
+			ns := ds.addNameServer(e.NameServer)
+			var zoneID int64
+			if e.Zone != "" {
+				if z, ok := ds.zonesByName[cleanName(e.Zone)]; ok {
+					zoneID = z.id
+				}
+			} else if z := ds.findZone(ns.name); z != nil {
+				zoneID = z.id
+			}
+			ds.ipNameservers[version].add(&memEdge{parent: ns.id, child: ip.id, zoneID: zoneID, firstSeen: e.FirstSeen.ptr(), lastSeen: e.LastSeen.ptr()})
+		}
+	}
+
+	ds.computeFeeds()
+
+	for _, z := range ds.zones {
+		for _, i := range z.imports {
+			ds.fillImport(z, i, pending[i])
+		}
+	}
+
+	return ds, nil
+}
+
+// fillImport sets the import_info counts, computing any that the fixture left out
+func (ds *MemoryDataStore) fillImport(z *memZone, i *memImport, fi fixtureImport) {
+	if fi.Domains != nil {
+		i.domains = *fi.Domains
+	} else {
+		for _, d := range ds.domains {
+			if d.zoneID == z.id && anyActiveOn(ds.domainsNameservers.byParent[d.id], i.date) {
+				i.domains++
+			}
+		}
+	}
+	if fi.Records != nil {
+		i.records = *fi.Records
+	} else {
+		for _, d := range ds.domains {
+			if d.zoneID == z.id {
+				for _, e := range ds.domainsNameservers.byParent[d.id] {
+					if e.activeOn(i.date) {
+						i.records++
+					}
+				}
+			}
+		}
+	}
+	countFeed := func(change string) int64 {
+		var c int64
+		for _, d := range ds.feeds[change][i.date] {
+			if d.zoneID == z.id {
+				c++
+			}
+		}
+		return c
+	}
+	i.feedNew, i.feedOld, i.feedMoved = countFeed("new"), countFeed("old"), countFeed("moved")
+	if fi.FeedNew != nil {
+		i.feedNew = *fi.FeedNew
+	}
+	if fi.FeedOld != nil {
+		i.feedOld = *fi.FeedOld
+	}
+	if fi.FeedMoved != nil {
+		i.feedMoved = *fi.FeedMoved
+	}
+}
+
+func cleanName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+func (ds *MemoryDataStore) addZone(name string) *memZone {
+	name = cleanName(name)
+	if z, ok := ds.zonesByName[name]; ok {
+		return z
+	}
+	z := &memZone{id: int64(len(ds.zones) + 1), name: name}
+	ds.zones = append(ds.zones, z)
+	ds.zonesByID[z.id] = z
+	ds.zonesByName[name] = z
+	return z
+}
+
+// findZone returns the longest known zone that name is a member of
+func (ds *MemoryDataStore) findZone(name string) *memZone {
+	name = cleanName(name)
+	for {
+		idx := strings.Index(name, ".")
+		if idx < 0 {
+			break
+		}
+		name = name[idx+1:]
+		if z, ok := ds.zonesByName[name]; ok {
+			return z
+		}
+	}
+	return nil
+}
+
+func (ds *MemoryDataStore) addDomain(name, zone string) (*memDomain, error) {
+	name = cleanName(name)
+	if d, ok := ds.domainsByName[name]; ok {
+		return d, nil
+	}
+	var z *memZone
+	if zone != "" {
+		z = ds.addZone(zone)
+	} else {
+		z = ds.findZone(name)
+	}
+	if z == nil {
+		return nil, fmt.Errorf("no zone found for domain %q", name)
+	}
+	d := &memDomain{id: int64(len(ds.domains) + 1), zoneID: z.id, name: name}
+	ds.domains = append(ds.domains, d)
+	ds.domainsByID[d.id] = d
+	ds.domainsByName[name] = d
+	return d, nil
+}
+
+func (ds *MemoryDataStore) addNameServer(name string) *memNameServer {
+	name = cleanName(name)
+	if ns, ok := ds.nameserversByName[name]; ok {
+		return ns
+	}
+	ns := &memNameServer{id: int64(len(ds.nameservers) + 1), name: name}
+	ds.nameservers = append(ds.nameservers, ns)
+	ds.nameserversByID[ns.id] = ns
+	ds.nameserversByName[name] = ns
+	return ns
+}
+
+func (ds *MemoryDataStore) addIP(version int, s string) (*memIP, error) {
+	parsed := net.ParseIP(strings.TrimSpace(s))
+	if parsed == nil {
+		return nil, fmt.Errorf("unable to parse IP %q", s)
+	}
+	key := parsed.String()
+	if ip, ok := ds.ipsByName[version][key]; ok {
+		return ip, nil
+	}
+	ip := &memIP{id: int64(len(ds.ips[version]) + 1), version: version, ip: parsed}
+	ds.ips[version] = append(ds.ips[version], ip)
+	ds.ipsByID[version][ip.id] = ip
+	ds.ipsByName[version][key] = ip
+	return ip, nil
+}
+
+// computeFeeds builds the recent_*_domains and recent_*_ns feeds from the edge history
+func (ds *MemoryDataStore) computeFeeds() {
+	ds.feeds = map[string]map[time.Time][]*memDomain{"new": {}, "old": {}, "moved": {}}
+	for _, d := range ds.domains {
+		for _, c := range memChanges(ds.domainsNameservers.byParent[d.id]) {
+			ds.feeds[c.change][c.date] = append(ds.feeds[c.change][c.date], d)
+		}
+	}
+
+	ds.nsFeeds = map[string]map[time.Time][]*memNSChange{"new": {}, "old": {}, "moved": {}}
+	for _, ns := range ds.nameservers {
+		for _, version := range []int{4, 6} {
+			for _, c := range memChanges(ds.ipNameservers[version].byParent[ns.id]) {
+				ds.nsFeeds[c.change][c.date] = append(ds.nsFeeds[c.change][c.date], &memNSChange{ns, version})
+			}
+		}
+	}
+}
+
+type memChange struct {
+	date   time.Time
+	change string
+}
+
+// memChanges returns every date the set of active children changed
+// an empty set becoming non-empty is new, the reverse is old, otherwise it is moved
+func memChanges(edges []*memEdge) []memChange {
+	dates := make(map[time.Time]bool)
+	for _, e := range edges {
+		if e.firstSeen != nil {
+			dates[*e.firstSeen] = true
+		}
+		if e.lastSeen != nil {
+			dates[e.lastSeen.AddDate(0, 0, 1)] = true
+		}
+	}
+	out := make([]memChange, 0, len(dates))
+	for date := range dates {
+		before := activeChildren(edges, date.AddDate(0, 0, -1))
+		after := activeChildren(edges, date)
+		switch {
+		case len(before) == 0 && len(after) > 0:
+			out = append(out, memChange{date, "new"})
+		case len(before) > 0 && len(after) == 0:
+			out = append(out, memChange{date, "old"})
+		case len(before) > 0 && !sameSet(before, after):
+			out = append(out, memChange{date, "moved"})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].date.Before(out[j].date) })
+	return out
+}
+
+func activeChildren(edges []*memEdge, date time.Time) map[int64]bool {
+	out := make(map[int64]bool)
+	for _, e := range edges {
+		if e.activeOn(date) {
+			out[e.child] = true
+		}
+	}
+	return out
+}
+
+func anyActiveOn(edges []*memEdge, date time.Time) bool {
+	for _, e := range edges {
+		if e.activeOn(date) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSet(a, b map[int64]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// memFirstSeen mirrors "order by first_seen asc nulls first limit 1"
+func memFirstSeen(edges []*memEdge) *time.Time {
+	var out *time.Time
+	for _, e := range edges {
+		if e.firstSeen == nil {
+			return nil
+		}
+		if out == nil || e.firstSeen.Before(*out) {
+			out = e.firstSeen
+		}
+	}
+	return out
+}
+
+// memLastSeen mirrors "order by last_seen desc nulls first limit 1"
+func memLastSeen(edges []*memEdge) *time.Time {
+	var out *time.Time
+	for _, e := range edges {
+		if e.lastSeen == nil {
+			return nil
+		}
+		if out == nil || e.lastSeen.After(*out) {
+			out = e.lastSeen
+		}
+	}
+	return out
+}
+
+// splitEdges splits edges into current and archive, archive is sorted by last_seen desc
+func splitEdges(edges []*memEdge) ([]*memEdge, []*memEdge) {
+	current := make([]*memEdge, 0, len(edges))
+	archive := make([]*memEdge, 0, len(edges))
+	for _, e := range edges {
+		if e.lastSeen == nil {
+			current = append(current, e)
+		} else {
+			archive = append(archive, e)
+		}
+	}
+	sort.SliceStable(archive, func(i, j int) bool { return archive[i].lastSeen.After(*archive[j].lastSeen) })
+	return current, archive
+}
+
+func int64Ptr(i int) *int64 {
+	v := int64(i)
+	return &v
+}
+
+func limitEdges(edges []*memEdge, limit int) []*memEdge {
+	if len(edges) > limit {
+		return edges[:limit]
+	}
+	return edges
+}
+
+func (ds *MemoryDataStore) nameServerFromEdge(e *memEdge, id int64) *model.NameServer {
+	ns := ds.nameserversByID[id]
+	return &model.NameServer{ID: ns.id, Name: ns.name, FirstSeen: e.firstSeen, LastSeen: e.lastSeen}
+}
+
+func (ds *MemoryDataStore) domainFromEdge(e *memEdge) *model.Domain {
+	d := ds.domainsByID[e.parent]
+	return &model.Domain{ID: d.id, Name: d.name, FirstSeen: e.firstSeen, LastSeen: e.lastSeen}
+}
+
+func (ds *MemoryDataStore) ipFromEdge(version int, e *memEdge) model.IP {
+	mip := ds.ipsByID[version][e.child]
+	ip := model.IP{ID: mip.id, Version: version, FirstSeen: e.firstSeen, LastSeen: e.lastSeen}
+	netIP := mip.ip
+	ip.IP = &netIP
+	ip.Name = ip.IPString()
+	return ip
+}
+
+func (z *memZone) firstImport() *memImport {
+	if len(z.imports) == 0 {
+		return nil
+	}
+	return z.imports[0]
+}
+
+func (z *memZone) lastImport() *memImport {
+	if len(z.imports) == 0 {
+		return nil
+	}
+	return z.imports[len(z.imports)-1]
+}
+
+// Close is a no-op for the MemoryDataStore
+func (ds *MemoryDataStore) Close() error {
+	return nil
+}
+
+// GetDomainID gets the domain's ID and domain's zone's ID
+func (ds *MemoryDataStore) GetDomainID(ctx context.Context, domain string) (int64, int64, error) {
+	d, ok := ds.domainsByName[domain]
+	if !ok {
+		return 0, 0, ErrNoResource
+	}
+	return d.id, d.zoneID, nil
+}
+
+// GetIPID gets the IPs ID, and the version (4 or 6)
+func (ds *MemoryDataStore) GetIPID(ctx context.Context, ipStr string) (int64, int, error) {
+	version := 4
+	if strings.Contains(ipStr, ":") {
+		version = 6
+	}
+	parsed := net.ParseIP(ipStr)
+	if parsed == nil {
+		return 0, version, ErrNoResource
+	}
+	ip, ok := ds.ipsByName[version][parsed.String()]
+	if !ok {
+		return 0, version, ErrNoResource
+	}
+	return ip.id, version, nil
+}
+
+// GetZoneID gets the zoneID with the given name
+func (ds *MemoryDataStore) GetZoneID(ctx context.Context, name string) (int64, error) {
+	z, ok := ds.zonesByName[name]
+	if !ok {
+		return 0, ErrNoResource
+	}
+	return z.id, nil
+}
+
+// GetNameServerID given a nameserver, find its ID
+func (ds *MemoryDataStore) GetNameServerID(ctx context.Context, domain string) (int64, error) {
+	ns, ok := ds.nameserversByName[domain]
+	if !ok {
+		return 0, ErrNoResource
+	}
+	return ns.id, nil
+}
+
+// GetZone gets the Zone with the given name from zones_nameservers
+func (ds *MemoryDataStore) GetZone(ctx context.Context, name string) (*model.Zone, error) {
+	mz, ok := ds.zonesByName[name]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	var z model.Zone
+	z.ID = mz.id
+	z.Name = mz.name
+
+	edges := ds.zonesNameservers.byParent[mz.id]
+	z.FirstSeen = memFirstSeen(edges)
+	z.LastSeen = memLastSeen(edges)
+
+	current, archive := splitEdges(edges)
+	z.NameServerCount = int64Ptr(len(current))
+	z.ArchiveNameServerCount = int64Ptr(len(archive))
+	z.NameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		z.NameServers = append(z.NameServers, ds.nameServerFromEdge(e, e.child))
+	}
+	z.ArchiveNameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		z.ArchiveNameServers = append(z.ArchiveNameServers, ds.nameServerFromEdge(e, e.child))
+	}
+
+	var root model.RootZone
+	if rz, ok := ds.zonesByName[""]; ok && len(rz.imports) > 0 {
+		root.FirstImport = &rz.firstImport().date
+		root.LastImport = &rz.lastImport().date
+	}
+	z.RootImport = &root
+
+	return &z, nil
+}
+
+// GetDomain gets information for the provided domain
+func (ds *MemoryDataStore) GetDomain(ctx context.Context, domain string) (*model.Domain, error) {
+	md, ok := ds.domainsByName[domain]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	var d model.Domain
+	d.ID = md.id
+	d.Name = md.name
+	mz := ds.zonesByID[md.zoneID]
+	d.Zone = &model.Zone{ID: mz.id, Name: mz.name}
+	if len(mz.imports) > 0 {
+		d.Zone.FirstSeen = &mz.firstImport().date
+		d.Zone.LastSeen = &mz.lastImport().date
+	}
+
+	edges := ds.domainsNameservers.byParent[md.id]
+	d.FirstSeen = memFirstSeen(edges)
+	d.LastSeen = memLastSeen(edges)
+
+	current, archive := splitEdges(edges)
+	d.NameServerCount = int64Ptr(len(current))
+	d.ArchiveNameServerCount = int64Ptr(len(archive))
+	d.NameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		d.NameServers = append(d.NameServers, ds.nameServerFromEdge(e, e.child))
+	}
+	d.ArchiveNameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		d.ArchiveNameServers = append(d.ArchiveNameServers, ds.nameServerFromEdge(e, e.child))
+	}
+
+	return &d, nil
+}
+
+// GetNameServer gets information for the provided nameserver
+func (ds *MemoryDataStore) GetNameServer(ctx context.Context, domain string) (*model.NameServer, error) {
+	mns, ok := ds.nameserversByName[domain]
+	if !ok {
+		return nil, ErrNoResource
+	}
+	var ns model.NameServer
+	ns.ID = mns.id
+	ns.Name = mns.name
+
+	domainEdges := ds.domainsNameservers.byChild[mns.id]
+	a := ds.ipNameservers[4].byParent[mns.id]
+	aaaa := ds.ipNameservers[6].byParent[mns.id]
+	all := make([]*memEdge, 0, len(domainEdges)+len(a)+len(aaaa))
+	all = append(append(append(all, domainEdges...), a...), aaaa...)
+	ns.FirstSeen = memFirstSeen(all)
+	ns.LastSeen = memLastSeen(all)
+
+	current, archive := splitEdges(domainEdges)
+	ns.DomainCount = int64Ptr(len(current))
+	ns.ArchiveDomainCount = int64Ptr(len(archive))
+	ns.Domains = make([]*model.Domain, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		ns.Domains = append(ns.Domains, ds.domainFromEdge(e))
+	}
+	ns.ArchiveDomains = make([]*model.Domain, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		ns.ArchiveDomains = append(ns.ArchiveDomains, ds.domainFromEdge(e))
+	}
+
+	current, archive = splitEdges(a)
+	ns.IP4Count = int64Ptr(len(current))
+	ns.ArchiveIP4Count = int64Ptr(len(archive))
+	ns.IP4 = make([]*model.IP4, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		ns.IP4 = append(ns.IP4, &model.IP4{IP: ds.ipFromEdge(4, e)})
+	}
+	ns.ArchiveIP4 = make([]*model.IP4, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		ns.ArchiveIP4 = append(ns.ArchiveIP4, &model.IP4{IP: ds.ipFromEdge(4, e)})
+	}
+
+	current, archive = splitEdges(aaaa)
+	ns.IP6Count = int64Ptr(len(current))
+	ns.ArchiveIP6Count = int64Ptr(len(archive))
+	ns.IP6 = make([]*model.IP6, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		ns.IP6 = append(ns.IP6, &model.IP6{IP: ds.ipFromEdge(6, e)})
+	}
+	ns.ArchiveIP6 = make([]*model.IP6, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		ns.ArchiveIP6 = append(ns.ArchiveIP6, &model.IP6{IP: ds.ipFromEdge(6, e)})
+	}
+
+	// the zone is taken from the glue records, same as the database
+	if len(a) > 0 && a[0].zoneID != 0 {
+		mz := ds.zonesByID[a[0].zoneID]
+		ns.Zone = &model.Zone{ID: mz.id, Name: mz.name}
+		if len(mz.imports) > 0 {
+			ns.Zone.FirstSeen = &mz.firstImport().date
+			ns.Zone.LastSeen = &mz.lastImport().date
+		}
+	}
+
+	return &ns, nil
+}
+
+// GetIP gets information for the provided IP
+func (ds *MemoryDataStore) GetIP(ctx context.Context, name string) (*model.IP, error) {
+	var ip model.IP
+	var err error
+	ip.ID, ip.Version, err = ds.GetIPID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	netIP := net.ParseIP(name)
+	ip.IP = &netIP
+	ip.Name = ip.IPString()
+
+	edges := ds.ipNameservers[ip.Version].byChild[ip.ID]
+	ip.FirstSeen = memFirstSeen(edges)
+	ip.LastSeen = memLastSeen(edges)
+
+	current, archive := splitEdges(edges)
+	ip.NameServerCount = int64Ptr(len(current))
+	ip.ArchiveNameServerCount = int64Ptr(len(archive))
+	ip.NameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(current, 100) {
+		ip.NameServers = append(ip.NameServers, ds.nameServerFromEdge(e, e.parent))
+	}
+	ip.ArchiveNameServers = make([]*model.NameServer, 0, 4)
+	for _, e := range limitEdges(archive, 100) {
+		ip.ArchiveNameServers = append(ip.ArchiveNameServers, ds.nameServerFromEdge(e, e.parent))
+	}
+
+	return &ip, nil
+}
+
+// GetIPs gets multiple IPs that fit query parameters
+func (ds *MemoryDataStore) GetIPs(ctx context.Context, ipPrefix *net.IPNet) (*model.IPList, error) {
+	if ipPrefix == nil { // Return nothing if no prefix query
+		return nil, ErrNoResource
+	}
+
+	topN := 100
+	var version int
+	addLimit := false
+	masklen, bits := ipPrefix.Mask.Size()
+	if bits == 32 {
+		version = 4
+		addLimit = masklen < 24
+	} else if bits == 128 {
+		version = 6
+		addLimit = masklen < 48
+	} else {
+		return nil, errors.New("IP conversion failure")
+	}
+
+	matches := make([]*memIP, 0)
+	for _, ip := range ds.ips[version] {
+		if ipPrefix.Contains(ip.ip) {
+			matches = append(matches, ip)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return bytes.Compare(matches[i].ip.To16(), matches[j].ip.To16()) < 0 })
+	if addLimit && len(matches) > topN {
+		matches = matches[:topN]
+	}
+
+	var ipl model.IPList
+	for _, ip := range matches {
+		filledIP, err := ds.GetIP(ctx, ip.ip.String())
+		if err != nil {
+			return nil, err
+		}
+		ipl.IPs = append(ipl.IPs, filledIP)
+	}
+	return &ipl, nil
+}
+
+// GetDomainCount gets the number of domains in the system
+func (ds *MemoryDataStore) GetDomainCount(ctx context.Context) (int64, error) {
+	return int64(len(ds.domains)), nil
+}
+
+// GetRandomDomain finds a random active domain
+func (ds *MemoryDataStore) GetRandomDomain(ctx context.Context) (*model.Domain, error) {
+	active := make([]*memDomain, 0)
+	for _, d := range ds.domains {
+		current, _ := splitEdges(ds.domainsNameservers.byParent[d.id])
+		if len(current) > 0 {
+			active = append(active, d)
+		}
+	}
+	if len(active) == 0 {
+		return nil, ErrNoResource
+	}
+	d := active[rand.Intn(len(active))]
+	return &model.Domain{ID: d.id, Name: d.name}, nil
+}
+
+func (ds *MemoryDataStore) getFeed(change string, date time.Time) *model.Feed {
+	var f model.Feed
+	f.Change = change
+	f.Date = date
+	f.Domains = make([]*model.Domain, 0, 100)
+	for _, d := range ds.feeds[change][date] {
+		f.Domains = append(f.Domains, &model.Domain{ID: d.id, Name: d.name})
+	}
+	return &f
+}
+
+// GetFeedNew returns the domains that were first seen on date
+func (ds *MemoryDataStore) GetFeedNew(ctx context.Context, date time.Time) (*model.Feed, error) {
+	return ds.getFeed("new", date), nil
+}
+
+// GetFeedOld returns the domains that were removed on date
+func (ds *MemoryDataStore) GetFeedOld(ctx context.Context, date time.Time) (*model.Feed, error) {
+	return ds.getFeed("old", date), nil
+}
+
+// GetFeedMoved returns the domains whose nameservers changed on date
+func (ds *MemoryDataStore) GetFeedMoved(ctx context.Context, date time.Time) (*model.Feed, error) {
+	return ds.getFeed("moved", date), nil
+}
+
+func (ds *MemoryDataStore) getNSFeed(change string, date time.Time) *model.NSFeed {
+	var f model.NSFeed
+	f.Change = change
+	f.Date = date
+	f.Nameservers4 = make([]*model.NameServer, 0, 10)
+	f.Nameservers6 = make([]*model.NameServer, 0, 10)
+	for _, c := range ds.nsFeeds[change][date] {
+		ns := &model.NameServer{ID: c.ns.id, Name: c.ns.name}
+		if c.version == 4 {
+			f.Nameservers4 = append(f.Nameservers4, ns)
+		} else {
+			f.Nameservers6 = append(f.Nameservers6, ns)
+		}
+	}
+	return &f
+}
+
+// GetFeedNsNew returns the nameservers that gained their first IPs on date
+func (ds *MemoryDataStore) GetFeedNsNew(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+	return ds.getNSFeed("new", date), nil
+}
+
+// GetFeedNsOld returns the nameservers that lost all of their IPs on date
+func (ds *MemoryDataStore) GetFeedNsOld(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+	return ds.getNSFeed("old", date), nil
+}
+
+// GetFeedNsMoved returns the nameservers whose IPs changed on date
+func (ds *MemoryDataStore) GetFeedNsMoved(ctx context.Context, date time.Time) (*model.NSFeed, error) {
+	return ds.getNSFeed("moved", date), nil
+}
+
+func (ds *MemoryDataStore) getFeedCount(change, search string) (*model.FeedCountList, error) {
+	var fc model.FeedCountList
+	fc.Search = strings.ToLower(search)
+	fc.Type = change
+
+	if err := checkSearch(search); err != nil {
+		return nil, err
+	}
+
+	fc.Counts = make([]model.FeedCount, 0, 20)
+	for date, domains := range ds.feeds[change] {
+		var count int64
+		for _, d := range domains {
+			if strings.Contains(d.name, fc.Search) {
+				count++
+			}
+		}
+		if count > 0 {
+			date := date
+			fc.Counts = append(fc.Counts, model.FeedCount{Date: &date, Count: count})
+		}
+	}
+	sort.Slice(fc.Counts, func(i, j int) bool { return fc.Counts[i].Date.After(*fc.Counts[j].Date) })
+	return &fc, nil
+}
+
+// GetNewFeedCount counts the new domains matching search per day
+func (ds *MemoryDataStore) GetNewFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+	return ds.getFeedCount("new", search)
+}
+
+// GetOldFeedCount counts the removed domains matching search per day
+func (ds *MemoryDataStore) GetOldFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+	return ds.getFeedCount("old", search)
+}
+
+// GetMovedFeedCount counts the moved domains matching search per day
+func (ds *MemoryDataStore) GetMovedFeedCount(ctx context.Context, search string) (*model.FeedCountList, error) {
+	return ds.getFeedCount("moved", search)
+}
+
+func (ds *MemoryDataStore) zoneImportResult(z *memZone) *model.ZoneImportResult {
+	first, last := z.firstImport(), z.lastImport()
+	return &model.ZoneImportResult{
+		Zone:            z.name,
+		Domains:         last.domains,
+		Records:         last.records,
+		FirstImportDate: &first.date,
+		FirstImportID:   first.id,
+		LastImportDate:  &last.date,
+		LastImportID:    last.id,
+		Count:           int64(len(z.imports)),
+	}
+}
+
+// GetZoneImport gets the most-recent recent ZoneImportResult for the given zone
+func (ds *MemoryDataStore) GetZoneImport(ctx context.Context, zone string) (*model.ZoneImportResult, error) {
+	z, ok := ds.zonesByName[zone]
+	if !ok || len(z.imports) == 0 {
+		return nil, ErrNoResource
+	}
+	return ds.zoneImportResult(z), nil
+}
+
+// GetZoneImportResults gets the most-recent recent ZoneImportResults for every zone
+func (ds *MemoryDataStore) GetZoneImportResults(ctx context.Context) (*model.ZoneImportResults, error) {
+	var zoneImportResults model.ZoneImportResults
+	zoneImportResults.Zones = make([]*model.ZoneImportResult, 0, 100)
+	for _, z := range ds.sortedZones() {
+		if len(z.imports) > 0 {
+			zoneImportResults.Zones = append(zoneImportResults.Zones, ds.zoneImportResult(z))
+		}
+	}
+	zoneImportResults.Count = len(zoneImportResults.Zones)
+	return &zoneImportResults, nil
+}
+
+func (ds *MemoryDataStore) sortedZones() []*memZone {
+	zones := make([]*memZone, len(ds.zones))
+	copy(zones, ds.zones)
+	sort.Slice(zones, func(i, j int) bool { return zones[i].name < zones[j].name })
+	return zones
+}
+
+// memBucket accumulates import counts for a single time bucket
+type memBucket struct {
+	date                   time.Time
+	domains                int64
+	days                   int64
+	old, moved, newDomains int64
+}
+
+func (b *memBucket) add(i *memImport) {
+	b.domains += i.domains
+	b.days++
+	b.old += i.feedOld
+	b.moved += i.feedMoved
+	b.newDomains += i.feedNew
+}
+
+func (b *memBucket) counts() *model.ZoneCounts {
+	c := &model.ZoneCounts{Date: b.date, Old: b.old, Moved: b.moved, New: b.newDomains}
+	if b.days > 0 {
+		c.Domains = b.domains / b.days
+	}
+	return c
+}
+
+// truncWeek mirrors date_trunc('week', date)
+func truncWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset)
+}
+
+// truncMonth mirrors date_trunc('month', date)
+func truncMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// GetInternetHistoryCounts returns the counts averages weekly for the past imports for all zones
+// opts can change the range and granularity
+func (ds *MemoryDataStore) GetInternetHistoryCounts(ctx context.Context, opts CountsOptions) (*model.ZoneCount, error) {
+	var zc model.ZoneCount
+	zc.History = make([]*model.ZoneCounts, 0, 100)
+	limit := 300
+
+	// sum every zone per day
+	days := make(map[time.Time]*memImport)
+	for _, z := range ds.zones {
+		for _, i := range z.imports {
+			day, ok := days[i.date]
+			if !ok {
+				day = &memImport{date: i.date}
+				days[i.date] = day
+			}
+			day.domains += i.domains
+			day.feedOld += i.feedOld
+			day.feedMoved += i.feedMoved
+			day.feedNew += i.feedNew
+		}
+	}
+	if !opts.isZero() {
+		sums := make([]*memImport, 0, len(days))
+		for _, day := range days {
+			sums = append(sums, day)
+		}
+		zc.History = memHistory(sums, opts, opts.granularity(GranularityWeek))
+		return &zc, nil
+	}
+	// then average per week
+	weeks := make(map[time.Time]*memBucket)
+	for _, day := range days {
+		week := truncWeek(day.date)
+		if _, ok := weeks[week]; !ok {
+			weeks[week] = &memBucket{date: week}
+		}
+		weeks[week].add(day)
+	}
+	for _, b := range weeks {
+		zc.History = append(zc.History, b.counts())
+	}
+	sort.Slice(zc.History, func(i, j int) bool { return zc.History[i].Date.After(zc.History[j].Date) })
+	if len(zc.History) > limit {
+		zc.History = zc.History[:limit]
+	}
+	return &zc, nil
+}
+
+// GetZoneHistoryCounts returns the counts averages weekly for the past imports for a given zone
+// opts can change the range and granularity
+func (ds *MemoryDataStore) GetZoneHistoryCounts(ctx context.Context, zone string, opts CountsOptions) (*model.ZoneCount, error) {
+	var zc model.ZoneCount
+	zc.History = make([]*model.ZoneCounts, 0, 100)
+	zc.Zone = zone
+
+	z, ok := ds.zonesByName[zone]
+	if !ok {
+		return &zc, nil
+	}
+	if !opts.isZero() {
+		zc.History = memHistory(z.imports, opts, opts.granularity(GranularityWeek))
+		return &zc, nil
+	}
+	// group every 7 imports starting with the most recent
+	var b *memBucket
+	for n := 0; n < len(z.imports) && n < 7*52*5; n++ {
+		i := z.imports[len(z.imports)-1-n]
+		if n%7 == 0 {
+			b = &memBucket{date: i.date}
+		}
+		b.add(i)
+		if n%7 == 6 || n == len(z.imports)-1 {
+			zc.History = append(zc.History, b.counts())
+		}
+	}
+	return &zc, nil
+}
+
+// GetAllZoneHistoryCounts returns the counts averages monthly for the past imports for all zones
+// opts can change the range and granularity, and limit the zones
+func (ds *MemoryDataStore) GetAllZoneHistoryCounts(ctx context.Context, opts CountsOptions) (*model.AllZoneCounts, error) {
+	var all model.AllZoneCounts
+	all.Counts = make(map[string]*model.ZoneCount)
+
+	if !opts.isZero() {
+		for _, z := range ds.zones {
+			if opts.Zones != nil && !containsString(opts.Zones, z.name) {
+				continue
+			}
+			history := memHistory(z.imports, opts, opts.granularity(GranularityMonth))
+			if len(history) > 0 {
+				all.Counts[z.name] = &model.ZoneCount{Zone: z.name, History: history}
+			}
+		}
+		return &all, nil
+	}
+
+	for _, z := range ds.zones {
+		if len(z.imports) == 0 {
+			continue
+		}
+		months := make(map[time.Time]*memBucket)
+		for _, i := range z.imports {
+			month := truncMonth(i.date)
+			if _, ok := months[month]; !ok {
+				months[month] = &memBucket{date: month}
+			}
+			months[month].add(i)
+		}
+		zc := &model.ZoneCount{Zone: z.name, History: make([]*model.ZoneCounts, 0, len(months))}
+		for _, b := range months {
+			zc.History = append(zc.History, b.counts())
+		}
+		sort.Slice(zc.History, func(i, j int) bool { return zc.History[i].Date.After(zc.History[j].Date) })
+		all.Counts[z.name] = zc
+	}
+	return &all, nil
+}
+
+// GetAvailablePrefixes returns available prefixes for the queried prefix
+func (ds *MemoryDataStore) GetAvailablePrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
+	var prefixes model.PrefixList
+	prefixes.Active = false
+	prefixes.Prefix = name
+	prefixes.Domains = make([]model.PrefixResult, 0, 10)
+
+	taken := make(map[int64]bool)
+	for _, d := range ds.domains {
+		if strings.HasPrefix(d.name, name+".") && isPrefixDomain(d.name) {
+			current, _ := splitEdges(ds.domainsNameservers.byParent[d.id])
+			if len(current) > 0 {
+				taken[d.zoneID] = true
+			}
+		}
+	}
+	for _, z := range ds.zones {
+		if taken[z.id] || len(z.imports) == 0 || z.name == "" || z.name == "arpa" {
+			continue
+		}
+		result := model.PrefixResult{Domain: name + "." + z.name}
+		if !isPrefixDomain(result.Domain) {
+			continue
+		}
+		if d, ok := ds.domainsByName[result.Domain]; ok {
+			edges := ds.domainsNameservers.byParent[d.id]
+			for _, e := range edges {
+				if e.lastSeen != nil && (result.LastSeen == nil || e.lastSeen.After(*result.LastSeen)) {
+					result.LastSeen = e.lastSeen
+				}
+			}
+		}
+		prefixes.Domains = append(prefixes.Domains, result)
+	}
+	sort.Slice(prefixes.Domains, func(i, j int) bool {
+		a, b := prefixes.Domains[i], prefixes.Domains[j]
+		if len(a.Domain) != len(b.Domain) {
+			return len(a.Domain) < len(b.Domain)
+		}
+		return a.Domain < b.Domain
+	})
+
+	return &prefixes, nil
+}
+
+// GetTakenPrefixes searched for domain prefixes that match the given pattern that are active
+func (ds *MemoryDataStore) GetTakenPrefixes(ctx context.Context, name string) (*model.PrefixList, error) {
+	var prefixes model.PrefixList
+	prefixes.Prefix = name
+	prefixes.Active = true
+	prefixes.Domains = make([]model.PrefixResult, 0, 10)
+
+	for _, d := range ds.domains {
+		if !strings.HasPrefix(d.name, name+".") || !isPrefixDomain(d.name) {
+			continue
+		}
+		current, _ := splitEdges(ds.domainsNameservers.byParent[d.id])
+		if len(current) == 0 {
+			continue
+		}
+		result := model.PrefixResult{Domain: d.name}
+		for _, e := range current {
+			if e.firstSeen != nil && (result.FirstSeen == nil || e.firstSeen.Before(*result.FirstSeen)) {
+				result.FirstSeen = e.firstSeen
+			}
+		}
+		prefixes.Domains = append(prefixes.Domains, result)
+	}
+	sort.Slice(prefixes.Domains, func(i, j int) bool { return prefixes.Domains[i].Domain < prefixes.Domains[j].Domain })
+
+	return &prefixes, nil
+}
+
+// GetDeadTLDs returns zones that have been removed from the root and their ages
+func (ds *MemoryDataStore) GetDeadTLDs(ctx context.Context) ([]*model.TLDLife, error) {
+	out := make([]*model.TLDLife, 0, 20)
+	for _, z := range ds.zones {
+		edges := ds.zonesNameservers.byParent[z.id]
+		current, _ := splitEdges(edges)
+		if len(edges) == 0 || len(current) > 0 {
+			continue
+		}
+		t := model.TLDLife{Zone: z.name, Created: memFirstSeen(edges), Removed: memLastSeen(edges)}
+		if t.Created != nil && t.Removed != nil {
+			age := pgAge(*t.Created, *t.Removed)
+			t.Age = &age
+		}
+		if len(z.imports) > 0 {
+			var max int64
+			for _, i := range z.imports {
+				if i.domains > max {
+					max = i.domains
+				}
+			}
+			t.Domains = &max
+		}
+		out = append(out, &t)
+	}
+	// like the query, the most recently removed first then the oldest, with unknown dates as postgres sorts NULLs
+	sort.Slice(out, func(i, j int) bool {
+		if c := compareDates(out[i].Removed, out[j].Removed); c != 0 {
+			return c > 0
+		}
+		if c := compareDates(out[i].Created, out[j].Created); c != 0 {
+			return c < 0
+		}
+		return out[i].Zone < out[j].Zone
+	})
+	return out, nil
+}
+
+// compareDates compares a and b as -1, 0 or 1, a nil date being after all others as a NULL is in postgres
+func compareDates(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	case a.Before(*b):
+		return -1
+	case a.After(*b):
+		return 1
+	}
+	return 0
+}
+
+// pgAge formats the difference between two dates like postgres' age()::text
+func pgAge(from, to time.Time) string {
+	years := to.Year() - from.Year()
+	months := int(to.Month()) - int(from.Month())
+	days := to.Day() - from.Day()
+	if days < 0 {
+		months--
+		// borrow the length of the month before to
+		days += time.Date(to.Year(), to.Month(), 0, 0, 0, 0, 0, time.UTC).Day()
+	}
+	if months < 0 {
+		years--
+		months += 12
+	}
+	parts := make([]string, 0, 3)
+	plural := func(n int, singular, many string) {
+		if n == 1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, singular))
+		} else if n > 1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, many))
+		}
+	}
+	plural(years, "year", "years")
+	plural(months, "mon", "mons")
+	plural(days, "day", "days")
+	if len(parts) == 0 {
+		return "00:00:00"
+	}
+	return strings.Join(parts, " ")
+}
+
+// GetDomainsInZoneID returns a sample of 50 domains in a given zoneID
+func (ds *MemoryDataStore) GetDomainsInZoneID(ctx context.Context, zoneID int64) ([]model.Domain, error) {
+	out := make([]model.Domain, 0, 50)
+	edges := make([]*memEdge, 0)
+	for _, e := range ds.domainsNameservers.all {
+		if e.zoneID == zoneID {
+			edges = append(edges, e)
+		}
+	}
+	// order by last_seen desc, nulls are first
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[j].lastSeen == nil {
+			return false
+		}
+		return edges[i].lastSeen == nil || edges[i].lastSeen.After(*edges[j].lastSeen)
+	})
+	edges = limitEdges(edges, 150)
+
+	seen := make(map[int64]bool)
+	for _, e := range edges {
+		if seen[e.parent] {
+			continue
+		}
+		seen[e.parent] = true
+		d := ds.domainsByID[e.parent]
+		out = append(out, model.Domain{Name: d.name, LastSeen: e.lastSeen})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	if len(out) > 50 {
+		out = out[:50]
+	}
+	return out, nil
+}
+
+// GetTopNameservers returns the topN nameservers sorted by number of
+// domains
+func (ds *MemoryDataStore) GetTopNameservers(ctx context.Context, topN int) ([]*model.NameServer, error) {
+	out := make([]*model.NameServer, 0, topN)
+	for _, mns := range ds.nameservers {
+		edges := ds.domainsNameservers.byChild[mns.id]
+		if len(edges) == 0 {
+			continue
+		}
+		current, _ := splitEdges(edges)
+		out = append(out, &model.NameServer{
+			Name:        mns.name,
+			DomainCount: int64Ptr(len(current)),
+			FirstSeen:   memFirstSeen(edges),
+			LastSeen:    memLastSeen(edges),
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return *out[i].DomainCount > *out[j].DomainCount })
+	if len(out) > topN {
+		out = out[:topN]
+	}
+	return out, nil
+}
+
+// GetActiveIPs returns the active IP addresses (IPv4 and IPv6) for a given date
+func (ds *MemoryDataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error) {
+	var aip model.ActiveIPs
+	aip.Date = date
+	active := func(version int) []string {
+		out := make([]string, 0)
+		for _, ip := range ds.ips[version] {
+			if anyActiveOn(ds.ipNameservers[version].byChild[ip.id], date) {
+				out = append(out, ip.ip.String())
+			}
+		}
+		return out
+	}
+	aip.IPv4IPs = active(4)
+	aip.IPv6IPs = active(6)
+	return &aip, nil
+}
+
+// GetIPNsZoneCount returns the count of nameservers pointing to an IP grouped
+// by the zone
+func (ds *MemoryDataStore) GetIPNsZoneCount(ctx context.Context, ip string) (*model.ResearchIPNsZoneCount, error) {
+	var ipZoneCount model.ResearchIPNsZoneCount
+	ipZoneCount.IP = ip
+	ipZoneCount.ZoneNSCounts = make([]model.ResearchZoneCount, 0)
+
+	id, version, err := ds.GetIPID(ctx, ip)
+	if err == ErrNoResource {
+		return &ipZoneCount, nil
+	}
+	counts := make(map[int64]int64)
+	total := float64(0)
+	for _, e := range ds.ipNameservers[version].byChild[id] {
+		if z, ok := ds.zonesByID[e.zoneID]; ok {
+			counts[z.id]++
+			total++
+		}
+	}
+	for zoneID, count := range counts {
+		ipZoneCount.ZoneNSCounts = append(ipZoneCount.ZoneNSCounts, model.ResearchZoneCount{
+			Zone:    ds.zonesByID[zoneID].name,
+			Count:   count,
+			Percent: 100 * float64(count) / total,
+		})
+	}
+	sort.Slice(ipZoneCount.ZoneNSCounts, func(i, j int) bool {
+		return ipZoneCount.ZoneNSCounts[i].Count > ipZoneCount.ZoneNSCounts[j].Count
+	})
+	return &ipZoneCount, nil
+}
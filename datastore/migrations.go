@@ -0,0 +1,129 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// the migrations follow the domains of the moved feed, comparing the operators of the nameservers
+// they had the day before each move with those of the day of the move
+// a domain is split evenly between the operators of its nameservers on both days,
+// so a move from two nameservers of a to one of a and one of b is half a domain from a to b
+
+const (
+	// MigrationDays is the default number of days of the migrations, up to the last one
+	MigrationDays = 30
+	// MaxMigrationDays limits the number of days of the migrations
+	MaxMigrationDays = 366
+)
+
+// MigrationRange sets the defaults of the range of the migrations, the past MigrationDays days
+func MigrationRange(from, to *time.Time) (time.Time, time.Time) {
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	if to != nil {
+		end = *to
+	}
+	start := end.AddDate(0, 0, 1-MigrationDays)
+	if from != nil {
+		start = *from
+	}
+	return start, end
+}
+
+// operatorWeights splits a domain evenly between its nameservers and sums the parts by operator
+func operatorWeights(nameservers []string) map[string]float64 {
+	out := make(map[string]float64)
+	for _, ns := range nameservers {
+		out[NameServerOperator(ns)] += 1 / float64(len(nameservers))
+	}
+	return out
+}
+
+// migrationFlows sums the flows of the moved domains between operators
+type migrationFlows struct {
+	moved int64
+	flows map[[2]string]float64
+}
+
+func newMigrationFlows() *migrationFlows {
+	return &migrationFlows{flows: make(map[[2]string]float64)}
+}
+
+// add adds a domain that moved from the nameservers before to the nameservers after
+func (m *migrationFlows) add(before, after []string) {
+	if len(before) == 0 || len(after) == 0 {
+		return
+	}
+	m.moved++
+	to := operatorWeights(after)
+	for a, wa := range operatorWeights(before) {
+		for b, wb := range to {
+			m.flows[[2]string{a, b}] += wa * wb
+		}
+	}
+}
+
+// migrationQuery selects the nameservers of the domains of the moved feed of zone $1 between $2 and $3
+// the day before and the day of their move
+const migrationQuery = `SELECT
+		array_agg(ns.domain) FILTER (WHERE (dns.first_seen IS NULL OR dns.first_seen <= m.date - 1) AND (dns.last_seen IS NULL OR dns.last_seen >= m.date - 1)),
+		array_agg(ns.domain) FILTER (WHERE (dns.first_seen IS NULL OR dns.first_seen <= m.date) AND (dns.last_seen IS NULL OR dns.last_seen >= m.date))
+	FROM recent_moved_domains m, domains d, domains_nameservers dns, nameservers ns
+	WHERE d.id = m.domain_id AND d.zone_id = $1 AND m.date >= $2 AND m.date <= $3
+		AND dns.domain_id = m.domain_id AND ns.id = dns.nameserver_id
+	GROUP BY m.domain_id, m.date`
+
+// GetProviderMigration gets the flows of the domains of zone that moved between nameserver operators from from to to,
+// listing the top operators
+func (ds *PostgresDataStore) GetProviderMigration(ctx context.Context, zone string, from, to time.Time, top int) (*model.ProviderMigration, error) {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	m := newMigrationFlows()
+	err = ds.streamRows(ctx, migrationQuery, []interface{}{zoneID, from, to}, func(scan func(dest ...interface{}) error) error {
+		var before, after []string
+		err := scan(&before, &after)
+		if err != nil {
+			return err
+		}
+		m.add(before, after)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model.NewProviderMigration(zone, from, to, m.moved, m.flows, top), nil
+}
+
+// GetProviderMigration gets the flows of the domains of zone that moved between nameserver operators from from to to,
+// listing the top operators
+func (ds *MemoryDataStore) GetProviderMigration(ctx context.Context, zone string, from, to time.Time, top int) (*model.ProviderMigration, error) {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	names := func(children map[int64]bool) []string {
+		out := make([]string, 0, len(children))
+		for id := range children {
+			out = append(out, ds.nameserversByID[id].name)
+		}
+		return out
+	}
+	m := newMigrationFlows()
+	for date, domains := range ds.feeds[changeMoved] {
+		if date.Before(from) || date.After(to) {
+			continue
+		}
+		for _, d := range domains {
+			if d.zoneID != zoneID {
+				continue
+			}
+			edges := ds.domainsNameservers.byParent[d.id]
+			m.add(names(activeChildren(edges, date.AddDate(0, 0, -1))), names(activeChildren(edges, date)))
+		}
+	}
+	return model.NewProviderMigration(zone, from, to, m.moved, m.flows, top), nil
+}
@@ -0,0 +1,288 @@
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/psl"
+)
+
+// the operator of a nameserver is the first override its hostname matches, or its registrable domain
+// by the ICANN rules of the Public Suffix List, so ns1 and ns2.example.com are both run by example.com
+// a domain delegated to several nameservers is split evenly between their operators,
+// so the shares of a zone add up to its domains
+
+const (
+	// OperatorTop is the default number of operators listed in each share
+	OperatorTop = 10
+	// MaxOperatorTop limits the number of operators listed in each share
+	MaxOperatorTop = 100
+	// MaxOperatorDates limits the number of dates of the operator shares
+	MaxOperatorDates = 120
+	// MaxOperatorZones limits the number of zones of the operator shares
+	MaxOperatorZones = 10
+)
+
+// OperatorOverride names the operator of the nameservers with a hostname matching Pattern,
+// a path.Match pattern, where * matches any run of labels as hostnames hold no slashes
+type OperatorOverride struct {
+	Pattern  string
+	Operator string
+}
+
+// DefaultOperatorOverrides group the operators that name their nameservers under several registrable domains
+var DefaultOperatorOverrides = []OperatorOverride{
+	{"*.awsdns-*", "awsdns"},
+	{"*.azure-dns.*", "azure-dns"},
+	{"*.ultradns.*", "ultradns"},
+}
+
+// operatorOverrides are the overrides in use, those loaded with LoadOperatorOverrides before the defaults
+var operatorOverrides = DefaultOperatorOverrides
+
+// LoadOperatorOverrides reads overrides from file, used before the defaults
+// each line is a hostname pattern and an operator, # starts a comment
+// it is not safe to call while the overrides are in use
+func LoadOperatorOverrides(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	overrides := make([]OperatorOverride, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("%s line %d: want a pattern and an operator", file, line)
+		}
+		pattern := strings.ToLower(strings.TrimSuffix(fields[0], "."))
+		if _, err = path.Match(pattern, ""); err != nil {
+			return 0, fmt.Errorf("%s line %d: %w", file, line, err)
+		}
+		overrides = append(overrides, OperatorOverride{pattern, fields[1]})
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	operatorOverrides = append(overrides, DefaultOperatorOverrides...)
+	return len(overrides), nil
+}
+
+// NameServerOperator returns the operator of the nameserver name
+func NameServerOperator(name string) string {
+	for _, o := range operatorOverrides {
+		if ok, _ := path.Match(o.Pattern, name); ok {
+			return o.Operator
+		}
+	}
+	if domain := psl.ICANNRegistrableDomain(name); domain != "" {
+		return domain
+	}
+	return name
+}
+
+// OperatorRange sets the defaults of the range of the operator shares, the past year by month
+func OperatorRange(opts CountsOptions) CountsOptions {
+	if opts.Granularity == "" {
+		opts.Granularity = GranularityMonth
+	}
+	if opts.To == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		opts.To = &today
+	}
+	if opts.From == nil {
+		from := opts.To.AddDate(-1, 0, 0)
+		opts.From = &from
+	}
+	return opts
+}
+
+// OperatorDates returns the dates the operator shares are counted on, most recent first,
+// the first day of every period of the granularity from the period of To back to From
+// the result can hold one more date than MaxOperatorDates, to tell a range that is too long
+func OperatorDates(opts CountsOptions) []time.Time {
+	opts = OperatorRange(opts)
+	dates := make([]time.Time, 0)
+	from := truncDate(*opts.From, opts.Granularity)
+	for date := truncDate(*opts.To, opts.Granularity); !date.Before(from) && len(dates) <= MaxOperatorDates; {
+		dates = append(dates, date)
+		switch opts.Granularity {
+		case GranularityWeek:
+			date = date.AddDate(0, 0, -7)
+		case GranularityMonth:
+			date = date.AddDate(0, -1, 0)
+		case GranularityYear:
+			date = date.AddDate(-1, 0, 0)
+		default:
+			date = date.AddDate(0, 0, -1)
+		}
+	}
+	return dates
+}
+
+// operatorDates returns the dates of OperatorDates, up to MaxOperatorDates
+func operatorDates(opts CountsOptions) []time.Time {
+	dates := OperatorDates(opts)
+	if len(dates) > MaxOperatorDates {
+		dates = dates[:MaxOperatorDates]
+	}
+	return dates
+}
+
+// operatorBucket sums the domains of the operators of a zone on a date
+type operatorBucket struct {
+	zone    string
+	date    time.Time
+	domains map[string]float64
+}
+
+// operatorShares passes the buckets of the rows of a zone and date on to fn as they end
+type operatorShares struct {
+	top     int
+	fn      func(s *model.OperatorShares) error
+	current *operatorBucket
+}
+
+// add adds the weight of the domains of nameserver on date in zone to its operator
+func (o *operatorShares) add(zone string, date time.Time, nameserver string, weight float64) error {
+	if o.current == nil || o.current.zone != zone || !o.current.date.Equal(date) {
+		if err := o.flush(); err != nil {
+			return err
+		}
+		o.current = &operatorBucket{zone: zone, date: date, domains: make(map[string]float64)}
+	}
+	o.current.domains[NameServerOperator(nameserver)] += weight
+	return nil
+}
+
+// flush passes the current bucket on
+func (o *operatorShares) flush() error {
+	if o.current == nil {
+		return nil
+	}
+	b := o.current
+	o.current = nil
+	return o.fn(model.NewOperatorShares(b.date, b.zone, b.domains, o.top))
+}
+
+// operatorSharesQuery selects the domains of every nameserver of the zone $1 on the date $2
+// each domain is split evenly between its nameservers
+const operatorSharesQuery = `SELECT ns.domain, sum(1.0 / e.n)::float8
+	FROM (
+		SELECT dns.nameserver_id, count(*) OVER (PARTITION BY dns.domain_id) AS n
+		FROM domains d, domains_nameservers dns
+		WHERE d.zone_id = $1 AND dns.domain_id = d.id
+			AND (dns.first_seen IS NULL OR dns.first_seen <= $2) AND (dns.last_seen IS NULL OR dns.last_seen >= $2)
+	) e, nameservers ns
+	WHERE ns.id = e.nameserver_id
+	GROUP BY ns.domain`
+
+// StreamOperatorShares calls fn with the shares of the nameserver operators of the zones of opts on the dates of OperatorDates,
+// by zone then most recent first, listing the top operators of each
+// every zone and date is counted by its own query, so each share is passed on as soon as it is counted
+func (ds *PostgresDataStore) StreamOperatorShares(ctx context.Context, opts CountsOptions, top int, fn func(s *model.OperatorShares) error) error {
+	zones := append([]string(nil), opts.Zones...)
+	sort.Strings(zones)
+	dates := operatorDates(opts)
+	shares := &operatorShares{top: top, fn: fn}
+	for i, zone := range zones {
+		if i > 0 && zone == zones[i-1] {
+			continue
+		}
+		zoneID, err := ds.GetZoneID(ctx, zone)
+		if err == ErrNoResource {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, date := range dates {
+			err = ds.streamRows(ctx, operatorSharesQuery, []interface{}{zoneID, date}, func(scan func(dest ...interface{}) error) error {
+				var nameserver string
+				var weight float64
+				err := scan(&nameserver, &weight)
+				if err != nil {
+					return err
+				}
+				return shares.add(zone, date, nameserver, weight)
+			})
+			if err != nil {
+				return err
+			}
+			if err = shares.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamOperatorShares calls fn with the shares of the nameserver operators of the zones of opts on the dates of OperatorDates,
+// by zone then most recent first, listing the top operators of each
+func (ds *MemoryDataStore) StreamOperatorShares(ctx context.Context, opts CountsOptions, top int, fn func(s *model.OperatorShares) error) error {
+	type key struct {
+		zone string
+		date time.Time
+	}
+	buckets := make(map[key]map[string]float64)
+	dates := operatorDates(opts)
+	for _, d := range ds.domains {
+		zone := ds.zonesByID[d.zoneID].name
+		if !containsString(opts.Zones, zone) {
+			continue
+		}
+		for _, date := range dates {
+			active := make([]*memEdge, 0)
+			for _, e := range ds.domainsNameservers.byParent[d.id] {
+				if e.activeOn(date) {
+					active = append(active, e)
+				}
+			}
+			if len(active) == 0 {
+				continue
+			}
+			k := key{zone, date}
+			if buckets[k] == nil {
+				buckets[k] = make(map[string]float64)
+			}
+			for _, e := range active {
+				buckets[k][ds.nameserversByID[e.child].name] += 1 / float64(len(active))
+			}
+		}
+	}
+	keys := make([]key, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].zone != keys[j].zone {
+			return keys[i].zone < keys[j].zone
+		}
+		return keys[i].date.After(keys[j].date)
+	})
+
+	shares := &operatorShares{top: top, fn: fn}
+	for _, k := range keys {
+		for nameserver, weight := range buckets[k] {
+			if err := shares.add(k.zone, k.date, nameserver, weight); err != nil {
+				return err
+			}
+		}
+	}
+	return shares.flush()
+}
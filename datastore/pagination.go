@@ -0,0 +1,208 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// page sizes for paginated listings
+const (
+	PageSize    = 100
+	MaxPageSize = 1000
+)
+
+// ErrInvalidCursor is returned when a page token can not be decoded
+var ErrInvalidCursor = errors.New("invalid page")
+
+// relationship states used by ListOptions
+const (
+	StateCurrent = "current"
+	StateArchive = "archive"
+)
+
+// ListOptions selects a page of a relationship listing
+// State is StateCurrent, StateArchive or empty for both
+// Page is an opaque token returned in a previous listing's NextPage or PrevPage
+// when Date is set the listing is as of that day: current relationships are the ones
+// active on Date, archive the ones that ended before it, and later ones are left out
+type ListOptions struct {
+	State string
+	Page  string
+	Limit int
+	Date  *time.Time
+}
+
+func (opts ListOptions) limit() int {
+	if opts.Limit <= 0 {
+		return PageSize
+	}
+	return opts.Limit
+}
+
+// includes returns true if a relationship seen from first to last is in the listing
+func (opts ListOptions) includes(first, last *time.Time) bool {
+	if opts.Date == nil {
+		switch opts.State {
+		case StateCurrent:
+			return last == nil
+		case StateArchive:
+			return last != nil
+		}
+		return true
+	}
+	if first != nil && first.After(*opts.Date) {
+		return false
+	}
+	ended := last != nil && last.Before(*opts.Date)
+	switch opts.State {
+	case StateCurrent:
+		return !ended
+	case StateArchive:
+		return ended
+	}
+	return true
+}
+
+// cursor is the decoded keyset position of a page token
+// rows are ordered by (group, id, first_seen), group is used for the IP version
+type cursor struct {
+	before    bool
+	group     int
+	id        int64
+	firstSeen time.Time
+}
+
+// epoch is used in place of a NULL first_seen so that keys are always comparable
+var epoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (c cursor) String() string {
+	dir := "a"
+	if c.before {
+		dir = "b"
+	}
+	s := fmt.Sprintf("%s.%d.%d.%s", dir, c.group, c.id, c.firstSeen.Format("20060102"))
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func parseCursor(page string) (*cursor, error) {
+	if page == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(page)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 4 || (parts[0] != "a" && parts[0] != "b") {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	c.before = parts[0] == "b"
+	_, err = fmt.Sscanf(parts[1]+" "+parts[2], "%d %d", &c.group, &c.id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c.firstSeen, err = time.Parse("20060102", parts[3])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// edgeRow is a single row of a relationship listing
+type edgeRow struct {
+	group     int
+	id        int64
+	name      string
+	firstSeen *time.Time
+	lastSeen  *time.Time
+}
+
+func (r *edgeRow) key() cursor {
+	c := cursor{group: r.group, id: r.id, firstSeen: epoch}
+	if r.firstSeen != nil {
+		c.firstSeen = *r.firstSeen
+	}
+	return c
+}
+
+// less orders cursors by (group, id, first_seen)
+func (c cursor) less(o cursor) bool {
+	if c.group != o.group {
+		return c.group < o.group
+	}
+	if c.id != o.id {
+		return c.id < o.id
+	}
+	return c.firstSeen.Before(o.firstSeen)
+}
+
+// finishPage trims rows fetched with limit+1 in the direction of c
+// it returns the rows in ascending order with the tokens for the next and previous pages
+func finishPage(rows []*edgeRow, c *cursor, limit int) ([]*edgeRow, string, string) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if c != nil && c.before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+	var next, prev string
+	first, last := rows[0].key(), rows[len(rows)-1].key()
+	first.before = true
+	if c == nil || !c.before {
+		if more {
+			next = last.String()
+		}
+		if c != nil {
+			prev = first.String()
+		}
+	} else {
+		if more {
+			prev = first.String()
+		}
+		next = last.String()
+	}
+	return rows, next, prev
+}
+
+// pageRows pages through rows that are already in memory
+func pageRows(rows []*edgeRow, opts ListOptions) ([]*edgeRow, string, string, error) {
+	c, err := parseCursor(opts.Page)
+	if err != nil {
+		return nil, "", "", err
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key().less(rows[j].key()) })
+	limit := opts.limit()
+	window := make([]*edgeRow, 0, limit+1)
+	if c == nil || !c.before {
+		for _, r := range rows {
+			if c == nil || c.less(r.key()) {
+				window = append(window, r)
+				if len(window) > limit {
+					break
+				}
+			}
+		}
+	} else {
+		for i := len(rows) - 1; i >= 0; i-- {
+			if rows[i].key().less(*c) {
+				window = append(window, rows[i])
+				if len(window) > limit {
+					break
+				}
+			}
+		}
+	}
+	rows, next, prev := finishPage(window, c, limit)
+	return rows, next, prev, nil
+}
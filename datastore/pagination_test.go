@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRows returns rows with the ids, all in group 0 and first seen on the same day
+func testRows(ids ...int64) []*edgeRow {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]*edgeRow, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, &edgeRow{id: id, firstSeen: &day})
+	}
+	return rows
+}
+
+func rowIDs(rows []*edgeRow) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.id)
+	}
+	return ids
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []cursor{
+		{group: 4, id: 1, firstSeen: epoch},
+		{before: true, group: 6, id: 123456789, firstSeen: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)},
+	} {
+		got, err := parseCursor(c.String())
+		if err != nil {
+			t.Fatalf("parseCursor(%v): %v", c, err)
+		}
+		if !reflect.DeepEqual(*got, c) {
+			t.Errorf("parseCursor(%v) = %v", c, *got)
+		}
+	}
+	if c, err := parseCursor(""); c != nil || err != nil {
+		t.Errorf("parseCursor(\"\") = %v, %v, want the first page", c, err)
+	}
+}
+
+func TestParseCursorMalformed(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, page := range []string{
+		"not base64!",
+		encode("a.0.1"),
+		encode("c.0.1.20200301"),
+		encode("a.x.1.20200301"),
+		encode("a.0.y.20200301"),
+		encode("a.0.1.2020-03-01"),
+		encode("a.0.1.20200301.5"),
+	} {
+		if _, err := parseCursor(page); err != ErrInvalidCursor {
+			t.Errorf("parseCursor(%q) = %v, want ErrInvalidCursor", page, err)
+		}
+	}
+	if _, _, _, err := pageRows(testRows(1, 2), ListOptions{Page: "garbage"}); err != ErrInvalidCursor {
+		t.Errorf("pageRows with a garbage page = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestPageRows(t *testing.T) {
+	type page struct {
+		ids        []int64
+		next, prev bool
+	}
+	tests := []struct {
+		name  string
+		ids   []int64
+		limit int
+		// forward are the pages following next from the first, backward those following prev from the last
+		forward, backward []page
+	}{
+		{"empty", nil, 2, []page{{[]int64{}, false, false}}, nil},
+		{"one page", []int64{2, 1}, 2, []page{{[]int64{1, 2}, false, false}}, nil},
+		{"exact pages", []int64{4, 3, 2, 1}, 2,
+			[]page{{[]int64{1, 2}, true, false}, {[]int64{3, 4}, false, true}},
+			[]page{{[]int64{1, 2}, true, false}}},
+		{"partial last page", []int64{5, 1, 4, 2, 3}, 2,
+			[]page{{[]int64{1, 2}, true, false}, {[]int64{3, 4}, true, true}, {[]int64{5}, false, true}},
+			[]page{{[]int64{3, 4}, true, true}, {[]int64{1, 2}, true, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var token, prev string
+			for i, want := range tt.forward {
+				rows, next, p, err := pageRows(testRows(tt.ids...), ListOptions{Page: token, Limit: tt.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := rowIDs(rows); !reflect.DeepEqual(got, want.ids) {
+					t.Errorf("forward page %d = %v, want %v", i, got, want.ids)
+				}
+				if (next != "") != want.next || (p != "") != want.prev {
+					t.Errorf("forward page %d has next %q and prev %q, want next %t and prev %t", i, next, p, want.next, want.prev)
+				}
+				token, prev = next, p
+			}
+			token = prev
+			for i, want := range tt.backward {
+				rows, next, p, err := pageRows(testRows(tt.ids...), ListOptions{Page: token, Limit: tt.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := rowIDs(rows); !reflect.DeepEqual(got, want.ids) {
+					t.Errorf("backward page %d = %v, want %v", i, got, want.ids)
+				}
+				if (next != "") != want.next || (p != "") != want.prev {
+					t.Errorf("backward page %d has next %q and prev %q, want next %t and prev %t", i, next, p, want.next, want.prev)
+				}
+				token = p
+			}
+		})
+	}
+}
+
+func TestFinishPageOrdersBackwardRows(t *testing.T) {
+	// rows fetched backward come in descending order, one more than the limit
+	c := &cursor{before: true, id: 9, firstSeen: epoch}
+	rows, next, prev := finishPage(testRows(8, 7, 6), c, 2)
+	if got := rowIDs(rows); !reflect.DeepEqual(got, []int64{7, 8}) {
+		t.Errorf("rows = %v, want [7 8]", got)
+	}
+	if next == "" || prev == "" {
+		t.Errorf("next %q and prev %q, want both", next, prev)
+	}
+	n, err := parseCursor(next)
+	if err != nil || n.before || n.id != 8 {
+		t.Errorf("next = %v, %v, want after 8", n, err)
+	}
+	p, err := parseCursor(prev)
+	if err != nil || !p.before || p.id != 7 {
+		t.Errorf("prev = %v, %v, want before 7", p, err)
+	}
+}
@@ -5,55 +5,16 @@ import (
 	"dnscoffee/model"
 	"strings"
 	"time"
-	"net"
 )
 
 // GetActiveIPs returns the active IP addresses (IPv4 and IPv6) for a given date
-func (ds *DataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error) {
-	var err error
-	var aip model.ActiveIPs
-	aip.Date = date
-
-	query := "select distinct a.ip from a_nameservers, a where a_nameservers.a_id = a.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"
-	rows, err := ds.db.Query(ctx, query, date)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	aip.IPv4IPs = make([]string, 0)
-	for rows.Next() {
-		var ipv4 net.IP
-		err = rows.Scan(&ipv4)
-		if err != nil {
-			return nil, err
-		}
-		aip.IPv4IPs = append(aip.IPv4IPs, ipv4.String())
-	}
-
-	query = "select distinct aaaa.ip from aaaa_nameservers, aaaa where aaaa_nameservers.aaaa_id = aaaa.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"
-	rows, err = ds.db.Query(ctx, query, date)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	aip.IPv6IPs = make([]string, 0)
-	for rows.Next() {
-		var ipv6 net.IP
-		err = rows.Scan(&ipv6)
-		if err != nil {
-			return nil, err
-		}
-		aip.IPv6IPs = append(aip.IPv6IPs, ipv6.String())
-	}
-
-	return &aip, nil
+func (ds *PostgresDataStore) GetActiveIPs(ctx context.Context, date time.Time) (*model.ActiveIPs, error) {
+	return collectActiveIPs(ctx, ds, date)
 }
 
 // GetIPNsZoneCount returns the count of nameservers pointing to an IP grouped
 // by the zone
-func (ds *DataStore) GetIPNsZoneCount(ctx context.Context, ip string) (*model.ResearchIPNsZoneCount, error) {
+func (ds *PostgresDataStore) GetIPNsZoneCount(ctx context.Context, ip string) (*model.ResearchIPNsZoneCount, error) {
 	var ipZoneCount model.ResearchIPNsZoneCount
 	var err error
 	ipZoneCount.IP = ip
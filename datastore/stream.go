@@ -0,0 +1,476 @@
+package datastore
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/psl"
+
+	"github.com/jackc/pgtype"
+)
+
+// the Stream methods call fn for every row of results too large to hold in memory, as they are read
+// an error returned by fn stops the stream and is returned
+// the Get methods of the same results collect the rows of the stream
+
+// collectAllZoneHistoryCounts groups the rows of StreamAllZoneHistoryCounts by zone
+func collectAllZoneHistoryCounts(ctx context.Context, ds DataStore, opts CountsOptions) (*model.AllZoneCounts, error) {
+	var all model.AllZoneCounts
+	all.Counts = make(map[string]*model.ZoneCount)
+	err := ds.StreamAllZoneHistoryCounts(ctx, opts, func(zone string, c *model.ZoneCounts) error {
+		if _, ok := all.Counts[zone]; !ok {
+			all.Counts[zone] = &model.ZoneCount{Zone: zone, History: make([]*model.ZoneCounts, 0, 100)}
+		}
+		all.Counts[zone].History = append(all.Counts[zone].History, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &all, nil
+}
+
+// collectActiveIPs collects the rows of StreamActiveIPs by IP version
+func collectActiveIPs(ctx context.Context, ds DataStore, date time.Time) (*model.ActiveIPs, error) {
+	var aip model.ActiveIPs
+	aip.Date = date
+	aip.IPv4IPs = make([]string, 0)
+	aip.IPv6IPs = make([]string, 0)
+	err := ds.StreamActiveIPs(ctx, date, func(version int, ip string) error {
+		if version == 4 {
+			aip.IPv4IPs = append(aip.IPv4IPs, ip)
+		} else {
+			aip.IPv6IPs = append(aip.IPv6IPs, ip)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &aip, nil
+}
+
+// StreamActiveIPs calls fn for every IP address active on date, IPv4 first
+func (ds *PostgresDataStore) StreamActiveIPs(ctx context.Context, date time.Time, fn func(version int, ip string) error) error {
+	queries := []struct {
+		version int
+		query   string
+	}{
+		{4, "select distinct a.ip from a_nameservers, a where a_nameservers.a_id = a.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"},
+		{6, "select distinct aaaa.ip from aaaa_nameservers, aaaa where aaaa_nameservers.aaaa_id = aaaa.id and first_seen <= $1 and (last_seen >= $1 or last_seen is NULL)"},
+	}
+	for _, q := range queries {
+		err := ds.streamRows(ctx, q.query, []interface{}{date}, func(scan func(dest ...interface{}) error) error {
+			var ip net.IP
+			err := scan(&ip)
+			if err != nil {
+				return err
+			}
+			return fn(q.version, ip.String())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamFeedNew calls fn for every domain first seen on date
+func (ds *PostgresDataStore) StreamFeedNew(ctx context.Context, date time.Time, fn func(d *model.Domain) error) error {
+	return ds.streamRows(ctx, "SELECT domain_id, domain from recent_new_domains where date = $1", []interface{}{date}, func(scan func(dest ...interface{}) error) error {
+		var d model.Domain
+		err := scan(&d.ID, &d.Name)
+		if err != nil {
+			return err
+		}
+		return fn(&d)
+	})
+}
+
+// StreamAllZoneHistoryCounts calls fn for every bucket of the zones' counts, ordered by zone and most recent first
+// buckets are monthly unless opts sets the granularity, and none are left out as the rows are streamed
+func (ds *PostgresDataStore) StreamAllZoneHistoryCounts(ctx context.Context, opts CountsOptions, fn func(zone string, c *model.ZoneCounts) error) error {
+	query := `select zone, date_trunc($1::text, date) AS bucket, floor(AVG(domains)) as domains, sum(old) as old, sum(moved) as moved, sum(new) as new
+		from weighted_counts, zones
+		where zones.id = zone_id
+			and ($2::date IS NULL OR date >= $2) AND ($3::date IS NULL OR date <= $3)
+			and ($4::text[] IS NULL OR zones.zone = ANY($4))
+		group by 1, 2
+		order by 1, 2 desc`
+	args := []interface{}{opts.granularity(GranularityMonth), opts.From, opts.To, opts.Zones}
+	return ds.streamRows(ctx, query, args, func(scan func(dest ...interface{}) error) error {
+		var c model.ZoneCounts
+		var zone string
+		err := scan(&zone, &c.Date, &c.Domains, &c.Old, &c.Moved, &c.New)
+		if err != nil {
+			return err
+		}
+		return fn(zone, &c)
+	})
+}
+
+// StreamTakenPrefixes calls fn for every active domain with the prefix as its first label, in order
+func (ds *PostgresDataStore) StreamTakenPrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error {
+	query := "select domains.domain, min(domains_nameservers.first_seen) first_seen from domains, domains_nameservers where domains.id = domains_nameservers.domain_id and domain LIKE $1 || '.%' and last_seen is null group by domains.domain order by domains.domain"
+	return ds.streamRows(ctx, query, []interface{}{name}, func(scan func(dest ...interface{}) error) error {
+		var domain model.PrefixResult
+		var firstSeen pgtype.Date
+		err := scan(&domain.Domain, &firstSeen)
+		if err != nil || !isPrefixDomain(domain.Domain) {
+			return err
+		}
+		if firstSeen.Status == pgtype.Present {
+			domain.FirstSeen = &firstSeen.Time
+		}
+		return fn(domain)
+	})
+}
+
+// StreamAvailablePrefixes calls fn for every zone the prefix is not active in, as the domain it would be
+func (ds *PostgresDataStore) StreamAvailablePrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error {
+	return ds.streamRows(ctx, availablePrefixesQuery, []interface{}{name}, func(scan func(dest ...interface{}) error) error {
+		var domain model.PrefixResult
+		var lastSeen pgtype.Date
+		err := scan(&domain.Domain, &lastSeen)
+		if err != nil || !isPrefixDomain(domain.Domain) {
+			return err
+		}
+		if lastSeen.Status == pgtype.Present {
+			domain.LastSeen = &lastSeen.Time
+		}
+		return fn(domain)
+	})
+}
+
+// zoneFileQueries select the records of the zone file of zone $1 on date $2
+// the NS records of the domains, then the in-zone glue of their nameservers
+var zoneFileQueries = []struct {
+	rrtype string
+	query  string
+}{
+	{"NS", `SELECT d.domain, ns.domain FROM domains d, domains_nameservers dns, nameservers ns
+		WHERE dns.domain_id = d.id AND dns.nameserver_id = ns.id AND d.zone_id = $1
+			AND (dns.first_seen IS NULL OR dns.first_seen <= $2) AND (dns.last_seen IS NULL OR dns.last_seen >= $2)
+		ORDER BY d.domain, ns.domain`},
+	{"A", `SELECT ns.domain, host(ip.ip) FROM a_nameservers ans, nameservers ns, a ip
+		WHERE ans.nameserver_id = ns.id AND ans.a_id = ip.id AND ans.zone_id = $1
+			AND (ans.first_seen IS NULL OR ans.first_seen <= $2) AND (ans.last_seen IS NULL OR ans.last_seen >= $2)
+		ORDER BY ns.domain, ip.ip`},
+	{"AAAA", `SELECT ns.domain, host(ip.ip) FROM aaaa_nameservers ans, nameservers ns, aaaa ip
+		WHERE ans.nameserver_id = ns.id AND ans.aaaa_id = ip.id AND ans.zone_id = $1
+			AND (ans.first_seen IS NULL OR ans.first_seen <= $2) AND (ans.last_seen IS NULL OR ans.last_seen >= $2)
+		ORDER BY ns.domain, ip.ip`},
+}
+
+// rootZoneFileQueries select the records of the zone file of the root zone $1 on date $2
+// the delegations of the root are the NS records of the TLDs, and every address of their nameservers,
+// and of the root's own, is glue, whichever zone it was seen in
+var rootZoneFileQueries = []struct {
+	rrtype string
+	query  string
+}{
+	{"NS", `SELECT z.zone, ns.domain FROM zones z, zones_nameservers zns, nameservers ns
+		WHERE zns.zone_id = z.id AND zns.nameserver_id = ns.id AND z.id != $1 AND strpos(z.zone, '.') = 0
+			AND (zns.first_seen IS NULL OR zns.first_seen <= $2) AND (zns.last_seen IS NULL OR zns.last_seen >= $2)
+		ORDER BY z.zone, ns.domain`},
+	{"A", `SELECT ns.domain, host(ip.ip) FROM a_nameservers ans, nameservers ns, a ip
+		WHERE ans.nameserver_id = ns.id AND ans.a_id = ip.id
+			AND (ans.first_seen IS NULL OR ans.first_seen <= $2) AND (ans.last_seen IS NULL OR ans.last_seen >= $2)
+			AND ns.id IN (` + rootNameServersQuery + `)
+		GROUP BY ns.domain, ip.ip ORDER BY ns.domain, ip.ip`},
+	{"AAAA", `SELECT ns.domain, host(ip.ip) FROM aaaa_nameservers ans, nameservers ns, aaaa ip
+		WHERE ans.nameserver_id = ns.id AND ans.aaaa_id = ip.id
+			AND (ans.first_seen IS NULL OR ans.first_seen <= $2) AND (ans.last_seen IS NULL OR ans.last_seen >= $2)
+			AND ns.id IN (` + rootNameServersQuery + `)
+		GROUP BY ns.domain, ip.ip ORDER BY ns.domain, ip.ip`},
+}
+
+// rootNameServersQuery selects the nameservers of the root zone $1 and of the TLDs on date $2
+const rootNameServersQuery = `SELECT zns.nameserver_id FROM zones_nameservers zns, zones z
+	WHERE zns.zone_id = z.id AND (z.id = $1 OR strpos(z.zone, '.') = 0)
+		AND (zns.first_seen IS NULL OR zns.first_seen <= $2) AND (zns.last_seen IS NULL OR zns.last_seen >= $2)`
+
+// StreamZoneFile calls fn for every record of the zone file of zone on date
+// the NS records of the domains active on date by domain, then their A and AAAA glue in the zone by nameserver
+// the domains of the root zone are the TLDs
+func (ds *PostgresDataStore) StreamZoneFile(ctx context.Context, zone string, date time.Time, fn func(rr *model.ZoneRecord) error) error {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return err
+	}
+	queries := zoneFileQueries
+	if zone == "" {
+		queries = rootZoneFileQueries
+	}
+	for _, q := range queries {
+		err = ds.streamRows(ctx, q.query, []interface{}{zoneID, date}, func(scan func(dest ...interface{}) error) error {
+			rr := model.ZoneRecord{Type: q.rrtype}
+			err := scan(&rr.Name, &rr.Value)
+			if err != nil {
+				return err
+			}
+			return fn(&rr)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// availablePrefixesQuery selects the domains of the prefix $1 in the zones it is not active in
+// with the last time each was seen
+const availablePrefixesQuery = `With available_domains as 
+	(
+	   WITH available AS 
+	   (
+		  WITH taken AS 
+		  (
+			 SELECT DISTINCT
+				domains.zone_id 
+			 FROM
+				domains,
+				domains_nameservers 
+			 WHERE
+				domains.id = domains_nameservers.domain_id 
+				AND domains_nameservers.last_seen IS NULL 
+				AND domains.domain LIKE $1 || '.%'
+		  )
+		  SELECT
+			 zone_imports.zone_id 
+		  FROM
+		  	 zones,
+			 zone_imports 
+			 LEFT JOIN
+				taken 
+				ON taken.zone_id = zone_imports.zone_id 
+		  WHERE
+			 taken.zone_id IS NULL
+			 AND zones.id = zone_imports.zone_id
+			 AND zones.zone != ''
+			 AND zones.zone != 'ARPA'
+	   )
+	   SELECT
+		  $1 || '.' || zones.zone AS domain 
+	   FROM
+		  zones,
+		  available 
+	   WHERE
+		  available.zone_id = zones.id 
+	)
+	Select
+	   available_domains.domain,
+	   max(Domains_nameservers.last_seen) last_seen 
+	from
+	   available_domains 
+	   Left join
+		  domains 
+		  on domains.domain = available_domains.domain 
+	   Left join
+		  domains_nameservers 
+		  on domains.id = domains_nameservers.domain_id 
+	Group by
+	   available_domains.domain 
+	ORDER BY
+	   Char_length(available_domains.domain),
+	   1,  2`
+
+// streamRows runs query and calls fn with the scan function of every row
+// the query stops when fn returns an error or the context is done
+func (ds *PostgresDataStore) streamRows(ctx context.Context, query string, args []interface{}, fn func(scan func(dest ...interface{}) error) error) error {
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = fn(rows.Scan)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamActiveIPs calls fn for every IP address active on date, IPv4 first
+func (ds *MemoryDataStore) StreamActiveIPs(ctx context.Context, date time.Time, fn func(version int, ip string) error) error {
+	aip, err := ds.GetActiveIPs(ctx, date)
+	if err != nil {
+		return err
+	}
+	for _, ips := range []struct {
+		version int
+		ips     []string
+	}{{4, aip.IPv4IPs}, {6, aip.IPv6IPs}} {
+		for _, ip := range ips.ips {
+			if err = fn(ips.version, ip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamFeedNew calls fn for every domain first seen on date
+func (ds *MemoryDataStore) StreamFeedNew(ctx context.Context, date time.Time, fn func(d *model.Domain) error) error {
+	for _, d := range ds.getFeed("new", date).Domains {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamAllZoneHistoryCounts calls fn for every bucket of the zones' counts, ordered by zone and most recent first
+func (ds *MemoryDataStore) StreamAllZoneHistoryCounts(ctx context.Context, opts CountsOptions, fn func(zone string, c *model.ZoneCounts) error) error {
+	all, err := ds.GetAllZoneHistoryCounts(ctx, opts)
+	if err != nil {
+		return err
+	}
+	zones := make([]string, 0, len(all.Counts))
+	for zone := range all.Counts {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		for _, c := range all.Counts[zone].History {
+			if err = fn(zone, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamTakenPrefixes calls fn for every active domain with the prefix as its first label, in order
+func (ds *MemoryDataStore) StreamTakenPrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error {
+	prefixes, err := ds.GetTakenPrefixes(ctx, name)
+	if err != nil {
+		return err
+	}
+	return streamPrefixes(prefixes, fn)
+}
+
+// StreamAvailablePrefixes calls fn for every zone the prefix is not active in, as the domain it would be
+func (ds *MemoryDataStore) StreamAvailablePrefixes(ctx context.Context, name string, fn func(domain model.PrefixResult) error) error {
+	prefixes, err := ds.GetAvailablePrefixes(ctx, name)
+	if err != nil {
+		return err
+	}
+	return streamPrefixes(prefixes, fn)
+}
+
+// StreamZoneFile calls fn for every record of the zone file of zone on date
+// the NS records of the domains active on date by domain, then their A and AAAA glue in the zone by nameserver
+// the domains of the root zone are the TLDs
+func (ds *MemoryDataStore) StreamZoneFile(ctx context.Context, zone string, date time.Time, fn func(rr *model.ZoneRecord) error) error {
+	zoneID, err := ds.GetZoneID(ctx, zone)
+	if err != nil {
+		return err
+	}
+	var records []*model.ZoneRecord
+	if zone == "" {
+		records = ds.rootZoneRecords(zoneID, date)
+	} else {
+		records = ds.zoneRecords(zoneID, date)
+	}
+	// in the order of the SQL queries
+	rank := map[string]int{"NS": 0, "A": 1, "AAAA": 2}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return rank[records[i].Type] < rank[records[j].Type]
+		}
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Value < records[j].Value
+	})
+	for _, rr := range records {
+		if err = fn(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneRecords returns the NS records of the domains of the zone on date and the glue seen in the zone
+func (ds *MemoryDataStore) zoneRecords(zoneID int64, date time.Time) []*model.ZoneRecord {
+	records := make([]*model.ZoneRecord, 0)
+	for _, d := range ds.domains {
+		if d.zoneID != zoneID {
+			continue
+		}
+		for _, e := range ds.domainsNameservers.byParent[d.id] {
+			if e.activeOn(date) {
+				records = append(records, &model.ZoneRecord{Name: d.name, Type: "NS", Value: ds.nameserversByID[e.child].name})
+			}
+		}
+	}
+	for _, rrtype := range []string{"A", "AAAA"} {
+		version := 4
+		if rrtype == "AAAA" {
+			version = 6
+		}
+		for _, e := range ds.ipNameservers[version].all {
+			if e.zoneID == zoneID && e.activeOn(date) {
+				records = append(records, &model.ZoneRecord{Name: ds.nameserversByID[e.parent].name, Type: rrtype, Value: ds.ipsByID[version][e.child].ip.String()})
+			}
+		}
+	}
+	return records
+}
+
+// rootZoneRecords returns the NS records of the TLDs on date,
+// and the addresses of their nameservers and of the root's own on date as glue, whichever zone they were seen in
+func (ds *MemoryDataStore) rootZoneRecords(rootID int64, date time.Time) []*model.ZoneRecord {
+	records := make([]*model.ZoneRecord, 0)
+	nameservers := make(map[int64]bool)
+	for _, z := range ds.zones {
+		if z.id != rootID && strings.Contains(z.name, ".") {
+			continue
+		}
+		for _, e := range ds.zonesNameservers.byParent[z.id] {
+			if !e.activeOn(date) {
+				continue
+			}
+			nameservers[e.child] = true
+			if z.id != rootID {
+				records = append(records, &model.ZoneRecord{Name: z.name, Type: "NS", Value: ds.nameserversByID[e.child].name})
+			}
+		}
+	}
+	for _, rrtype := range []string{"A", "AAAA"} {
+		version := 4
+		if rrtype == "AAAA" {
+			version = 6
+		}
+		glue := make(map[[2]int64]bool)
+		for _, e := range ds.ipNameservers[version].all {
+			key := [2]int64{e.parent, e.child}
+			if nameservers[e.parent] && !glue[key] && e.activeOn(date) {
+				glue[key] = true
+				records = append(records, &model.ZoneRecord{Name: ds.nameserversByID[e.parent].name, Type: rrtype, Value: ds.ipsByID[version][e.child].ip.String()})
+			}
+		}
+	}
+	return records
+}
+
+// isPrefixDomain reports whether domain is the label of a prefix right before a public suffix,
+// the prefix tools skip the zones that are not public suffixes and the domains deeper in them
+func isPrefixDomain(domain string) bool {
+	return psl.ICANNRegistrableDomain(domain) == domain
+}
+
+func streamPrefixes(prefixes *model.PrefixList, fn func(domain model.PrefixResult) error) error {
+	for _, d := range prefixes.Domains {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
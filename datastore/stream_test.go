@@ -0,0 +1,43 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// TestRootZoneFile checks that the root zone file delegates the TLDs with the glue of their nameservers
+func TestRootZoneFile(t *testing.T) {
+	ds, err := NewMemory("../fixtures/sample.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(map[string]bool)
+	err = ds.StreamZoneFile(context.Background(), "", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), func(rr *model.ZoneRecord) error {
+		if records[rr.String()] {
+			t.Errorf("%s twice", rr)
+		}
+		records[rr.String()] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range []model.ZoneRecord{
+		{Name: "com", Type: "NS", Value: "a.root-servers.net"},
+		{Name: "org", Type: "NS", Value: "b.root-servers.net"},
+		{Name: "a.root-servers.net", Type: "A", Value: "198.41.0.4"},
+		{Name: "a.root-servers.net", Type: "AAAA", Value: "2001:503:ba3e::2:30"},
+	} {
+		if !records[rr.String()] {
+			t.Errorf("missing %s", rr.String())
+		}
+	}
+	for rr := range records {
+		if rr[0] == '.' {
+			t.Errorf("apex record %s, the apex is in the header", rr)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package datastore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// activeOn returns true if the relationship of the row existed on the given date
+func (r *edgeRow) activeOn(date time.Time) bool {
+	if r.firstSeen != nil && r.firstSeen.After(date) {
+		return false
+	}
+	return r.lastSeen == nil || !r.lastSeen.Before(date)
+}
+
+// nameSet returns the names of the rows that were active on date
+// when group is not 0 only the rows of that group are used
+func nameSet(rows []*edgeRow, group int, date time.Time) map[string]bool {
+	out := make(map[string]bool)
+	for _, r := range rows {
+		if (group == 0 || r.group == group) && r.activeOn(date) {
+			out[r.name] = true
+		}
+	}
+	return out
+}
+
+// sortedNames returns the names in a that are not in b in order
+func sortedNames(a, b map[string]bool) []string {
+	out := make([]string, 0, len(a))
+	for name := range a {
+		if !b[name] {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// buildTimeline builds the delegation timeline of domain
+// nameservers are the domain's rows from domains_nameservers, and glue the A and AAAA rows
+// of those nameservers with the IP version as the group and the nameserver ID as the id
+// a removal is an event on the day after the last_seen of the row
+func buildTimeline(domain string, nameservers, glue []*edgeRow) *model.DomainTimeline {
+	changes := make(map[time.Time]bool)
+	for _, rows := range [][]*edgeRow{nameservers, glue} {
+		for _, r := range rows {
+			if r.firstSeen != nil {
+				changes[*r.firstSeen] = true
+			}
+			if r.lastSeen != nil {
+				changes[r.lastSeen.AddDate(0, 0, 1)] = true
+			}
+		}
+	}
+	dates := make([]time.Time, 0, len(changes))
+	for date := range changes {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	glueByNS := make(map[int64][]*edgeRow)
+	for _, r := range glue {
+		glueByNS[r.id] = append(glueByNS[r.id], r)
+	}
+
+	timeline := &model.DomainTimeline{Domain: domain, Events: make([]*model.TimelineEvent, 0, len(dates))}
+	for _, date := range dates {
+		prev := date.AddDate(0, 0, -1)
+		before, after := nameSet(nameservers, 0, prev), nameSet(nameservers, 0, date)
+		event := &model.TimelineEvent{
+			Date:    date,
+			Before:  sortedNames(before, nil),
+			After:   sortedNames(after, nil),
+			Added:   sortedNames(after, before),
+			Removed: sortedNames(before, after),
+		}
+
+		// IP changes of the nameservers delegated to on either day
+		delegated := make([]*edgeRow, 0)
+		seen := make(map[int64]bool)
+		for _, r := range nameservers {
+			if !seen[r.id] && (r.activeOn(prev) || r.activeOn(date)) {
+				seen[r.id] = true
+				delegated = append(delegated, r)
+			}
+		}
+		sort.Slice(delegated, func(i, j int) bool { return delegated[i].name < delegated[j].name })
+		for _, ns := range delegated {
+			for _, version := range []int{4, 6} {
+				ipsBefore := nameSet(glueByNS[ns.id], version, prev)
+				ipsAfter := nameSet(glueByNS[ns.id], version, date)
+				added, removed := sortedNames(ipsAfter, ipsBefore), sortedNames(ipsBefore, ipsAfter)
+				if len(added) == 0 && len(removed) == 0 {
+					continue
+				}
+				event.IPChanges = append(event.IPChanges, &model.IPChange{NameServer: ns.name, Version: version, Added: added, Removed: removed})
+			}
+		}
+
+		if len(event.Added) == 0 && len(event.Removed) == 0 && len(event.IPChanges) == 0 {
+			continue
+		}
+		timeline.Events = append(timeline.Events, event)
+	}
+	return timeline
+}
+
+// GetDomainTimeline gets the delegation change events of the provided domain
+func (ds *PostgresDataStore) GetDomainTimeline(ctx context.Context, domain string) (*model.DomainTimeline, error) {
+	id, _, err := ds.GetDomainID(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	nameservers, err := ds.queryEdgeRows(ctx, "SELECT 0, ns.id, ns.domain, dns.first_seen, dns.last_seen FROM domains_nameservers dns, nameservers ns WHERE dns.nameserver_id = ns.id AND dns.domain_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(nameservers))
+	for _, r := range nameservers {
+		ids = append(ids, r.id)
+	}
+	glue, err := ds.queryEdgeRows(ctx, `SELECT 4, ans.nameserver_id, host(ip.ip), ans.first_seen, ans.last_seen FROM a_nameservers ans, a ip WHERE ans.a_id = ip.id AND ans.nameserver_id = ANY($1)
+		UNION ALL
+		SELECT 6, ans.nameserver_id, host(ip.ip), ans.first_seen, ans.last_seen FROM aaaa_nameservers ans, aaaa ip WHERE ans.aaaa_id = ip.id AND ans.nameserver_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	return buildTimeline(domain, nameservers, glue), nil
+}
+
+// queryEdgeRows runs a query selecting group, id, name, first_seen and last_seen
+func (ds *PostgresDataStore) queryEdgeRows(ctx context.Context, query string, args ...interface{}) ([]*edgeRow, error) {
+	rows, err := ds.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]*edgeRow, 0)
+	for rows.Next() {
+		var r edgeRow
+		err = rows.Scan(&r.group, &r.id, &r.name, &r.firstSeen, &r.lastSeen)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &r)
+	}
+	return out, rows.Err()
+}
+
+// GetDomainTimeline gets the delegation change events of the provided domain
+func (ds *MemoryDataStore) GetDomainTimeline(ctx context.Context, domain string) (*model.DomainTimeline, error) {
+	id, _, err := ds.GetDomainID(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	nameservers := make([]*edgeRow, 0)
+	glue := make([]*edgeRow, 0)
+	for _, e := range ds.domainsNameservers.byParent[id] {
+		nameservers = append(nameservers, ds.nameServerRow(e.child, e))
+	}
+	seen := make(map[int64]bool)
+	for _, r := range nameservers {
+		if seen[r.id] {
+			continue
+		}
+		seen[r.id] = true
+		for _, version := range []int{4, 6} {
+			for _, e := range ds.ipNameservers[version].byParent[r.id] {
+				glue = append(glue, &edgeRow{group: version, id: r.id, name: ds.ipsByID[version][e.child].ip.String(), firstSeen: e.firstSeen, lastSeen: e.lastSeen})
+			}
+		}
+	}
+	return buildTimeline(domain, nameservers, glue), nil
+}
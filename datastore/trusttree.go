@@ -0,0 +1,419 @@
+package datastore
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"dnscoffee/model"
+	"dnscoffee/psl"
+)
+
+// the trust tree of a domain is the graph of what resolving it depends on, as static/tree draws it in the browser
+// a domain depends on its zone and its nameservers, a nameserver on its IPs and on the domain it is named under,
+// which is followed in turn until the depth limit
+// the graph is crawled a level at a time, with a bulk lookup and the batched loaders for each level
+
+const (
+	// TrustTreeDepth is the default depth limit of the trust trees, two levels per delegation followed
+	TrustTreeDepth = 8
+	// MaxTrustTreeDepth is the largest depth limit of the trust trees
+	MaxTrustTreeDepth = 20
+	// maxTrustTreeNodes bounds the size of a tree, the crawl stops after the level reaching it
+	maxTrustTreeNodes = 5000
+)
+
+// TrustTreeOptions are the options of a trust tree
+// the tree is of the delegations on Date, or the current ones if it is nil
+// ResolveZones follows the nameservers of the zones as well as those of the domains, as resolveZones does in the browser
+type TrustTreeOptions struct {
+	Date         *time.Time
+	MaxDepth     int
+	ResolveZones bool
+}
+
+// publicResolvers are the addresses of the public recursive resolvers, which are not authoritative for any domain
+var publicResolvers = addressSet("8.8.8.8", "8.8.4.4", "9.9.9.9", "149.112.112.112", "208.67.222.222",
+	"208.67.220.220", "1.1.1.1", "1.0.0.1", "185.228.168.9", "185.228.169.9", "64.6.64.6", "64.6.65.6",
+	"198.101.242.72", "23.253.163.53", "176.103.130.130", "176.103.130.131", "2001:4860:4860::8888",
+	"2001:4860:4860::8844", "2620:fe::fe", "2620:fe::9", "9.9.9.10", "2620:fe::10", "2620:119:35::35",
+	"2620:119:53::53", "2606:4700:4700::1111", "2606:4700:4700::1001", "2a0d:2a00:1::2", "2a0d:2a00:2::2",
+	"2620:74:1b::1:1", "2620:74:1c::2:2", "2001:4800:780e:510:a8cf:392e:ff04:8982",
+	"2001:4801:7825:103:be76:4eff:fe10:2e49", "2a00:5a60::ad1:ff", "2a00:5a60::ad2:ff", "84.200.69.80",
+	"84.200.70.40", "8.26.56.26", "8.20.247.20", "205.171.3.66", "205.171.202.166", "195.46.39.39",
+	"195.46.39.40", "66.187.76.168", "147.135.76.183", "216.146.35.35", "216.146.36.36",
+	"45.33.97.5", "37.235.1.177", "77.88.8.8", "77.88.8.1", "91.239.100.100", "89.233.43.71",
+	"74.82.42.42", "109.69.8.51", "156.154.70.5", "156.154.71.5", "45.77.165.194", "45.32.36.36")
+
+// privateNetworks are the private, unique local and loopback networks, which are not publicly routable
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "fc00::/7", "::1/128")
+
+// addressSet returns the set of the addresses, as net.IP formats them
+func addressSet(addresses ...string) map[string]bool {
+	set := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		set[net.ParseIP(address).String()] = true
+	}
+	return set
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPrivate returns true if the address is in one of the privateNetworks
+func isPrivate(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustTree builds a TrustTree
+type trustTree struct {
+	ctx          context.Context
+	ds           DataStore
+	opts         ListOptions
+	tree         *model.TrustTree
+	resolveZones bool
+
+	nodes map[string]*model.TrustNode
+	edges map[[2]string]bool
+	// the IDs of the zones looked up, 0 for those that do not exist, to tell the domains that could be registered
+	zones map[string]int64
+}
+
+// node returns the node of kind and name, adding it at depth if it is new
+func (t *trustTree) node(kind, name string, depth int) (*model.TrustNode, bool) {
+	id := kind + ":" + name
+	if n, ok := t.nodes[id]; ok {
+		return n, false
+	}
+	n := &model.TrustNode{ID: id, Kind: kind, Name: name, Depth: depth}
+	if kind == model.TrustNodeZone && name == "" {
+		n.Name = "."
+	}
+	t.nodes[id] = n
+	t.tree.Nodes = append(t.tree.Nodes, n)
+	return n, true
+}
+
+// edge adds the dependency of source on target
+func (t *trustTree) edge(source, target *model.TrustNode) {
+	key := [2]string{source.ID, target.ID}
+	if t.edges[key] {
+		return
+	}
+	t.edges[key] = true
+	t.tree.Edges = append(t.tree.Edges, &model.TrustEdge{Source: source.ID, Target: target.ID})
+}
+
+// zoneID returns the ID of the zone, or 0 if it is not imported
+func (t *trustTree) zoneID(zone string) (int64, error) {
+	if id, ok := t.zones[zone]; ok {
+		return id, nil
+	}
+	id, err := t.ds.GetZoneID(t.ctx, zone)
+	if err != nil && err != ErrNoResource {
+		return 0, err
+	}
+	t.zones[zone] = id
+	return id, nil
+}
+
+// BuildTrustTree returns the trust tree of the domain
+// returns ErrNoResource if the domain was never seen
+func BuildTrustTree(ctx context.Context, ds DataStore, domain string, opts TrustTreeOptions) (*model.TrustTree, error) {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = TrustTreeDepth
+	}
+	if opts.MaxDepth > MaxTrustTreeDepth {
+		opts.MaxDepth = MaxTrustTreeDepth
+	}
+	found, err := ds.GetDomainsByName(ctx, []string{domain})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := found[domain]; !ok {
+		return nil, ErrNoResource
+	}
+
+	t := &trustTree{
+		ctx:          ctx,
+		ds:           ds,
+		opts:         ListOptions{State: StateCurrent, Date: opts.Date, Limit: PageSize},
+		tree:         &model.TrustTree{Domain: domain, AsOf: opts.Date, MaxDepth: opts.MaxDepth, Nodes: make([]*model.TrustNode, 0), Edges: make([]*model.TrustEdge, 0)},
+		resolveZones: opts.ResolveZones,
+		nodes:        make(map[string]*model.TrustNode),
+		edges:        make(map[[2]string]bool),
+		zones:        make(map[string]int64),
+	}
+	t.node(model.TrustNodeDomain, domain, 0)
+	frontier := []string{domain}
+	for depth := 0; len(frontier) > 0; depth += 2 {
+		// a domain is followed when its nameservers and what they depend on are within the limit
+		if depth+2 > opts.MaxDepth || len(t.nodes) >= maxTrustTreeNodes {
+			for _, name := range frontier {
+				t.nodes[model.TrustNodeDomain+":"+name].Truncated = true
+			}
+			t.tree.Truncated = true
+			break
+		}
+		frontier, err = t.expandDomains(frontier, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.markCycles()
+	t.markHazards()
+	return t.tree, nil
+}
+
+// expandDomains adds the zones and nameservers of the domains at depth, and those of the new zones when resolving zones
+// returns the domains of their nameservers to expand next
+func (t *trustTree) expandDomains(names []string, depth int) ([]string, error) {
+	found, err := t.ds.GetDomainsByName(t.ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(found))
+	for _, d := range found {
+		ids = append(ids, d.ID)
+	}
+	nameservers, err := t.ds.GetDomainsNameServers(t.ctx, ids, t.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	added := make([]*model.TrustNode, 0)
+	zones := make([]*model.TrustNode, 0)
+	for _, name := range names {
+		dn := t.nodes[model.TrustNodeDomain+":"+name]
+		zone := name[strings.LastIndex(name, ".")+1:]
+		var list []*model.NameServer
+		if d, ok := found[name]; ok {
+			zone = d.Zone.Name
+			list = nameservers[d.ID]
+		}
+		zn, isNew := t.node(model.TrustNodeZone, zone, depth+1)
+		t.edge(dn, zn)
+		if isNew {
+			zones = append(zones, zn)
+		}
+		if len(list) == 0 {
+			// not delegated on the day, anyone may be able to register it
+			dn.Missing = true
+			zoneID, err := t.zoneID(zone)
+			if err != nil {
+				return nil, err
+			}
+			if zoneID != 0 {
+				dn.Issues = append(dn.Issues, model.NewTrustIssue(model.TrustIssueUnregistered, name))
+			}
+		}
+		for _, ns := range list {
+			nn, isNew := t.node(model.TrustNodeNameServer, ns.Name, depth+1)
+			t.edge(dn, nn)
+			if isNew {
+				added = append(added, nn)
+			}
+		}
+	}
+	if t.resolveZones {
+		zoneNameServers, err := t.expandZones(zones, depth)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, zoneNameServers...)
+	}
+	return t.expandNameServers(added, depth+1)
+}
+
+// expandZones adds the nameservers of the zone nodes, on the level of the zones as they are part of the delegation
+// returns the new nameserver nodes
+func (t *trustTree) expandZones(nodes []*model.TrustNode, depth int) ([]*model.TrustNode, error) {
+	ids := make([]int64, 0, len(nodes))
+	byID := make(map[int64]*model.TrustNode, len(nodes))
+	for _, zn := range nodes {
+		zone := zn.Name
+		if zone == "." {
+			zone = ""
+		}
+		id, err := t.zoneID(zone)
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			continue
+		}
+		ids = append(ids, id)
+		byID[id] = zn
+	}
+	added := make([]*model.TrustNode, 0)
+	if len(ids) == 0 {
+		return added, nil
+	}
+	nameservers, err := t.ds.GetZonesNameServers(t.ctx, ids, t.opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		for _, ns := range nameservers[id] {
+			nn, isNew := t.node(model.TrustNodeNameServer, ns.Name, depth+1)
+			t.edge(byID[id], nn)
+			if isNew {
+				added = append(added, nn)
+			}
+		}
+	}
+	return added, nil
+}
+
+// expandNameServers adds the IPs of the nameservers at depth and the domains they are named under
+// returns the new domains
+func (t *trustTree) expandNameServers(nodes []*model.TrustNode, depth int) ([]string, error) {
+	next := make([]string, 0)
+	names := make([]string, 0, len(nodes))
+	byName := make(map[string]*model.TrustNode, len(nodes))
+	for _, nn := range nodes {
+		switch {
+		case net.ParseIP(nn.Name) != nil:
+			nn.Issues = append(nn.Issues, model.NewTrustIssue(model.TrustIssueNSAddress, nn.Name))
+			continue
+		case strings.HasPrefix(nn.Name, "*"):
+			nn.Issues = append(nn.Issues, model.NewTrustIssue(model.TrustIssueNSWildcard, nn.Name))
+			continue
+		case psl.ICANNRegistrableDomain(nn.Name) == "":
+			nn.Issues = append(nn.Issues, model.NewTrustIssue(model.TrustIssueNSTLD, nn.Name))
+			continue
+		}
+		dn, isNew := t.node(model.TrustNodeDomain, psl.ICANNRegistrableDomain(nn.Name), depth+1)
+		t.edge(nn, dn)
+		if isNew {
+			next = append(next, dn.Name)
+		}
+		names = append(names, nn.Name)
+		byName[nn.Name] = nn
+	}
+	if len(names) == 0 {
+		return next, nil
+	}
+
+	found, err := t.ds.GetNameServersByName(t.ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(found))
+	for _, ns := range found {
+		ids = append(ids, ns.ID)
+	}
+	for _, version := range []int{4, 6} {
+		ips, err := t.ds.GetNameServersIPs(t.ctx, ids, version, t.opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			ns, ok := found[name]
+			if !ok {
+				continue
+			}
+			for _, ip := range ips[ns.ID] {
+				in, isNew := t.node(model.TrustNodeIP, ip.Name, depth+1)
+				t.edge(byName[name], in)
+				if !isNew {
+					continue
+				}
+				if addr := net.ParseIP(ip.Name); addr != nil {
+					if publicResolvers[addr.String()] {
+						in.Issues = append(in.Issues, model.NewTrustIssue(model.TrustIssuePublicResolver, ip.Name))
+					}
+					if isPrivate(addr) {
+						in.Issues = append(in.Issues, model.NewTrustIssue(model.TrustIssuePrivateAddress, ip.Name))
+					}
+				}
+			}
+		}
+	}
+	return next, nil
+}
+
+// markCycles marks the edges back to a node on the path from the domain to their source
+// such as a domain whose nameservers are named under it
+func (t *trustTree) markCycles() {
+	children := make(map[string][]*model.TrustEdge)
+	for _, e := range t.tree.Edges {
+		children[e.Source] = append(children[e.Source], e)
+	}
+	const (
+		onPath = 1
+		done   = 2
+	)
+	state := make(map[string]int, len(t.nodes))
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = onPath
+		for _, e := range children[id] {
+			switch state[e.Target] {
+			case onPath:
+				e.Cycle = true
+				t.tree.Cycles++
+			case 0:
+				visit(e.Target)
+			}
+		}
+		state[id] = done
+	}
+	visit(t.tree.Nodes[0].ID)
+}
+
+// markHazards marks the nodes with a hazard and the nodes depending on them,
+// and counts the issues of the tree
+func (t *trustTree) markHazards() {
+	parents := make(map[string][]string)
+	for _, e := range t.tree.Edges {
+		parents[e.Target] = append(parents[e.Target], e.Source)
+	}
+	codes := make(map[int]bool)
+	queue := make([]string, 0)
+	for _, n := range t.tree.Nodes {
+		for _, issue := range n.Issues {
+			codes[issue.Code] = true
+			if issue.Severity == model.TrustHazard {
+				t.tree.Hazards++
+				if !n.Hazardous {
+					n.Hazardous = true
+					queue = append(queue, n.ID)
+				}
+			} else {
+				t.tree.Warnings++
+			}
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, parent := range parents[id] {
+			if n := t.nodes[parent]; !n.Hazardous {
+				n.Hazardous = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	t.tree.Codes = make([]model.TrustIssueCode, 0, len(codes))
+	for code := range codes {
+		t.tree.Codes = append(t.tree.Codes, model.TrustIssueCodes[code])
+	}
+	sort.Slice(t.tree.Codes, func(i, j int) bool { return t.tree.Codes[i].Code < t.tree.Codes[j].Code })
+}
@@ -0,0 +1,26 @@
+package datastore
+
+import "fmt"
+
+// MinSearchLength is the shortest search term accepted by the feed searches
+const MinSearchLength = 4
+
+// ValidationError is returned when a parameter of a request is invalid
+// Parameter is the name of the parameter as used in the request
+type ValidationError struct {
+	Parameter string
+	Detail    string
+}
+
+// Error implements the error interface.
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("invalid parameter %q: %s", err.Parameter, err.Detail)
+}
+
+// checkSearch returns a ValidationError if search is too short to search for
+func checkSearch(search string) error {
+	if len(search) < MinSearchLength {
+		return &ValidationError{Parameter: "search", Detail: fmt.Sprintf("The search term must be at least %d characters long.", MinSearchLength)}
+	}
+	return nil
+}
@@ -3,15 +3,17 @@ module dnscoffee
 require (
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/graph-gophers/graphql-go v1.5.0
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/jackc/pgconn v1.14.0 // indirect
 	github.com/jackc/pgtype v1.3.0
 	github.com/jackc/pgx/v4 v4.6.0
+	github.com/miekg/dns v1.1.50
 	github.com/throttled/throttled/v2 v2.7.1
 	golang.org/x/net v0.7.0
-	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/term v0.5.0 // indirect
 	golang.org/x/text v0.7.0
 )
 
-go 1.13
+go 1.16
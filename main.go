@@ -4,6 +4,7 @@ import (
 	"context"
 	"dnscoffee/app"
 	"dnscoffee/datastore"
+	"dnscoffee/psl"
 	"dnscoffee/server"
 	"dnscoffee/version"
 	"flag"
@@ -12,36 +13,73 @@ import (
 )
 
 var (
-	listenAddr = flag.String("listen", "127.0.0.1:8080", "ip:port to listen on")
+	listenAddr  = flag.String("listen", "127.0.0.1:8080", "ip:port to listen on")
+	fixtureFile = flag.String("fixture", "", "load data from this JSON fixture into memory instead of connecting to $DATABASE_URL")
+	dnsAddr     = flag.String("dns", "", "ip:port to answer DNS queries on over UDP and TCP, none when empty")
+	pslFile     = flag.String("psl", "", "load the Public Suffix List from this file instead of the embedded copy")
+	operators   = flag.String("operators", "", "load nameserver operator overrides from this file, lines of a hostname pattern and an operator")
+	streamLimit = flag.Int("stream-timeout", server.DefaultAPIConfig.StreamTimeout, "seconds a streamed response such as a CSV export can take")
+	zoneLimit   = flag.Int("zonefile-timeout", server.DefaultAPIConfig.ZoneFileTimeout, "seconds a zone file can take")
 )
 
 // main
 func main() {
 	flag.Parse()
 	log.Printf("version: %s", version.String())
+	if *pslFile != "" {
+		err := psl.Load(*pslFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("loaded public suffix list %s, %d rules", *pslFile, psl.Default.Len())
+	}
+	if *operators != "" {
+		n, err := datastore.LoadOperatorOverrides(*operators)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("loaded operator overrides %s, %d overrides", *operators, n)
+	}
 	// get datstore
-	// if no DB wait for valid connection
-	var ds *datastore.DataStore
+	var ds datastore.DataStore
 	var err error
-	ctx := context.Background()
-	for {
-		ds, err = datastore.New(ctx)
+	if *fixtureFile != "" {
+		ds, err = datastore.NewMemory(*fixtureFile)
 		if err != nil {
-			log.Println(err)
-			log.Println("waiting for 30s")
-			time.Sleep(30 * time.Second)
-		} else {
-			break
+			log.Fatal(err)
+		}
+		log.Printf("loaded fixture %s", *fixtureFile)
+	} else {
+		// if no DB wait for valid connection
+		ctx := context.Background()
+		for {
+			ds, err = datastore.New(ctx)
+			if err != nil {
+				log.Println(err)
+				log.Println("waiting for 30s")
+				time.Sleep(30 * time.Second)
+			} else {
+				break
+			}
 		}
 	}
 	defer ds.Close()
 
 	// get server and start application
-	coffeeServer, err := server.New(*listenAddr, server.DefaultAPIConfig)
+	apiConfig := server.DefaultAPIConfig
+	apiConfig.StreamTimeout = *streamLimit
+	apiConfig.ZoneFileTimeout = *zoneLimit
+	coffeeServer, err := server.New(*listenAddr, apiConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 	app.Start(ds, coffeeServer)
+	if *dnsAddr != "" {
+		go func() {
+			log.Fatal(app.StartDNS(ds, *dnsAddr))
+		}()
+		log.Printf("DNS starting on %s", *dnsAddr)
+	}
 	log.Printf("Server starting on %s", *listenAddr)
 	log.Fatal(coffeeServer.Start())
 }
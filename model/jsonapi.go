@@ -0,0 +1,614 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the types of the v2 API are JSON:API documents built from the v1 types
+// unlike v1 the resources keep their attributes apart from their relationships,
+// dates are ISO 8601 days and durations ISO 8601 durations
+
+// V2Prefix is the path of the v2 API, its links are absolute paths
+const V2Prefix = "/api/v2"
+
+// JSONAPIVersion is the version of JSON:API the v2 documents follow
+const JSONAPIVersion = "1.0"
+
+// Document is a JSON:API top level document
+// Data is a *Resource or a []*Resource
+type Document struct {
+	Data     interface{}            `json:"data"`
+	Included []*Resource            `json:"included,omitempty"`
+	Links    *Links                 `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	JSONAPI  JSONAPIObject          `json:"jsonapi"`
+}
+
+// JSONAPIObject describes the JSON:API implementation of a Document
+type JSONAPIObject struct {
+	Version string `json:"version"`
+}
+
+// NewDocument returns a Document of data
+func NewDocument(data interface{}) *Document {
+	return &Document{Data: data, JSONAPI: JSONAPIObject{Version: JSONAPIVersion}}
+}
+
+// Links are the links of a document, resource or relationship
+type Links struct {
+	Self    string `json:"self,omitempty"`
+	Related string `json:"related,omitempty"`
+	First   string `json:"first,omitempty"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+}
+
+// Resource is a JSON:API resource object
+// Attributes is one of the *Attributes types below
+type Resource struct {
+	Type          string                   `json:"type"`
+	ID            string                   `json:"id"`
+	Attributes    interface{}              `json:"attributes,omitempty"`
+	Relationships map[string]*Relationship `json:"relationships,omitempty"`
+	Links         *Links                   `json:"links,omitempty"`
+	Meta          map[string]interface{}   `json:"meta,omitempty"`
+}
+
+// Identifier returns the resource identifier of r
+func (r *Resource) Identifier() *ResourceIdentifier {
+	return &ResourceIdentifier{Type: r.Type, ID: r.ID}
+}
+
+// Relationship is a JSON:API relationship object
+// Data is a *ResourceIdentifier or []*ResourceIdentifier, and is left out when the relationship is not loaded
+type Relationship struct {
+	Data  interface{}       `json:"data,omitempty"`
+	Links *Links            `json:"links,omitempty"`
+	Meta  *RelationshipMeta `json:"meta,omitempty"`
+}
+
+// RelationshipMeta holds the size of a to-many relationship, of which the data may only be the first page
+// Truncated is set when the data of an included relationship stops at the include limit
+type RelationshipMeta struct {
+	Total     *int64 `json:"total,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// ResourceIdentifier identifies a related resource
+// Meta holds the first and last day of the relationship, which differ from those of the resource
+type ResourceIdentifier struct {
+	Type string    `json:"type"`
+	ID   string    `json:"id"`
+	Meta *SeenMeta `json:"meta,omitempty"`
+}
+
+// SeenMeta holds the first and last day a relationship was seen
+type SeenMeta struct {
+	FirstSeen *Date `json:"first_seen,omitempty"`
+	LastSeen  *Date `json:"last_seen,omitempty"`
+}
+
+// Date is a day, marshalled as an ISO 8601 date
+type Date time.Time
+
+// NewDate returns the Date of t, or nil if t is nil
+func NewDate(t *time.Time) *Date {
+	if t == nil {
+		return nil
+	}
+	d := Date(*t)
+	return &d
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Time(d).Format("2006-01-02") + `"`), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return err
+	}
+	*d = Date(t)
+	return nil
+}
+
+// Duration is marshalled as an ISO 8601 duration such as P1DT2H30M
+// days are always 24 hours, and the seconds have up to millisecond precision
+type Duration time.Duration
+
+// String formats d as an ISO 8601 duration
+func (d Duration) String() string {
+	var buf bytes.Buffer
+	v := time.Duration(d)
+	if v < 0 {
+		buf.WriteByte('-')
+		v = -v
+	}
+	buf.WriteByte('P')
+	if days := v / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&buf, "%dD", days)
+		v -= days * 24 * time.Hour
+	}
+	if v == 0 && buf.Len() > 1 && buf.Bytes()[buf.Len()-1] == 'D' {
+		return buf.String()
+	}
+	buf.WriteByte('T')
+	if hours := v / time.Hour; hours > 0 {
+		fmt.Fprintf(&buf, "%dH", hours)
+		v -= hours * time.Hour
+	}
+	if minutes := v / time.Minute; minutes > 0 {
+		fmt.Fprintf(&buf, "%dM", minutes)
+		v -= minutes * time.Minute
+	}
+	if v > 0 || buf.Bytes()[buf.Len()-1] == 'T' {
+		seconds := strconv.FormatFloat(v.Round(time.Millisecond).Seconds(), 'f', -1, 64)
+		fmt.Fprintf(&buf, "%sS", seconds)
+	}
+	return buf.String()
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// v2Link returns the v2 API link of the path, with the as of date of a point in time view
+func v2Link(path string, asOf *time.Time) string {
+	return asOfLink(V2Prefix+path, asOf)
+}
+
+// zoneID is the ID of a zone resource, "." for the root
+func zoneID(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// zonePath is the path of a zone resource, /root for the root as "." does not survive path cleaning
+func zonePath(name string) string {
+	if name == "" {
+		return "/root"
+	}
+	return "/zones/" + name
+}
+
+// zoneRelationship returns the to-one relationship to the zone
+func zoneRelationship(name string) *Relationship {
+	return &Relationship{
+		Data:  &ResourceIdentifier{Type: zoneType, ID: zoneID(name)},
+		Links: &Links{Related: V2Prefix + zonePath(name)},
+	}
+}
+
+// DomainAttributes are the attributes of a domain resource
+type DomainAttributes struct {
+	Name      string `json:"name"`
+	FirstSeen *Date  `json:"first_seen"`
+	LastSeen  *Date  `json:"last_seen"`
+}
+
+// ZoneAttributes are the attributes of a zone resource
+// the import dates and counts are set when the zone's import data is loaded
+type ZoneAttributes struct {
+	Name            string `json:"name"`
+	FirstSeen       *Date  `json:"first_seen"`
+	LastSeen        *Date  `json:"last_seen"`
+	FirstImportDate *Date  `json:"first_import_date,omitempty"`
+	LastImportDate  *Date  `json:"last_import_date,omitempty"`
+	Records         *int64 `json:"records,omitempty"`
+	Domains         *int64 `json:"domains,omitempty"`
+}
+
+// NameServerAttributes are the attributes of a nameserver resource
+type NameServerAttributes struct {
+	Name      string `json:"name"`
+	FirstSeen *Date  `json:"first_seen"`
+	LastSeen  *Date  `json:"last_seen"`
+}
+
+// IPAttributes are the attributes of an IP resource
+type IPAttributes struct {
+	Address   string `json:"address"`
+	Version   int    `json:"version"`
+	FirstSeen *Date  `json:"first_seen"`
+	LastSeen  *Date  `json:"last_seen"`
+}
+
+// toMany returns the relationship of a to-many relationship at the related link
+// the data is only set when loaded, with the dates of each relationship
+// truncated lists the relationships whose data stops at the include limit
+func toMany(loaded bool, identifiers []*ResourceIdentifier, total *int64, truncated []string, name, related string) *Relationship {
+	rel := &Relationship{Links: &Links{Related: related}}
+	if loaded {
+		rel.Data = identifiers
+	}
+	meta := &RelationshipMeta{Total: total}
+	for _, t := range truncated {
+		if t == name {
+			meta.Truncated = true
+		}
+	}
+	if meta.Total != nil || meta.Truncated {
+		rel.Meta = meta
+	}
+	return rel
+}
+
+// listingLink returns the v2 link of a relationship listing in state, as of asOf
+func listingLink(path, state string, asOf *time.Time) string {
+	values := url.Values{}
+	if state != "" {
+		values.Set("filter[state]", state)
+	}
+	if asOf != nil {
+		values.Set("date", asOf.Format("2006-01-02"))
+	}
+	if len(values) == 0 {
+		return V2Prefix + path
+	}
+	return V2Prefix + path + "?" + values.Encode()
+}
+
+func seenMeta(first, last *time.Time) *SeenMeta {
+	if first == nil && last == nil {
+		return nil
+	}
+	return &SeenMeta{FirstSeen: NewDate(first), LastSeen: NewDate(last)}
+}
+
+func nameServerIdentifiers(list []*NameServer) []*ResourceIdentifier {
+	out := make([]*ResourceIdentifier, 0, len(list))
+	for _, ns := range list {
+		out = append(out, &ResourceIdentifier{Type: nameServerType, ID: ns.Name, Meta: seenMeta(ns.FirstSeen, ns.LastSeen)})
+	}
+	return out
+}
+
+func domainIdentifiers(list []*Domain) []*ResourceIdentifier {
+	out := make([]*ResourceIdentifier, 0, len(list))
+	for _, d := range list {
+		out = append(out, &ResourceIdentifier{Type: domainType, ID: d.Name, Meta: seenMeta(d.FirstSeen, d.LastSeen)})
+	}
+	return out
+}
+
+func ipIdentifiers(list []*IP) []*ResourceIdentifier {
+	out := make([]*ResourceIdentifier, 0, len(list))
+	for _, ip := range list {
+		out = append(out, &ResourceIdentifier{Type: ipType, ID: ip.Name, Meta: seenMeta(ip.FirstSeen, ip.LastSeen)})
+	}
+	return out
+}
+
+// IP4s returns the IPs of the IPv4 list
+func IP4s(list []*IP4) []*IP {
+	out := make([]*IP, 0, len(list))
+	for _, ip := range list {
+		out = append(out, &ip.IP)
+	}
+	return out
+}
+
+// IP6s returns the IPs of the IPv6 list
+func IP6s(list []*IP6) []*IP {
+	out := make([]*IP, 0, len(list))
+	for _, ip := range list {
+		out = append(out, &ip.IP)
+	}
+	return out
+}
+
+// Resource returns the v2 resource of the domain
+// the first and last seen dates of a relationship are those of the edge, not of the related resource
+func (d *Domain) Resource() *Resource {
+	path := "/domains/" + d.Name
+	r := &Resource{
+		Type:       domainType,
+		ID:         d.Name,
+		Attributes: &DomainAttributes{Name: d.Name, FirstSeen: NewDate(d.FirstSeen), LastSeen: NewDate(d.LastSeen)},
+		Links:      &Links{Self: v2Link(path, d.AsOf)},
+		Relationships: map[string]*Relationship{
+			"nameservers":         toMany(d.NameServers != nil, nameServerIdentifiers(d.NameServers), d.NameServerCount, d.Truncated, "nameservers", listingLink(path+"/nameservers", "current", d.AsOf)),
+			"archive_nameservers": toMany(d.ArchiveNameServers != nil, nameServerIdentifiers(d.ArchiveNameServers), d.ArchiveNameServerCount, d.Truncated, "archive_nameservers", listingLink(path+"/nameservers", "archive", d.AsOf)),
+		},
+	}
+	if d.Zone != nil {
+		r.Relationships["zone"] = zoneRelationship(d.Zone.Name)
+	}
+	return r
+}
+
+// Resource returns the v2 resource of the zone
+func (z *Zone) Resource() *Resource {
+	path := zonePath(z.Name)
+	attributes := &ZoneAttributes{Name: z.Name, FirstSeen: NewDate(z.FirstSeen), LastSeen: NewDate(z.LastSeen)}
+	if z.ImportData != nil {
+		attributes.FirstImportDate = NewDate(z.ImportData.FirstImportDate)
+		attributes.LastImportDate = NewDate(z.ImportData.LastImportDate)
+		attributes.Records = &z.ImportData.Records
+		attributes.Domains = &z.ImportData.Domains
+	}
+	return &Resource{
+		Type:       zoneType,
+		ID:         zoneID(z.Name),
+		Attributes: attributes,
+		Links:      &Links{Self: v2Link(path, z.AsOf)},
+		Relationships: map[string]*Relationship{
+			"nameservers":         toMany(z.NameServers != nil, nameServerIdentifiers(z.NameServers), z.NameServerCount, z.Truncated, "nameservers", listingLink(path+"/nameservers", "current", z.AsOf)),
+			"archive_nameservers": toMany(z.ArchiveNameServers != nil, nameServerIdentifiers(z.ArchiveNameServers), z.ArchiveNameServerCount, z.Truncated, "archive_nameservers", listingLink(path+"/nameservers", "archive", z.AsOf)),
+		},
+	}
+}
+
+// Resource returns the v2 resource of the nameserver
+func (ns *NameServer) Resource() *Resource {
+	path := "/nameservers/" + ns.Name
+	r := &Resource{
+		Type:       nameServerType,
+		ID:         ns.Name,
+		Attributes: &NameServerAttributes{Name: ns.Name, FirstSeen: NewDate(ns.FirstSeen), LastSeen: NewDate(ns.LastSeen)},
+		Links:      &Links{Self: v2Link(path, ns.AsOf)},
+		Relationships: map[string]*Relationship{
+			"domains":         toMany(ns.Domains != nil, domainIdentifiers(ns.Domains), ns.DomainCount, ns.Truncated, "domains", listingLink(path+"/domains", "current", ns.AsOf)),
+			"archive_domains": toMany(ns.ArchiveDomains != nil, domainIdentifiers(ns.ArchiveDomains), ns.ArchiveDomainCount, ns.Truncated, "archive_domains", listingLink(path+"/domains", "archive", ns.AsOf)),
+			"ipv4":            toMany(ns.IP4 != nil, ipIdentifiers(IP4s(ns.IP4)), ns.IP4Count, ns.Truncated, "ipv4", listingLink(path+"/ipv4", "current", ns.AsOf)),
+			"archive_ipv4":    toMany(ns.ArchiveIP4 != nil, ipIdentifiers(IP4s(ns.ArchiveIP4)), ns.ArchiveIP4Count, ns.Truncated, "archive_ipv4", listingLink(path+"/ipv4", "archive", ns.AsOf)),
+			"ipv6":            toMany(ns.IP6 != nil, ipIdentifiers(IP6s(ns.IP6)), ns.IP6Count, ns.Truncated, "ipv6", listingLink(path+"/ipv6", "current", ns.AsOf)),
+			"archive_ipv6":    toMany(ns.ArchiveIP6 != nil, ipIdentifiers(IP6s(ns.ArchiveIP6)), ns.ArchiveIP6Count, ns.Truncated, "archive_ipv6", listingLink(path+"/ipv6", "archive", ns.AsOf)),
+		},
+	}
+	if ns.Zone != nil {
+		r.Relationships["zone"] = zoneRelationship(ns.Zone.Name)
+	}
+	return r
+}
+
+// Resource returns the v2 resource of the IP
+func (ip *IP) Resource() *Resource {
+	path := "/ip/" + ip.Name
+	return &Resource{
+		Type:       ipType,
+		ID:         ip.Name,
+		Attributes: &IPAttributes{Address: ip.Name, Version: ip.Version, FirstSeen: NewDate(ip.FirstSeen), LastSeen: NewDate(ip.LastSeen)},
+		Links:      &Links{Self: v2Link(path, ip.AsOf)},
+		Relationships: map[string]*Relationship{
+			"nameservers":         toMany(ip.NameServers != nil, nameServerIdentifiers(ip.NameServers), ip.NameServerCount, ip.Truncated, "nameservers", listingLink(path+"/nameservers", "current", ip.AsOf)),
+			"archive_nameservers": toMany(ip.ArchiveNameServers != nil, nameServerIdentifiers(ip.ArchiveNameServers), ip.ArchiveNameServerCount, ip.Truncated, "archive_nameservers", listingLink(path+"/nameservers", "archive", ip.AsOf)),
+		},
+	}
+}
+
+// ListingDocument returns the v2 document of a page of a relationship listing at path
+// the links keep the query of the request, with the page cursor replaced
+func ListingDocument(p *Pagination, resources []*Resource, path string, query url.Values) *Document {
+	doc := NewDocument(resources)
+	link := func(cursor string) string {
+		values := url.Values{}
+		for k, v := range query {
+			values[k] = v
+		}
+		values.Del("page[cursor]")
+		if cursor != "" {
+			values.Set("page[cursor]", cursor)
+		}
+		if len(values) == 0 {
+			return V2Prefix + path
+		}
+		return V2Prefix + path + "?" + values.Encode()
+	}
+	doc.Links = &Links{Self: link(p.Page), First: link("")}
+	if p.NextPage != "" {
+		doc.Links.Next = link(p.NextPage)
+	}
+	if p.PrevPage != "" {
+		doc.Links.Prev = link(p.PrevPage)
+	}
+	doc.Meta = map[string]interface{}{"total": p.Total}
+	if p.State != "" {
+		doc.Meta["state"] = p.State
+	}
+	if p.Date != nil {
+		doc.Meta["date"] = NewDate(p.Date)
+	}
+	return doc
+}
+
+// ImportAttributes are the attributes of a zone import resource
+type ImportAttributes struct {
+	Zone      string `json:"zone"`
+	Date      *Date  `json:"date"`
+	Records   uint64 `json:"records"`
+	Domains   int64  `json:"domains"`
+	FeedNew   int64  `json:"feed_new"`
+	FeedOld   int64  `json:"feed_old"`
+	FeedMoved int64  `json:"feed_moved"`
+}
+
+// Resource returns the v2 resource of the zone import, identified by zone and day
+func (zi *ZoneImport) Resource() *Resource {
+	id := fmt.Sprintf("%s/%s", zoneID(zi.Zone), zi.Date.Format("2006-01-02"))
+	return &Resource{
+		Type: "zone_import",
+		ID:   id,
+		Attributes: &ImportAttributes{
+			Zone:      zi.Zone,
+			Date:      NewDate(zi.Date),
+			Records:   zi.Count,
+			Domains:   zi.Domains,
+			FeedNew:   zi.FeedNew,
+			FeedOld:   zi.FeedOld,
+			FeedMoved: zi.FeedMoved,
+		},
+		Relationships: map[string]*Relationship{
+			"zone": zoneRelationship(zi.Zone),
+		},
+	}
+}
+
+// Document returns the v2 document of the zone imports of the day, a resource per zone
+func (ip *ImportProgress) Document() *Document {
+	resources := make([]*Resource, 0, len(ip.Imports))
+	for _, zi := range ip.Imports {
+		resources = append(resources, zi.Resource())
+	}
+	doc := NewDocument(resources)
+	self := fmt.Sprintf("%s/imports/%s", V2Prefix, ip.Date.Format("2006-01-02"))
+	if ip.Zone != nil {
+		self = fmt.Sprintf("%s/%s", self, *ip.Zone)
+		if *ip.Zone == "" {
+			self += "root"
+		}
+	}
+	doc.Links = &Links{Self: self}
+	doc.Meta = map[string]interface{}{"date": Date(ip.Date), "count": ip.Count}
+	return doc
+}
+
+// ZoneHealthAttributes are the attributes of a zone import health resource
+type ZoneHealthAttributes struct {
+	Zone            string              `json:"zone"`
+	FirstImportDate *Date               `json:"first_import_date"`
+	LastImportDate  *Date               `json:"last_import_date"`
+	SinceLastImport Duration            `json:"since_last_import"`
+	Stale           bool                `json:"stale"`
+	MissingDays     []Date              `json:"missing_days"`
+	Anomalies       []*AnomalyAttribute `json:"anomalies"`
+}
+
+// AnomalyAttribute is an ImportAnomaly in the v2 API
+type AnomalyAttribute struct {
+	Date     Date   `json:"date"`
+	Field    string `json:"field"`
+	Value    int64  `json:"value"`
+	Expected int64  `json:"expected"`
+}
+
+// Document returns the v2 document of the health of every zone, a resource per zone
+// the meta of the document has the overall health
+func (ih *ImportHealth) Document() *Document {
+	resources := make([]*Resource, 0, len(ih.Zones))
+	for _, zh := range ih.Zones {
+		missing := make([]Date, 0, len(zh.MissingDays))
+		for _, day := range zh.MissingDays {
+			missing = append(missing, Date(day))
+		}
+		anomalies := make([]*AnomalyAttribute, 0, len(zh.Anomalies))
+		for _, a := range zh.Anomalies {
+			anomalies = append(anomalies, &AnomalyAttribute{Date: Date(a.Date), Field: a.Field, Value: a.Value, Expected: a.Expected})
+		}
+		resources = append(resources, &Resource{
+			Type: "zone_import_health",
+			ID:   zoneID(zh.Zone),
+			Attributes: &ZoneHealthAttributes{
+				Zone:            zh.Zone,
+				FirstImportDate: NewDate(zh.FirstImportDate),
+				LastImportDate:  NewDate(zh.LastImportDate),
+				SinceLastImport: Duration(zh.SinceLastImport),
+				Stale:           zh.Stale,
+				MissingDays:     missing,
+				Anomalies:       anomalies,
+			},
+			Relationships: map[string]*Relationship{
+				"zone": zoneRelationship(zh.Zone),
+			},
+		})
+	}
+	doc := NewDocument(resources)
+	doc.Links = &Links{Self: V2Prefix + "/imports/health"}
+	doc.Meta = map[string]interface{}{
+		"last_import_date":  NewDate(ih.LastImportDate),
+		"since_last_import": Duration(ih.SinceLastImport),
+		"stale_after":       Duration(ih.StaleAfter),
+		"window_start":      Date(ih.WindowStart),
+		"stale_count":       ih.StaleCount,
+	}
+	return doc
+}
+
+// CountAttributes are the attributes of a feed count or zone size on a day
+type CountAttributes struct {
+	Date    Date   `json:"date"`
+	Count   *int64 `json:"count,omitempty"`
+	Domains *int64 `json:"domains,omitempty"`
+	Old     *int64 `json:"old,omitempty"`
+	Moved   *int64 `json:"moved,omitempty"`
+	New     *int64 `json:"new,omitempty"`
+}
+
+// Document returns the v2 document of the daily counts of the search, a resource per day
+func (fc *FeedCountList) Document() *Document {
+	resources := make([]*Resource, 0, len(fc.Counts))
+	for i := range fc.Counts {
+		c := &fc.Counts[i]
+		if c.Date == nil {
+			continue
+		}
+		resources = append(resources, &Resource{
+			Type:       "feed_count",
+			ID:         c.Date.Format("2006-01-02"),
+			Attributes: &CountAttributes{Date: Date(*c.Date), Count: &c.Count},
+		})
+	}
+	doc := NewDocument(resources)
+	doc.Links = &Links{Self: fmt.Sprintf("%s/feeds/%s/search/%s", V2Prefix, fc.Type, url.PathEscape(fc.Search))}
+	doc.Meta = map[string]interface{}{"search": fc.Search, "change": fc.Type}
+	return doc
+}
+
+// Document returns the v2 document of the zone's history, a resource per bucket
+func (zc *ZoneCount) Document(query url.Values) *Document {
+	resources := make([]*Resource, 0, len(zc.History))
+	for _, c := range zc.History {
+		c := c
+		resources = append(resources, &Resource{
+			Type:       "zone_count",
+			ID:         fmt.Sprintf("%s/%s", zoneID(zc.Zone), c.Date.Format("2006-01-02")),
+			Attributes: &CountAttributes{Date: Date(c.Date), Domains: &c.Domains, Old: &c.Old, Moved: &c.Moved, New: &c.New},
+		})
+	}
+	doc := NewDocument(resources)
+	self := V2Prefix + "/counts" + zonePath(zc.Zone)
+	if len(query) > 0 {
+		self += "?" + query.Encode()
+	}
+	doc.Links = &Links{Self: self}
+	doc.Meta = map[string]interface{}{"zone": zc.Zone}
+	return doc
+}
+
+// Document returns the v2 document of the search, a resource identifier per result
+func (s *Search) Document() *Document {
+	identifiers := make([]*ResourceIdentifier, 0, len(s.Results))
+	for _, result := range s.Results {
+		kind := strings.ToLower(result.Type)
+		id := result.Name
+		if kind == zoneType {
+			id = zoneID(id)
+		}
+		identifiers = append(identifiers, &ResourceIdentifier{Type: kind, ID: id})
+	}
+	doc := NewDocument(identifiers)
+	values := url.Values{}
+	values.Set("query", s.Query)
+	if s.Type != "" {
+		values.Set("type", s.Type)
+	}
+	doc.Links = &Links{Self: V2Prefix + "/search?" + values.Encode()}
+	doc.Meta = map[string]interface{}{"query": s.Query}
+	return doc
+}
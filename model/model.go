@@ -2,9 +2,13 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"time"
+
+	"dnscoffee/psl"
 )
 
 var (
@@ -20,6 +24,17 @@ var (
 	zoneImportResultsType = "zone_import_results"
 	zoneCountsType        = "zone_counts"
 	zoneAllCountsType     = "zone_all_counts"
+	domainNameServersType = "domain_nameservers"
+	zoneNameServersType   = "zone_nameservers"
+	zoneDomainsType       = "zone_domains"
+	ipNameServersType     = "ip_nameservers"
+	nameServerDomainsType = "nameserver_domains"
+	nameServerIPsType     = "nameserver_ips"
+	domainTimelineType    = "domain_timeline"
+	zoneDiffType          = "zone_diff"
+	importHealthType      = "import_health"
+	prefixListType        = "prefix_list"
+	bulkLookupType        = "bulk_lookup"
 )
 
 // APIData interface forces the use of GenerateMetaData on response data
@@ -28,9 +43,12 @@ type APIData interface {
 }
 
 // Metadata defines the object's type and Link to self for API responses
+// paginated responses also link to the next and previous pages
 type Metadata struct {
 	Type *string `json:"type,omitempty"`
 	Link string  `json:"link,omitempty"`
+	Next string  `json:"next,omitempty"`
+	Prev string  `json:"prev,omitempty"`
 }
 
 // JSONResponse JSON-API root data object
@@ -45,10 +63,16 @@ type JSONErrors struct {
 
 // JSONError JSON-API error object
 type JSONError struct {
-	ID     string `json:"-"`
-	Status int    `json:"status"`
-	Title  string `json:"title"`
-	Detail string `json:"detail"`
+	ID     string           `json:"-"`
+	Status int              `json:"status"`
+	Title  string           `json:"title"`
+	Detail string           `json:"detail"`
+	Source *JSONErrorSource `json:"source,omitempty"`
+}
+
+// JSONErrorSource JSON-API error source object, names the parameter that caused the error
+type JSONErrorSource struct {
+	Parameter string `json:"parameter,omitempty"`
 }
 
 // NewJSONError returns a New JSONError
@@ -67,6 +91,14 @@ func (err JSONError) Error() string {
 	return err.Detail
 }
 
+// asOfLink adds the date of a point in time view to link
+func asOfLink(link string, asOf *time.Time) string {
+	if asOf == nil {
+		return link
+	}
+	return link + "?date=" + asOf.Format("2006-01-02")
+}
+
 // Dataset holds information about entire dataset
 type Dataset struct {
 	TopNameServers []*NameServer `json:"topnameservers,omitempty"`
@@ -81,6 +113,98 @@ type ImportDate struct {
 	Count          uint64        `json:"count"`
 }
 
+// ImportProgress holds the zone imports of a single day
+// when Zone is set only the imports of that zone are included
+type ImportProgress struct {
+	Metadata
+	Date    time.Time     `json:"date"`
+	Zone    *string       `json:"zone,omitempty"`
+	Count   int           `json:"count"`
+	Imports []*ZoneImport `json:"imports"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ip *ImportProgress) GenerateMetaData() {
+	ip.Type = &importProgressType
+	y, m, d := ip.Date.Date()
+	ip.Link = fmt.Sprintf("/imports/%04d/%02d/%02d", y, m, d)
+	if ip.Zone != nil {
+		ip.Link = fmt.Sprintf("%s/%s", ip.Link, *ip.Zone)
+	}
+	for _, zi := range ip.Imports {
+		zi.Link = fmt.Sprintf("/zones/%s", zi.Zone)
+	}
+}
+
+// ZoneImport holds the results of a single zone import
+// Count is the number of records imported, the durations of ImportDate are not kept
+type ZoneImport struct {
+	Metadata
+	Date      *time.Time `json:"date"`
+	Count     uint64     `json:"count"`
+	Zone      string     `json:"zone"`
+	Domains   int64      `json:"domains"`
+	FeedNew   int64      `json:"feed_new"`
+	FeedOld   int64      `json:"feed_old"`
+	FeedMoved int64      `json:"feed_moved"`
+}
+
+// Seconds is a duration marshalled as a whole number of seconds, unlike time.Duration which is marshalled as nanoseconds
+type Seconds time.Duration
+
+// Duration returns s as a time.Duration
+func (s Seconds) Duration() time.Duration {
+	return time.Duration(s)
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(time.Duration(s) / time.Second))
+}
+
+// ImportHealth holds the import freshness, gaps and anomalies of every zone
+// LastImportDate is the most recent import of any zone, zones more than StaleAfter behind it are stale
+type ImportHealth struct {
+	Metadata
+	LastImportDate  *time.Time    `json:"last_date"`
+	SinceLastImport Seconds       `json:"since_last_import"`
+	StaleAfter      Seconds       `json:"stale_after"`
+	WindowStart     time.Time     `json:"window_start"`
+	StaleCount      int           `json:"stale_count"`
+	Zones           []*ZoneHealth `json:"zones"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (ih *ImportHealth) GenerateMetaData() {
+	ih.Type = &importHealthType
+	ih.Link = "/imports/health"
+	for _, zh := range ih.Zones {
+		zh.Link = fmt.Sprintf("/zones/%s", zh.Zone)
+	}
+}
+
+// ZoneHealth holds the import health of a single zone
+// MissingDays are the days without an import since the WindowStart of the ImportHealth
+type ZoneHealth struct {
+	Metadata
+	Zone            string           `json:"zone"`
+	FirstImportDate *time.Time       `json:"first_date"`
+	LastImportDate  *time.Time       `json:"last_date"`
+	SinceLastImport Seconds          `json:"since_last_import"`
+	Stale           bool             `json:"stale"`
+	MissingDays     []time.Time      `json:"missing_days"`
+	Anomalies       []*ImportAnomaly `json:"anomalies"`
+}
+
+// ImportAnomaly is an import whose records or domains count is far from the zone's recent history
+// Expected is the median of the previous imports
+type ImportAnomaly struct {
+	Date     time.Time `json:"date"`
+	Field    string    `json:"field"`
+	Value    int64     `json:"value"`
+	Expected int64     `json:"expected"`
+}
+
 // ZoneImportResults results for imports
 type ZoneImportResults struct {
 	Metadata
@@ -108,6 +232,7 @@ type ZoneImportResult struct {
 	Records         int64      `json:"records"`
 	Domains         int64      `json:"domains"`
 	Count           int64      `json:"count"`
+	Stale           bool       `json:"stale,omitempty"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
@@ -151,6 +276,7 @@ func (zc *AllZoneCounts) GenerateMetaData() {
 }
 
 // Zone holds information about a zone
+// Truncated names the included relationships cut at the include limit
 type Zone struct {
 	Metadata
 	ID                     int64             `json:"-"`
@@ -164,6 +290,55 @@ type Zone struct {
 	ImportData             *ZoneImportResult `json:"import_data,omitempty"`
 	Domains                *[]Domain         `json:"domains,omitempty"`
 	RootImport             *RootZone         `json:"root,omitempty"`
+	Truncated              []string          `json:"truncated,omitempty"`
+	AsOf                   *time.Time        `json:"as_of,omitempty"`
+}
+
+// ZoneDiff holds the domains added, removed and moved in a zone between two dates
+// the lists are paginated together, Page is the page of each list starting at 1 and Limit its size
+type ZoneDiff struct {
+	Metadata
+	Zone         string    `json:"zone"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	AddedCount   int64     `json:"added_count"`
+	RemovedCount int64     `json:"removed_count"`
+	MovedCount   int64     `json:"moved_count"`
+	Page         int       `json:"page"`
+	Limit        int       `json:"-"`
+	Added        []*Domain `json:"added"`
+	Removed      []*Domain `json:"removed"`
+	Moved        []*Domain `json:"moved"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (zd *ZoneDiff) GenerateMetaData() {
+	zd.Type = &zoneDiffType
+	link := func(page int) string {
+		values := url.Values{}
+		values.Set("from", zd.From.Format("2006-01-02"))
+		values.Set("to", zd.To.Format("2006-01-02"))
+		if page > 1 {
+			values.Set("page", fmt.Sprintf("%d", page))
+		}
+		values.Set("limit", fmt.Sprintf("%d", zd.Limit))
+		return fmt.Sprintf("/zones/%s/diff?%s", zd.Zone, values.Encode())
+	}
+	zd.Link = link(zd.Page)
+	shown := int64(zd.Page * zd.Limit)
+	if shown < zd.AddedCount || shown < zd.RemovedCount || shown < zd.MovedCount {
+		zd.Next = link(zd.Page + 1)
+	}
+	if zd.Page > 1 {
+		zd.Prev = link(zd.Page - 1)
+	}
+	for _, list := range [][]*Domain{zd.Added, zd.Removed, zd.Moved} {
+		for _, d := range list {
+			if d.Type == nil {
+				d.GenerateMetaData()
+			}
+		}
+	}
 }
 
 // RootZone adds root metadata to the zone types
@@ -175,14 +350,27 @@ type RootZone struct {
 // GenerateMetaData generates metadata recursively of member models
 func (z *Zone) GenerateMetaData() {
 	z.Type = &zoneType
-	z.Link = fmt.Sprintf("/zones/%s", z.Name)
+	z.Link = asOfLink(fmt.Sprintf("/zones/%s", z.Name), z.AsOf)
+	for _, ns := range z.NameServers {
+		if ns.Type == nil {
+			ns.GenerateMetaData()
+		}
+	}
+	for _, ns := range z.ArchiveNameServers {
+		if ns.Type == nil {
+			ns.GenerateMetaData()
+		}
+	}
 }
 
 // Domain domain object
+// Truncated names the included relationships cut at the include limit
 type Domain struct {
 	Metadata
 	ID                     int64         `json:"-"`
 	Name                   string        `json:"name"`
+	RegistrableDomain      string        `json:"registrable_domain,omitempty"`
+	PublicSuffix           string        `json:"public_suffix,omitempty"`
 	FirstSeen              *time.Time    `json:"firstseen,omitempty"`
 	LastSeen               *time.Time    `json:"lastseen,omitempty"`
 	NameServers            []*NameServer `json:"nameservers,omitempty"`
@@ -190,12 +378,18 @@ type Domain struct {
 	NameServerCount        *int64        `json:"nameserver_count,omitempty"`
 	ArchiveNameServerCount *int64        `json:"archive_nameserver_count,omitempty"`
 	Zone                   *Zone         `json:"zone,omitempty"`
+	Truncated              []string      `json:"truncated,omitempty"`
+	AsOf                   *time.Time    `json:"as_of,omitempty"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
 func (d *Domain) GenerateMetaData() {
 	d.Type = &domainType
-	d.Link = fmt.Sprintf("/domains/%s", d.Name)
+	d.Link = asOfLink(fmt.Sprintf("/domains/%s", d.Name), d.AsOf)
+	d.RegistrableDomain, d.PublicSuffix = suffixes(d.Name)
+	if d.Zone != nil && d.Zone.Type == nil {
+		d.Zone.GenerateMetaData()
+	}
 	for _, ns := range d.NameServers {
 		if ns.Type == nil {
 			ns.GenerateMetaData()
@@ -208,6 +402,44 @@ func (d *Domain) GenerateMetaData() {
 	}
 }
 
+// suffixes returns the registrable domain and public suffix of name in the Public Suffix List
+func suffixes(name string) (string, string) {
+	suffix, _ := psl.PublicSuffix(name)
+	return psl.RegistrableDomain(name), suffix
+}
+
+// DomainTimeline is the ordered list of delegation changes of a domain
+type DomainTimeline struct {
+	Metadata
+	Domain string           `json:"domain"`
+	Events []*TimelineEvent `json:"events"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (dt *DomainTimeline) GenerateMetaData() {
+	dt.Type = &domainTimelineType
+	dt.Link = fmt.Sprintf("/domains/%s/timeline", dt.Domain)
+}
+
+// TimelineEvent is a single change to a domain's delegation
+// the change was first seen on Date, so Before is the NS set on the previous day
+type TimelineEvent struct {
+	Date      time.Time   `json:"date"`
+	Before    []string    `json:"nameservers_before"`
+	After     []string    `json:"nameservers_after"`
+	Added     []string    `json:"added"`
+	Removed   []string    `json:"removed"`
+	IPChanges []*IPChange `json:"ip_changes,omitempty"`
+}
+
+// IPChange is a change to the A or AAAA records of one of a domain's nameservers
+type IPChange struct {
+	NameServer string   `json:"nameserver"`
+	Version    int      `json:"version"`
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+}
+
 type Feed struct {
 	Metadata
 	Change  string    `json:"change,omitempty"`
@@ -251,10 +483,13 @@ func (f *NSFeed) GenerateMetaData() {
 }
 
 // NameServer nameserver object
+// Truncated names the included relationships cut at the include limit
 type NameServer struct {
 	Metadata
 	ID                 int64      `json:"-"`
 	Name               string     `json:"name"`
+	RegistrableDomain  string     `json:"registrable_domain,omitempty"`
+	PublicSuffix       string     `json:"public_suffix,omitempty"`
 	FirstSeen          *time.Time `json:"firstseen,omitempty"`
 	LastSeen           *time.Time `json:"lastseen,omitempty"`
 	Domains            []*Domain  `json:"domains,omitempty"`
@@ -270,12 +505,15 @@ type NameServer struct {
 	IP6Count           *int64     `json:"ipv6_count,omitempty"`
 	ArchiveIP6Count    *int64     `json:"archive_ipv6_count,omitempty"`
 	Zone               *Zone      `json:"zone,omitempty"`
+	Truncated          []string   `json:"truncated,omitempty"`
+	AsOf               *time.Time `json:"as_of,omitempty"`
 }
 
 // GenerateMetaData generates metadata recursively of member models
 func (ns *NameServer) GenerateMetaData() {
 	ns.Type = &nameServerType
-	ns.Link = fmt.Sprintf("/nameservers/%s", ns.Name)
+	ns.Link = asOfLink(fmt.Sprintf("/nameservers/%s", ns.Name), ns.AsOf)
+	ns.RegistrableDomain, ns.PublicSuffix = suffixes(ns.Name)
 	for _, d := range ns.Domains {
 		if d.Type == nil {
 			d.GenerateMetaData()
@@ -309,6 +547,7 @@ func (ns *NameServer) GenerateMetaData() {
 }
 
 // IP holds information about an IP address
+// Truncated names the included relationships cut at the include limit
 type IP struct {
 	Metadata
 	ID                     int64         `json:"-"`
@@ -321,6 +560,8 @@ type IP struct {
 	ArchiveNameServers     []*NameServer `json:"archive_nameservers,omitempty"`
 	NameServerCount        *int64        `json:"nameserver_count,omitempty"`
 	ArchiveNameServerCount *int64        `json:"archive_nameserver_count,omitempty"`
+	Truncated              []string      `json:"truncated,omitempty"`
+	AsOf                   *time.Time    `json:"as_of,omitempty"`
 }
 
 // IP4 is an alias to the IP type
@@ -347,7 +588,7 @@ func (ip *IP) IPString() string {
 // GenerateMetaData generates metadata recursively of member models
 func (ip *IP) GenerateMetaData() {
 	ip.Type = &ipType
-	ip.Link = fmt.Sprintf("/ip/%s", ip.Name)
+	ip.Link = asOfLink(fmt.Sprintf("/ip/%s", ip.Name), ip.AsOf)
 	for _, ns := range ip.NameServers {
 		if ns.Type == nil {
 			ns.GenerateMetaData()
@@ -377,18 +618,179 @@ func (ipl *IPList) GenerateMetaData() {
 	}
 }
 
+// Pagination holds the state of a single page of a relationship listing
+// the page tokens are opaque and only used to build the Metadata links
+type Pagination struct {
+	State    string     `json:"state,omitempty"`
+	Date     *time.Time `json:"date,omitempty"`
+	Total    int64      `json:"total"`
+	Limit    int        `json:"-"`
+	Page     string     `json:"-"`
+	NextPage string     `json:"-"`
+	PrevPage string     `json:"-"`
+}
+
+// generateLinks sets the self, next and prev links of m for the listing at base
+func (p *Pagination) generateLinks(m *Metadata, base string) {
+	if p.State != "" {
+		base = base + "/" + p.State
+	}
+	values := url.Values{}
+	if p.Date != nil {
+		values.Set("date", p.Date.Format("2006-01-02"))
+	}
+	query := ""
+	if len(values) > 0 {
+		query = "?" + values.Encode()
+	}
+	m.Link = base + query
+	if p.Page != "" {
+		m.Link = base + "/page/" + p.Page + query
+	}
+	if p.Limit != 0 {
+		values.Set("limit", fmt.Sprintf("%d", p.Limit))
+		query = "?" + values.Encode()
+	}
+	if p.NextPage != "" {
+		m.Next = base + "/page/" + p.NextPage + query
+	}
+	if p.PrevPage != "" {
+		m.Prev = base + "/page/" + p.PrevPage + query
+	}
+}
+
+// DomainNameServers is a page of the nameservers of a domain
+type DomainNameServers struct {
+	Metadata
+	Pagination
+	Domain      string        `json:"domain"`
+	NameServers []*NameServer `json:"nameservers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *DomainNameServers) GenerateMetaData() {
+	l.Type = &domainNameServersType
+	l.generateLinks(&l.Metadata, fmt.Sprintf("/domains/%s/nameservers", l.Domain))
+	for _, ns := range l.NameServers {
+		if ns.Type == nil {
+			ns.GenerateMetaData()
+		}
+	}
+}
+
+// ZoneNameServers is a page of the nameservers of a zone
+type ZoneNameServers struct {
+	Metadata
+	Pagination
+	Zone        string        `json:"zone"`
+	NameServers []*NameServer `json:"nameservers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *ZoneNameServers) GenerateMetaData() {
+	l.Type = &zoneNameServersType
+	l.generateLinks(&l.Metadata, fmt.Sprintf("/zones/%s/nameservers", l.Zone))
+	for _, ns := range l.NameServers {
+		if ns.Type == nil {
+			ns.GenerateMetaData()
+		}
+	}
+}
+
+// ZoneDomains is a page of the domains delegated in a zone
+type ZoneDomains struct {
+	Metadata
+	Pagination
+	Zone    string    `json:"zone"`
+	Domains []*Domain `json:"domains"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *ZoneDomains) GenerateMetaData() {
+	l.Type = &zoneDomainsType
+	l.generateLinks(&l.Metadata, fmt.Sprintf("/zones/%s/domains", l.Zone))
+	for _, d := range l.Domains {
+		if d.Type == nil {
+			d.GenerateMetaData()
+		}
+	}
+}
+
+// IPNameServers is a page of the nameservers using an IP
+type IPNameServers struct {
+	Metadata
+	Pagination
+	IP          string        `json:"ip"`
+	NameServers []*NameServer `json:"nameservers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *IPNameServers) GenerateMetaData() {
+	l.Type = &ipNameServersType
+	l.generateLinks(&l.Metadata, fmt.Sprintf("/ip/%s/nameservers", l.IP))
+	for _, ns := range l.NameServers {
+		if ns.Type == nil {
+			ns.GenerateMetaData()
+		}
+	}
+}
+
+// NameServerDomains is a page of the domains using a nameserver
+type NameServerDomains struct {
+	Metadata
+	Pagination
+	NameServer string    `json:"nameserver"`
+	Domains    []*Domain `json:"domains"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *NameServerDomains) GenerateMetaData() {
+	l.Type = &nameServerDomainsType
+	l.generateLinks(&l.Metadata, fmt.Sprintf("/nameservers/%s/domains", l.NameServer))
+	for _, d := range l.Domains {
+		if d.Type == nil {
+			d.GenerateMetaData()
+		}
+	}
+}
+
+// NameServerIPs is a page of the IPs of a nameserver
+// Version is 4 or 6, or 0 when both are listed
+type NameServerIPs struct {
+	Metadata
+	Pagination
+	NameServer string `json:"nameserver"`
+	Version    int    `json:"version,omitempty"`
+	IPs        []*IP  `json:"ips"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (l *NameServerIPs) GenerateMetaData() {
+	l.Type = &nameServerIPsType
+	base := fmt.Sprintf("/nameservers/%s/ip", l.NameServer)
+	if l.Version != 0 {
+		base = fmt.Sprintf("%s/%d", base, l.Version)
+	}
+	l.generateLinks(&l.Metadata, base)
+	for _, ip := range l.IPs {
+		if ip.Type == nil {
+			ip.GenerateMetaData()
+		}
+	}
+}
+
 // Search has the metadata and results for a search operation
 type Search struct {
-	Query   string
-	Type    string
-	Results []SearchResult
+	Query   string         `json:"query"`
+	Type    string         `json:"type"`
+	Results []SearchResult `json:"results"`
 }
 
 // SearchResult has the name and type of search results
 type SearchResult struct {
-	Name string
-	Link string
-	Type string
+	Name string `json:"name"`
+	Link string `json:"link"`
+	Type string `json:"type"`
 }
 
 // PrefixResult stores the result of an individual prefix search result
@@ -406,6 +808,16 @@ type PrefixList struct {
 	Domains []PrefixResult `json:"domains"`
 }
 
+// GenerateMetaData generates metadata recursively of member models
+func (pl *PrefixList) GenerateMetaData() {
+	pl.Type = &prefixListType
+	if pl.Active {
+		pl.Link = fmt.Sprintf("/prefixes/active/%s", pl.Prefix)
+	} else {
+		pl.Link = fmt.Sprintf("/prefixes/available/%s", pl.Prefix)
+	}
+}
+
 // TLDLife holds TLD age information for the TLD graveyard page
 type TLDLife struct {
 	Metadata
@@ -416,6 +828,8 @@ type TLDLife struct {
 	Age     *string    `json:"age"`
 }
 
+// FeedCountList holds the daily counts of the domains of a feed matching a search
+// Type is the change of the feed, new, old or moved
 type FeedCountList struct {
 	Search string      `json:"search"`
 	Type   string      `json:"type"`
@@ -431,3 +845,31 @@ type FeedCount struct {
 	Date  *time.Time `json:"date"`
 	Count int64      `json:"count"`
 }
+
+// BulkLookup holds the lookups of many domains, nameservers or IPs at once
+// Kind is the resource looked up, domains, nameservers or ip
+type BulkLookup struct {
+	Metadata
+	Kind    string        `json:"kind"`
+	Results []*BulkResult `json:"results"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (bl *BulkLookup) GenerateMetaData() {
+	bl.Type = &bulkLookupType
+	bl.Link = fmt.Sprintf("/bulk/%s", bl.Kind)
+}
+
+// BulkResult is the lookup of a single name of a BulkLookup
+// Error is set instead of Found when the name is not valid
+// the current NameServers are set for domains and IPs, the current IPs for nameservers
+type BulkResult struct {
+	Name        string     `json:"name"`
+	Found       bool       `json:"found"`
+	Error       string     `json:"error,omitempty"`
+	FirstSeen   *time.Time `json:"firstseen,omitempty"`
+	LastSeen    *time.Time `json:"lastseen,omitempty"`
+	Zone        *string    `json:"zone,omitempty"`
+	NameServers []string   `json:"nameservers,omitempty"`
+	IPs         []string   `json:"ips,omitempty"`
+}
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"time"
+)
+
+// RDAPPrefix is the path of the RDAP service
+const RDAPPrefix = "/rdap"
+
+// RDAPConformance is the rdapConformance of every RDAP response
+var RDAPConformance = []string{"rdap_level_0"}
+
+// RDAP object class names
+const (
+	rdapDomainClass     = "domain"
+	rdapNameServerClass = "nameserver"
+	rdapIPNetworkClass  = "ip network"
+)
+
+// RDAP statuses, an object is active while it is in the zone files
+const (
+	RDAPStatusActive   = "active"
+	RDAPStatusInactive = "inactive"
+)
+
+// RDAP event actions for the dates an object was first and last seen in the zone files
+const (
+	RDAPEventFirstSeen = "registration"
+	RDAPEventLastSeen  = "deletion"
+)
+
+// RDAPLink is a link of an RDAP object, RFC 9083 section 4.2
+type RDAPLink struct {
+	Value string `json:"value"`
+	Rel   string `json:"rel"`
+	Href  string `json:"href"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// RDAPNotice is a notice or remark, RFC 9083 section 4.3
+type RDAPNotice struct {
+	Title       string     `json:"title,omitempty"`
+	Description []string   `json:"description"`
+	Links       []RDAPLink `json:"links,omitempty"`
+}
+
+// RDAPEvent is an event of an RDAP object, RFC 9083 section 4.5
+type RDAPEvent struct {
+	EventAction string    `json:"eventAction"`
+	EventDate   time.Time `json:"eventDate"`
+}
+
+// RDAPIPAddresses are the glue addresses of a nameserver
+type RDAPIPAddresses struct {
+	V4 []string `json:"v4,omitempty"`
+	V6 []string `json:"v6,omitempty"`
+}
+
+// RDAPObject holds the members common to the RDAP objects
+type RDAPObject struct {
+	RDAPConformance []string     `json:"rdapConformance,omitempty"`
+	ObjectClassName string       `json:"objectClassName"`
+	Handle          string       `json:"handle,omitempty"`
+	Status          []string     `json:"status,omitempty"`
+	Events          []RDAPEvent  `json:"events,omitempty"`
+	Links           []RDAPLink   `json:"links,omitempty"`
+	Notices         []RDAPNotice `json:"notices,omitempty"`
+	Remarks         []RDAPNotice `json:"remarks,omitempty"`
+}
+
+// RDAPNameServer is a nameserver object, RFC 9083 section 5.2
+type RDAPNameServer struct {
+	RDAPObject
+	LDHName     string           `json:"ldhName"`
+	UnicodeName string           `json:"unicodeName,omitempty"`
+	IPAddresses *RDAPIPAddresses `json:"ipAddresses,omitempty"`
+}
+
+// RDAPDomain is a domain object, RFC 9083 section 5.3
+type RDAPDomain struct {
+	RDAPObject
+	LDHName     string            `json:"ldhName"`
+	UnicodeName string            `json:"unicodeName,omitempty"`
+	NameServers []*RDAPNameServer `json:"nameservers,omitempty"`
+}
+
+// RDAPIPNetwork is an IP network object, RFC 9083 section 5.4
+// the network of an address is the address alone
+type RDAPIPNetwork struct {
+	RDAPObject
+	StartAddress string `json:"startAddress"`
+	EndAddress   string `json:"endAddress"`
+	IPVersion    string `json:"ipVersion"`
+}
+
+// RDAPHelp is the response of the help path, RFC 9083 section 7
+type RDAPHelp struct {
+	RDAPConformance []string     `json:"rdapConformance"`
+	Notices         []RDAPNotice `json:"notices"`
+}
+
+// RDAPError is an error response, RFC 9083 section 6
+type RDAPError struct {
+	RDAPConformance []string `json:"rdapConformance"`
+	ErrorCode       int      `json:"errorCode"`
+	Title           string   `json:"title"`
+	Description     []string `json:"description"`
+}
+
+// NewRDAPError returns the RDAP error of the JSON error
+func NewRDAPError(jsonErr *JSONError) *RDAPError {
+	return &RDAPError{
+		RDAPConformance: RDAPConformance,
+		ErrorCode:       jsonErr.Status,
+		Title:           jsonErr.Title,
+		Description:     []string{jsonErr.Detail},
+	}
+}
+
+// rdapEvents returns the events of the first and last seen dates
+// as of a day, the events that had not happened yet are left out:
+// the registration after it, and the deletion of an object still seen on it
+func rdapEvents(firstSeen, lastSeen, asOf *time.Time) []RDAPEvent {
+	events := make([]RDAPEvent, 0, 2)
+	if firstSeen != nil && (asOf == nil || !firstSeen.After(*asOf)) {
+		events = append(events, RDAPEvent{EventAction: RDAPEventFirstSeen, EventDate: firstSeen.UTC()})
+	}
+	if lastSeen != nil && (asOf == nil || lastSeen.Before(*asOf)) {
+		events = append(events, RDAPEvent{EventAction: RDAPEventLastSeen, EventDate: lastSeen.UTC()})
+	}
+	return events
+}
+
+// rdapStatus is active while the object is in the zone files, or as of a day while it was seen on it
+func rdapStatus(lastSeen, asOf *time.Time) []string {
+	if lastSeen == nil || (asOf != nil && !lastSeen.Before(*asOf)) {
+		return []string{RDAPStatusActive}
+	}
+	return []string{RDAPStatusInactive}
+}
+
+// RDAPSelfLink returns the self link of the RDAP object at path, such as /domain/example.com, of the service at base
+func RDAPSelfLink(base, path string) RDAPLink {
+	href := base + RDAPPrefix + path
+	return RDAPLink{Value: href, Rel: "self", Href: href, Type: "application/rdap+json"}
+}
+
+// RDAP returns the RDAP nameserver object of ns, with the addresses of its IP4 and IP6
+// base is the URL of the service, and unicode the U-label form of the name or "" if it is the same
+func (ns *NameServer) RDAP(base, unicode string) *RDAPNameServer {
+	obj := &RDAPNameServer{
+		RDAPObject: RDAPObject{
+			ObjectClassName: rdapNameServerClass,
+			Handle:          ns.Name,
+			Status:          rdapStatus(ns.LastSeen, ns.AsOf),
+			Events:          rdapEvents(ns.FirstSeen, ns.LastSeen, ns.AsOf),
+			Links:           []RDAPLink{RDAPSelfLink(base, "/nameserver/"+ns.Name)},
+		},
+		LDHName:     ns.Name,
+		UnicodeName: unicode,
+	}
+	if len(ns.IP4) > 0 || len(ns.IP6) > 0 {
+		obj.IPAddresses = &RDAPIPAddresses{}
+		for _, ip := range ns.IP4 {
+			obj.IPAddresses.V4 = append(obj.IPAddresses.V4, ip.Name)
+		}
+		for _, ip := range ns.IP6 {
+			obj.IPAddresses.V6 = append(obj.IPAddresses.V6, ip.Name)
+		}
+	}
+	return obj
+}
+
+// RDAP returns the RDAP domain object of d, the nameservers are the RDAP objects of its current nameservers
+func (d *Domain) RDAP(base, unicode string, nameservers []*RDAPNameServer) *RDAPDomain {
+	return &RDAPDomain{
+		RDAPObject: RDAPObject{
+			ObjectClassName: rdapDomainClass,
+			Handle:          d.Name,
+			Status:          rdapStatus(d.LastSeen, d.AsOf),
+			Events:          rdapEvents(d.FirstSeen, d.LastSeen, d.AsOf),
+			Links:           []RDAPLink{RDAPSelfLink(base, "/domain/"+d.Name)},
+		},
+		LDHName:     d.Name,
+		UnicodeName: unicode,
+		NameServers: nameservers,
+	}
+}
+
+// RDAP returns the RDAP IP network object of the address, linking to the nameservers using it
+func (ip *IP) RDAP(base string) *RDAPIPNetwork {
+	obj := &RDAPIPNetwork{
+		RDAPObject: RDAPObject{
+			ObjectClassName: rdapIPNetworkClass,
+			Handle:          ip.Name,
+			Status:          rdapStatus(ip.LastSeen, ip.AsOf),
+			Events:          rdapEvents(ip.FirstSeen, ip.LastSeen, ip.AsOf),
+			Links:           []RDAPLink{RDAPSelfLink(base, "/ip/"+ip.Name)},
+		},
+		StartAddress: ip.Name,
+		EndAddress:   ip.Name,
+		IPVersion:    "v4",
+	}
+	if ip.Version == 6 {
+		obj.IPVersion = "v6"
+	}
+	self := obj.Links[0].Value
+	for _, ns := range ip.NameServers {
+		link := RDAPSelfLink(base, "/nameserver/"+ns.Name)
+		link.Value, link.Rel, link.Title = self, "related", ns.Name
+		obj.Links = append(obj.Links, link)
+	}
+	return obj
+}
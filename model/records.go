@@ -0,0 +1,336 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Records is implemented by the APIData types that hold a list of rows
+// the rows are written one per line as NDJSON, and flattened into columns for CSV and TSV
+// types that do not implement it are written as a single row
+type Records interface {
+	Records() []interface{}
+}
+
+// zoneCountsRecord is a row of the history counts with the zone it belongs to
+type zoneCountsRecord struct {
+	Zone string `json:"zone"`
+	*ZoneCounts
+}
+
+// CountsRecord returns the row of a bucket of the history of zone
+func CountsRecord(zone string, c *ZoneCounts) interface{} {
+	return zoneCountsRecord{zone, c}
+}
+
+// Records returns a row for every bucket of the history
+func (zc *ZoneCount) Records() []interface{} {
+	out := make([]interface{}, 0, len(zc.History))
+	for _, c := range zc.History {
+		out = append(out, CountsRecord(zc.Zone, c))
+	}
+	return out
+}
+
+// Records returns a row for every bucket of every zone's history, ordered by zone
+func (zc *AllZoneCounts) Records() []interface{} {
+	zones := make([]string, 0, len(zc.Counts))
+	for zone := range zc.Counts {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	out := make([]interface{}, 0)
+	for _, zone := range zones {
+		out = append(out, zc.Counts[zone].Records()...)
+	}
+	return out
+}
+
+// Records returns the zones
+func (zirs *ZoneImportResults) Records() []interface{} {
+	out := make([]interface{}, 0, len(zirs.Zones))
+	for _, z := range zirs.Zones {
+		out = append(out, z)
+	}
+	return out
+}
+
+// Records returns the zone imports of the day
+func (ip *ImportProgress) Records() []interface{} {
+	out := make([]interface{}, 0, len(ip.Imports))
+	for _, zi := range ip.Imports {
+		out = append(out, zi)
+	}
+	return out
+}
+
+// Records returns the health of every zone
+func (ih *ImportHealth) Records() []interface{} {
+	out := make([]interface{}, 0, len(ih.Zones))
+	for _, zh := range ih.Zones {
+		out = append(out, zh)
+	}
+	return out
+}
+
+// changeRecord is a domain or nameserver in a feed or diff with the change it is listed under
+type changeRecord struct {
+	Date    *time.Time `json:"date,omitempty"`
+	Change  string     `json:"change"`
+	Version int        `json:"version,omitempty"`
+	Name    string     `json:"name"`
+	Link    string     `json:"link"`
+}
+
+// Record returns the row of a domain of the feed
+func (f *Feed) Record(d *Domain) interface{} {
+	return changeRecord{Date: &f.Date, Change: f.Change, Name: d.Name, Link: d.Link}
+}
+
+// Records returns a row for every domain of the feed
+func (f *Feed) Records() []interface{} {
+	out := make([]interface{}, 0, len(f.Domains))
+	for _, d := range f.Domains {
+		out = append(out, f.Record(d))
+	}
+	return out
+}
+
+// Records returns a row for every nameserver of the feed with its IP version
+func (f *NSFeed) Records() []interface{} {
+	out := make([]interface{}, 0, len(f.Nameservers4)+len(f.Nameservers6))
+	for _, ns := range f.Nameservers4 {
+		out = append(out, changeRecord{Date: &f.Date, Change: f.Change, Version: 4, Name: ns.Name, Link: ns.Link})
+	}
+	for _, ns := range f.Nameservers6 {
+		out = append(out, changeRecord{Date: &f.Date, Change: f.Change, Version: 6, Name: ns.Name, Link: ns.Link})
+	}
+	return out
+}
+
+// Records returns a row for every domain added, removed and moved in this page of the diff
+func (zd *ZoneDiff) Records() []interface{} {
+	out := make([]interface{}, 0, len(zd.Added)+len(zd.Removed)+len(zd.Moved))
+	for _, change := range []struct {
+		name    string
+		domains []*Domain
+	}{{"added", zd.Added}, {"removed", zd.Removed}, {"moved", zd.Moved}} {
+		for _, d := range change.domains {
+			out = append(out, changeRecord{Change: change.name, Name: d.Name, Link: d.Link})
+		}
+	}
+	return out
+}
+
+// Records returns the change events
+func (dt *DomainTimeline) Records() []interface{} {
+	out := make([]interface{}, 0, len(dt.Events))
+	for _, e := range dt.Events {
+		out = append(out, e)
+	}
+	return out
+}
+
+// Records returns the IPs
+func (ipl *IPList) Records() []interface{} {
+	out := make([]interface{}, 0, len(ipl.IPs))
+	for _, ip := range ipl.IPs {
+		out = append(out, ip)
+	}
+	return out
+}
+
+// Records returns the nameservers of the page
+func (l *DomainNameServers) Records() []interface{} {
+	return nameServerRecords(l.NameServers)
+}
+
+// Records returns the nameservers of the page
+func (l *ZoneNameServers) Records() []interface{} {
+	return nameServerRecords(l.NameServers)
+}
+
+// Records returns the nameservers of the page
+func (l *IPNameServers) Records() []interface{} {
+	return nameServerRecords(l.NameServers)
+}
+
+func nameServerRecords(nameservers []*NameServer) []interface{} {
+	out := make([]interface{}, 0, len(nameservers))
+	for _, ns := range nameservers {
+		out = append(out, ns)
+	}
+	return out
+}
+
+// Records returns the domains of the page
+func (l *NameServerDomains) Records() []interface{} {
+	return domainRecords(l.Domains)
+}
+
+// Records returns the domains of the page
+func (l *ZoneDomains) Records() []interface{} {
+	return domainRecords(l.Domains)
+}
+
+func domainRecords(domains []*Domain) []interface{} {
+	out := make([]interface{}, 0, len(domains))
+	for _, d := range domains {
+		out = append(out, d)
+	}
+	return out
+}
+
+// Records returns the IPs of the page
+func (l *NameServerIPs) Records() []interface{} {
+	out := make([]interface{}, 0, len(l.IPs))
+	for _, ip := range l.IPs {
+		out = append(out, ip)
+	}
+	return out
+}
+
+// Records returns the counts per date with the search term
+func (fc *FeedCountList) Records() []interface{} {
+	out := make([]interface{}, 0, len(fc.Counts))
+	for _, c := range fc.Counts {
+		out = append(out, struct {
+			Search string `json:"search"`
+			Type   string `json:"type"`
+			FeedCount
+		}{fc.Search, fc.Type, c})
+	}
+	return out
+}
+
+// Record returns the row of a domain with the prefix
+func (pl *PrefixList) Record(d PrefixResult) interface{} {
+	return struct {
+		Prefix string `json:"prefix"`
+		Active bool   `json:"active"`
+		PrefixResult
+	}{pl.Prefix, pl.Active, d}
+}
+
+// Records returns the domains with the prefix
+func (pl *PrefixList) Records() []interface{} {
+	out := make([]interface{}, 0, len(pl.Domains))
+	for _, d := range pl.Domains {
+		out = append(out, pl.Record(d))
+	}
+	return out
+}
+
+// Records returns a row for every active IP with its version
+func (aip *ActiveIPs) Records() []interface{} {
+	out := make([]interface{}, 0, len(aip.IPv4IPs)+len(aip.IPv6IPs))
+	for _, ips := range []struct {
+		version int
+		ips     []string
+	}{{4, aip.IPv4IPs}, {6, aip.IPv6IPs}} {
+		for _, ip := range ips.ips {
+			out = append(out, aip.Record(ips.version, ip))
+		}
+	}
+	return out
+}
+
+// Record returns the row of an active IP
+func (aip *ActiveIPs) Record(version int, ip string) interface{} {
+	return struct {
+		Date    time.Time `json:"date"`
+		Version int       `json:"version"`
+		IP      string    `json:"ip"`
+	}{aip.Date, version, ip}
+}
+
+// Records returns the nameserver count of every zone with the IP
+func (c *ResearchIPNsZoneCount) Records() []interface{} {
+	out := make([]interface{}, 0, len(c.ZoneNSCounts))
+	for _, zc := range c.ZoneNSCounts {
+		out = append(out, struct {
+			IP string `json:"ip"`
+			ResearchZoneCount
+		}{c.IP, zc})
+	}
+	return out
+}
+
+// Records returns the result of every name
+func (bl *BulkLookup) Records() []interface{} {
+	out := make([]interface{}, 0, len(bl.Results))
+	for _, r := range bl.Results {
+		out = append(out, r)
+	}
+	return out
+}
+
+// Records returns a row for every dangling nameserver
+func (dn *DanglingNameServers) Records() []interface{} {
+	out := make([]interface{}, 0, len(dn.NameServers))
+	for _, ns := range dn.NameServers {
+		out = append(out, dn.Record(ns))
+	}
+	return out
+}
+
+// Record returns the row of a dangling nameserver, with its zone counts as zone:count and its domains space separated
+func (dn *DanglingNameServers) Record(ns *DanglingNameServer) interface{} {
+	counts := make([]string, 0, len(ns.ZoneCounts))
+	for _, zc := range ns.ZoneCounts {
+		counts = append(counts, fmt.Sprintf("%s:%d", zc.Zone, zc.Count))
+	}
+	return struct {
+		Date         time.Time `json:"date"`
+		Name         string    `json:"name"`
+		Domain       string    `json:"domain"`
+		Zone         string    `json:"zone"`
+		Unregistered bool      `json:"unregistered"`
+		NoAddress    bool      `json:"no_address"`
+		DomainCount  int64     `json:"domain_count"`
+		ZoneCounts   string    `json:"zone_counts"`
+		Domains      string    `json:"domains"`
+	}{dn.Date, ns.Name, ns.Domain, ns.Zone, ns.Unregistered, ns.NoAddress, ns.DomainCount, strings.Join(counts, " "), strings.Join(ns.Domains, " ")}
+}
+
+// Records returns a row for every zone and date
+func (om *OperatorMarket) Records() []interface{} {
+	out := make([]interface{}, 0, len(om.History))
+	for _, s := range om.History {
+		out = append(out, om.Record(s))
+	}
+	return out
+}
+
+// Record returns the row of the shares of a zone on a date, with its top operators as operator:percent
+func (om *OperatorMarket) Record(s *OperatorShares) interface{} {
+	top := make([]string, 0, len(s.Top))
+	for _, share := range s.Top {
+		top = append(top, fmt.Sprintf("%s:%g", share.Operator, share.Percent))
+	}
+	return struct {
+		Date      time.Time `json:"date"`
+		Zone      string    `json:"zone"`
+		Domains   float64   `json:"domains"`
+		Operators int       `json:"operators"`
+		TopShare  float64   `json:"top_share"`
+		HHI       float64   `json:"hhi"`
+		Top       string    `json:"top"`
+	}{s.Date, s.Zone, s.Domains, s.Operators, s.TopShare, s.HHI, strings.Join(top, " ")}
+}
+
+// Records returns a row for every flow
+func (pm *ProviderMigration) Records() []interface{} {
+	out := make([]interface{}, 0, len(pm.Flows))
+	for _, f := range pm.Flows {
+		out = append(out, struct {
+			Zone    string  `json:"zone"`
+			From    string  `json:"from"`
+			To      string  `json:"to"`
+			Domains float64 `json:"domains"`
+		}{pm.Zone, f.From, f.To, f.Domains})
+	}
+	return out
+}
@@ -2,13 +2,20 @@ package model
 
 import (
 	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
 // API Explain Strings
 var (
-	IPNsZoneCountType = "ip_ns_zone_count"
-	ActiveIPsType     = "active_ips"
+	IPNsZoneCountType       = "ip_ns_zone_count"
+	ActiveIPsType           = "active_ips"
+	DanglingNameServersType = "dangling_nameservers"
+	OperatorMarketType      = "operator_market"
+	ProviderMigrationType   = "provider_migration"
 )
 
 type ResearchIPNsZoneCount struct {
@@ -43,3 +50,258 @@ func (c *ResearchIPNsZoneCount) GenerateMetaData() {
 	c.Type = &IPNsZoneCountType
 	c.Link = "/research/ipnszonecount/" + c.IP
 }
+
+// DanglingNameServer is a nameserver domains are still delegated to that could be taken over
+// Unregistered is set when the domain it is named under is not delegated in its zone, so anyone could register it,
+// and NoAddress when it has no A or AAAA glue
+// Domains are the first of the DomainCount domains delegated to it, and ZoneCounts their counts by zone
+type DanglingNameServer struct {
+	ID           int64               `json:"-"`
+	Name         string              `json:"name"`
+	Domain       string              `json:"domain"`
+	Zone         string              `json:"zone"`
+	Unregistered bool                `json:"unregistered"`
+	NoAddress    bool                `json:"no_address"`
+	DomainCount  int64               `json:"domain_count"`
+	ZoneCounts   []ResearchZoneCount `json:"zone_counts"`
+	Domains      []string            `json:"domains"`
+}
+
+// DanglingNameServers lists the dangling nameservers on a date, named in Zone if it is set
+// Truncated is set when the list stops at its limit
+type DanglingNameServers struct {
+	Metadata
+	Date        time.Time             `json:"date"`
+	Zone        string                `json:"zone,omitempty"`
+	Truncated   bool                  `json:"truncated,omitempty"`
+	NameServers []*DanglingNameServer `json:"nameservers"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (dn *DanglingNameServers) GenerateMetaData() {
+	dn.Type = &DanglingNameServersType
+	dn.Link = "/research/dangling?date=" + dn.Date.Format("2006-01-02")
+	if dn.Zone != "" {
+		dn.Link += "&zone=" + dn.Zone
+	}
+}
+
+// ZoneCounts returns the domains delegated to the nameservers by zone, most first
+func (dn *DanglingNameServers) ZoneCounts() []ResearchZoneCount {
+	counts := make(map[string]int64)
+	total := int64(0)
+	for _, ns := range dn.NameServers {
+		for _, zc := range ns.ZoneCounts {
+			counts[zc.Zone] += zc.Count
+			total += zc.Count
+		}
+	}
+	return zoneCounts(counts, total)
+}
+
+// zoneCounts returns the counts by zone with their percent of total, most first
+func zoneCounts(counts map[string]int64, total int64) []ResearchZoneCount {
+	out := make([]ResearchZoneCount, 0, len(counts))
+	for zone, count := range counts {
+		out = append(out, ResearchZoneCount{Zone: zone, Count: count, Percent: float64(count) / float64(total) * 100})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Zone < out[j].Zone
+	})
+	return out
+}
+
+// NewDanglingNameServer returns the dangling nameserver with the counts of its delegated domains by zone
+func NewDanglingNameServer(id int64, name, domain, zone string, unregistered, noAddress bool, counts map[string]int64) *DanglingNameServer {
+	ns := &DanglingNameServer{ID: id, Name: name, Domain: domain, Zone: zone, Unregistered: unregistered, NoAddress: noAddress, Domains: make([]string, 0)}
+	for _, count := range counts {
+		ns.DomainCount += count
+	}
+	ns.ZoneCounts = zoneCounts(counts, ns.DomainCount)
+	return ns
+}
+
+// OperatorShare is the domains of a nameserver operator in a zone on a date
+// a domain delegated to several operators is split between them, so Domains can be fractional
+type OperatorShare struct {
+	Operator string  `json:"operator"`
+	Domains  float64 `json:"domains"`
+	Percent  float64 `json:"percent"`
+}
+
+// OperatorShares is the market of the nameserver operators of a zone on a date
+// TopShare is the percent of the domains of the Top operators,
+// and HHI the Herfindahl-Hirschman Index of all of them, the sum of their squared percents, from 0 to 10000
+type OperatorShares struct {
+	Date      time.Time        `json:"date"`
+	Zone      string           `json:"zone"`
+	Domains   float64          `json:"domains"`
+	Operators int              `json:"operators"`
+	TopShare  float64          `json:"top_share"`
+	HHI       float64          `json:"hhi"`
+	Top       []*OperatorShare `json:"top"`
+}
+
+// round2 rounds x to two decimals
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// NewOperatorShares returns the shares of the operators with the domains on date in zone, listing the top of them
+func NewOperatorShares(date time.Time, zone string, domains map[string]float64, top int) *OperatorShares {
+	s := &OperatorShares{Date: date, Zone: zone, Operators: len(domains)}
+	all := make([]*OperatorShare, 0, len(domains))
+	for operator, count := range domains {
+		s.Domains += count
+		all = append(all, &OperatorShare{Operator: operator, Domains: count})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Domains != all[j].Domains {
+			return all[i].Domains > all[j].Domains
+		}
+		return all[i].Operator < all[j].Operator
+	})
+	for i, share := range all {
+		share.Percent = share.Domains / s.Domains * 100
+		s.HHI += share.Percent * share.Percent
+		if i < top {
+			s.TopShare += share.Percent
+		}
+		share.Domains, share.Percent = round2(share.Domains), round2(share.Percent)
+	}
+	if len(all) > top {
+		all = all[:top]
+	}
+	s.Top = all
+	s.Domains, s.TopShare, s.HHI = round2(s.Domains), round2(s.TopShare), round2(s.HHI)
+	return s
+}
+
+// OperatorMarket is the history of the shares of the nameserver operators of the zones,
+// counted on the first day of every period of Granularity from From to To
+type OperatorMarket struct {
+	Metadata
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Granularity string            `json:"granularity"`
+	Zones       []string          `json:"zones,omitempty"`
+	Top         int               `json:"top"`
+	History     []*OperatorShares `json:"history"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (om *OperatorMarket) GenerateMetaData() {
+	om.Type = &OperatorMarketType
+	query := url.Values{}
+	query.Set("from", om.From.Format("2006-01-02"))
+	query.Set("to", om.To.Format("2006-01-02"))
+	query.Set("granularity", om.Granularity)
+	query.Set("top", fmt.Sprint(om.Top))
+	if len(om.Zones) > 0 {
+		zones := make([]string, 0, len(om.Zones))
+		for _, zone := range om.Zones {
+			if zone == "" {
+				zone = "."
+			}
+			zones = append(zones, zone)
+		}
+		query.Set("zones", strings.Join(zones, ","))
+	}
+	om.Link = "/research/operators?" + query.Encode()
+}
+
+// OtherOperators is the operator the operators past the top ones of a ProviderMigration are grouped in
+const OtherOperators = "other"
+
+// MigrationFlow is the domains that moved From an operator To another
+type MigrationFlow struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Domains float64 `json:"domains"`
+}
+
+// ProviderMigration is the flows of the domains of a zone between nameserver operators, an edge list for a Sankey diagram
+// Moved is the domains of the moved feed from From to To, Internal the domains that moved between nameservers of one operator
+// a domain delegated to several operators before or after its move is split between them
+type ProviderMigration struct {
+	Metadata
+	Zone      string           `json:"zone"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Moved     int64            `json:"moved"`
+	Internal  float64          `json:"internal"`
+	Operators []string         `json:"operators"`
+	Flows     []*MigrationFlow `json:"flows"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (pm *ProviderMigration) GenerateMetaData() {
+	pm.Type = &ProviderMigrationType
+	zone := pm.Zone
+	if zone == "" {
+		zone = "."
+	}
+	pm.Link = fmt.Sprintf("/research/migrations/%s?from=%s&to=%s", zone, pm.From.Format("2006-01-02"), pm.To.Format("2006-01-02"))
+}
+
+// NewProviderMigration returns the migration of the moved domains with the flows between their operators,
+// keeping the top operators by the domains that left or joined them and grouping the others
+func NewProviderMigration(zone string, from, to time.Time, moved int64, flows map[[2]string]float64, top int) *ProviderMigration {
+	pm := &ProviderMigration{Zone: zone, From: from, To: to, Moved: moved, Operators: make([]string, 0), Flows: make([]*MigrationFlow, 0)}
+	volume := make(map[string]float64)
+	for flow, domains := range flows {
+		if flow[0] == flow[1] {
+			pm.Internal += domains
+			continue
+		}
+		volume[flow[0]] += domains
+		volume[flow[1]] += domains
+	}
+	for operator := range volume {
+		pm.Operators = append(pm.Operators, operator)
+	}
+	sort.Slice(pm.Operators, func(i, j int) bool {
+		a, b := pm.Operators[i], pm.Operators[j]
+		if volume[a] != volume[b] {
+			return volume[a] > volume[b]
+		}
+		return a < b
+	})
+	if len(pm.Operators) > top {
+		pm.Operators = append(pm.Operators[:top], OtherOperators)
+	}
+	kept := make(map[string]bool, len(pm.Operators))
+	for _, operator := range pm.Operators {
+		kept[operator] = true
+	}
+	grouped := make(map[[2]string]float64)
+	for flow, domains := range flows {
+		if flow[0] == flow[1] {
+			continue
+		}
+		for i := range flow {
+			if !kept[flow[i]] {
+				flow[i] = OtherOperators
+			}
+		}
+		grouped[flow] += domains
+	}
+	for flow, domains := range grouped {
+		pm.Flows = append(pm.Flows, &MigrationFlow{From: flow[0], To: flow[1], Domains: round2(domains)})
+	}
+	sort.Slice(pm.Flows, func(i, j int) bool {
+		a, b := pm.Flows[i], pm.Flows[j]
+		if a.Domains != b.Domains {
+			return a.Domains > b.Domains
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	pm.Internal = round2(pm.Internal)
+	return pm
+}
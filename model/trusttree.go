@@ -0,0 +1,234 @@
+package model
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TrustTreeType is the type of the trust trees
+var TrustTreeType = "trust_tree"
+
+// kinds of the nodes of a trust tree
+const (
+	TrustNodeDomain     = "domain"
+	TrustNodeZone       = "zone"
+	TrustNodeNameServer = "nameserver"
+	TrustNodeIP         = "ip"
+)
+
+// severities of the issues of a trust tree
+const (
+	TrustHazard  = "hazard"
+	TrustWarning = "warning"
+)
+
+// codes of the issues, the indexes of static/tree/dns-errors.js
+const (
+	TrustIssueUnregistered = iota
+	TrustIssueNSAddress
+	TrustIssueNSWildcard
+	TrustIssueNSTLD
+	TrustIssuePublicResolver
+	TrustIssuePrivateAddress
+)
+
+// TrustIssueCode explains an issue of a trust tree
+type TrustIssueCode struct {
+	Code        int    `json:"code"`
+	Severity    string `json:"severity"`
+	Message     string `json:"message"`
+	Explanation string `json:"explanation"`
+}
+
+// TrustIssueCodes are the issues a trust tree can have, by code
+// the missing AS warning of the browser tree needs RIPEstat and is not raised
+var TrustIssueCodes = []TrustIssueCode{
+	{TrustIssueUnregistered, TrustHazard, "Domain potentially available for registration",
+		"This domain is not listed in its TLD's zone files. If it is still registrable, records pointing to this domain are at risk."},
+	{TrustIssueNSAddress, TrustWarning, "NS record with IPv4/6 address",
+		"RFC 1035 stipulates that an NS record should point to an authoritative hostname."},
+	{TrustIssueNSWildcard, TrustWarning, "NS record has a wildcard domain",
+		"DNS implementations for wildcard records (records beginning with an '*') vary and can lead to inconsistent results."},
+	{TrustIssueNSTLD, TrustWarning, "NS record points to a potential TLD",
+		"This NS record points to a hostname at the TLD level, and is likely not authoritative for a given domain."},
+	{TrustIssuePublicResolver, TrustWarning, "IP belongs to a public nameserver",
+		"This IP address belongs to a public nameserver, and is likely not authoritative for a given domain."},
+	{TrustIssuePrivateAddress, TrustWarning, "IP is a part of private address space",
+		"This IP address is part of the 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 127.0.0.0/8, fc00::/7 or ::1 address spaces. These address spaces are reserved for private, unique local, or loopback IPs and are not publicly routable."},
+}
+
+// TrustIssue is a hazard or warning of a node
+type TrustIssue struct {
+	Code     int    `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// NewTrustIssue returns the issue code about the node named name
+func NewTrustIssue(code int, name string) TrustIssue {
+	c := TrustIssueCodes[code]
+	return TrustIssue{Code: code, Severity: c.Severity, Message: fmt.Sprintf("%s: %s", c.Message, name)}
+}
+
+// TrustNode is a domain, zone, nameserver or IP a domain depends on to resolve
+// Depth is its level below the domain of the tree, two levels per delegation
+type TrustNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+	// Missing is set for the domains not delegated in their zone on the day
+	Missing bool `json:"missing,omitempty"`
+	// Truncated is set for the domains not followed for the depth limit
+	Truncated bool         `json:"truncated,omitempty"`
+	Issues    []TrustIssue `json:"issues,omitempty"`
+	// Hazardous is set when the node or a node it depends on has a hazard
+	Hazardous bool `json:"hazardous,omitempty"`
+}
+
+// TrustEdge is a dependency of the Source node on the Target node
+// Cycle is set for the edges back to a node on the path from the domain of the tree to Source
+type TrustEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Cycle  bool   `json:"cycle,omitempty"`
+}
+
+// TrustTree is the graph of the zones, nameservers and IPs the resolution of a domain depends on
+type TrustTree struct {
+	Metadata
+	Domain    string           `json:"domain"`
+	AsOf      *time.Time       `json:"as_of,omitempty"`
+	MaxDepth  int              `json:"max_depth"`
+	Truncated bool             `json:"truncated"`
+	Hazards   int              `json:"hazards"`
+	Warnings  int              `json:"warnings"`
+	Cycles    int              `json:"cycles"`
+	Codes     []TrustIssueCode `json:"issue_codes"`
+	Nodes     []*TrustNode     `json:"nodes"`
+	Edges     []*TrustEdge     `json:"edges"`
+}
+
+// GenerateMetaData generates metadata recursively of member models
+func (t *TrustTree) GenerateMetaData() {
+	t.Type = &TrustTreeType
+	t.Link = asOfLink("/research/trust-tree/"+t.Domain, t.AsOf)
+}
+
+// trustNodeColors are the fill colors of the node kinds, those of the browser tree
+var trustNodeColors = map[string]string{
+	TrustNodeDomain:     "#FFFFFF",
+	TrustNodeZone:       "#cbe870",
+	TrustNodeNameServer: "#d37b5f",
+	TrustNodeIP:         "#fddd82",
+}
+
+// issueText joins the messages of the issues
+func issueText(issues []TrustIssue) string {
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// WriteGML writes the tree as a GML graph, the nodes are numbered in order
+func (t *TrustTree) WriteGML(w io.Writer) error {
+	// GML strings can not hold quotes, they are escaped as HTML entities
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer("&", "&amp;", `"`, "&quot;").Replace(s) + `"`
+	}
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	bw := bufio.NewWriter(w)
+	index := make(map[string]int, len(t.Nodes))
+	fmt.Fprintf(bw, "graph [\n  directed 1\n  label %s\n", quote(t.Domain))
+	for i, n := range t.Nodes {
+		index[n.ID] = i
+		fmt.Fprintf(bw, "  node [\n    id %d\n    label %s\n    kind %s\n    depth %d\n    hazardous %d\n", i, quote(n.Name), quote(n.Kind), n.Depth, flag(n.Hazardous))
+		if len(n.Issues) > 0 {
+			fmt.Fprintf(bw, "    issues %s\n", quote(issueText(n.Issues)))
+		}
+		fmt.Fprintf(bw, "    graphics [\n      fill %s\n    ]\n  ]\n", quote(trustNodeColors[n.Kind]))
+	}
+	for _, e := range t.Edges {
+		fmt.Fprintf(bw, "  edge [\n    source %d\n    target %d\n    cycle %d\n  ]\n", index[e.Source], index[e.Target], flag(e.Cycle))
+	}
+	fmt.Fprint(bw, "]\n")
+	return bw.Flush()
+}
+
+// WriteGraphML writes the tree as a GraphML document
+func (t *TrustTree) WriteGraphML(w io.Writer) error {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, xml.Header)
+	fmt.Fprint(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`+"\n")
+	for _, key := range []struct{ id, target, name, typ string }{
+		{"kind", "node", "kind", "string"},
+		{"depth", "node", "depth", "int"},
+		{"hazardous", "node", "hazardous", "boolean"},
+		{"issues", "node", "issues", "string"},
+		{"color", "node", "color", "string"},
+		{"cycle", "edge", "cycle", "boolean"},
+	} {
+		fmt.Fprintf(bw, `  <key id="%s" for="%s" attr.name="%s" attr.type="%s"/>`+"\n", key.id, key.target, key.name, key.typ)
+	}
+	fmt.Fprintf(bw, `  <graph id="%s" edgedefault="directed">`+"\n", escape(t.Domain))
+	for _, n := range t.Nodes {
+		fmt.Fprintf(bw, `    <node id="%s">`+"\n", escape(n.ID))
+		fmt.Fprintf(bw, `      <data key="kind">%s</data><data key="depth">%d</data><data key="hazardous">%t</data><data key="color">%s</data>`+"\n", n.Kind, n.Depth, n.Hazardous, trustNodeColors[n.Kind])
+		if len(n.Issues) > 0 {
+			fmt.Fprintf(bw, `      <data key="issues">%s</data>`+"\n", escape(issueText(n.Issues)))
+		}
+		fmt.Fprint(bw, "    </node>\n")
+	}
+	for _, e := range t.Edges {
+		fmt.Fprintf(bw, `    <edge source="%s" target="%s"><data key="cycle">%t</data></edge>`+"\n", escape(e.Source), escape(e.Target), e.Cycle)
+	}
+	fmt.Fprint(bw, "  </graph>\n</graphml>\n")
+	return bw.Flush()
+}
+
+// WriteDOT writes the tree as a Graphviz digraph
+// hazardous nodes are outlined in red and the edges of cycles are dashed
+func (t *TrustTree) WriteDOT(w io.Writer) error {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n  rankdir=TB;\n  node [shape=box, style=filled];\n", quote(t.Domain))
+	for _, n := range t.Nodes {
+		attrs := fmt.Sprintf("label=%s, fillcolor=%s", quote(n.Name), quote(trustNodeColors[n.Kind]))
+		if n.Hazardous {
+			attrs += `, color="#FF0000", penwidth=2`
+		} else if len(n.Issues) > 0 {
+			attrs += `, color="#CA9E2A", penwidth=2`
+		}
+		if len(n.Issues) > 0 {
+			attrs += ", tooltip=" + quote(issueText(n.Issues))
+		}
+		fmt.Fprintf(bw, "  %s [%s];\n", quote(n.ID), attrs)
+	}
+	for _, e := range t.Edges {
+		attrs := ""
+		if e.Cycle {
+			attrs = " [style=dashed]"
+		}
+		fmt.Fprintf(bw, "  %s -> %s%s;\n", quote(e.Source), quote(e.Target), attrs)
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"fmt"
+)
+
+// ZoneRecord is a resource record of a zone file rebuilt from the delegations of a day
+// Name is the owner without its trailing dot, Value the RDATA, a name for NS and an address for A and AAAA
+type ZoneRecord struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// String returns the record as a line of an RFC 1035 master file, with absolute names and the TTL of $TTL
+func (rr *ZoneRecord) String() string {
+	value := rr.Value
+	if rr.Type == "NS" {
+		value += "."
+	}
+	return fmt.Sprintf("%s.\tIN\t%s\t%s", rr.Name, rr.Type, value)
+}
@@ -0,0 +1,189 @@
+// Package psl finds the public suffix and registrable domain of names with the Public Suffix List
+// a copy of the list is embedded, a newer one can be loaded at start with Load
+package psl
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed" // for the embedded list
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// embedded is the list built in, refreshed with make psl
+//
+//go:embed public_suffix_list.dat
+var embedded []byte
+
+// kinds of the rules of the list, a name can be the key of several
+type rule uint8
+
+const (
+	ruleExact     rule = 1 << iota // example.com
+	ruleWildcard                   // *.example.com, the key is example.com
+	ruleException                  // !www.example.com, the key is www.example.com
+)
+
+// sections of the list
+const (
+	sectionICANN = iota
+	sectionPrivate
+)
+
+// List is a parsed Public Suffix List
+type List struct {
+	// sections are the rules of the ICANN and private domains, by the ASCII name they apply to
+	sections [2]map[string]rule
+}
+
+// Default is the list used by the functions of the package, the embedded list until Load is called
+var Default = mustParse(embedded)
+
+func mustParse(b []byte) *List {
+	l, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Parse reads a list in the format of https://publicsuffix.org/list/public_suffix_list.dat
+// the rules before the ===BEGIN PRIVATE DOMAINS=== marker are ICANN rules
+func Parse(r io.Reader) (*List, error) {
+	l := &List{sections: [2]map[string]rule{make(map[string]rule), make(map[string]rule)}}
+	section := sectionICANN
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "//") {
+			if strings.Contains(text, "===BEGIN PRIVATE DOMAINS===") {
+				section = sectionPrivate
+			} else if strings.Contains(text, "===BEGIN ICANN DOMAINS===") {
+				section = sectionICANN
+			}
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := ruleExact
+		name := fields[0]
+		if strings.HasPrefix(name, "!") {
+			kind, name = ruleException, name[1:]
+		} else if strings.HasPrefix(name, "*.") {
+			kind, name = ruleWildcard, name[2:]
+		}
+		name, err := idna.ToASCII(strings.ToLower(name))
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("public suffix list line %d: invalid rule %q", line, fields[0])
+		}
+		l.sections[section][name] |= kind
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if l.Len() == 0 {
+		return nil, errors.New("public suffix list has no rules")
+	}
+	return l, nil
+}
+
+// Load replaces Default with the list in the file at path
+// it is not safe to call while the list is in use
+func Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	Default = l
+	return nil
+}
+
+// Len returns the number of names with rules in the list
+func (l *List) Len() int {
+	return len(l.sections[sectionICANN]) + len(l.sections[sectionPrivate])
+}
+
+// match returns the public suffix of name and whether it comes from an ICANN rule
+// the private rules are skipped unless private is set
+// a name no rule matches has its last label as its suffix, by the implicit * rule
+func (l *List) match(name string, private bool) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	labels := strings.Split(name, ".")
+	// the candidates go from the longest to the shortest, so the first match is the longest,
+	// and the exceptions, which are always longer than their wildcard, win over it
+	for i := range labels {
+		candidate := strings.Join(labels[i:], ".")
+		parent := strings.Join(labels[i+1:], ".")
+		for section, rules := range l.sections {
+			if section == sectionPrivate && !private {
+				continue
+			}
+			if rules[candidate]&ruleException != 0 {
+				return parent, section == sectionICANN
+			}
+			if rules[candidate]&ruleExact != 0 || (parent != "" && rules[parent]&ruleWildcard != 0) {
+				return candidate, section == sectionICANN
+			}
+		}
+	}
+	return labels[len(labels)-1], false
+}
+
+// registrable returns the suffix of name with one label more than suffix, or "" if name is suffix
+// a name with an empty label, such as .example.com, has none either
+func registrable(name, suffix string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if len(name) <= len(suffix) || strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return ""
+	}
+	rest := name[:len(name)-len(suffix)-1]
+	return name[strings.LastIndexByte(rest, '.')+1:]
+}
+
+// PublicSuffix returns the public suffix of name and whether it is an ICANN suffix, managed by a registry,
+// rather than a private one such as a hosting provider's
+func (l *List) PublicSuffix(name string) (string, bool) {
+	return l.match(name, true)
+}
+
+// RegistrableDomain returns the public suffix of name with the label before it,
+// or "" if name is a public suffix
+func (l *List) RegistrableDomain(name string) string {
+	suffix, _ := l.match(name, true)
+	return registrable(name, suffix)
+}
+
+// ICANNRegistrableDomain is RegistrableDomain with the ICANN rules only, the domain a registry delegates,
+// and the one found in the zone files: foo.github.io is under github.io
+func (l *List) ICANNRegistrableDomain(name string) string {
+	suffix, _ := l.match(name, false)
+	return registrable(name, suffix)
+}
+
+// PublicSuffix returns the public suffix of name in Default and whether it is an ICANN suffix
+func PublicSuffix(name string) (string, bool) {
+	return Default.PublicSuffix(name)
+}
+
+// RegistrableDomain returns the registrable domain of name in Default, or "" if name is a public suffix
+func RegistrableDomain(name string) string {
+	return Default.RegistrableDomain(name)
+}
+
+// ICANNRegistrableDomain returns the registrable domain of name by the ICANN rules of Default,
+// or "" if name is a public suffix
+func ICANNRegistrableDomain(name string) string {
+	return Default.ICANNRegistrableDomain(name)
+}
@@ -0,0 +1,144 @@
+package psl
+
+import (
+	"testing"
+
+	"golang.org/x/net/idna"
+)
+
+// checkPublicSuffixTests are the checkPublicSuffix vectors of tests/test_psl.txt of the Public Suffix List
+// a want of "" is null, a name with no registrable domain, and the null input is the empty name
+// the vectors of the listed but non-Internet TLD local are left out, as they are commented out there
+var checkPublicSuffixTests = []struct {
+	name, want string
+}{
+	// null input
+	{"", ""},
+	// mixed case
+	{"COM", ""},
+	{"example.COM", "example.com"},
+	{"WwW.example.COM", "example.com"},
+	// leading dot
+	{".com", ""},
+	{".example", ""},
+	{".example.com", ""},
+	{".example.example", ""},
+	// unlisted TLD
+	{"example", ""},
+	{"example.example", "example.example"},
+	{"b.example.example", "example.example"},
+	{"a.b.example.example", "example.example"},
+	// TLD with only 1 rule
+	{"biz", ""},
+	{"domain.biz", "domain.biz"},
+	{"b.domain.biz", "domain.biz"},
+	{"a.b.domain.biz", "domain.biz"},
+	// TLD with some 2-level rules
+	{"com", ""},
+	{"example.com", "example.com"},
+	{"b.example.com", "example.com"},
+	{"a.b.example.com", "example.com"},
+	{"uk.com", ""},
+	{"example.uk.com", "example.uk.com"},
+	{"b.example.uk.com", "example.uk.com"},
+	{"a.b.example.uk.com", "example.uk.com"},
+	{"test.ac", "test.ac"},
+	// TLD with only 1 (wildcard) rule
+	{"mm", ""},
+	{"c.mm", ""},
+	{"b.c.mm", "b.c.mm"},
+	{"a.b.c.mm", "b.c.mm"},
+	// more complex TLD
+	{"jp", ""},
+	{"test.jp", "test.jp"},
+	{"www.test.jp", "test.jp"},
+	{"ac.jp", ""},
+	{"test.ac.jp", "test.ac.jp"},
+	{"www.test.ac.jp", "test.ac.jp"},
+	{"kyoto.jp", ""},
+	{"test.kyoto.jp", "test.kyoto.jp"},
+	{"ide.kyoto.jp", ""},
+	{"b.ide.kyoto.jp", "b.ide.kyoto.jp"},
+	{"a.b.ide.kyoto.jp", "b.ide.kyoto.jp"},
+	{"c.kobe.jp", ""},
+	{"b.c.kobe.jp", "b.c.kobe.jp"},
+	{"a.b.c.kobe.jp", "b.c.kobe.jp"},
+	{"city.kobe.jp", "city.kobe.jp"},
+	{"www.city.kobe.jp", "city.kobe.jp"},
+	// TLD with a wildcard rule and exceptions
+	{"ck", ""},
+	{"test.ck", ""},
+	{"b.test.ck", "b.test.ck"},
+	{"a.b.test.ck", "b.test.ck"},
+	{"www.ck", "www.ck"},
+	{"www.www.ck", "www.ck"},
+	// US K12
+	{"us", ""},
+	{"test.us", "test.us"},
+	{"www.test.us", "test.us"},
+	{"ak.us", ""},
+	{"test.ak.us", "test.ak.us"},
+	{"www.test.ak.us", "test.ak.us"},
+	{"k12.ak.us", ""},
+	{"test.k12.ak.us", "test.k12.ak.us"},
+	{"www.test.k12.ak.us", "test.k12.ak.us"},
+	// IDN labels
+	{"食狮.com.cn", "食狮.com.cn"},
+	{"食狮.公司.cn", "食狮.公司.cn"},
+	{"www.食狮.公司.cn", "食狮.公司.cn"},
+	{"shishi.公司.cn", "shishi.公司.cn"},
+	{"公司.cn", ""},
+	{"食狮.中国", "食狮.中国"},
+	{"www.食狮.中国", "食狮.中国"},
+	{"shishi.中国", "shishi.中国"},
+	{"中国", ""},
+	// same as above, but punycoded
+	{"xn--85x722f.com.cn", "xn--85x722f.com.cn"},
+	{"xn--85x722f.xn--55qx5d.cn", "xn--85x722f.xn--55qx5d.cn"},
+	{"www.xn--85x722f.xn--55qx5d.cn", "xn--85x722f.xn--55qx5d.cn"},
+	{"shishi.xn--55qx5d.cn", "shishi.xn--55qx5d.cn"},
+	{"xn--55qx5d.cn", ""},
+	{"xn--85x722f.xn--fiqs8s", "xn--85x722f.xn--fiqs8s"},
+	{"www.xn--85x722f.xn--fiqs8s", "xn--85x722f.xn--fiqs8s"},
+	{"shishi.xn--fiqs8s", "shishi.xn--fiqs8s"},
+	{"xn--fiqs8s", ""},
+}
+
+// toASCII returns the A-label form of name, as the names of the zone files are
+func toASCII(t *testing.T, name string) string {
+	t.Helper()
+	ascii, err := idna.ToASCII(name)
+	if err != nil {
+		t.Fatalf("idna.ToASCII(%q): %s", name, err)
+	}
+	return ascii
+}
+
+func TestCheckPublicSuffix(t *testing.T) {
+	for _, tt := range checkPublicSuffixTests {
+		name, want := toASCII(t, tt.name), toASCII(t, tt.want)
+		if got := RegistrableDomain(name); got != want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestICANNRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		// uk.com is a private rule, so the ICANN registrable domain is the one delegated in com
+		{"a.b.example.uk.com", "uk.com"},
+		{"www.example.com", "example.com"},
+		{"b.test.ck", "b.test.ck"},
+		{"www.www.ck", "www.ck"},
+	}
+	for _, tt := range tests {
+		if got := ICANNRegistrableDomain(tt.name); got != tt.want {
+			t.Errorf("ICANNRegistrableDomain(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if suffix, icann := PublicSuffix("example.uk.com"); suffix != "uk.com" || icann {
+		t.Errorf("PublicSuffix(\"example.uk.com\") = %q, %t, want the private uk.com", suffix, icann)
+	}
+}
@@ -4,12 +4,28 @@ import "dnscoffee/model"
 
 // variables to hold common json errors
 var (
-	//ErrBadRequest           = &JSONError{"bad_request", 400, "Bad request", "Request body is not well-formed. It must be JSON."}
 	//ErrUnauthorized         = &JSONError{"unauthorized", 401, "Unauthorized", "Access token is invalid."}
+	ErrBadRequest       = model.NewJSONError("bad_request", 400, "Bad request", "Request body is not well-formed. It must be a JSON array or a name per line.")
+	ErrBadQuery         = model.NewJSONError("bad_request", 400, "Bad request", "Request body is not well-formed. It must be a JSON object with a GraphQL query.")
+	ErrInvalidPage      = model.NewJSONError("invalid_page", 400, "Bad request", "The requested page does not exist.")
+	ErrInvalidParameter = model.NewJSONError("invalid_parameter", 400, "Bad request", "A parameter of the request is invalid.")
 	ErrNotFound         = model.NewJSONError("not_found", 404, "Not found", "Route not found.")
 	ErrResourceNotFound = model.NewJSONError("resource_not_found", 404, "Not found", "Resource not found.")
+	ErrRequestTooLarge  = model.NewJSONError("request_too_large", 413, "Payload Too Large", "The request has more names than can be looked up at once.")
+	ErrQueryTooLarge    = model.NewJSONError("request_too_large", 413, "Payload Too Large", "The GraphQL query is longer than can be run.")
 	ErrLimitExceeded    = model.NewJSONError("limit_exceeded", 429, "Too Many Requests", "To many requests, please wait and submit again.")
 	ErrInternalServer   = model.NewJSONError("internal_server_error", 500, "Internal Server Error", "Something went wrong.")
 	ErrNotImplemented   = model.NewJSONError("not_implemented", 501, "Not Implemented", "The server does not support the functionality required to fulfill the request. It may not have been implemented yet")
 	ErrTimeout          = model.NewJSONError("timeout", 503, "Service Unavailable", "The request took longer than expected to process.")
 )
+
+// InvalidParameter returns a copy of ErrInvalidParameter pointing at parameter
+// detail replaces the generic detail when set
+func InvalidParameter(parameter, detail string) *model.JSONError {
+	jsonErr := *ErrInvalidParameter
+	if detail != "" {
+		jsonErr.Detail = detail
+	}
+	jsonErr.Source = &model.JSONErrorSource{Parameter: parameter}
+	return &jsonErr
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldsets are the sparse fieldsets asked for with ?fields[type]=name,..., by type
+type fieldsets map[string]map[string]bool
+
+// alwaysFields are kept in every object of a sparse fieldset
+var alwaysFields = map[string]bool{"type": true, "link": true}
+
+// parseFields returns the sparse fieldsets of the request, or nil if there are none
+// ?fields[domain]=name,firstseen keeps only those fields in every domain of the response
+func parseFields(r *http.Request) fieldsets {
+	var fields fieldsets
+	for key, values := range r.URL.Query() {
+		if !strings.HasPrefix(key, "fields[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+		if fields == nil {
+			fields = make(fieldsets)
+		}
+		kind := key[len("fields[") : len(key)-1]
+		set := make(map[string]bool)
+		for _, value := range values {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					set[name] = true
+				}
+			}
+		}
+		fields[kind] = set
+	}
+	return fields
+}
+
+// sparse returns the JSON of v with only the fields of the fieldsets kept in objects of their type
+// objects are matched by their "type" member, the order of the members is kept
+func (fields fieldsets) sparse(v interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return fields.filter(raw)
+}
+
+func (fields fieldsets) filter(raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	switch raw[0] {
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, item := range items {
+			item, err := fields.filter(item)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(item)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	case '{':
+		keys, values, err := objectMembers(raw)
+		if err != nil {
+			return nil, err
+		}
+		var kind string
+		for i, key := range keys {
+			if key == "type" {
+				json.Unmarshal(values[i], &kind)
+			}
+		}
+		set, sparse := fields[kind]
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		n := 0
+		for i, key := range keys {
+			if sparse && !set[key] && !alwaysFields[key] {
+				continue
+			}
+			value, err := fields.filter(values[i])
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			n++
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	}
+	return raw, nil
+}
+
+// columns returns the table columns kept by the fieldset of kind, those of its fields and their dotted columns
+func (fields fieldsets) columns(kind string, columns []string) []string {
+	set, sparse := fields[kind]
+	if !sparse {
+		return columns
+	}
+	out := make([]string, 0, len(columns))
+	for _, column := range columns {
+		name := strings.SplitN(column, ".", 2)[0]
+		if set[name] || alwaysFields[name] {
+			out = append(out, column)
+		}
+	}
+	return out
+}
+
+// objectMembers returns the keys and values of the JSON object raw, in order
+func objectMembers(raw json.RawMessage) ([]string, []json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0)
+	values := make([]json.RawMessage, 0)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key.(string))
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
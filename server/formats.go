@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// output formats of the API
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatNDJSON = "ndjson"
+)
+
+// formatTypes are the content types of the formats, the first is the one sent
+var formatTypes = map[string][]string{
+	FormatJSON:   {"application/json"},
+	FormatCSV:    {"text/csv"},
+	FormatTSV:    {"text/tab-separated-values"},
+	FormatNDJSON: {"application/x-ndjson", "application/ndjson"},
+}
+
+// ErrInvalidFormat is returned for an unknown ?format=
+var ErrInvalidFormat = InvalidParameter("format", "The format must be one of json, csv, tsv or ndjson.")
+
+// Format returns the output format asked for with ?format= or the Accept header, JSON by default
+// the second return value is false if ?format= is not a known format
+func Format(r *http.Request) (string, bool) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		_, ok := formatTypes[format]
+		return format, ok
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		for format, types := range formatTypes {
+			for _, t := range types {
+				if mediaType == t {
+					return format, true
+				}
+			}
+		}
+	}
+	return FormatJSON, true
+}
+
+// WriteData writes data in the format negotiated with the request
+// CSV and TSV flatten the records of data into columns, and NDJSON writes a record per line
+// the sparse fieldsets of ?fields[type]= apply to every format
+func WriteData(w http.ResponseWriter, r *http.Request, data model.APIData) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := Format(r)
+	if !ok {
+		WriteJSONError(w, ErrInvalidFormat)
+		return
+	}
+	fields := parseFields(r)
+	if format == FormatJSON && fields == nil {
+		WriteJSON(w, data)
+		return
+	}
+
+	data.GenerateMetaData()
+	if format == FormatJSON {
+		raw, err := fields.sparse(data)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(model.JSONResponse{Data: raw})
+		if err != nil && err != http.ErrHandlerTimeout {
+			panic(err)
+		}
+		return
+	}
+	records := []interface{}{data}
+	if list, ok := data.(model.Records); ok {
+		records = list.Records()
+	}
+	columns := tableColumns(records)
+	if fields != nil {
+		if columns != nil && len(records) > 0 {
+			columns = fields.columns(recordType(records[0]), columns)
+		}
+		for i, record := range records {
+			raw, err := fields.sparse(record)
+			if err != nil {
+				panic(err)
+			}
+			records[i] = raw
+		}
+	}
+	contentType := formatTypes[format][0]
+	if format != FormatNDJSON {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	var err error
+	switch format {
+	case FormatNDJSON:
+		err = writeNDJSON(w, records)
+	case FormatCSV:
+		err = writeTable(w, records, columns, ',')
+	case FormatTSV:
+		err = writeTable(w, records, columns, '\t')
+	}
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
+
+func writeNDJSON(w io.Writer, records []interface{}) error {
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		err := enc.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the columns of the types of the records, in the order the types are first seen,
+// or nil if a record is not a struct and the columns can only be read from the rows
+func tableColumns(records []interface{}) []string {
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	types := make(map[reflect.Type]bool)
+	for _, record := range records {
+		t := reflect.TypeOf(record)
+		if types[t] {
+			continue
+		}
+		types[t] = true
+		recordCols := recordColumns(record)
+		if recordCols == nil {
+			return nil
+		}
+		for _, column := range recordCols {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+// recordType returns the type member of the JSON of record, the kind of its sparse fieldset
+func recordType(record interface{}) string {
+	row, err := flattenRecord(record, nil)
+	if err != nil {
+		return ""
+	}
+	return row.values["type"]
+}
+
+// writeTable writes the records as rows separated by comma, with a header of the columns
+// nil columns are those of every record, for records that are not structs
+func writeTable(w io.Writer, records []interface{}, columns []string, comma rune) error {
+	rows := make([]*flatRow, 0, len(records))
+	leaves := columns
+	if columns == nil {
+		columns = make([]string, 0)
+	}
+	seen := make(map[string]bool)
+	for _, record := range records {
+		row, err := flattenRecord(record, leaves)
+		if err != nil {
+			return err
+		}
+		if leaves == nil {
+			for _, column := range row.columns {
+				if !seen[column] {
+					seen[column] = true
+					columns = append(columns, column)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+	line := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			line[i] = row.values[column]
+		}
+		err = cw.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// flatRow holds the flattened values of a record and its columns in order
+type flatRow struct {
+	columns []string
+	values  map[string]string
+}
+
+func (row *flatRow) set(column, value string) {
+	if _, ok := row.values[column]; !ok {
+		row.columns = append(row.columns, column)
+	}
+	row.values[column] = value
+}
+
+// flattenRecord flattens the JSON of record into columns, in the order of the JSON fields
+// nested objects become dotted columns, and lists are joined with spaces using the name of objects in them
+// the leaves are columns kept as a single cell even when they hold an object, such as maps
+func flattenRecord(record interface{}, leaves []string) (*flatRow, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	leaf := make(map[string]bool, len(leaves))
+	for _, column := range leaves {
+		leaf[column] = true
+	}
+	row := &flatRow{values: make(map[string]string)}
+	return row, flatten(raw, "", row, leaf)
+}
+
+// flatten sets the columns of the JSON value raw in row
+// objects are read field by field to keep their order
+func flatten(raw json.RawMessage, column string, row *flatRow, leaf map[string]bool) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '{' || leaf[column] {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var value interface{}
+		err := dec.Decode(&value)
+		if err != nil {
+			return err
+		}
+		if column == "" {
+			column = "value"
+		}
+		row.set(column, flatValue(value))
+		return nil
+	}
+	if column != "" {
+		column += "."
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// opening delimiter
+	_, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return err
+		}
+		err = flatten(value, column+key.(string), row, leaf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// recordColumns returns the columns of the type of record, those of every JSON field whether it is empty or not,
+// so the columns of a response do not depend on its rows
+// nested structs become dotted columns as in flattenRecord, and the other fields a single column
+// returns nil if record is not a struct, whose columns can only be read from its JSON
+func recordColumns(record interface{}) []string {
+	t := reflect.TypeOf(record)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || marshalsJSON(t) {
+		return nil
+	}
+	columns := make([]string, 0)
+	structColumns(t, "", &columns, map[string]bool{}, map[reflect.Type]bool{})
+	return columns
+}
+
+var metadataType = reflect.TypeOf(model.Metadata{})
+
+// isResource returns true if the struct t is a resource of the API, with metadata and a name
+func isResource(t reflect.Type) bool {
+	var metadata, name bool
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		metadata = metadata || (f.Anonymous && f.Type == metadataType)
+		name = name || strings.Split(f.Tag.Get("json"), ",")[0] == "name"
+	}
+	return metadata && name
+}
+
+// marshalsJSON returns true if t writes its own JSON, like time.Time
+func marshalsJSON(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return t.Implements(jsonMarshalerType) || p.Implements(jsonMarshalerType) ||
+		t.Implements(textMarshalerType) || p.Implements(textMarshalerType)
+}
+
+// structColumns appends the columns of the fields of the struct t under prefix, following the rules of encoding/json
+// embedded structs without a JSON name add their fields, and a struct nested in itself is a single column
+// nested resources are a single column of their name, as they are in lists
+func structColumns(t reflect.Type, prefix string, columns *[]string, seen map[string]bool, nesting map[reflect.Type]bool) {
+	nesting[t] = true
+	defer delete(nesting, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		nested := ft.Kind() == reflect.Struct && !marshalsJSON(ft) && !nesting[ft]
+		if f.Anonymous && name == "" && nested {
+			structColumns(ft, prefix, columns, seen, nesting)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if nested && !isResource(ft) {
+			structColumns(ft, prefix+name+".", columns, seen, nesting)
+			continue
+		}
+		if column := prefix + name; !seen[column] {
+			seen[column] = true
+			*columns = append(*columns, column)
+		}
+	}
+}
+
+// flatValue formats a decoded JSON value as a single cell
+func flatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, flatValue(item))
+		}
+		return strings.Join(parts, " ")
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok {
+			return name
+		}
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnscoffee/model"
+)
+
+func TestWriteTableColumns(t *testing.T) {
+	zone := &model.Zone{Name: "com"}
+	records := []interface{}{
+		// the empty fields of every record still have their columns, and a nested resource is its name
+		&model.NameServer{Name: "a.example"},
+		&model.NameServer{Name: "b.example", Zone: zone},
+	}
+	columns := tableColumns(records)
+	if !reflect.DeepEqual(columns, recordColumns(&model.NameServer{})) {
+		t.Errorf("columns = %q, want those of the type", columns)
+	}
+	for _, column := range []string{"lastseen", "zone"} {
+		if !containsColumn(columns, column) {
+			t.Errorf("columns %q miss %s", columns, column)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeTable(&buf, records, columns, ','); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], ",com,") {
+		t.Errorf("rows = %q, want the zone of the second", lines)
+	}
+
+	sparse := fieldsets{"nameserver": {"name": true}}
+	if got, want := sparse.columns("nameserver", columns), []string{"type", "link", "name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sparse columns = %q, want %q", got, want)
+	}
+}
+
+func containsColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// JSONAPIContentType is the media type of the v2 documents
+const JSONAPIContentType = "application/vnd.api+json"
+
+// documentError is a JSON:API error object, the status is a string and the code is the ID of the error
+type documentError struct {
+	Status string                 `json:"status"`
+	Code   string                 `json:"code"`
+	Title  string                 `json:"title"`
+	Detail string                 `json:"detail"`
+	Source *model.JSONErrorSource `json:"source,omitempty"`
+}
+
+// isV2 returns true if the request is for the v2 API
+func isV2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, model.V2Prefix+"/")
+}
+
+// WriteDocument writes the JSON:API document
+// ?fields[type]=name,... keeps only those attributes and relationships in the resources of type
+func WriteDocument(w http.ResponseWriter, r *http.Request, doc *model.Document) {
+	if fields := parseFields(r); fields != nil {
+		err := fields.resources(doc)
+		if err != nil {
+			panic(err)
+		}
+	}
+	w.Header().Set("Content-Type", JSONAPIContentType)
+	// the links have query strings
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(doc)
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
+
+// WriteDocumentError returns an error as a JSON:API error document
+func WriteDocumentError(w http.ResponseWriter, jsonErr *model.JSONError) {
+	w.Header().Set("Content-Type", JSONAPIContentType)
+	w.WriteHeader(jsonErr.Status)
+	doc := struct {
+		Errors  []*documentError    `json:"errors"`
+		JSONAPI model.JSONAPIObject `json:"jsonapi"`
+	}{
+		Errors: []*documentError{{
+			Status: strconv.Itoa(jsonErr.Status),
+			Code:   jsonErr.ID,
+			Title:  jsonErr.Title,
+			Detail: jsonErr.Detail,
+			Source: jsonErr.Source,
+		}},
+		JSONAPI: model.JSONAPIObject{Version: model.JSONAPIVersion},
+	}
+	err := json.NewEncoder(w).Encode(doc)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// resources applies the fieldsets to the attributes and relationships of the resources of the document
+func (fields fieldsets) resources(doc *model.Document) error {
+	resources := append(make([]*model.Resource, 0, len(doc.Included)+1), doc.Included...)
+	switch data := doc.Data.(type) {
+	case *model.Resource:
+		resources = append(resources, data)
+	case []*model.Resource:
+		resources = append(resources, data...)
+	}
+	for _, res := range resources {
+		set, ok := fields[res.Type]
+		if !ok {
+			continue
+		}
+		for name := range res.Relationships {
+			if !set[name] {
+				delete(res.Relationships, name)
+			}
+		}
+		if res.Attributes == nil {
+			continue
+		}
+		raw, err := json.Marshal(res.Attributes)
+		if err != nil {
+			return err
+		}
+		keys, values, err := objectMembers(raw)
+		if err != nil {
+			return err
+		}
+		attributes := make(map[string]json.RawMessage, len(keys))
+		for i, key := range keys {
+			if set[key] {
+				attributes[key] = values[i]
+			}
+		}
+		res.Attributes = attributes
+	}
+	return nil
+}
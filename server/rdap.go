@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"dnscoffee/model"
+)
+
+// RDAPContentType is the media type of the RDAP responses
+const RDAPContentType = "application/rdap+json"
+
+// isRDAP returns true if the request is for the RDAP service
+func isRDAP(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, model.RDAPPrefix+"/")
+}
+
+// WriteRDAP writes the RDAP response
+// RDAP clients run in browsers too, so any origin may read it
+func WriteRDAP(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", RDAPContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil && err != http.ErrHandlerTimeout {
+		panic(err)
+	}
+}
+
+// WriteRDAPError returns an error as an RDAP error response
+func WriteRDAPError(w http.ResponseWriter, jsonErr *model.JSONError) {
+	w.Header().Set("Content-Type", RDAPContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(jsonErr.Status)
+	err := json.NewEncoder(w).Encode(model.NewRDAPError(jsonErr))
+	if err != nil {
+		panic(err)
+	}
+}
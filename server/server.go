@@ -2,6 +2,9 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -10,8 +13,13 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// APIConfig holds the limits of the API, the timeouts are in seconds
+// StreamTimeout is the time a stream route has to send its response, APITimeout that of the other routes
+// ZoneFileTimeout is that of the zone files, which hold every domain of a zone
 type APIConfig struct {
 	APITimeout           int
+	StreamTimeout        int
+	ZoneFileTimeout      int
 	APIRequestsPerMinute int
 	APIMaxRequestHistory int
 	APIRequestsBurst     int
@@ -19,6 +27,8 @@ type APIConfig struct {
 
 var DefaultAPIConfig = APIConfig{
 	APITimeout:           60,
+	StreamTimeout:        3600,
+	ZoneFileTimeout:      6 * 3600,
 	APIRequestsPerMinute: 6000,
 	APIMaxRequestHistory: 16384,
 	APIRequestsBurst:     10,
@@ -34,6 +44,12 @@ type Server struct {
 	listenAddr string
 
 	apiConfig APIConfig
+
+	// renders the HTML error pages, errors are only JSON when nil
+	errorPage ErrorPageFunc
+
+	// routes that stream their response, and so are not buffered by the timeout handler, with their timeout
+	streams map[*mux.Route]time.Duration
 }
 
 // New creates a new server object with the default (included) handlers
@@ -42,6 +58,7 @@ func New(listenAddr string, apiConfig APIConfig) (*Server, error) {
 		listenAddr: listenAddr,
 		apiConfig:  apiConfig,
 		router:     mux.NewRouter().StrictSlash(true),
+		streams:    make(map[*mux.Route]time.Duration),
 	}
 
 	// serve static content
@@ -61,9 +78,19 @@ func New(listenAddr string, apiConfig APIConfig) (*Server, error) {
 		http.ServeFile(w, r, "static/docs.html")
 	}).Methods(http.MethodGet)
 
+	// not found
+	server.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.WriteError(w, r, ErrNotFound)
+	})
+
 	return server, nil
 }
 
+// SetErrorPage sets the function rendering the HTML error pages
+func (s *Server) SetErrorPage(fn ErrorPageFunc) {
+	s.errorPage = fn
+}
+
 // Get registers a HTTP GET to the router & handler
 func (s *Server) Get(path string, fn http.HandlerFunc) {
 	s.router.Handle(path, fn).Methods(http.MethodGet)
@@ -72,6 +99,28 @@ func (s *Server) Get(path string, fn http.HandlerFunc) {
 	}).Methods(http.MethodHead)
 }
 
+// GetStream registers a HTTP GET to the router & handler for a response written with a Stream
+// the response is sent as it is written, so the handler must stop by itself when the request context is done,
+// which is at the StreamTimeout
+func (s *Server) GetStream(path string, fn http.HandlerFunc) {
+	s.GetStreamTimeout(path, s.apiConfig.StreamTimeout, fn)
+}
+
+// GetStreamTimeout registers a HTTP GET for a response written with a Stream, as GetStream,
+// for a route with its own timeout in seconds, such as the ZoneFileTimeout
+func (s *Server) GetStreamTimeout(path string, timeout int, fn http.HandlerFunc) {
+	route := s.router.Handle(path, fn).Methods(http.MethodGet)
+	s.streams[route] = time.Duration(timeout) * time.Second
+	s.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}).Methods(http.MethodHead)
+}
+
+// APIConfig returns the limits of the API the server was created with
+func (s *Server) APIConfig() APIConfig {
+	return s.apiConfig
+}
+
 // Post registers a HTTP POST to the router & handler
 func (s *Server) Post(path string, fn http.HandlerFunc) {
 	s.router.Handle(path, fn).Methods(http.MethodPost)
@@ -86,6 +135,9 @@ func (s *Server) Start() error {
 		s.apiConfig.APIRequestsPerMinute,
 		s.apiConfig.APIRequestsBurst,
 		s.apiConfig.APIMaxRequestHistory,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.WriteError(w, r, ErrLimitExceeded)
+		}),
 	)
 	h := throttleHandler(s.router)
 	// prep proxy handler
@@ -93,10 +145,16 @@ func (s *Server) Start() error {
 	h = setProxyURLHost(h)
 	// cors
 	h = handlers.CORS(handlers.AllowedOrigins([]string{"http://127.0.0.1:5353"}))(h)
-	// timeouts
-	h = http.TimeoutHandler(h, timeoutDuration, ErrTimeout.Error())
+	// timeouts, the connections can be written to for as long as the longest stream
+	h = s.timeoutHandler(h, timeoutDuration)
+	writeTimeout := timeoutDuration
+	for _, timeout := range s.streams {
+		if timeout > writeTimeout {
+			writeTimeout = timeout
+		}
+	}
 	// add recovery
-	h = handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(h)
+	h = s.recoveryHandler(h)
 	// setup logging
 	h = handlers.LoggingHandler(os.Stdout, h)
 
@@ -104,8 +162,39 @@ func (s *Server) Start() error {
 	srv := &http.Server{
 		Handler:      h,
 		Addr:         s.listenAddr,
-		WriteTimeout: timeoutDuration,
+		WriteTimeout: writeTimeout,
 		ReadTimeout:  timeoutDuration,
 	}
 	return srv.ListenAndServe()
 }
+
+// timeoutHandler is http.TimeoutHandler with the error page as the message for clients accepting HTML
+// streams are not buffered, their context has a deadline a little before their own timeout instead
+func (s *Server) timeoutHandler(h http.Handler, dt time.Duration) http.Handler {
+	plain := http.TimeoutHandler(h, dt, ErrTimeout.Error())
+	html := plain
+	if s.errorPage != nil {
+		var page bytes.Buffer
+		err := s.errorPage(&page, ErrTimeout)
+		if err != nil {
+			log.Printf("unable to render timeout page: %s", err)
+		} else {
+			html = http.TimeoutHandler(h, dt, page.String())
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+		if s.router.Match(r, &match) && s.streams[match.Route] > 0 {
+			timeout := s.streams[match.Route]
+			ctx, cancel := context.WithTimeout(r.Context(), timeout-timeout/10)
+			defer cancel()
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if acceptsHTML(r) {
+			html.ServeHTTP(w, r)
+			return
+		}
+		plain.ServeHTTP(w, r)
+	})
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"dnscoffee/model"
 	"encoding/json"
+	"io"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"strings"
 
 	"github.com/throttled/throttled/v2"
@@ -34,7 +36,8 @@ func neuterDirectoryListing(next http.Handler) http.Handler {
 }
 
 // creates a throttled handler using the perMin limit on requests
-func makeThrottleHandler(perMin, burst, storeSize int) func(http.Handler) http.Handler {
+// denied is called for the requests over the limit
+func makeThrottleHandler(perMin, burst, storeSize int, denied http.Handler) func(http.Handler) http.Handler {
 	store, err := memstore.New(storeSize)
 	if err != nil {
 		log.Fatal(err)
@@ -49,11 +52,9 @@ func makeThrottleHandler(perMin, burst, storeSize int) func(http.Handler) http.H
 	}
 
 	httpRateLimiter := throttled.HTTPRateLimiter{
-		RateLimiter: rateLimiter,
-		VaryBy:      new(ipVaryBy),
-		DeniedHandler: http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			WriteJSONError(w, ErrLimitExceeded)
-		})),
+		RateLimiter:   rateLimiter,
+		VaryBy:        new(ipVaryBy),
+		DeniedHandler: denied,
 	}
 
 	return httpRateLimiter.RateLimit
@@ -69,8 +70,56 @@ func makeThrottleHandler(perMin, burst, storeSize int) func(http.Handler) http.H
 // 	WriteJSONError(w, ErrNotImplemented)
 // }
 
+// ErrorPageFunc renders the HTML page of an error
+type ErrorPageFunc func(w io.Writer, jsonErr *model.JSONError) error
+
+// acceptsHTML returns true if the client asked for an HTML response, as browsers do
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// WriteError writes the error as an HTML page if the client accepts HTML, and as JSON otherwise
+// errors of the v2 API are always JSON:API error documents, and those of the RDAP service RDAP errors
+func (s *Server) WriteError(w http.ResponseWriter, r *http.Request, jsonErr *model.JSONError) {
+	if isV2(r) {
+		WriteDocumentError(w, jsonErr)
+		return
+	}
+	if isRDAP(r) {
+		WriteRDAPError(w, jsonErr)
+		return
+	}
+	if s.errorPage == nil || !acceptsHTML(r) {
+		WriteJSONError(w, jsonErr)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(jsonErr.Status)
+	err := s.errorPage(w, jsonErr)
+	if err != nil {
+		log.Printf("unable to render error page: %s", err)
+	}
+}
+
+// recoveryHandler logs panics and replies with ErrInternalServer
+func (s *Server) recoveryHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("%v\n%s", err, debug.Stack())
+			s.WriteError(w, r, ErrInternalServer)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // WriteJSONError returns an error as JSON
-// TODO make not all errors JSON
 func WriteJSONError(w http.ResponseWriter, jsonErr *model.JSONError) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(jsonErr.Status)
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"dnscoffee/model"
+)
+
+// streamFlushRows is how many rows are buffered before they are flushed to the client
+const streamFlushRows = 1000
+
+// streamErrorTrailer is the trailer set when a stream ends early, as CSV has nowhere else to say so
+const streamErrorTrailer = "X-Stream-Error"
+
+// formatText is the format of the text streams, which write their records as lines
+const formatText = "text"
+
+// Stream writes the rows of a large result to the response as they are read
+// JSON keeps the envelope of WriteJSON, with the lists written an element at a time between Begin and End
+// NDJSON, CSV and TSV write a record per row and ignore the JSON structure, as do the text streams with a line per row
+// the first write error is kept and returned by Write, so Begin, Field and End need no checks
+type Stream struct {
+	ctx    context.Context
+	w      http.ResponseWriter
+	format string
+	out    *countingWriter
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	// columns of the CSV and TSV rows, those of the type of the first record
+	columns []string
+	// the open JSON objects and arrays
+	stack []*jsonContainer
+	rows  int
+	err   error
+}
+
+// jsonContainer is an open JSON object or array of a Stream
+type jsonContainer struct {
+	end     byte
+	members bool
+}
+
+// countingWriter counts the bytes written to the client
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// NewStream starts streaming the response to r in the format negotiated with it
+// in JSON header is the envelope, written without the omit fields which are streamed in its place
+// returns nil after writing the error if the format is not valid
+func NewStream(w http.ResponseWriter, r *http.Request, header model.APIData, omit ...string) *Stream {
+	w.Header().Add("Vary", "Accept")
+	format, ok := Format(r)
+	if !ok {
+		WriteJSONError(w, ErrInvalidFormat)
+		return nil
+	}
+	s := &Stream{ctx: r.Context(), w: w, format: format, out: &countingWriter{w: w}}
+	s.buf = bufio.NewWriter(s.out)
+	contentType := formatTypes[format][0]
+	if format == FormatCSV || format == FormatTSV {
+		contentType += "; charset=utf-8"
+		s.csv = csv.NewWriter(s.buf)
+		if format == FormatTSV {
+			s.csv.Comma = '\t'
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Trailer", streamErrorTrailer)
+
+	if format == FormatJSON {
+		header.GenerateMetaData()
+		s.Begin("", '{')
+		s.Begin("data", '{')
+		s.writeFields(header, omit)
+	}
+	return s
+}
+
+// NewTextStream starts streaming the response to r as text of contentType, such as a zone file
+// Write writes the records as lines, formatted with fmt, and the items and JSON structure are ignored
+func NewTextStream(w http.ResponseWriter, r *http.Request, contentType string) *Stream {
+	s := &Stream{ctx: r.Context(), w: w, format: formatText, out: &countingWriter{w: w}}
+	s.buf = bufio.NewWriter(s.out)
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Trailer", streamErrorTrailer)
+	return s
+}
+
+// writeFields writes the fields of the JSON of v in the open object, except the omit fields
+func (s *Stream) writeFields(v interface{}, omit []string) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	skip := make(map[string]bool)
+	for _, name := range omit {
+		skip[name] = true
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err = dec.Token(); err != nil {
+		s.fail(err)
+		return
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			s.fail(err)
+			return
+		}
+		if !skip[key.(string)] {
+			s.Field(key.(string), value)
+		}
+	}
+}
+
+func (s *Stream) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Stream) write(p []byte) {
+	if s.err != nil {
+		return
+	}
+	_, err := s.buf.Write(p)
+	s.fail(err)
+}
+
+// member starts a member of the innermost JSON container, named in objects
+func (s *Stream) member(name string) {
+	if len(s.stack) == 0 {
+		return
+	}
+	top := s.stack[len(s.stack)-1]
+	if top.members {
+		s.write([]byte{','})
+	}
+	top.members = true
+	if top.end == '}' {
+		key, _ := json.Marshal(name)
+		s.write(append(key, ':'))
+	}
+}
+
+// Begin opens the JSON object or array name, delim is '{' or '['
+// name is ignored for the elements of arrays
+func (s *Stream) Begin(name string, delim byte) {
+	if s.format != FormatJSON {
+		return
+	}
+	s.member(name)
+	s.write([]byte{delim})
+	end := byte('}')
+	if delim == '[' {
+		end = ']'
+	}
+	s.stack = append(s.stack, &jsonContainer{end: end})
+}
+
+// Field writes the JSON member name of the open object
+func (s *Stream) Field(name string, value interface{}) {
+	if s.format != FormatJSON {
+		return
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.member(name)
+	s.write(raw)
+}
+
+// End closes the innermost JSON object or array
+func (s *Stream) End() {
+	if s.format != FormatJSON || len(s.stack) == 0 {
+		return
+	}
+	top := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	s.write([]byte{top.end})
+}
+
+// Write writes a row, item is the element of the open JSON array and record the row of the other formats
+// returns an error if the row could not be written or the request is done, which should end the stream
+func (s *Stream) Write(item, record interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	switch s.format {
+	case FormatJSON:
+		s.Field("", item)
+	case FormatNDJSON:
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		s.write(append(raw, '\n'))
+	case formatText:
+		s.write([]byte(fmt.Sprintln(record)))
+	default:
+		s.writeRow(record)
+	}
+	s.rows++
+	if s.rows%streamFlushRows == 0 {
+		s.flush()
+	}
+	return s.err
+}
+
+// writeRow writes record as a CSV or TSV row
+// the columns are those of the type of the first record, with the empty fields as empty cells,
+// or the fields of its JSON if it is not a struct
+func (s *Stream) writeRow(record interface{}) {
+	columns := s.columns
+	if columns == nil {
+		columns = recordColumns(record)
+	}
+	row, err := flattenRecord(record, columns)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if s.columns == nil {
+		if columns == nil {
+			columns = row.columns
+		}
+		s.columns = append(make([]string, 0, len(columns)), columns...)
+		s.fail(s.csv.Write(s.columns))
+	}
+	line := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		line[i] = row.values[column]
+	}
+	s.fail(s.csv.Write(line))
+}
+
+// flush sends the buffered rows to the client
+func (s *Stream) flush() {
+	if s.csv != nil {
+		s.csv.Flush()
+		s.fail(s.csv.Error())
+	}
+	if s.err != nil {
+		return
+	}
+	s.fail(s.buf.Flush())
+	if f, ok := s.w.(http.Flusher); ok && s.err == nil {
+		f.Flush()
+	}
+}
+
+// Started returns true once part of the stream was sent to the client
+func (s *Stream) Started() bool {
+	return s.out.n > 0
+}
+
+// Close ends the stream with the error that ended it, or nil if all rows were written
+// if nothing was sent yet the error is returned for the caller to write as the response instead
+// otherwise the error ends the stream: in JSON as the errors of the envelope, in NDJSON as the last line,
+// and always in the X-Stream-Error trailer
+func (s *Stream) Close(err error) error {
+	if err == nil {
+		err = s.err
+	}
+	if err == nil {
+		for len(s.stack) > 0 {
+			s.End()
+		}
+		s.flush()
+		return s.err
+	}
+
+	// the datastore may fail in its own way once the request is done
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	jsonErr := ErrInternalServer
+	switch err {
+	case context.Canceled:
+		// the client is gone
+		return nil
+	case context.DeadlineExceeded, http.ErrHandlerTimeout:
+		jsonErr = ErrTimeout
+	default:
+		if !s.Started() {
+			return err
+		}
+		log.Printf("stream ended early: %s", err)
+	}
+	if !s.Started() {
+		WriteJSONError(s.w, jsonErr)
+		return nil
+	}
+
+	// the write error may have been the reason to stop, but try to say why anyway
+	s.err = nil
+	s.w.Header().Set(streamErrorTrailer, jsonErr.Detail)
+	switch s.format {
+	case FormatJSON:
+		for len(s.stack) > 1 {
+			s.End()
+		}
+		s.Field("errors", []*model.JSONError{jsonErr})
+		s.End()
+	case FormatNDJSON:
+		raw, _ := json.Marshal(model.JSONErrors{Errors: []*model.JSONError{jsonErr}})
+		s.write(append(raw, '\n'))
+	}
+	s.flush()
+	return nil
+}
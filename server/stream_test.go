@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnscoffee/model"
+)
+
+// testHeader is the envelope of the test streams, its rows are streamed in place of Rows
+type testHeader struct {
+	model.Metadata
+	Name string    `json:"name"`
+	Rows []testRow `json:"rows"`
+}
+
+func (h *testHeader) GenerateMetaData() {
+	h.Link = "/test"
+}
+
+// testRow is a row of the test streams
+type testRow struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newTestStream(t *testing.T, format string) (*Stream, *httptest.ResponseRecorder) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test?format="+format, nil)
+	s := NewStream(w, r, &testHeader{Name: "test"}, "rows")
+	if s == nil {
+		t.Fatalf("no stream for format %q", format)
+	}
+	return s, w
+}
+
+func TestStreamJSONNesting(t *testing.T) {
+	s, w := newTestStream(t, FormatJSON)
+	s.Begin("rows", '[')
+	for i := 0; i < 2; i++ {
+		if err := s.Write(testRow{"a", i}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.End()
+	s.Begin("groups", '{')
+	s.Begin("empty", '[')
+	s.End()
+	s.Field("n", 1)
+	// the object and the envelope are left open for Close
+	if err := s.Close(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"data":{"link":"/test","name":"test","rows":[{"name":"a","count":0},{"name":"a","count":1}],"groups":{"empty":[],"n":1}}}`
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestStreamJSONErrorAfterStart(t *testing.T) {
+	s, w := newTestStream(t, FormatJSON)
+	s.Begin("rows", '[')
+	// enough rows to flush, so the error can not replace the response
+	for i := 0; i < streamFlushRows; i++ {
+		if err := s.Write(testRow{"a", i}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.Started() {
+		t.Fatal("the stream was not flushed")
+	}
+	if err := s.Close(errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Data   testHeader         `json:"data"`
+		Errors []*model.JSONError `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Status != 500 {
+		t.Errorf("errors = %v, want an internal server error", body.Errors)
+	}
+	if got := w.Result().Trailer.Get(streamErrorTrailer); got != ErrInternalServer.Detail {
+		t.Errorf("trailer = %q, want %q", got, ErrInternalServer.Detail)
+	}
+}
+
+func TestStreamNDJSONErrorLine(t *testing.T) {
+	s, w := newTestStream(t, FormatNDJSON)
+	s.Begin("rows", '[')
+	for i := 0; i < streamFlushRows; i++ {
+		if err := s.Write(nil, testRow{"a", i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(context.DeadlineExceeded); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make([]string, 0, streamFlushRows+1)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != streamFlushRows+1 {
+		t.Fatalf("%d lines, want %d", len(lines), streamFlushRows+1)
+	}
+	if lines[0] != `{"name":"a","count":0}` {
+		t.Errorf("first line = %s", lines[0])
+	}
+	var last model.JSONErrors
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("invalid error line: %s", err)
+	}
+	if len(last.Errors) != 1 || last.Errors[0].Status != ErrTimeout.Status {
+		t.Errorf("error line = %s, want a timeout", lines[len(lines)-1])
+	}
+}
+
+func TestStreamErrorBeforeStart(t *testing.T) {
+	s, w := newTestStream(t, FormatNDJSON)
+	boom := errors.New("boom")
+	if err := s.Close(boom); err != boom {
+		t.Errorf("Close = %v, want the error back to write as the response", err)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q, want nothing", w.Body.String())
+	}
+
+	s, w = newTestStream(t, FormatCSV)
+	if err := s.Close(context.DeadlineExceeded); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != ErrTimeout.Status || !strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("response = %d %s, want the timeout error", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamCSVColumns(t *testing.T) {
+	s, w := newTestStream(t, FormatCSV)
+	rows := []interface{}{
+		testRow{"a,b", 1},
+		// a later record's extra column is left out
+		struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+			Extra string `json:"extra"`
+		}{"c", 2, "x"},
+		// and a missing one is empty
+		struct {
+			Name string `json:"name"`
+		}{"d"},
+	}
+	for _, row := range rows {
+		if err := s.Write(nil, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"name,count", `"a,b",1`, "c,2", "d,"}
+	if got := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("content type = %q", got)
+	}
+}
+
+func TestStreamTSV(t *testing.T) {
+	s, w := newTestStream(t, FormatTSV)
+	if err := s.Write(nil, testRow{"a", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.Body.String(), "name\tcount\na\t1\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestStreamCSVColumnsOfType(t *testing.T) {
+	type zone struct {
+		Name string `json:"name"`
+	}
+	type record struct {
+		model.Metadata
+		Name     string     `json:"name"`
+		LastSeen *time.Time `json:"lastseen,omitempty"`
+		Zone     *zone      `json:"zone,omitempty"`
+		Tags     []string   `json:"tags,omitempty"`
+		skipped  string
+	}
+	s, w := newTestStream(t, FormatCSV)
+	// the first record leaves out the empty fields, which still have their columns
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, row := range []record{{Name: "a"}, {Name: "b", LastSeen: &day, Zone: &zone{"com"}, Tags: []string{"x", "y"}}} {
+		if err := s.Write(nil, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"type,link,next,prev,name,lastseen,zone.name,tags", ",,,,a,,,", ",,,,b,2020-03-01T00:00:00Z,com,x y"}
+	if got := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+}